@@ -249,6 +249,17 @@ func initCommands(ui, serverCmdUi cli.Ui, runOpts *RunOptions) {
 				BaseCommand: getBaseCommand(),
 			}, nil
 		},
+		"debug": func() (cli.Command, error) {
+			return &DebugCommand{
+				BaseCommand: getBaseCommand(),
+				ShutdownCh:  MakeShutdownCh(),
+			}, nil
+		},
+		"debug verify": func() (cli.Command, error) {
+			return &DebugVerifyCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
 		"delete": func() (cli.Command, error) {
 			return &DeleteCommand{
 				BaseCommand: getBaseCommand(),
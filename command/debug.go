@@ -0,0 +1,5832 @@
+package command
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/version"
+	"github.com/mitchellh/cli"
+	"github.com/posener/complete"
+	"github.com/shirou/gopsutil/disk"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// EnvVaultDebugTargets is a comma-separated list of targets to capture,
+	// used when no targets are provided via flags or the configuration file.
+	EnvVaultDebugTargets = "VAULT_DEBUG_TARGETS"
+
+	// debugIndexVersion tracks the canonical version in the index file
+	// for compatibility with future format/layout changes on the bundle.
+	debugIndexVersion = 2
+
+	// debugMinInterval is the minimum acceptable interval capture value. This
+	// value applies to duration and all interval-related flags.
+	debugMinInterval = 5 * time.Second
+
+	// debugDurationGrace is the default grace period added to the run deadline
+	// so that captures started during the last frame are given a chance to
+	// complete.
+	debugDurationGrace = 1 * time.Second
+
+	// debugCompressionExt is the default compression extension used if
+	// compression is enabled.
+	debugCompressionExt = ".tar.gz"
+
+	// fileFriendlyTimeFormat is the time format used for file and directory
+	// naming.
+	fileFriendlyTimeFormat = "2006-01-02T15-04-05Z"
+
+	// debugStaticFrame is the frame index recorded for errors that occur on
+	// one-shot targets, which are not captured as part of a frame.
+	debugStaticFrame = -1
+
+	// debugRedactedValue is the placeholder written in place of sensitive
+	// values that are stripped from the bundle.
+	debugRedactedValue = "redacted"
+)
+
+// debugActiveTargets are the targets that describe cluster-wide state, which
+// are captured from the active node when -follow-active is set. All other
+// targets describe the node itself and are captured from the original address.
+var debugActiveTargets = []string{
+	"auth",
+	"entropy",
+	"license",
+	"mounts",
+	"plugins",
+	"policies",
+	"quotas",
+	"raft-snapshot-info",
+	"self",
+}
+
+// debugTokenRedactKeys are the keys in the token lookup response that could be
+// used to identify or use the token, which are never written to the bundle.
+var debugTokenRedactKeys = []string{
+	"accessor",
+	"id",
+}
+
+// debugStorageKeys are the keys of the sanitized storage and HA storage
+// configuration that are captured by the storage target. Backend-specific
+// configuration, which can hold credentials, is never captured.
+var debugStorageKeys = []string{"cluster_addr", "disable_clustering", "redirect_addr", "type"}
+
+// debugPluginKeys are the keys of each plugin catalog entry that are captured
+// by the plugins target. The command and args of a plugin can reveal the
+// layout of the host or hold credentials, so they are never captured.
+var debugPluginKeys = []string{"builtin", "name", "sha256", "version"}
+
+// debugSealWrapKeys are the keys of the sanitized configuration that are
+// captured by the entropy target alongside the seal wrap status.
+var debugSealWrapKeys = []string{"disable_sealwrap", "entropy", "seals"}
+
+// debugLicenseRedactKeys are the keys in the license status response that
+// hold the raw license blob, which is never written to the bundle.
+var debugLicenseRedactKeys = []string{
+	"license",
+	"signed",
+	"signed_license",
+	"text",
+}
+
+// debugGlobalTargets are the targets that describe the server process rather
+// than a namespace, whose requests are never scoped to the namespace set with
+// -namespace.
+var debugGlobalTargets = []string{
+	"host",
+	"pprof",
+}
+
+// debugAuditProbeInput is the input hashed with every audit device by the
+// audit-probe target. It is fixed, so that the hashes can be compared across
+// bundles.
+const debugAuditProbeInput = "vault-debug-audit-probe"
+
+// debugAuditSlowThreshold is the time an audit device may take to log a
+// request or response before the audit-status target reports it as slow.
+const debugAuditSlowThreshold = time.Second
+
+// debugHotPathsPrefix is the prefix of the request metrics rolled up by the
+// hot-paths target, which are named after the operation and mount, such as
+// vault.route.read.secret-.
+const debugHotPathsPrefix = "vault.route."
+
+// debugSealTimingPrefixes are the prefixes of the timers rolled up by the
+// seal-timing target, covering the barrier operations and the seal and unseal
+// of the core.
+var debugSealTimingPrefixes = []string{
+	"vault.barrier.",
+	"vault.core.seal",
+	"vault.core.unseal",
+}
+
+// debugPprofProfiles is the list of pprof profiles that can be selected with
+// -pprof-profiles.
+var debugPprofProfiles = []string{
+	"block",
+	"goroutine",
+	"heap",
+	"mutex",
+	"profile",
+	"trace",
+}
+
+// DebugIndex represents the data structure in the index file
+type DebugIndex struct {
+	Version                int                           `json:"version"`
+	VaultAddress           string                        `json:"vault_address"`
+	ActiveAddress          string                        `json:"active_address,omitempty"`
+	Namespace              string                        `json:"namespace,omitempty"`
+	ServerVersion          string                        `json:"server_version,omitempty"`
+	ClientVersion          string                        `json:"client_version"`
+	Timestamp              time.Time                     `json:"timestamp"`
+	DurationSeconds        int                           `json:"duration_seconds"`
+	IntervalSeconds        int                           `json:"interval_seconds"`
+	MetricsIntervalSeconds int                           `json:"metrics_interval_seconds"`
+	Compress               bool                          `json:"compress"`
+	RawArgs                []string                      `json:"raw_args"`
+	Targets                []string                      `json:"targets"`
+	Output                 []DebugOutputFile             `json:"output"`
+	Checksums              map[string]string             `json:"checksums"`
+	Metadata               map[string]string             `json:"metadata,omitempty"`
+	Errors                 []CaptureError                `json:"errors"`
+	Frames                 []DebugFrame                  `json:"frames"`
+	PprofFrames            []int                         `json:"pprof_frames,omitempty"`
+	Clusters               map[string]*DebugClusterIndex `json:"clusters,omitempty"`
+	WaitUntil              string                        `json:"wait_until,omitempty"`
+	WaitResult             string                        `json:"wait_result,omitempty"`
+	ExtendOn               string                        `json:"extend_on,omitempty"`
+	ExtendedSeconds        int                           `json:"extended_seconds,omitempty"`
+	Partial                bool                          `json:"partial,omitempty"`
+	CapturedAgainstStandby bool                          `json:"captured_against_standby,omitempty"`
+	TLSVerificationSkipped bool                          `json:"tls_verification_skipped,omitempty"`
+	SigningKeyFingerprint  string                        `json:"signing_key_fingerprint,omitempty"`
+	Resumed                []time.Time                   `json:"resumed,omitempty"`
+	CustomPaths            []*DebugCustomPath            `json:"custom_paths,omitempty"`
+}
+
+// DebugFrame maps a frame index to the directory its output is written to,
+// along with the time the frame started and the time its last target
+// completed.
+type DebugFrame struct {
+	Frame        int       `json:"frame"`
+	Directory    string    `json:"directory"`
+	Timestamp    time.Time `json:"timestamp"`
+	EndTimestamp time.Time `json:"end_timestamp"`
+}
+
+// DebugOutputFile is a file in the bundle, along with the time it was
+// written.
+type DebugOutputFile struct {
+	Path      string    `json:"path"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// UnmarshalJSON also accepts the plain path that version 1 of the index lists
+// each file as, so that older bundles can still be read.
+func (f *DebugOutputFile) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		f.Path = path
+		return nil
+	}
+
+	type outputFile DebugOutputFile
+	return json.Unmarshal(data, (*outputFile)(f))
+}
+
+// CaptureError holds an error entry that can occur during capture. It
+// includes the target, the frame index, the timestamp, and the error itself.
+type CaptureError struct {
+	Target      string    `json:"target"`
+	Frame       int       `json:"frame"`
+	Timestamp   time.Time `json:"timestamp"`
+	TargetError string    `json:"error"`
+}
+
+// debugConfig represents the configuration file that can be supplied via
+// -config. Durations can be specified as either a number of seconds or a time
+// string.
+type debugConfig struct {
+	Targets         []string    `json:"targets"`
+	Duration        interface{} `json:"duration"`
+	Interval        interface{} `json:"interval"`
+	MetricsInterval interface{} `json:"metrics_interval"`
+	Compress        *bool       `json:"compress"`
+	Redact          *bool       `json:"redact"`
+	RedactFile      *string     `json:"redact_file"`
+}
+
+// serverStatus holds a single interval entry for the server-status target
+type serverStatus struct {
+	Timestamp time.Time               `json:"timestamp"`
+	Health    *api.HealthResponse     `json:"health"`
+	Seal      *api.SealStatusResponse `json:"seal"`
+}
+
+// requestTiming holds the timing information for a single API request made
+// during the capture
+type requestTiming struct {
+	Target    string    `json:"target"`
+	Frame     int       `json:"frame"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	LatencyMS float64   `json:"latency_ms"`
+}
+
+// goroutineCount holds the number of goroutines of the server sampled on a
+// single frame, along with where the count was read from
+type goroutineCount struct {
+	Frame     int       `json:"frame"`
+	Timestamp time.Time `json:"timestamp"`
+	Count     int       `json:"count"`
+	Source    string    `json:"source"`
+}
+
+// captureTargetKey is the context key used to carry the target and frame of
+// a capture down to the requests it makes.
+type captureTargetKey struct{}
+
+// captureTarget identifies the target and frame a request is made for.
+type captureTarget struct {
+	target string
+	frame  int
+}
+
+// clockSkew holds the estimated clock skew between the local host and the
+// server. The skew is the server time minus the local time at the midpoint of
+// the request, and the error bound accounts for the round trip and the
+// one-second resolution of the server time.
+type clockSkew struct {
+	LocalTime         time.Time `json:"local_time"`
+	ServerTime        time.Time `json:"server_time"`
+	RoundTripSeconds  float64   `json:"round_trip_seconds"`
+	SkewSeconds       float64   `json:"skew_seconds"`
+	ErrorBoundSeconds float64   `json:"error_bound_seconds"`
+}
+
+// debugSummary is the machine-readable summary of a run printed with -json
+type debugSummary struct {
+	OutputPath string         `json:"output_path"`
+	Bundles    []string       `json:"bundles,omitempty"`
+	Duration   string         `json:"duration"`
+	Frames     int            `json:"frames"`
+	Targets    []string       `json:"targets"`
+	Bytes      int64          `json:"bytes"`
+	Errors     []CaptureError `json:"errors"`
+}
+
+// debugQuietUi is a cli.Ui that discards informational output, used to keep
+// stdout clean for the -json summary. With silenceWarnings, warnings are
+// discarded as well, so that only errors are written with -quiet.
+type debugQuietUi struct {
+	cli.Ui
+
+	silenceWarnings bool
+}
+
+func (u *debugQuietUi) Output(string) {}
+func (u *debugQuietUi) Info(string)   {}
+
+func (u *debugQuietUi) Warn(s string) {
+	if !u.silenceWarnings {
+		u.Ui.Warn(s)
+	}
+}
+
+var _ cli.Command = (*DebugCommand)(nil)
+var _ cli.CommandAutocomplete = (*DebugCommand)(nil)
+
+type DebugCommand struct {
+	*BaseCommand
+
+	// cfg holds the options of the capture, which the flags that configure
+	// the capture are parsed into.
+	cfg CaptureConfig
+
+	flagConfig      string
+	flagPreset      string
+	flagDryRun      bool
+	flagListTargets bool
+	flagJSON        bool
+	flagQuiet       bool
+
+	// debugIndex is used to keep track of the index state, which gets written
+	// to a file at the end.
+	debugIndex *DebugIndex
+
+	// durationSet is whether -duration was explicitly provided
+	durationSet bool
+
+	// skipPreflight bypasses the token check made before the capture, used
+	// primarily for tests against servers that don't implement it
+	skipPreflight bool
+
+	// ShutdownCh is used to capture interrupt signal and end polling capture
+	ShutdownCh chan struct{}
+
+	// unixSocket is the path of the socket the server is reached over when
+	// the address is a unix:// address
+	unixSocket string
+
+	// runLog is the log of the run written with -log-file
+	runLog *debugRunLog
+
+	// cachedClient holds the client retrieved during preflight
+	cachedClient *api.Client
+
+	// activeClient holds the client pointed at the active node, used for
+	// cluster-wide targets when -follow-active is set
+	activeClient *api.Client
+
+	// errLock is used to lock error capture into the index file, as well as
+	// the count of successful captures
+	errLock sync.Mutex
+
+	// captureCount is the number of successful target captures over the run
+	captureCount int
+
+	// capturedTargets holds the targets that were captured successfully at
+	// least once over the run
+	capturedTargets map[string]bool
+
+	// requestSem limits the number of in-flight API requests across all
+	// targets
+	requestSem chan struct{}
+
+	// limiter paces the API requests across all targets when -rate-limit is
+	// set
+	limiter *rate.Limiter
+
+	// timingLock is used to lock the request timings, which get written to a
+	// file at the end.
+	timingLock     sync.Mutex
+	requestTimings []requestTiming
+
+	// goroutineLock is used to lock the goroutine counts sampled on every
+	// frame, which get written to a file at the end.
+	goroutineLock   sync.Mutex
+	goroutineCounts []goroutineCount
+
+	// pprofLock is used to lock the pprof ring, which holds the frames whose
+	// pprof output is retained when -pprof-ring is set.
+	pprofLock sync.Mutex
+	pprofRing []DebugFrame
+
+	// outputPipe is the named pipe the archive is streamed into, if -output
+	// points at one. The output is then staged under stagingDir.
+	outputPipe string
+	stagingDir string
+
+	// signingKey is the key loaded from -sign-key that archives are signed
+	// with
+	signingKey ed25519.PrivateKey
+
+	// uploadPartSize is the size of the parts an s3://, gcs://, or azure://
+	// -upload-url is uploaded in, parsed from -upload-part-size
+	uploadPartSize int64
+
+	// s3Uploader uploads the bundle to an s3:// -upload-url. If nil, a client
+	// is created from the environment, used primarily for tests
+	s3Uploader debugMultipartUploader
+
+	// gcsWriter and azureUploader upload the bundle to a gcs:// or azure://
+	// -upload-url the same way
+	gcsWriter     debugGCSWriterFunc
+	azureUploader debugBlockBlobUploader
+
+	// customPaths holds the paths passed with -poll-path
+	customPaths []*DebugCustomPath
+
+	// mountHealth holds the health paths passed with -mount-health
+	mountHealth []*DebugCustomPath
+
+	// redactor replaces the values matched by the default rules of -redact
+	// and the rules of -redact-file in the captured files
+	redactor *debugRedactor
+
+	// anonymizer replaces identifying values in the captured files with
+	// stable pseudonyms when -anonymize is set
+	anonymizer *debugAnonymizer
+
+	// clusters holds the clusters captured with -cluster, or the raft peers
+	// captured with -raft-peers, and raftPeerErrs the peers that were skipped
+	clusters     []*debugCluster
+	raftPeerErrs []error
+
+	// clusterCmds holds the commands capturing each cluster, and parent the
+	// command whose bundle a cluster is captured into
+	clusterCmds []*DebugCommand
+	parent      *DebugCommand
+
+	// indexLock serializes the writes of the partial index file made as each
+	// frame completes
+	indexLock sync.Mutex
+
+	// fileTimes holds the time each file was written, keyed by its path
+	// including the output directory. It is only populated on the top-level
+	// command, so that it covers the files of every cluster.
+	fileTimes     map[string]time.Time
+	fileTimesLock sync.Mutex
+
+	// streamLock serializes the records appended to the JSON lines files of
+	// the poll results with -format=jsonl
+	streamLock sync.Mutex
+
+	// frameHook, if set, is called after each frame is captured and the
+	// partial index is written, used primarily for tests
+	frameHook func(idx int)
+
+	// waitCondition is the parsed -wait-until condition, which must be met
+	// before the capture begins
+	waitCondition *debugCondition
+
+	// extendCondition is the parsed -extend-on condition, and extendObserved
+	// is set once it is observed during the current window
+	extendCondition *debugCondition
+	extendObserved  int32
+
+	// summary accumulates the results of every bundle written over the run,
+	// which is printed with -json
+	summary debugSummary
+
+	// cgroupRoot overrides the root of the cgroup filesystem that container
+	// limits are read from, used primarily for tests
+	cgroupRoot string
+
+	// procRoot overrides the root of the proc filesystem that the server
+	// process is read from, used primarily for tests
+	procRoot string
+
+	// frameOffset and metricsOffset are the indexes that the first frame and
+	// metrics capture of the run are numbered from, which are past those
+	// already in the bundle when resuming
+	frameOffset   int
+	metricsOffset int
+
+	// pprofProfiles is the list of profiles selected with -pprof-profiles,
+	// which is empty if every profile is captured
+	pprofProfiles []string
+
+	// traceInFlight is set while an execution trace is being captured, since
+	// the server only allows a single trace at a time.
+	traceInFlight int32
+}
+
+func (c *DebugCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictNothing
+}
+
+func (c *DebugCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *DebugCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP)
+
+	f := set.NewFlagSet("Command Options")
+
+	f.StringVar(&StringVar{
+		Name:       "config",
+		Target:     &c.flagConfig,
+		Completion: complete.PredictFiles("*.json"),
+		Usage: "Path to a JSON configuration file that specifies the targets, " +
+			"duration, interval, metrics_interval, and compress values to use. " +
+			"Flags that are explicitly provided take precedence over the values " +
+			"in the file.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "preset",
+		Target:     &c.flagPreset,
+		Completion: complete.PredictSet(debugPresetNames()...),
+		Usage: "Curated combination of targets and flags to use. Valid " +
+			"values are \"quick\", which captures a single frame of the " +
+			"health, server status, and metrics for a fast triage, \"full\", " +
+			"which captures every target with profiles on every frame, and " +
+			"\"pprof\", which captures profiles on every frame. Flags that are " +
+			"explicitly provided, and the values in the -config file, take " +
+			"precedence over those of the preset.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "anonymize",
+		Target:  &c.cfg.Anonymize,
+		Default: false,
+		Usage: "Replaces hostnames, cluster names, and node and cluster IDs " +
+			"in the captured files with stable pseudonyms, such as node-1 " +
+			"and cluster-a. The same value is replaced with the same " +
+			"pseudonym in every file. The mapping is not written to the " +
+			"bundle.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "capture-process-env",
+		Target:  &c.cfg.CaptureProcessEnv,
+		Default: false,
+		Usage: "Toggles whether the host target also captures the command " +
+			"line and environment of the Vault server process, read from " +
+			"the machine running this command if the server is on a unix " +
+			"socket or loopback address. Values of flags and " +
+			"environment variables whose names contain KEY, PASSWORD, " +
+			"SECRET, or TOKEN are redacted.",
+	})
+
+	f.StringMapVar(&StringMapVar{
+		Name:       "cluster",
+		Target:     &c.cfg.Clusters,
+		Completion: complete.PredictAnything,
+		Usage: "Cluster to capture, in the format of name=address. This can " +
+			"be specified multiple times. Every cluster is captured " +
+			"concurrently with the same targets and token, and written to " +
+			"the clusters/<name> sub-directory of the bundle.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "raft-peers",
+		Target:  &c.cfg.RaftPeers,
+		Default: false,
+		Usage: "Toggles whether to capture every peer listed in the raft " +
+			"configuration of the configured address. Every peer is " +
+			"captured concurrently with the same targets and token, and " +
+			"written to the clusters/<node_id> sub-directory of the bundle. " +
+			"The API address of each peer is derived from its raft address " +
+			"with the scheme and port of the configured address.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "compress",
+		Target:  &c.cfg.Compress,
+		Default: true,
+		Usage:   "Toggles whether to compress output package.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "compress-profiles",
+		Target:  &c.cfg.CompressProfiles,
+		Default: false,
+		Usage: "Toggles whether to write each pprof profile gzip-compressed " +
+			"with a .prof.gz suffix, regardless of whether the output is " +
+			"compressed. Profiles that the server already returns " +
+			"gzip-compressed are written as is.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "keep-dir",
+		Target:  &c.cfg.KeepDir,
+		Default: false,
+		Usage: "Toggles whether to keep the output directory alongside the " +
+			"compressed archive instead of removing it. This only applies " +
+			"if compression is enabled.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "compression",
+		Target:     &c.cfg.Compression,
+		Completion: complete.PredictSet("gzip", "bzip2", "xz", "none"),
+		Usage: "Compression of the tarball the output is bundled into, " +
+			"taking precedence over that of -archive-format. Valid values " +
+			"are \"gzip\", \"bzip2\", \"xz\", and \"none\". This can only be " +
+			"set when the archive format is a tarball.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "dry-run",
+		Target:  &c.flagDryRun,
+		Default: false,
+		Usage: "Validates the flags and prints the capture plan, including " +
+			"the frame count and the files that would be written, without " +
+			"capturing any data or creating any files.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "list-targets",
+		Target:  &c.flagListTargets,
+		Default: false,
+		Usage: "Prints every available target with a description and " +
+			"whether it is captured by default, then exits without " +
+			"capturing any data.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "log-file",
+		Target:     &c.cfg.LogFile,
+		Completion: complete.PredictFiles("*"),
+		Usage: "Path of a file to write a JSON-lines log of every step of " +
+			"the run to, such as the start and end of each target along " +
+			"with its duration and error.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "log-in-bundle",
+		Target:  &c.cfg.LogInBundle,
+		Default: false,
+		Usage: "Toggles whether to include the log written with -log-file " +
+			"in the bundle as capture_log.jsonl.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "log-level",
+		Target:     &c.cfg.LogLevel,
+		Default:    "info",
+		Completion: complete.PredictSet("trace", "debug", "info", "warn", "error"),
+		Usage: "Level of the server log streamed by the log target. Valid " +
+			"values are \"trace\", \"debug\", \"info\", \"warn\", and " +
+			"\"error\". The log target requires a server that serves " +
+			"sys/monitor, added in Vault 1.5, and writes a note in place of " +
+			"the log on older servers, including those built from this version.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "duration",
+		Target:     &c.cfg.Duration,
+		Completion: complete.PredictAnything,
+		Default:    2 * time.Minute,
+		Usage:      "Duration to run the command.",
+	})
+
+	f.IntVar(&IntVar{
+		Name:       "count",
+		Target:     &c.cfg.Count,
+		Completion: complete.PredictAnything,
+		Usage: "Number of frames to capture, spaced by the interval. When " +
+			"set, the duration of the run is the count multiplied by the " +
+			"interval. This cannot be used with -duration.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "interval",
+		Target:     &c.cfg.Interval,
+		Completion: complete.PredictAnything,
+		Default:    30 * time.Second,
+		Usage: "The polling interval at which to collect profiling data and " +
+			"server state.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "json",
+		Target:  &c.flagJSON,
+		Default: false,
+		Usage: "Toggles whether to print a machine-readable JSON summary of " +
+			"the capture on completion. Other output to stdout is " +
+			"suppressed.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "quiet",
+		Target:  &c.flagQuiet,
+		Default: false,
+		Usage: "Toggles whether to suppress all output other than errors, " +
+			"such as for captures run from cron. The exit code is " +
+			"unaffected. Cannot be used with -json.",
+	})
+
+	f.IntVar(&IntVar{
+		Name:       "max-concurrent-requests",
+		Target:     &c.cfg.MaxConcurrentRequests,
+		Completion: complete.PredictAnything,
+		Default:    4,
+		Usage: "Maximum number of API requests that are allowed to be in " +
+			"flight at any given time, shared across all targets.",
+	})
+
+	f.Float64Var(&Float64Var{
+		Name:       "rate-limit",
+		Target:     &c.cfg.RateLimit,
+		Completion: complete.PredictAnything,
+		Default:    0,
+		Usage: "Maximum number of API requests per second, shared across all " +
+			"targets. Useful to avoid saturating slow links to remote " +
+			"clusters. A value of 0 means no limit.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "metrics-interval",
+		Target:     &c.cfg.MetricsInterval,
+		Completion: complete.PredictAnything,
+		Default:    10 * time.Second,
+		Usage:      "The polling interval at which to collect metrics data.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "metrics-csv",
+		Target:  &c.cfg.MetricsCSV,
+		Default: false,
+		Usage: "Toggles whether to roll up the gauges and counters of every " +
+			"metrics capture into a single metrics.csv file, with one row " +
+			"per capture, in addition to the per-capture JSON files.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "prometheus-metrics",
+		Target:  &c.cfg.PrometheusMetrics,
+		Default: false,
+		Usage: "Toggles whether to also capture the metrics in the " +
+			"Prometheus exposition format on every metrics interval, which " +
+			"includes histograms. This requires Prometheus metrics to be " +
+			"enabled in the server's telemetry configuration.",
+	})
+
+	f.StringSliceVar(&StringSliceVar{
+		Name:       "metrics-csv-keys",
+		Target:     &c.cfg.MetricsCSVKeys,
+		Completion: complete.PredictAnything,
+		Usage: "Name of a gauge or counter to include in metrics.csv. This " +
+			"can be specified multiple times. Defaults to every gauge and " +
+			"counter.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "metrics-delta",
+		Target:  &c.cfg.MetricsDelta,
+		Default: false,
+		Usage: "Toggles whether to compute the change of every counter " +
+			"between consecutive metrics captures into a metrics_delta.json " +
+			"file, along with its rate per second over the metrics interval.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "min-free-space",
+		Target:     &c.cfg.MinFreeSpace,
+		Completion: complete.PredictAnything,
+		Usage: "Minimum free space required on the filesystem that the " +
+			"output is written to, such as \"500MB\" or \"2GiB\". The " +
+			"command fails before making any requests if less space is " +
+			"available. Defaults to no check.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "output",
+		Target:     &c.cfg.Output,
+		Completion: complete.PredictAnything,
+		Usage:      "Specifies the output path for the debug package.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "output-format",
+		Target:     &c.cfg.OutputFormat,
+		Default:    "archive",
+		Completion: complete.PredictSet("archive", "dir"),
+		Usage: "Controls whether the final step archives the output " +
+			"directory. Valid values are \"archive\", which produces an " +
+			"archive of -archive-format, and \"dir\", which leaves the " +
+			"output as a directory. Setting -compress=false is equivalent to " +
+			"\"dir\".",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "archive-format",
+		Target:     &c.cfg.ArchiveFormat,
+		Default:    "tgz",
+		Completion: complete.PredictSet("tgz", "tbz2", "txz", "zip", "tar", "none"),
+		Usage: "Format of the archive the output is bundled into. Valid " +
+			"values are \"tgz\", which produces a gzip-compressed tarball, " +
+			"\"tbz2\" and \"txz\", which produce a bzip2 or xz-compressed " +
+			"tarball, \"zip\", \"tar\", which produces an uncompressed " +
+			"tarball, and \"none\", which leaves the output as a directory " +
+			"the same as -output-format=dir.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "format",
+		Target:     &c.cfg.Format,
+		Default:    "json",
+		Completion: complete.PredictSet("json", "jsonl"),
+		Usage: "Format of the poll results. Valid values are \"json\", which " +
+			"writes a JSON file per target on every frame, and \"jsonl\", " +
+			"which also appends each poll result as a JSON record to a .jsonl " +
+			"file per target as the capture progresses, so that it can be " +
+			"followed live and is usable if the command is killed.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "output-compress-after",
+		Target:     &c.cfg.OutputCompressAfter,
+		Completion: complete.PredictAnything,
+		Usage: "Only archives the output if the capture ran for at least " +
+			"this long, leaving the output of shorter captures as a " +
+			"directory. Defaults to always archiving the output.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "output-template",
+		Target:     &c.cfg.OutputTemplate,
+		Completion: complete.PredictAnything,
+		Usage: "Template for the output path, used in place of -output. The " +
+			"placeholders {{.Time}}, {{.Host}}, and {{.ClusterName}} expand to " +
+			"the capture time, the host of the Vault address, and the name of " +
+			"the cluster, such as \"debug-{{.Host}}-{{.Time}}\". The archive " +
+			"extension is appended after expansion.",
+	})
+
+	f.StringSliceVar(&StringSliceVar{
+		Name:       "target",
+		Target:     &c.cfg.Targets,
+		Completion: complete.PredictSet(debugTargetNames()...),
+		Usage: "Target to capture, defaulting to all but the log target if " +
+			"none specified. This can be specified multiple times to capture multiple targets. " +
+			"This can also be specified via the VAULT_DEBUG_TARGETS environment " +
+			"variable as a comma-separated list. " +
+			"Available targets are: " + strings.Join(debugTargetNames(), ", ") + ".",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "target-timeout",
+		Target:     &c.cfg.TargetTimeout,
+		Completion: complete.PredictAnything,
+		Default:    10 * time.Second,
+		Usage: "Maximum amount of time to wait on a single target capture " +
+			"within a frame. Profiling captures are given this amount of " +
+			"time in addition to their own profiling duration.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "goroutine-dump",
+		Target:  &c.cfg.GoroutineDump,
+		Default: true,
+		Usage: "Toggles whether to capture a human-readable dump of all " +
+			"goroutine stack traces alongside the goroutine profile. This " +
+			"only applies if pprof is a target.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "follow-active",
+		Target:  &c.cfg.FollowActive,
+		Default: false,
+		Usage: "Toggles whether to capture cluster-wide targets, such as " +
+			"mounts and policies, from the active node if the specified node " +
+			"is a standby. Node-level targets are still captured from the " +
+			"specified node.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "require-active",
+		Target:  &c.cfg.RequireActive,
+		Default: false,
+		Usage: "Toggles whether to refuse to capture from a standby or " +
+			"performance standby node, which may return stale or partial data " +
+			"for some targets.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "grace",
+		Target:     &c.cfg.Grace,
+		Completion: complete.PredictAnything,
+		Default:    debugDurationGrace,
+		Usage: "Grace period added to the duration to form the deadline of " +
+			"the whole run. Captures that are still in flight when the " +
+			"deadline passes are abandoned and the bundle is written with " +
+			"whatever was collected.",
+	})
+
+	f.IntVar(&IntVar{
+		Name:       "hot-paths-n",
+		Target:     &c.cfg.HotPathsN,
+		Default:    20,
+		Completion: complete.PredictAnything,
+		Usage: "Number of request paths reported in hot_paths.json by the " +
+			"hot-paths target.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "include-policy-bodies",
+		Target:  &c.cfg.IncludePolicyBodies,
+		Default: false,
+		Usage: "Toggles whether to capture the body of each ACL policy in " +
+			"addition to the list of policy names. Policy bodies may be " +
+			"considered sensitive. This only applies if policies is a target.",
+	})
+
+	f.StringSliceVar(&StringSliceVar{
+		Name:       "poll-path",
+		Target:     &c.cfg.PollPaths,
+		Completion: complete.PredictAnything,
+		Usage: "API path to capture on every metrics interval, such as " +
+			"\"/v1/sys/internal/counters/config\", in addition to the " +
+			"targets. The path must be under /v1/ and is read with a GET " +
+			"request. This can be specified multiple times.",
+	})
+
+	f.StringMapVar(&StringMapVar{
+		Name:       "mount-health",
+		Target:     &c.cfg.MountHealth,
+		Completion: complete.PredictAnything,
+		Usage: "Health path of a mount to capture on every metrics interval, " +
+			"in the format of mount=path, such as " +
+			"\"database=config/postgres\". The path must resolve under the " +
+			"mount, and is written to mounts/<mount>/<index>/health.json. " +
+			"This can be specified multiple times.",
+	})
+
+	f.StringSliceVar(&StringSliceVar{
+		Name:       "metadata",
+		Target:     &c.cfg.Metadata,
+		Completion: complete.PredictAnything,
+		Usage: "Arbitrary key=value metadata to store in the index file of " +
+			"the debug package, such as the reason for the capture. This can " +
+			"be specified multiple times to add multiple pieces of metadata.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "proxy",
+		Target:     &c.cfg.Proxy,
+		Completion: complete.PredictAnything,
+		Usage: "URL of an HTTP, HTTPS, or SOCKS5 proxy to send every API " +
+			"request of the run through, such as http://proxy:3128. The TLS " +
+			"settings of the command still apply to the connection to the " +
+			"server. Defaults to the proxy set by the HTTPS_PROXY " +
+			"environment variable, if any.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "insecure",
+		Target:  &c.cfg.Insecure,
+		Default: false,
+		Usage: "Disable verification of the server's TLS certificate for the " +
+			"debug run only, such as for nodes with self-signed certificates " +
+			"during bring-up. Unlike -tls-skip-verify, the client used by " +
+			"other commands is left untouched. This is recorded in the index " +
+			"file. Using this option is highly discouraged.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "pprof-every-frame",
+		Target:  &c.cfg.PprofEveryFrame,
+		Default: false,
+		Usage: "Toggles whether to capture heap and goroutine profiles on " +
+			"every frame instead of only the first and last frames. The " +
+			"number of frames is bounded by -pprof-max-frames. This only " +
+			"applies if pprof is a target.",
+	})
+
+	f.IntVar(&IntVar{
+		Name:       "pprof-max-frames",
+		Target:     &c.cfg.PprofMaxFrames,
+		Completion: complete.PredictAnything,
+		Default:    10,
+		Usage: "Maximum number of frames on which heap and goroutine " +
+			"profiles are captured when -pprof-every-frame is set. A value " +
+			"of 0 removes the limit.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "pprof-profiles",
+		Target:     &c.cfg.PprofProfiles,
+		Completion: complete.PredictSet(debugPprofProfiles...),
+		Usage: "Comma-separated list of pprof profiles to capture, defaulting " +
+			"to all. Valid profiles are: " + strings.Join(debugPprofProfiles, ", ") +
+			". This only applies if pprof is a target.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "pprof-trace-duration",
+		Target:     &c.cfg.PprofTraceDuration,
+		Completion: complete.PredictAnything,
+		Usage: "Duration of the execution trace captured on each frame, " +
+			"independent of the interval and the CPU profile duration. If a " +
+			"trace is still being captured when the next frame starts, that " +
+			"frame's trace is skipped. Defaults to the interval.",
+	})
+
+	f.IntVar(&IntVar{
+		Name:       "pprof-ring",
+		Target:     &c.cfg.PprofRing,
+		Completion: complete.PredictAnything,
+		Usage: "Retains the pprof output of only the most recent N frames, " +
+			"removing the output of older frames as the capture progresses. " +
+			"A value of 0 retains the output of every frame. This only " +
+			"applies if pprof is a target.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "redact",
+		Target:  &c.cfg.Redact,
+		Default: false,
+		Usage: "Redacts well-known sensitive values from the captured files " +
+			"before they are archived: cluster names and IDs, hostnames, " +
+			"addresses, and IP addresses from the server status, host " +
+			"information, and configuration, token and mount accessors from " +
+			"every file, and the license IDs from the license status. Rules " +
+			"of -redact-file are applied along with these.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "redact-file",
+		Target:     &c.cfg.RedactFile,
+		Completion: complete.PredictFiles("*"),
+		Usage: "Path to a JSON or YAML file listing the paths of values to " +
+			"redact from the captured JSON files, such as " +
+			"\"metrics.Gauges[*].Name\", each with an optional regular " +
+			"expression that values must match to be redacted.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "resume",
+		Target:     &c.cfg.Resume,
+		Completion: complete.PredictDirs("*"),
+		Usage: "Path to an existing directory bundle to append the capture " +
+			"to, such as one left behind by an interrupted run. Frames are " +
+			"numbered after those of the bundle, and the new captures and " +
+			"errors are merged into its index file, which is regenerated. " +
+			"The bundle is written in place and left as a directory.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "force",
+		Target:  &c.cfg.Force,
+		Default: false,
+		Usage: "Toggles whether to resume a bundle even if its targets differ " +
+			"from the targets of the run. This only applies if -resume is set.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "rotate",
+		Target:     &c.cfg.Rotate,
+		Completion: complete.PredictAnything,
+		Usage: "Splits the capture into time windows of the given length, " +
+			"finalizing the archive of each window before starting the " +
+			"next. Archives are suffixed with the window number, and each " +
+			"contains its own index file. This requires compression to be " +
+			"enabled.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "sign-key",
+		Target:     &c.cfg.SignKey,
+		Completion: complete.PredictFiles("*"),
+		Usage: "Path to a PEM-encoded ed25519 private key used to sign the " +
+			"archive. The signature is written next to the archive with a " +
+			".sig extension, and the fingerprint of the public key is " +
+			"recorded in the index file. This requires compression to be " +
+			"enabled.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "skip-polling-profiles",
+		Target:  &c.cfg.SkipPollingProfiles,
+		Default: false,
+		Usage: "Toggles whether to skip the CPU profile and execution trace, " +
+			"which each block for the length of an interval, while still " +
+			"capturing the heap and goroutine profiles. This only applies if " +
+			"pprof is a target.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "timestamp-dirs",
+		Target:  &c.cfg.TimestampDirs,
+		Default: false,
+		Usage: "Toggles whether to name each frame directory after the time " +
+			"the frame was captured instead of its sequence number. The " +
+			"mapping of frames to directories is recorded in the index file.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "timings-csv",
+		Target:  &c.cfg.TimingsCSV,
+		Default: false,
+		Usage: "Toggles whether to also write the request timings to a " +
+			"request_timings.csv file, with one row per API request, for " +
+			"analysis in a spreadsheet.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "tmp-dir",
+		Target:     &c.cfg.TmpDir,
+		Completion: complete.PredictDirs("*"),
+		Usage: "Directory to stage the output in before it is streamed into " +
+			"a named pipe passed with -output. Defaults to the TMPDIR " +
+			"environment variable if set, and to the parent directory of " +
+			"the output otherwise.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "upload-url",
+		Target:     &c.cfg.UploadURL,
+		Completion: complete.PredictAnything,
+		Usage: "HTTP, HTTPS, S3, GCS, or Azure URL to upload the bundle to " +
+			"once it has been archived, such as \"s3://bucket/key\", " +
+			"\"gcs://bucket/name\", or \"azure://container/name\". The " +
+			"local bundle is preserved if the upload fails.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "upload-method",
+		Target:     &c.cfg.UploadMethod,
+		Default:    http.MethodPut,
+		Completion: complete.PredictSet(http.MethodPut, http.MethodPost),
+		Usage:      "HTTP method used to upload the bundle, either PUT or POST.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "upload-part-size",
+		Target:     &c.cfg.UploadPartSize,
+		Default:    "64MiB",
+		Completion: complete.PredictAnything,
+		Usage: "Size of the parts the bundle is uploaded in when -upload-url " +
+			"is an S3, GCS, or Azure URL, such as \"64MiB\". Each part is " +
+			"retried on its own if it fails. Must be at least 5MiB, and at " +
+			"most 100MiB for Azure.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "upload-remove",
+		Target:  &c.cfg.UploadRemove,
+		Default: false,
+		Usage: "Removes the local bundle once it has been uploaded to " +
+			"-upload-url and verified, for hosts without the disk space to " +
+			"keep it. The bundle is kept if the upload fails.",
+	})
+
+	f.StringMapVar(&StringMapVar{
+		Name:       "upload-header",
+		Target:     &c.cfg.UploadHeaders,
+		Completion: complete.PredictAnything,
+		Usage: "Header to set on the upload request, such as an " +
+			"authorization token, in the format of key=value. This can be " +
+			"specified multiple times.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "extend-on",
+		Target:     &c.cfg.ExtendOn,
+		Completion: complete.PredictAnything,
+		Usage: "Condition that extends the capture by -extend-by when it is " +
+			"observed on any frame of the capture, such as " +
+			"metric.vault.runtime.num_goroutines>10000. The capture keeps " +
+			"being extended for as long as the condition is observed, up to " +
+			"-max-duration. Conditions use the same format as -wait-until.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "extend-by",
+		Target:     &c.cfg.ExtendBy,
+		Completion: complete.PredictAnything,
+		Usage: "Amount of time to extend the capture by when the -extend-on " +
+			"condition is observed. Defaults to the duration.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "max-duration",
+		Target:     &c.cfg.MaxDuration,
+		Completion: complete.PredictAnything,
+		Usage: "Maximum total duration of a capture extended with -extend-on, " +
+			"which is required with -extend-on.",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "validate-index",
+		Target:  &c.cfg.ValidateIndex,
+		Default: false,
+		Usage: "Toggles whether to validate the index file against its JSON " +
+			"schema before it is written, failing the run if it does not " +
+			"conform.",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "wait-until",
+		Target:     &c.cfg.WaitUntil,
+		Completion: complete.PredictAnything,
+		Usage: "Condition that must be met before the capture begins, such " +
+			"as sealed==true. Fields refer to the health endpoint, or to a " +
+			"gauge reported by the metrics endpoint when prefixed with " +
+			"\"metric.\", such as metric.vault.runtime.num_goroutines>1000. " +
+			"Supported operators are ==, !=, >, >=, <, and <=.",
+	})
+
+	f.DurationVar(&DurationVar{
+		Name:       "wait-timeout",
+		Target:     &c.cfg.WaitTimeout,
+		Completion: complete.PredictAnything,
+		Usage: "Maximum amount of time to wait for the -wait-until condition " +
+			"before starting the capture regardless. Defaults to waiting " +
+			"indefinitely.",
+	})
+
+	return set
+}
+
+func (c *DebugCommand) Help() string {
+	helpText := `
+Usage: vault debug [options]
+
+  Probes a specific Vault server node for a specified period of time, recording
+  information about the node, its cluster, and its host environment. The
+  information collected is packaged and written to the specified path.
+
+  Certain endpoints that this command uses require ACL permissions to access.
+  If not permitted, the information from these endpoints will not be part of
+  the output. The command uses the Vault address and token as specified via
+  the login command, environment variables, or CLI flags.
+
+  To create a debug package using default duration and interval values in the
+  current directory that captures all applicable targets:
+
+      $ vault debug
+
+  To create a debug package with a specific duration and interval in the
+  current directory that captures all applicable targets:
+
+      $ vault debug -duration=10m -interval=1m
+
+  To create a debug package in the current directory with a specific sub-set
+  of targets:
+
+      $ vault debug -target=host -target=metrics
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *DebugCommand) Synopsis() string {
+	return "Runs the debug command"
+}
+
+func (c *DebugCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	parsedArgs := f.Args()
+	if len(parsedArgs) > 0 {
+		c.UI.Error(fmt.Sprintf("Too many arguments (expected 0, got %d)", len(parsedArgs)))
+		return 1
+	}
+
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == "duration" {
+			c.durationSet = true
+		}
+	})
+
+	if c.flagListTargets {
+		if err := c.listTargets(); err != nil {
+			c.UI.Error(fmt.Sprintf("Error listing targets: %s", err))
+			return 1
+		}
+		return 0
+	}
+
+	if c.flagQuiet && c.flagJSON {
+		c.UI.Error("The -quiet and -json flags cannot be used together")
+		return 1
+	}
+
+	// With -json, only the final summary is written to stdout. Warnings and
+	// errors are still written to stderr. With -quiet, warnings are also
+	// suppressed.
+	jsonUI := c.UI
+	if (c.flagJSON || c.flagQuiet) && !c.flagDryRun {
+		c.UI = &debugQuietUi{Ui: c.UI, silenceWarnings: c.flagQuiet}
+		defer func() { c.UI = jsonUI }()
+	}
+
+	if c.flagPreset != "" {
+		if err := c.applyPreset(f); err != nil {
+			c.UI.Error(fmt.Sprintf("Error applying preset: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagConfig != "" {
+		if err := c.applyConfigFile(f); err != nil {
+			c.UI.Error(fmt.Sprintf("Error loading configuration from %s: %s", c.flagConfig, err))
+			return 1
+		}
+	}
+
+	bundle, err := c.execute(c.cfg, args)
+	if err != nil {
+		c.UI.Error(err.Error())
+	}
+	if c.flagJSON && bundle != nil {
+		if err := c.printSummary(jsonUI); err != nil {
+			jsonUI.Error(fmt.Sprintf("Error printing summary: %s", err))
+			return 1
+		}
+	}
+	return debugExitCode(err)
+}
+
+// execute runs a capture with the given configuration and returns the
+// resulting bundle. No bundle is returned if the capture never started, such
+// as on a validation error or with -dry-run, while a bundle is returned along
+// with the error if the capture failed after it started.
+func (c *DebugCommand) execute(cfg CaptureConfig, rawArgs []string) (*Bundle, error) {
+	dstOutputFile, err := c.preflight(cfg, rawArgs)
+	if err != nil {
+		return nil, fmt.Errorf("error during validation: %s", err)
+	}
+
+	// Print debug information
+	c.UI.Output("==> Starting debug capture...")
+	c.UI.Info(fmt.Sprintf("         Vault Address: %s", c.debugIndex.VaultAddress))
+	if c.debugIndex.ActiveAddress != "" {
+		c.UI.Info(fmt.Sprintf("        Active Address: %s", c.debugIndex.ActiveAddress))
+	}
+	c.UI.Info(fmt.Sprintf("              Duration: %s", c.cfg.Duration))
+	if c.cfg.Count > 0 {
+		c.UI.Info(fmt.Sprintf("                 Count: %d", c.cfg.Count))
+	}
+	c.UI.Info(fmt.Sprintf("              Interval: %s", c.cfg.Interval))
+	c.UI.Info(fmt.Sprintf("      Metrics Interval: %s", c.cfg.MetricsInterval))
+	c.UI.Info(fmt.Sprintf("        Target Timeout: %s", c.cfg.TargetTimeout))
+	if c.cfg.RateLimit > 0 {
+		c.UI.Info(fmt.Sprintf("            Rate Limit: %g requests/s", c.cfg.RateLimit))
+	}
+	if c.cfg.Rotate > 0 {
+		c.UI.Info(fmt.Sprintf("                Rotate: %s", c.cfg.Rotate))
+	}
+	c.UI.Info(fmt.Sprintf("               Targets: %s", strings.Join(c.cfg.Targets, ", ")))
+	for _, cluster := range c.clusters {
+		if c.cfg.RaftPeers {
+			c.UI.Info(fmt.Sprintf("             Raft Peer: %s (%s)", cluster.name, cluster.client.Address()))
+			continue
+		}
+		c.UI.Info(fmt.Sprintf("               Cluster: %s (%s)", cluster.name, cluster.client.Address()))
+	}
+	if c.waitCondition != nil {
+		c.UI.Info(fmt.Sprintf("            Wait Until: %s", c.waitCondition))
+	}
+	if c.extendCondition != nil {
+		c.UI.Info(fmt.Sprintf("             Extend On: %s (by %s, up to %s)", c.extendCondition, c.cfg.ExtendBy, c.cfg.MaxDuration))
+	}
+	c.UI.Info(fmt.Sprintf("                Output: %s", dstOutputFile))
+	c.UI.Output("")
+
+	if c.flagDryRun {
+		c.printPlan()
+		return nil, nil
+	}
+
+	if c.waitCondition != nil {
+		c.UI.Output(fmt.Sprintf("==> Waiting until %s...", c.waitCondition))
+		met, proceed := c.waitForCondition()
+		if !proceed {
+			// Only removes the output directory if nothing was written to it
+			os.Remove(c.cfg.Output)
+			if c.stagingDir != "" {
+				os.RemoveAll(c.stagingDir)
+			}
+			return nil, errors.New("interrupted while waiting for the condition, no data was captured")
+		}
+
+		c.debugIndex.WaitUntil = c.waitCondition.String()
+		c.debugIndex.WaitResult = "met"
+		if met {
+			c.UI.Info("Condition met, starting capture")
+		} else {
+			c.debugIndex.WaitResult = "timeout"
+			c.UI.Warn(fmt.Sprintf("Condition not met within %s, starting capture", c.cfg.WaitTimeout))
+		}
+		c.debugIndex.Timestamp = time.Now().UTC()
+		c.UI.Output("")
+	}
+	if c.extendCondition != nil {
+		c.debugIndex.ExtendOn = c.extendCondition.String()
+	}
+
+	if c.cfg.LogFile != "" {
+		runLog, err := newDebugRunLog(c.cfg.LogFile)
+		if err != nil {
+			// Only removes the output directory if nothing was written to it
+			os.Remove(c.cfg.Output)
+			if c.stagingDir != "" {
+				os.RemoveAll(c.stagingDir)
+			}
+			return nil, fmt.Errorf("error creating log file: %s", err)
+		}
+		c.runLog = runLog
+		defer func() {
+			c.runLog.Close()
+			c.runLog = nil
+		}()
+	}
+	c.runLog.log("run_start", map[string]interface{}{
+		"targets":          c.cfg.Targets,
+		"duration_seconds": c.cfg.Duration.Seconds(),
+		"output":           dstOutputFile,
+	})
+
+	start := time.Now()
+	err = c.run(dstOutputFile)
+	c.printReport(time.Since(start))
+	c.runLog.log("run_end", map[string]interface{}{
+		"exit_code":   debugExitCode(err),
+		"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+	})
+
+	bundle := &Bundle{
+		Path:  dstOutputFile,
+		Index: c.debugIndex,
+	}
+	// The output of a short capture is left as a directory with
+	// -output-compress-after
+	if c.cfg.OutputCompressAfter > 0 && !c.debugIndex.Compress {
+		bundle.Path = c.cfg.Output
+	}
+	if c.cfg.Rotate > 0 {
+		bundle.Path = c.summary.OutputPath
+		bundle.Windows = c.summary.Bundles
+	}
+	return bundle, err
+}
+
+// run captures all targets into the given output file, splitting the capture
+// into windows if -rotate is set.
+func (c *DebugCommand) run(dstOutputFile string) error {
+	if c.cfg.Rotate <= 0 {
+		if err := c.capture(c.cfg.Duration, dstOutputFile); err != nil {
+			return err
+		}
+		return c.captureErr()
+	}
+
+	// When rotating, the output path serves as the base name for each window
+	// and every window is captured and bundled on its own.
+	baseOutput := c.cfg.Output
+	c.summary.OutputPath = baseOutput
+	ext := strings.TrimPrefix(dstOutputFile, baseOutput)
+	baseIndex := *c.debugIndex
+
+	windows := int(math.Ceil(float64(c.cfg.Duration) / float64(c.cfg.Rotate)))
+	for w := 0; w < windows; w++ {
+		// Stop starting new windows once an interrupt has been received
+		select {
+		case <-c.ShutdownCh:
+			return c.captureErr()
+		default:
+		}
+
+		duration := c.cfg.Rotate
+		if remaining := c.cfg.Duration - time.Duration(w)*c.cfg.Rotate; remaining < duration {
+			duration = remaining
+		}
+
+		c.cfg.Output = fmt.Sprintf("%s-%03d", baseOutput, w)
+		windowOutputFile := c.cfg.Output + ext
+		if _, err := os.Stat(windowOutputFile); err == nil {
+			return fmt.Errorf("error creating window %d: output file already exists: %s", w, windowOutputFile)
+		}
+		if err := createOutputDir(c.cfg.Output); err != nil {
+			return fmt.Errorf("error creating window %d: %s", w, err)
+		}
+
+		// Scope the index to the current window
+		windowIndex := baseIndex
+		windowIndex.Timestamp = time.Now().UTC()
+		windowIndex.DurationSeconds = int(duration.Seconds())
+		windowIndex.Errors = []CaptureError{}
+		windowIndex.Frames = []DebugFrame{}
+		c.debugIndex = &windowIndex
+
+		c.UI.Info(fmt.Sprintf("==> Capturing window %d of %d...", w+1, windows))
+		if err := c.capture(duration, windowOutputFile); err != nil {
+			return err
+		}
+		c.UI.Output("")
+	}
+
+	return c.captureErr()
+}
+
+// captureErr returns the error for a run that completed, which reflects
+// whether any data was captured at all.
+func (c *DebugCommand) captureErr() error {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+
+	if c.captureCount == 0 {
+		return &debugFailedError{err: errors.New("every capture target failed, see the index file for details")}
+	}
+	return nil
+}
+
+// debugFailedError is an error that ended a run in which no data could be
+// captured, for which the command exits with 2 rather than 1.
+type debugFailedError struct {
+	err error
+}
+
+func (e *debugFailedError) Error() string {
+	return e.err.Error()
+}
+
+// debugExitCode returns the exit code of the command for the error that
+// ended a run, if any.
+func debugExitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return 0
+	case *debugFailedError:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// debugEnvTargets returns the targets set with VAULT_DEBUG_TARGETS, if any.
+func debugEnvTargets() []string {
+	var targets []string
+	for _, target := range strings.Split(os.Getenv(EnvVaultDebugTargets), ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// listTargets prints every registered target along with its description
+// and whether it is captured when no target is specified, which takes
+// VAULT_DEBUG_TARGETS into account.
+func (c *DebugCommand) listTargets() error {
+	defaults := debugEnvTargets()
+	if len(defaults) == 0 {
+		defaults = debugDefaultTargets()
+	}
+
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Target\tDefault\tDescription\n")
+	for _, target := range debugTargetNames() {
+		included := "no"
+		if strutil.StrListContains(defaults, target) {
+			included = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", target, included, debugTargetRegistry[target].description)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	c.UI.Output(strings.TrimSuffix(b.String(), "\n"))
+	return nil
+}
+
+// printPlan prints the number of frames and the files that a capture with the
+// current settings would produce.
+func (c *DebugCommand) printPlan() {
+	frames, metricsFrames := c.plannedFrames()
+
+	c.UI.Output("==> Dry run, no data will be captured")
+	c.UI.Info(fmt.Sprintf("                Frames: %d", frames))
+	if strutil.StrListContains(c.cfg.Targets, "metrics") || len(c.customPaths) > 0 || len(c.mountHealth) > 0 {
+		c.UI.Info(fmt.Sprintf("      Metrics Captures: %d", metricsFrames))
+	}
+	c.UI.Info("         Planned Files:")
+	for _, file := range c.plannedFiles() {
+		c.UI.Info(fmt.Sprintf("           - %s", file))
+	}
+}
+
+// plannedFrames returns the number of polling frames and metrics captures
+// over the whole run, taking rotation windows into account.
+func (c *DebugCommand) plannedFrames() (int, int) {
+	if c.cfg.Rotate <= 0 {
+		return frameCount(c.cfg.Duration, c.cfg.Interval), frameCount(c.cfg.Duration, c.cfg.MetricsInterval)
+	}
+
+	var frames, metricsFrames int
+	for remaining := c.cfg.Duration; remaining > 0; remaining -= c.cfg.Rotate {
+		duration := c.cfg.Rotate
+		if remaining < duration {
+			duration = remaining
+		}
+		frames += frameCount(duration, c.cfg.Interval)
+		metricsFrames += frameCount(duration, c.cfg.MetricsInterval)
+	}
+	return frames, metricsFrames
+}
+
+// plannedFiles returns the estimated list of files in each bundle, relative to
+// the bundle root. Per-frame files are listed once under a <frame> directory.
+func (c *DebugCommand) plannedFiles() []string {
+	staticFiles := map[string][]string{
+		"auth":       {"auth.json"},
+		"clock-skew": {"clock_skew.json"},
+		"config":     {"config.json"},
+		"entropy":    {"sealwrap_status.json"},
+		"license":    {"license_status.json"},
+		"metrics":    {"metrics/<index>.json"},
+		"mounts":     {"mounts.json"},
+		"openapi":    {"openapi.json"},
+		"plugins":    {"plugins.json"},
+		"policies":   {"policies.json"},
+		"quotas":     {"quota_config.json", "rate_limit_quotas.json"},
+		"self":       {"token_self.json"},
+		"storage":    {"storage.json"},
+
+		"audit-probe":        {"audit_probe.json"},
+		"raft-snapshot-info": {"raft_snapshot_info.json"},
+		"seal-timing":        {"seal_timing.json"},
+		"hot-paths":          {"hot_paths.json"},
+		"log":                {"vault.log"},
+	}
+	if c.cfg.IncludePolicyBodies {
+		staticFiles["policies"] = append(staticFiles["policies"], "policies/<name>.hcl")
+	}
+	if c.cfg.PrometheusMetrics {
+		staticFiles["metrics"] = append(staticFiles["metrics"], "metrics_prometheus/<index>.prom")
+	}
+
+	frameFiles := map[string][]string{
+		"audit-status":       {"audit_status.json"},
+		"counters":           {"counters_activity.json", "counters_tokens.json"},
+		"goroutine-count":    {},
+		"ha-lock":            {"ha_lock.json"},
+		"health":             {"health.json"},
+		"host":               {"host_info.json"},
+		"leases":             {"leases.json"},
+		"pprof":              {},
+		"replication-perf":   {"replication_performance.json", "replication_dr.json"},
+		"replication-status": {"replication_status.json"},
+		"requests":           {"requests.json"},
+		"server-status":      {"server_status.json"},
+	}
+	profileExt := ".prof"
+	if c.cfg.CompressProfiles {
+		profileExt = ".prof.gz"
+	}
+	for _, name := range []string{"block", "goroutine", "heap", "mutex"} {
+		if c.pprofProfile(name) {
+			frameFiles["pprof"] = append(frameFiles["pprof"], name+profileExt)
+		}
+	}
+	if c.cfg.GoroutineDump && c.pprofProfile("goroutine") {
+		frameFiles["pprof"] = append(frameFiles["pprof"], "goroutines.txt")
+	}
+	if !c.cfg.SkipPollingProfiles {
+		if c.pprofProfile("profile") {
+			frameFiles["pprof"] = append(frameFiles["pprof"], "profile"+profileExt)
+		}
+		if c.pprofProfile("trace") {
+			frameFiles["pprof"] = append(frameFiles["pprof"], "trace.out")
+		}
+	}
+
+	captured := []string{"request_timings.json"}
+	if c.cfg.LogInBundle {
+		captured = append(captured, "capture_log.jsonl")
+	}
+	if c.cfg.TimingsCSV {
+		captured = append(captured, "request_timings.csv")
+	}
+	if c.cfg.MetricsCSV {
+		captured = append(captured, "metrics.csv")
+	}
+	if c.cfg.MetricsDelta {
+		captured = append(captured, "metrics_delta.json")
+	}
+	if strutil.StrListContains(c.cfg.Targets, "goroutine-count") {
+		captured = append(captured, "goroutine_counts.json")
+	}
+	if strutil.StrListContains(c.cfg.Targets, "replication-status") {
+		captured = append(captured, "replication_progress.json")
+	}
+	for _, custom := range append(append([]*DebugCustomPath{}, c.customPaths...), c.mountHealth...) {
+		captured = append(captured, custom.capturePath("<index>"))
+	}
+	for _, target := range c.cfg.Targets {
+		captured = append(captured, staticFiles[target]...)
+		for _, file := range frameFiles[target] {
+			captured = append(captured, "<frame>/"+file)
+		}
+	}
+	if c.cfg.Format == "jsonl" {
+		for _, file := range captured {
+			if stream, ok := debugStreamPath(file); ok {
+				captured = append(captured, stream)
+			}
+		}
+	}
+
+	// With -cluster, the captured files are written once per cluster
+	files := []string{"README.txt", "index.json"}
+	if len(c.clusters) == 0 {
+		files = append(files, captured...)
+	}
+	for _, cluster := range c.clusters {
+		for _, file := range captured {
+			files = append(files, path.Join("clusters", cluster.name, file))
+		}
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// capture captures all targets over the given duration, then generates the
+// index file and bundles the output directory into dstOutputFile.
+func (c *DebugCommand) capture(duration time.Duration, dstOutputFile string) error {
+	c.pprofLock.Lock()
+	c.pprofRing = nil
+	c.pprofLock.Unlock()
+	start := time.Now()
+
+	// Bound the whole capture by the run deadline so that a wedged request
+	// can't keep the command running past the duration and grace period.
+	// An extended capture can run for up to the maximum duration instead.
+	runLength := duration
+	if c.extendCondition != nil {
+		runLength = c.cfg.MaxDuration
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runLength+c.cfg.Grace)
+	defer cancel()
+
+	// Cancel the run early on interrupt
+	go func() {
+		select {
+		case <-c.ShutdownCh:
+			c.UI.Info("==> Caught interrupt, finishing up...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var err error
+	if len(c.clusters) > 0 {
+		err = c.captureClusters(ctx, duration)
+	} else {
+		err = c.captureTargets(ctx, duration)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.UI.Output("Finished capturing information, bundling files...")
+
+	// The run log is included as it stands once the targets are captured
+	if c.cfg.LogInBundle {
+		content, err := ioutil.ReadFile(c.cfg.LogFile)
+		if err == nil {
+			err = c.writeFile("capture_log.jsonl", content)
+		}
+		if err != nil {
+			return fmt.Errorf("error including the log in the bundle: %s", err)
+		}
+	}
+
+	// Short captures are left as a directory, which the index has to reflect
+	compress := c.cfg.Compress
+	if compress && c.cfg.OutputCompressAfter > 0 && time.Since(start) < c.cfg.OutputCompressAfter {
+		c.UI.Info(fmt.Sprintf("Capture ran for less than %s, leaving the output as a directory", c.cfg.OutputCompressAfter))
+		compress = false
+		dstOutputFile = c.cfg.Output
+		c.debugIndex.Compress = false
+	}
+
+	// The index is redacted before the README is derived from it
+	if c.redactor != nil {
+		if _, err := c.redactor.redactIndex(c.debugIndex); err != nil {
+			return fmt.Errorf("error redacting index: %s", err)
+		}
+	}
+
+	if err := c.writeReadme(); err != nil {
+		return fmt.Errorf("error writing README: %s", err)
+	}
+
+	if c.anonymizer != nil {
+		if err := c.anonymizer.anonymizeDir(c.cfg.Output); err != nil {
+			return fmt.Errorf("error anonymizing output: %s", err)
+		}
+	}
+
+	// Generate index file
+	if err := c.generateIndex(); err != nil {
+		return fmt.Errorf("error generating index: %s", err)
+	}
+
+	if compress {
+		if err := c.compress(dstOutputFile); err != nil {
+			// We want to inform that data collection was captured and stored in
+			// a directory even if compression fails
+			c.UI.Info(fmt.Sprintf("Data written to: %s", c.cfg.Output))
+			return fmt.Errorf("error encountered during bundle compression: %s", err)
+		}
+	}
+
+	c.recordBundle(dstOutputFile)
+
+	c.UI.Info(fmt.Sprintf("Success! Bundle written to: %s", dstOutputFile))
+	if compress && c.cfg.KeepDir {
+		c.UI.Info(fmt.Sprintf("Directory kept at: %s", c.cfg.Output))
+	}
+
+	if c.signingKey != nil {
+		sigPath, err := c.signBundle(dstOutputFile)
+		if err != nil {
+			return fmt.Errorf("error signing bundle: %s", err)
+		}
+		c.UI.Info(fmt.Sprintf("Signature written to: %s", sigPath))
+	}
+
+	if c.cfg.UploadURL != "" {
+		c.UI.Info(fmt.Sprintf("Uploading bundle to: %s", c.cfg.UploadURL))
+		if err := c.uploadBundle(dstOutputFile); err != nil {
+			c.UI.Info(fmt.Sprintf("Bundle preserved at: %s", dstOutputFile))
+			return fmt.Errorf("error uploading bundle: %s", err)
+		}
+		c.UI.Info("Success! Bundle uploaded")
+
+		if c.cfg.UploadRemove {
+			if err := os.Remove(dstOutputFile); err != nil {
+				return fmt.Errorf("error removing uploaded bundle: %s", err)
+			}
+			c.UI.Info(fmt.Sprintf("Removed local bundle: %s", dstOutputFile))
+		}
+	}
+
+	return nil
+}
+
+// captureTargets captures the static and polling targets into the output
+// directory, redacts them if -redact or -redact-file is set, then writes the
+// files derived from the captured data, such as the request timings.
+func (c *DebugCommand) captureTargets(ctx context.Context, duration time.Duration) error {
+	// Capture static information
+	c.UI.Info("==> Capturing static information...")
+	if err := c.captureStaticTargets(ctx); err != nil {
+		return &debugFailedError{err: fmt.Errorf("error capturing static information: %s", err)}
+	}
+
+	c.UI.Output("")
+
+	// Capture polling information
+	c.UI.Info("==> Capturing dynamic information...")
+	if err := c.capturePollingTargets(ctx, duration); err != nil {
+		return &debugFailedError{err: fmt.Errorf("error capturing dynamic information: %s", err)}
+	}
+	if c.extendCondition != nil {
+		if err := c.extendCapture(ctx, duration); err != nil {
+			return &debugFailedError{err: fmt.Errorf("error capturing dynamic information: %s", err)}
+		}
+	}
+
+	if c.cfg.PprofRing > 0 {
+		c.pprofLock.Lock()
+		c.debugIndex.PprofFrames = []int{}
+		for _, frame := range c.pprofRing {
+			c.debugIndex.PprofFrames = append(c.debugIndex.PprofFrames, frame.Frame)
+		}
+		c.pprofLock.Unlock()
+	}
+
+	// Write out request timings
+	if err := c.writeRequestTimings(); err != nil {
+		return fmt.Errorf("error writing request timings: %s", err)
+	}
+
+	if strutil.StrListContains(c.cfg.Targets, "goroutine-count") {
+		if err := c.writeGoroutineCounts(); err != nil {
+			return fmt.Errorf("error writing goroutine counts: %s", err)
+		}
+	}
+
+	// Redaction is applied before the metrics CSV is derived from the
+	// captured metrics, so that redacted values don't end up in it
+	if c.redactor != nil {
+		count, err := c.redactor.redactDir(c.cfg.Output)
+		if err != nil {
+			return fmt.Errorf("error redacting output: %s", err)
+		}
+		rules := "the rules in " + c.cfg.RedactFile
+		switch {
+		case c.cfg.Redact && c.cfg.RedactFile != "":
+			rules = "the default rules and " + rules
+		case c.cfg.Redact:
+			rules = "the default rules"
+		}
+		c.UI.Info(fmt.Sprintf("Redacted %d value(s) matching %s", count, rules))
+	}
+
+	if c.cfg.MetricsCSV {
+		if err := c.writeMetricsCSV(); err != nil {
+			return fmt.Errorf("error writing metrics CSV: %s", err)
+		}
+	}
+
+	if c.cfg.MetricsDelta {
+		if err := c.writeMetricsDelta(); err != nil {
+			return fmt.Errorf("error writing metrics deltas: %s", err)
+		}
+	}
+
+	for _, target := range c.selectedTargets(debugTargetRollup) {
+		c.runRegisteredCapture(ctx, target, nil)
+	}
+
+	if strutil.StrListContains(c.cfg.Targets, "replication-status") {
+		if err := c.writeReplicationProgress(); err != nil {
+			return fmt.Errorf("error writing replication progress: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// recordBundle adds the bundle written by a capture to the run summary.
+func (c *DebugCommand) recordBundle(path string) {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+
+	size, err := bundleSize(path)
+	if err != nil {
+		c.UI.Warn(fmt.Sprintf("Error determining the size of %s: %s", path, err))
+	}
+
+	c.summary.Bundles = append(c.summary.Bundles, path)
+	c.summary.Frames += len(c.debugIndex.Frames)
+	c.summary.Bytes += size
+	c.summary.Errors = append(c.summary.Errors, c.debugIndex.Errors...)
+	for _, cluster := range c.debugIndex.Clusters {
+		c.summary.Frames += len(cluster.Frames)
+		c.summary.Errors = append(c.summary.Errors, cluster.Errors...)
+	}
+}
+
+// printReport prints a one-line wrap-up of the run. It is written to stderr
+// so that it never interferes with the -json summary on stdout.
+func (c *DebugCommand) printReport(elapsed time.Duration) {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+
+	c.UI.Warn(fmt.Sprintf("Debug run finished in %s: %d frame(s), %d of %d target(s) captured, %d error(s), %d bytes on disk",
+		elapsed.Round(time.Millisecond), c.summary.Frames, len(c.capturedTargets), len(c.cfg.Targets), len(c.summary.Errors), c.summary.Bytes))
+}
+
+// printSummary prints the run summary as a single JSON object.
+func (c *DebugCommand) printSummary(ui cli.Ui) error {
+	summary := c.summary
+	summary.Duration = c.cfg.Duration.String()
+	summary.Targets = c.cfg.Targets
+	if summary.Errors == nil {
+		summary.Errors = []CaptureError{}
+	}
+
+	// A single bundle is reported as the output path, while rotated bundles
+	// are reported individually under the base output path.
+	if c.cfg.Rotate <= 0 && len(summary.Bundles) > 0 {
+		summary.OutputPath = summary.Bundles[0]
+		summary.Bundles = nil
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	ui.Output(string(data))
+	return nil
+}
+
+// bundleSize returns the size in bytes of the bundle at the given path, which
+// is either an archive or a directory.
+func bundleSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// applyConfigFile loads the configuration file and applies its values to any
+// flags that were not explicitly provided on the command line.
+func (c *DebugCommand) applyConfigFile(f *FlagSets) error {
+	file, err := os.Open(c.flagConfig)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var config debugConfig
+	dec := json.NewDecoder(file)
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return fmt.Errorf("failed to parse configuration: %s", err)
+	}
+
+	setFlags := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) {
+		setFlags[fl.Name] = true
+	})
+
+	if config.Targets != nil && !setFlags["target"] {
+		c.cfg.Targets = config.Targets
+	}
+	if config.Compress != nil && !setFlags["compress"] {
+		c.cfg.Compress = *config.Compress
+	}
+	if config.Redact != nil && !setFlags["redact"] {
+		c.cfg.Redact = *config.Redact
+	}
+	if config.RedactFile != nil && !setFlags["redact-file"] {
+		c.cfg.RedactFile = *config.RedactFile
+	}
+
+	durations := []struct {
+		name   string
+		value  interface{}
+		target *time.Duration
+	}{
+		{"duration", config.Duration, &c.cfg.Duration},
+		{"interval", config.Interval, &c.cfg.Interval},
+		{"metrics-interval", config.MetricsInterval, &c.cfg.MetricsInterval},
+	}
+	for _, d := range durations {
+		if d.value == nil || setFlags[d.name] {
+			continue
+		}
+
+		dur, err := parseutil.ParseDurationSecond(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s value: %s", strings.Replace(d.name, "-", "_", -1), err)
+		}
+		*d.target = dur
+	}
+
+	return nil
+}
+
+// preflight performs various checks against the provided configuration to
+// ensure it holds valid/reasonable values, then sets it as the configuration of
+// the command. It also takes care of instantiating a client and index object
+// for use by the command.
+func (c *DebugCommand) preflight(cfg CaptureConfig, rawArgs []string) (string, error) {
+	c.cfg = cfg
+
+	switch {
+	case c.cfg.Count < 0:
+		return "", fmt.Errorf("count must be a non-negative value")
+	case c.cfg.Count > 0 && c.durationSet:
+		return "", fmt.Errorf("count and duration cannot be set together")
+	}
+
+	if !c.cfg.skipTimingChecks {
+		// Guard duration and interval values to acceptable values. With
+		// -count, the duration is derived from the interval instead.
+		if c.cfg.Count == 0 && c.cfg.Duration < debugMinInterval {
+			c.UI.Info(fmt.Sprintf("Overwriting duration value %q to the minimum value of %q", c.cfg.Duration, debugMinInterval))
+			c.cfg.Duration = debugMinInterval
+		}
+		if c.cfg.Interval < debugMinInterval {
+			c.UI.Info(fmt.Sprintf("Overwriting interval value %q to the minimum value of %q", c.cfg.Interval, debugMinInterval))
+			c.cfg.Interval = debugMinInterval
+		}
+		if c.cfg.MetricsInterval < debugMinInterval {
+			c.UI.Info(fmt.Sprintf("Overwriting metrics interval value %q to the minimum value of %q", c.cfg.MetricsInterval, debugMinInterval))
+			c.cfg.MetricsInterval = debugMinInterval
+		}
+	}
+
+	// A count-based run captures exactly count frames spaced by the interval
+	if c.cfg.Count > 0 {
+		c.cfg.Duration = time.Duration(c.cfg.Count) * c.cfg.Interval
+	}
+
+	// These timing checks are always applicable since interval shouldn't be
+	// greater than the duration
+	if c.cfg.Interval > c.cfg.Duration {
+		c.UI.Info(fmt.Sprintf("Overwriting interval value %q to the duration value %q", c.cfg.Interval, c.cfg.Duration))
+		c.cfg.Interval = c.cfg.Duration
+	}
+	if c.cfg.MetricsInterval > c.cfg.Duration {
+		c.UI.Info(fmt.Sprintf("Overwriting metrics interval value %q to the duration value %q", c.cfg.MetricsInterval, c.cfg.Duration))
+		c.cfg.MetricsInterval = c.cfg.Duration
+	}
+
+	switch c.cfg.OutputFormat {
+	case "archive":
+	case "dir":
+		c.cfg.Compress = false
+	default:
+		return "", fmt.Errorf("invalid output format %q, must be one of: archive, dir", c.cfg.OutputFormat)
+	}
+
+	switch c.cfg.ArchiveFormat {
+	case "tgz", "tbz2", "txz", "zip", "tar":
+	case "none":
+		c.cfg.Compress = false
+	default:
+		return "", fmt.Errorf("invalid archive format %q, must be one of: tgz, tbz2, txz, zip, tar, none", c.cfg.ArchiveFormat)
+	}
+
+	// The compression selects the format of the tarball, so that the
+	// extension of the archive always matches its compression
+	if c.cfg.Compression != "" {
+		format, ok := debugTarCompressions[c.cfg.Compression]
+		if !ok {
+			return "", fmt.Errorf("invalid compression %q, must be one of: gzip, bzip2, xz, none", c.cfg.Compression)
+		}
+		if !debugTarFormats[c.cfg.ArchiveFormat] {
+			return "", fmt.Errorf("compression %s is not supported with archive format %s", c.cfg.Compression, c.cfg.ArchiveFormat)
+		}
+		c.cfg.ArchiveFormat = format
+	}
+
+	c.cfg.LogLevel = strings.ToLower(strings.TrimSpace(c.cfg.LogLevel))
+	switch c.cfg.LogLevel {
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		return "", fmt.Errorf("invalid log level %q, must be one of: trace, debug, info, warn, error", c.cfg.LogLevel)
+	}
+
+	if c.cfg.Format != "json" && c.cfg.Format != "jsonl" {
+		return "", fmt.Errorf("invalid format %q, must be one of: json, jsonl", c.cfg.Format)
+	}
+
+	// Similarly, intervals shouldn't be greater than a rotation window
+	if c.cfg.Rotate > 0 {
+		if !c.cfg.Compress {
+			return "", fmt.Errorf("rotate requires compression to be enabled and the archive output format")
+		}
+		if !c.cfg.skipTimingChecks && c.cfg.Rotate < debugMinInterval {
+			c.UI.Info(fmt.Sprintf("Overwriting rotate value %q to the minimum value of %q", c.cfg.Rotate, debugMinInterval))
+			c.cfg.Rotate = debugMinInterval
+		}
+		if c.cfg.Rotate > c.cfg.Duration {
+			c.UI.Info(fmt.Sprintf("Overwriting rotate value %q to the duration value %q", c.cfg.Rotate, c.cfg.Duration))
+			c.cfg.Rotate = c.cfg.Duration
+		}
+		if c.cfg.Interval > c.cfg.Rotate {
+			c.UI.Info(fmt.Sprintf("Overwriting interval value %q to the rotate value %q", c.cfg.Interval, c.cfg.Rotate))
+			c.cfg.Interval = c.cfg.Rotate
+		}
+		if c.cfg.MetricsInterval > c.cfg.Rotate {
+			c.UI.Info(fmt.Sprintf("Overwriting metrics interval value %q to the rotate value %q", c.cfg.MetricsInterval, c.cfg.Rotate))
+			c.cfg.MetricsInterval = c.cfg.Rotate
+		}
+	}
+
+	if c.cfg.TargetTimeout <= 0 {
+		return "", fmt.Errorf("target timeout must be a positive duration")
+	}
+
+	if c.cfg.Grace < 0 {
+		return "", fmt.Errorf("grace must be a non-negative duration")
+	}
+
+	if c.cfg.MaxConcurrentRequests <= 0 {
+		return "", fmt.Errorf("max concurrent requests must be greater than 0")
+	}
+	c.requestSem = make(chan struct{}, c.cfg.MaxConcurrentRequests)
+
+	if c.cfg.RateLimit < 0 {
+		return "", fmt.Errorf("rate limit must be a non-negative value")
+	}
+	if c.cfg.RateLimit > 0 {
+		c.limiter = rate.NewLimiter(rate.Limit(c.cfg.RateLimit), 1)
+	}
+
+	runLength := c.cfg.Duration
+	if c.cfg.Rotate > 0 {
+		runLength = c.cfg.Rotate
+	}
+	switch {
+	case c.cfg.PprofTraceDuration < 0:
+		return "", fmt.Errorf("pprof trace duration must be a non-negative duration")
+	case c.cfg.PprofTraceDuration > runLength:
+		return "", fmt.Errorf("pprof trace duration %q must not exceed the run length %q", c.cfg.PprofTraceDuration, runLength)
+	case c.cfg.PprofTraceDuration > 0 && c.cfg.SkipPollingProfiles:
+		return "", fmt.Errorf("pprof trace duration cannot be set when skipping polling profiles")
+	}
+
+	if c.cfg.PprofMaxFrames < 0 {
+		return "", fmt.Errorf("pprof max frames must be a non-negative value")
+	}
+
+	if c.cfg.PprofRing < 0 {
+		return "", fmt.Errorf("pprof ring must be a non-negative value")
+	}
+
+	c.pprofProfiles = nil
+	for _, name := range strings.Split(c.cfg.PprofProfiles, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !strutil.StrListContains(debugPprofProfiles, name) {
+			return "", fmt.Errorf("invalid pprof profile %q, must be one of: %s", name, strings.Join(debugPprofProfiles, ", "))
+		}
+		c.pprofProfiles = strutil.AppendIfMissing(c.pprofProfiles, name)
+	}
+
+	var resumeIndex *DebugIndex
+	if c.cfg.Resume != "" {
+		var err error
+		resumeIndex, err = c.readResumeIndex()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(c.cfg.Targets) == 0 {
+		c.cfg.Targets = debugEnvTargets()
+	}
+
+	// A resumed capture defaults to the targets of the bundle
+	if len(c.cfg.Targets) == 0 && resumeIndex != nil {
+		c.cfg.Targets = resumeIndex.Targets
+	}
+
+	if len(c.cfg.Targets) == 0 {
+		c.cfg.Targets = debugDefaultTargets()
+	}
+
+	for _, target := range c.cfg.Targets {
+		if _, ok := debugTargetRegistry[target]; !ok {
+			return "", fmt.Errorf("invalid target %q, must be one of: %s", target, strings.Join(debugTargetNames(), ", "))
+		}
+	}
+	c.cfg.Targets = strutil.RemoveDuplicatesStable(c.cfg.Targets, false)
+
+	if resumeIndex != nil && !c.cfg.Force && !strutil.EquivalentSlices(c.cfg.Targets, resumeIndex.Targets) {
+		return "", fmt.Errorf("targets %s do not match the targets %s of the bundle being resumed, use -force to resume anyway",
+			strings.Join(c.cfg.Targets, ", "), strings.Join(resumeIndex.Targets, ", "))
+	}
+
+	if (c.cfg.MetricsCSV || len(c.cfg.MetricsCSVKeys) > 0) && !strutil.StrListContains(c.cfg.Targets, "metrics") {
+		return "", fmt.Errorf("metrics-csv requires the metrics target")
+	}
+	if c.cfg.MetricsDelta && !strutil.StrListContains(c.cfg.Targets, "metrics") {
+		return "", fmt.Errorf("metrics-delta requires the metrics target")
+	}
+	if c.cfg.PrometheusMetrics && !strutil.StrListContains(c.cfg.Targets, "metrics") {
+		return "", fmt.Errorf("prometheus-metrics requires the metrics target")
+	}
+
+	customPaths, err := parseDebugPollPaths(c.cfg.PollPaths)
+	if err != nil {
+		return "", err
+	}
+	c.customPaths = customPaths
+
+	mountHealth, err := parseDebugMountHealth(c.cfg.MountHealth)
+	if err != nil {
+		return "", err
+	}
+	c.mountHealth = mountHealth
+
+	var outputTemplate *template.Template
+	if c.cfg.OutputTemplate != "" {
+		if c.cfg.Output != "" {
+			return "", fmt.Errorf("output-template cannot be used with output")
+		}
+		outputTemplate, err = parseDebugOutputTemplate(c.cfg.OutputTemplate)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	metadata, err := parseDebugMetadata(c.cfg.Metadata)
+	if err != nil {
+		return "", err
+	}
+
+	if c.cfg.Redact || c.cfg.RedactFile != "" {
+		redactor, err := newDebugRedactor(c.cfg.Redact, c.cfg.RedactFile)
+		if err != nil {
+			return "", fmt.Errorf("error loading redaction rules: %s", err)
+		}
+		c.redactor = redactor
+	}
+
+	// Check the free space before making any requests, so that a capture
+	// that won't fit fails fast
+	if c.cfg.MinFreeSpace != "" {
+		minFree, err := parseDebugSize(c.cfg.MinFreeSpace)
+		if err != nil {
+			return "", fmt.Errorf("invalid min free space: %s", err)
+		}
+		if err := checkFreeSpace(c.cfg.Output, c.cfg.TmpDir, minFree); err != nil {
+			return "", err
+		}
+	}
+
+	// Make sure we can talk to the server. A client passed to the command is
+	// used as is, whatever the address flags are set to.
+	var socket string
+	if c.client == nil {
+		socket, _ = debugUnixSocket(c.configuredAddress())
+	}
+	client, err := c.Client()
+	if err != nil {
+		return "", fmt.Errorf("unable to create client to connect to Vault: %s", err)
+	}
+	switch {
+	case socket != "":
+		if c.cfg.Proxy != "" {
+			return "", fmt.Errorf("-proxy cannot be used with the unix socket address %s", c.configuredAddress())
+		}
+		if c.flagCACert != "" || c.flagCAPath != "" || c.flagClientCert != "" ||
+			c.flagClientKey != "" || c.flagTLSServerName != "" || c.flagTLSSkipVerify || c.cfg.Insecure {
+			c.UI.Warn(fmt.Sprintf("The TLS flags are ignored for the unix socket address %s", c.configuredAddress()))
+		}
+		c.cfg.Insecure = false
+		c.unixSocket = socket
+
+		client, err = c.unixClient(client, socket)
+		if err != nil {
+			return "", err
+		}
+	case c.cfg.Proxy != "" || c.cfg.Insecure:
+		client, err = c.transportClient(client)
+		if err != nil {
+			return "", err
+		}
+	}
+	if c.cfg.Insecure {
+		c.UI.Warn(wrapAtLength("WARNING! TLS verification of the server's "+
+			"certificate is disabled with -insecure. The identity of the server "+
+			"is not verified, and the captured data may be intercepted. This is "+
+			"recorded in the index file.") + "\n")
+	}
+	c.cachedClient = client
+	c.activeClient = client
+
+	if c.cfg.Anonymize {
+		c.anonymizer = newDebugAnonymizer()
+	}
+
+	// With -cluster, every cluster is captured on its own and the configured
+	// address is not captured unless it is also listed. With -raft-peers, the
+	// configured address is only used to list the peers.
+	var serverVersion, clusterName string
+	var standby bool
+	switch {
+	case c.cfg.RaftPeers && len(c.cfg.Clusters) > 0:
+		return "", fmt.Errorf("raft-peers cannot be used with cluster")
+	case len(c.cfg.Clusters) > 0:
+		clusters, err := c.parseClusters(client)
+		if err != nil {
+			return "", err
+		}
+		c.clusters = clusters
+	case c.cfg.RaftPeers:
+		peers, errs, err := c.parseRaftPeers(client)
+		if err != nil {
+			return "", fmt.Errorf("pre-flight check failed: %s", err)
+		}
+		c.clusters = peers
+		c.raftPeerErrs = errs
+	default:
+		activeClient, health, err := c.connect(client)
+		if err != nil {
+			return "", fmt.Errorf("pre-flight check failed: %s", err)
+		}
+		c.activeClient = activeClient
+		serverVersion = health.Version
+		clusterName = health.ClusterName
+		standby = health.Standby || health.PerformanceStandby
+	}
+
+	captureTime := time.Now().UTC()
+	if outputTemplate != nil {
+		// The server listening on a unix socket runs on this host
+		var host string
+		if c.unixSocket != "" {
+			host, _ = os.Hostname()
+		} else if u, err := url.Parse(client.Address()); err == nil {
+			host = u.Hostname()
+		}
+
+		output, err := expandDebugOutputTemplate(outputTemplate, debugOutputTemplateData{
+			Time:        captureTime.Format(fileFriendlyTimeFormat),
+			Host:        host,
+			ClusterName: clusterName,
+		})
+		if err != nil {
+			return "", err
+		}
+		c.cfg.Output = output
+	}
+	if len(c.cfg.Output) == 0 {
+		formattedTime := captureTime.Format(fileFriendlyTimeFormat)
+		c.cfg.Output = fmt.Sprintf("vault-debug-%s", formattedTime)
+	}
+
+	// Strip trailing slash before proceeding
+	c.cfg.Output = strings.TrimSuffix(c.cfg.Output, "/")
+
+	if c.cfg.TmpDir != "" {
+		if err := checkWritableDir(c.cfg.TmpDir); err != nil {
+			return "", fmt.Errorf("invalid tmp dir: %s", err)
+		}
+	}
+
+	// If the output is a named pipe, the archive is streamed into it and the
+	// output is staged in a temporary directory instead.
+	if info, err := os.Stat(c.cfg.Output); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		switch {
+		case !c.cfg.Compress:
+			return "", fmt.Errorf("output to a named pipe requires the archive output format")
+		case c.cfg.Rotate > 0:
+			return "", fmt.Errorf("rotate cannot be used with output to a named pipe")
+		}
+
+		c.outputPipe = c.cfg.Output
+		if !c.flagDryRun {
+			stagingDir, err := ioutil.TempDir(debugStagingParent(c.cfg.Output, c.cfg.TmpDir), "vault-debug")
+			if err != nil {
+				return "", fmt.Errorf("unable to create staging directory: %s", err)
+			}
+			c.stagingDir = stagingDir
+			c.cfg.Output = filepath.Join(stagingDir, fmt.Sprintf("vault-debug-%s", captureTime.Format(fileFriendlyTimeFormat)))
+		}
+	}
+
+	if c.cfg.WaitTimeout < 0 {
+		return "", fmt.Errorf("wait timeout must be non-negative")
+	}
+	if c.cfg.WaitUntil != "" {
+		cond, err := parseDebugCondition(c.cfg.WaitUntil)
+		if err != nil {
+			return "", fmt.Errorf("invalid wait condition: %s", err)
+		}
+		c.waitCondition = cond
+	}
+
+	if c.cfg.ExtendOn != "" {
+		cond, err := parseDebugCondition(c.cfg.ExtendOn)
+		if err != nil {
+			return "", fmt.Errorf("invalid extend condition: %s", err)
+		}
+
+		switch {
+		case c.cfg.MaxDuration <= 0:
+			return "", fmt.Errorf("extend-on requires max-duration to be set")
+		case c.cfg.MaxDuration < c.cfg.Duration:
+			return "", fmt.Errorf("max duration %q must not be less than the duration %q", c.cfg.MaxDuration, c.cfg.Duration)
+		case c.cfg.ExtendBy < 0:
+			return "", fmt.Errorf("extend-by must be a non-negative duration")
+		case c.cfg.Count > 0:
+			return "", fmt.Errorf("extend-on cannot be used with count")
+		case c.cfg.Rotate > 0:
+			return "", fmt.Errorf("extend-on cannot be used with rotate")
+		case len(c.cfg.Clusters) > 0:
+			return "", fmt.Errorf("extend-on cannot be used with cluster")
+		case c.cfg.RaftPeers:
+			return "", fmt.Errorf("extend-on cannot be used with raft-peers")
+		}
+		if c.cfg.ExtendBy == 0 {
+			c.cfg.ExtendBy = c.cfg.Duration
+		}
+		c.extendCondition = cond
+	}
+
+	if c.cfg.LogInBundle && c.cfg.LogFile == "" {
+		return "", fmt.Errorf("log-in-bundle requires log-file to be set")
+	}
+
+	if c.cfg.HotPathsN <= 0 {
+		return "", fmt.Errorf("hot paths n must be greater than 0")
+	}
+
+	if c.cfg.OutputCompressAfter < 0 {
+		return "", fmt.Errorf("output compress after must be a non-negative duration")
+	}
+	if c.cfg.OutputCompressAfter > 0 {
+		switch {
+		case !c.cfg.Compress:
+			return "", fmt.Errorf("output-compress-after requires compression to be enabled and the archive output format")
+		case c.outputPipe != "":
+			return "", fmt.Errorf("output-compress-after cannot be used with output to a named pipe")
+		case c.cfg.Rotate > 0:
+			return "", fmt.Errorf("output-compress-after cannot be used with rotate")
+		case c.cfg.UploadURL != "", c.cfg.SignKey != "":
+			return "", fmt.Errorf("output-compress-after cannot be used with upload-url or sign-key, which require an archive")
+		}
+	}
+
+	if c.cfg.UploadURL != "" {
+		u, err := url.Parse(c.cfg.UploadURL)
+		objectStore := err == nil && (u.Scheme == "s3" || u.Scheme == "gcs" || u.Scheme == "azure")
+		switch {
+		case err != nil:
+			return "", fmt.Errorf("invalid upload URL: %s", err)
+		case u.Scheme != "http" && u.Scheme != "https" && !objectStore:
+			return "", fmt.Errorf("invalid upload URL %q, scheme must be http, https, s3, gcs, or azure", c.cfg.UploadURL)
+		case u.Scheme == "azure" && u.Host == "":
+			return "", fmt.Errorf("invalid upload URL %q, must include a container", c.cfg.UploadURL)
+		case objectStore && u.Host == "":
+			return "", fmt.Errorf("invalid upload URL %q, must include a bucket", c.cfg.UploadURL)
+		case objectStore && len(c.cfg.UploadHeaders) > 0:
+			return "", fmt.Errorf("upload-header cannot be used with an %s upload URL", u.Scheme)
+		case !c.cfg.Compress:
+			return "", fmt.Errorf("upload-url requires compression to be enabled and the archive output format")
+		case c.outputPipe != "":
+			return "", fmt.Errorf("upload-url cannot be used with output to a named pipe")
+		}
+
+		c.cfg.UploadMethod = strings.ToUpper(c.cfg.UploadMethod)
+		if c.cfg.UploadMethod != http.MethodPut && c.cfg.UploadMethod != http.MethodPost {
+			return "", fmt.Errorf("invalid upload method %q, must be one of: PUT, POST", c.cfg.UploadMethod)
+		}
+
+		partSize, err := parseDebugSize(c.cfg.UploadPartSize)
+		if err != nil {
+			return "", fmt.Errorf("invalid upload part size: %s", err)
+		}
+		if partSize < debugMinUploadPartSize {
+			return "", fmt.Errorf("upload-part-size must be at least 5MiB")
+		}
+		if u.Scheme == "azure" && partSize > debugMaxUploadBlockSize {
+			return "", fmt.Errorf("upload-part-size must be at most 100MiB for an azure upload URL")
+		}
+		c.uploadPartSize = int64(partSize)
+	} else if c.cfg.UploadRemove {
+		return "", fmt.Errorf("upload-remove requires upload-url")
+	}
+
+	if c.cfg.KeepDir {
+		switch {
+		case !c.cfg.Compress:
+			return "", fmt.Errorf("keep-dir requires compression to be enabled and the archive output format")
+		case c.outputPipe != "":
+			return "", fmt.Errorf("keep-dir cannot be used with output to a named pipe")
+		}
+	}
+
+	var fingerprint string
+	if c.cfg.SignKey != "" {
+		switch {
+		case !c.cfg.Compress:
+			return "", fmt.Errorf("sign-key requires compression to be enabled and the archive output format")
+		case c.outputPipe != "":
+			return "", fmt.Errorf("sign-key cannot be used with output to a named pipe")
+		}
+
+		key, err := loadDebugSigningKey(c.cfg.SignKey)
+		if err != nil {
+			return "", fmt.Errorf("error loading signing key: %s", err)
+		}
+		c.signingKey = key
+		fingerprint = debugKeyFingerprint(key.Public().(ed25519.PublicKey))
+	}
+
+	// If compression is enabled, trim the extension so that the files are
+	// written to a directory even if compression somehow fails. We ensure the
+	// extension during compression. We also prevent overwriting if the file
+	// already exists.
+	dstOutputFile := c.cfg.Output
+	switch {
+	case c.outputPipe != "":
+		dstOutputFile = c.outputPipe
+	case c.cfg.Compress:
+		exts := debugArchiveExts[c.cfg.ArchiveFormat]
+		ext := exts[0]
+		for _, e := range exts {
+			if strings.HasSuffix(dstOutputFile, e) {
+				ext = e
+			}
+		}
+		if !strings.HasSuffix(dstOutputFile, ext) {
+			dstOutputFile = dstOutputFile + ext
+		}
+
+		// Ensure that the file doesn't already exist, and ensure that we always
+		// trim the extension from flagOutput since we'll be progressively
+		// writing to that.
+		_, err := os.Stat(dstOutputFile)
+		switch {
+		case os.IsNotExist(err):
+			c.cfg.Output = strings.TrimSuffix(c.cfg.Output, ext)
+		case err != nil:
+			return "", fmt.Errorf("unable to stat file: %s", err)
+		default:
+			return "", fmt.Errorf("output file already exists: %s", dstOutputFile)
+		}
+	}
+
+	// When rotating, each window's directory is created as the capture
+	// progresses. On a dry run, nothing is created but an existing directory
+	// is still reported.
+	switch {
+	case c.cfg.Rotate > 0, c.cfg.Resume != "":
+	case c.flagDryRun:
+		if _, err := os.Stat(c.cfg.Output); err == nil {
+			return "", fmt.Errorf("output directory already exists: %s", c.cfg.Output)
+		}
+	default:
+		if err := createOutputDir(c.cfg.Output); err != nil {
+			return "", err
+		}
+	}
+
+	var activeAddress string
+	if c.activeClient != client {
+		activeAddress = c.activeClient.Address()
+	}
+
+	vaultAddress := client.Address()
+	if c.unixSocket != "" {
+		vaultAddress = "unix://" + c.unixSocket
+	}
+
+	// Populate initial index fields
+	c.debugIndex = &DebugIndex{
+		VaultAddress:           vaultAddress,
+		ActiveAddress:          activeAddress,
+		Namespace:              client.Headers().Get(consts.NamespaceHeaderName),
+		ClientVersion:          version.GetVersion().FullVersionNumber(false),
+		Compress:               c.cfg.Compress,
+		DurationSeconds:        int(c.cfg.Duration.Seconds()),
+		IntervalSeconds:        int(c.cfg.Interval.Seconds()),
+		MetricsIntervalSeconds: int(c.cfg.MetricsInterval.Seconds()),
+		RawArgs:                rawArgs,
+		Version:                debugIndexVersion,
+		Targets:                c.cfg.Targets,
+		Timestamp:              captureTime,
+		Metadata:               metadata,
+		Errors:                 []CaptureError{},
+		Frames:                 []DebugFrame{},
+		SigningKeyFingerprint:  fingerprint,
+		CustomPaths:            c.customPaths,
+		CapturedAgainstStandby: standby,
+		TLSVerificationSkipped: c.cfg.Insecure,
+	}
+
+	// The server version is recorded for each cluster with -cluster, since
+	// the configured address is not necessarily captured
+	if len(c.clusters) == 0 {
+		if serverVersion == "" && !c.flagDryRun {
+			// Fall back to the seal status on servers whose health endpoint
+			// does not report the version
+			var status api.SealStatusResponse
+			err := c.requestJSON(withCaptureTarget(context.Background(), "version", debugStaticFrame), "/v1/sys/seal-status", nil, &status)
+			if err == nil && status.Version == "" {
+				err = fmt.Errorf("version not reported by the server")
+			}
+			if err != nil {
+				c.recordCapture("version", debugStaticFrame, fmt.Errorf("unable to fetch server version: %s", err))
+			}
+			serverVersion = status.Version
+		}
+		c.debugIndex.ServerVersion = serverVersion
+	}
+	for _, cluster := range c.clusters {
+		if cluster.versionErr != nil {
+			c.recordCapture("version", debugStaticFrame, fmt.Errorf("unable to fetch server version of cluster %q: %s", cluster.name, cluster.versionErr))
+		}
+	}
+	for _, err := range c.raftPeerErrs {
+		c.recordCapture("raft-peers", debugStaticFrame, err)
+	}
+
+	if resumeIndex != nil {
+		c.resume(resumeIndex, captureTime)
+	}
+
+	return dstOutputFile, nil
+}
+
+// readResumeIndex reads the index file of the directory bundle at -resume,
+// which the capture is appended to. The bundle becomes the output of the run
+// and is left as a directory. Its index may be partial if the run that wrote
+// it was killed before completing.
+func (c *DebugCommand) readResumeIndex() (*DebugIndex, error) {
+	dir := strings.TrimSuffix(c.cfg.Resume, "/")
+	switch {
+	case c.cfg.Rotate > 0:
+		return nil, fmt.Errorf("resume cannot be used with rotate")
+	case len(c.cfg.Clusters) > 0:
+		return nil, fmt.Errorf("resume cannot be used with cluster")
+	case c.cfg.RaftPeers:
+		return nil, fmt.Errorf("resume cannot be used with raft-peers")
+	case c.cfg.OutputTemplate != "":
+		return nil, fmt.Errorf("resume cannot be used with output-template")
+	case c.cfg.Output != "" && strings.TrimSuffix(c.cfg.Output, "/") != dir:
+		return nil, fmt.Errorf("output %s must be the bundle being resumed, %s", c.cfg.Output, dir)
+	}
+
+	c.cfg.Output = dir
+	c.cfg.Compress = false
+	info, err := os.Stat(c.cfg.Output)
+	switch {
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("no bundle to resume at %s", c.cfg.Output)
+	case err != nil:
+		return nil, fmt.Errorf("unable to stat directory: %s", err)
+	case !info.IsDir():
+		return nil, fmt.Errorf("only directory bundles can be resumed, %s is not a directory", c.cfg.Output)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(c.cfg.Output, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read index file of the bundle being resumed: %s", err)
+	}
+
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("failed to parse index file of the bundle being resumed: %s", err)
+	}
+	if index.Version != debugIndexVersion {
+		return nil, fmt.Errorf("cannot resume a bundle with index version %d, expected version %d", index.Version, debugIndexVersion)
+	}
+
+	// A partial index that was being rewritten when the run was killed is
+	// discarded, since the index is regenerated once the capture completes
+	os.Remove(filepath.Join(c.cfg.Output, "index.json.tmp"))
+
+	return index, nil
+}
+
+// resume merges the index of the bundle being resumed into the index of the
+// run, so that frames and metrics captures are numbered after those already
+// in the bundle and earlier errors are retained.
+func (c *DebugCommand) resume(index *DebugIndex, captureTime time.Time) {
+	for _, frame := range index.Frames {
+		if frame.Frame >= c.frameOffset {
+			c.frameOffset = frame.Frame + 1
+		}
+	}
+
+	// A run that was killed leaves the directory of the frame in progress
+	// behind without the frame being in its partial index
+	if next := nextCaptureIndex(c.cfg.Output); next > c.frameOffset {
+		c.frameOffset = next
+	}
+
+	c.metricsOffset = nextCaptureIndex(filepath.Join(c.cfg.Output, "metrics"))
+
+	c.debugIndex.Timestamp = index.Timestamp
+	c.debugIndex.RawArgs = index.RawArgs
+	c.debugIndex.DurationSeconds += index.DurationSeconds
+	c.debugIndex.Targets = strutil.RemoveDuplicatesStable(append(append([]string{}, index.Targets...), c.cfg.Targets...), false)
+	c.debugIndex.Frames = append(append([]DebugFrame{}, index.Frames...), c.debugIndex.Frames...)
+	c.debugIndex.Errors = append(append([]CaptureError{}, index.Errors...), c.debugIndex.Errors...)
+	c.debugIndex.Resumed = append(index.Resumed, captureTime)
+	for _, file := range index.Output {
+		if !file.Timestamp.IsZero() {
+			c.recordFileTimeAt(filepath.Join(c.cfg.Output, filepath.FromSlash(file.Path)), file.Timestamp)
+		}
+	}
+	for _, custom := range index.CustomPaths {
+		var found bool
+		for _, p := range c.customPaths {
+			if p.Path == custom.Path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.debugIndex.CustomPaths = append(c.debugIndex.CustomPaths, custom)
+		}
+	}
+	if c.debugIndex.Metadata == nil {
+		c.debugIndex.Metadata = index.Metadata
+	}
+}
+
+// debugSizeUnits maps the suffixes accepted by parseDebugSize to their size
+// in bytes.
+var debugSizeUnits = map[string]uint64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// parseDebugSize parses a size such as "500MB" or "2GiB" into bytes. Decimal
+// and binary suffixes are both accepted, and a bare number is in bytes.
+func parseDebugSize(raw string) (uint64, error) {
+	s := strings.TrimSpace(raw)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+
+	multiplier, ok := debugSizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q, unknown unit %q", raw, s[i:])
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+
+	return uint64(value * float64(multiplier)), nil
+}
+
+// debugStagingParent returns the directory that the output is staged in when
+// it is streamed into a named pipe, which is tmpDir if set, then TMPDIR, and
+// the parent directory of the output otherwise.
+func debugStagingParent(output, tmpDir string) string {
+	switch {
+	case tmpDir != "":
+		return tmpDir
+	case os.Getenv("TMPDIR") != "":
+		return os.Getenv("TMPDIR")
+	}
+	return filepath.Dir(output)
+}
+
+// checkWritableDir ensures that the directory exists and that files can be
+// created in it.
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	f, err := ioutil.TempFile(dir, "vault-debug")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %s", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkFreeSpace ensures that the filesystem the output is written to has at
+// least minFree bytes available. Since the output doesn't exist yet, the
+// nearest existing parent directory is checked instead.
+func checkFreeSpace(output, tmpDir string, minFree uint64) error {
+	dir := output
+	if dir == "" {
+		dir = "."
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			// Output to a named pipe is staged in a temporary directory
+			if info.Mode()&os.ModeNamedPipe != 0 {
+				dir = debugStagingParent(output, tmpDir)
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	usage, err := disk.Usage(dir)
+	if err != nil {
+		return fmt.Errorf("unable to determine free space of %s: %s", dir, err)
+	}
+	if usage.Free < minFree {
+		return fmt.Errorf("only %d bytes are free on the filesystem of %s, at least %d are required", usage.Free, dir, minFree)
+	}
+
+	return nil
+}
+
+// createOutputDir creates the output directory, ensuring that we don't
+// override any existing data.
+func createOutputDir(dir string) error {
+	_, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("unable to create output directory: %s", err)
+		}
+	case err != nil:
+		return fmt.Errorf("unable to stat directory: %s", err)
+	default:
+		return fmt.Errorf("output directory already exists: %s", dir)
+	}
+
+	return nil
+}
+
+// debugOutputTemplateData is the data that -output-template is expanded with.
+type debugOutputTemplateData struct {
+	Time        string
+	Host        string
+	ClusterName string
+}
+
+// parseDebugOutputTemplate parses the template passed with -output-template,
+// and expands it once with placeholder values so that references to unknown
+// fields are rejected before connecting to the server.
+func parseDebugOutputTemplate(raw string) (*template.Template, error) {
+	tmpl, err := template.New("output").Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %s", err)
+	}
+	if err := tmpl.Execute(ioutil.Discard, debugOutputTemplateData{}); err != nil {
+		return nil, fmt.Errorf("invalid output template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// expandDebugOutputTemplate expands the output template into the base output
+// path. Each value is sanitized before expansion so that it can't introduce
+// path separators or other characters that are unsafe in a filename.
+func expandDebugOutputTemplate(tmpl *template.Template, data debugOutputTemplateData) (string, error) {
+	data.Time = debugSanitizeRe.ReplaceAllString(data.Time, "_")
+	data.Host = debugSanitizeRe.ReplaceAllString(data.Host, "_")
+	data.ClusterName = debugSanitizeRe.ReplaceAllString(data.ClusterName, "_")
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("unable to expand output template: %s", err)
+	}
+
+	output := strings.TrimSpace(buf.String())
+	if output == "" || output == "." || output == ".." {
+		return "", fmt.Errorf("output template expanded to an invalid path %q", output)
+	}
+	return output, nil
+}
+
+// parseDebugMetadata parses the list of key=value metadata entries into a map,
+// rejecting malformed and duplicate keys.
+func parseDebugMetadata(entries []string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	metadata := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		idx := strings.Index(entry, "=")
+		if idx < 1 {
+			return nil, fmt.Errorf("invalid metadata %q, must be in the form of key=value", entry)
+		}
+
+		k, v := entry[:idx], entry[idx+1:]
+		if _, ok := metadata[k]; ok {
+			return nil, fmt.Errorf("duplicate metadata key %q", k)
+		}
+		metadata[k] = v
+	}
+
+	return metadata, nil
+}
+
+// nextCaptureIndex returns the index following the highest numbered capture
+// in the given directory, such as a metrics capture or the sub-directory of a
+// mount health capture, or 0 if it holds none.
+func nextCaptureIndex(dir string) int {
+	var next int
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(match), ".json"))
+		if err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
+// frameCount returns the number of frames that are captured over the given
+// duration given a polling interval.
+func frameCount(duration, interval time.Duration) int {
+	if interval <= 0 {
+		return 1
+	}
+	return int(math.Ceil(float64(duration) / float64(interval)))
+}
+
+// targetContext returns a context that is bound by the target timeout on top
+// of any extra time required by the capture itself. Because it is derived
+// from the run context, the overall run deadline still applies if it is the
+// shorter of the two.
+func (c *DebugCommand) targetContext(ctx context.Context, extra time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.cfg.TargetTimeout+extra)
+}
+
+// withCaptureTarget returns a context that carries the target and frame of a
+// capture, which is used to attribute request timings.
+func withCaptureTarget(ctx context.Context, target string, frame int) context.Context {
+	return context.WithValue(ctx, captureTargetKey{}, captureTarget{target: target, frame: frame})
+}
+
+// recordTiming records the timing information of a single API request.
+func (c *DebugCommand) recordTiming(ctx context.Context, path string, status int, start time.Time) {
+	timing := requestTiming{
+		Frame:     debugStaticFrame,
+		Path:      path,
+		Status:    status,
+		Timestamp: start.UTC(),
+		LatencyMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if t, ok := ctx.Value(captureTargetKey{}).(captureTarget); ok {
+		timing.Target = t.target
+		timing.Frame = t.frame
+	}
+
+	c.timingLock.Lock()
+	defer c.timingLock.Unlock()
+	c.requestTimings = append(c.requestTimings, timing)
+}
+
+// writeRequestTimings writes the timings of all requests made during the
+// capture to the output directory and resets them for the next capture.
+func (c *DebugCommand) writeRequestTimings() error {
+	c.timingLock.Lock()
+	timings := c.requestTimings
+	c.requestTimings = nil
+	c.timingLock.Unlock()
+
+	if timings == nil {
+		timings = []requestTiming{}
+	}
+
+	// The timings of a resumed capture are appended to those of the bundle
+	if c.cfg.Resume != "" {
+		var existing []requestTiming
+		content, err := ioutil.ReadFile(filepath.Join(c.cfg.Output, "request_timings.json"))
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(content, &existing); err != nil {
+				return fmt.Errorf("failed to parse existing request timings: %s", err)
+			}
+		}
+		timings = append(existing, timings...)
+	}
+
+	if err := c.writeJSON("request_timings.json", timings); err != nil {
+		return err
+	}
+	if c.cfg.TimingsCSV {
+		return c.writeRequestTimingsCSV(timings)
+	}
+	return nil
+}
+
+// writeRequestTimingsCSV writes the request timings to request_timings.csv,
+// with one row per request in the order they were made.
+func (c *DebugCommand) writeRequestTimingsCSV(timings []requestTiming) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"frame", "target", "path", "status", "latency_ms"}); err != nil {
+		return err
+	}
+	for _, timing := range timings {
+		record := []string{
+			strconv.Itoa(timing.Frame),
+			timing.Target,
+			timing.Path,
+			strconv.Itoa(timing.Status),
+			strconv.FormatFloat(timing.LatencyMS, 'f', -1, 64),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return c.writeFile("request_timings.csv", buf.Bytes())
+}
+
+// recordCapture records the result of a single target capture. Failures are
+// stored in the index file rather than aborting the run.
+func (c *DebugCommand) recordCapture(target string, frame int, err error) {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+
+	if err == nil {
+		c.captureCount++
+		if c.capturedTargets == nil {
+			c.capturedTargets = make(map[string]bool)
+		}
+		c.capturedTargets[target] = true
+		return
+	}
+
+	if frame == debugStaticFrame {
+		c.UI.Warn(fmt.Sprintf("Error capturing %s: %s", target, err))
+	} else {
+		c.UI.Warn(fmt.Sprintf("Error capturing %s on frame %d: %s", target, frame, err))
+	}
+
+	c.debugIndex.Errors = append(c.debugIndex.Errors, CaptureError{
+		Target:      target,
+		Frame:       frame,
+		Timestamp:   time.Now().UTC(),
+		TargetError: err.Error(),
+	})
+}
+
+// captureStaticTargets captures every selected target registered to be
+// captured once, in the order of their names.
+func (c *DebugCommand) captureStaticTargets(ctx context.Context) error {
+	for _, target := range c.selectedTargets(debugTargetOnce) {
+		c.UI.Info("    - " + debugTargetRegistry[target].progress)
+		c.runRegisteredCapture(ctx, target, nil)
+	}
+
+	return nil
+}
+
+// captureAuth captures the enabled auth methods, including each method's type,
+// accessor, and configuration. If the token used for the run is not permitted
+// to read the auth table, a note is written in its place.
+func (c *DebugCommand) captureAuth(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	data, err := c.requestData(ctx, "/v1/sys/auth", nil)
+	if err != nil {
+		if isResponseStatus(err, http.StatusForbidden) {
+			return c.writeNote("auth.txt", "Permission denied reading the auth table, auth methods were not captured.")
+		}
+		return err
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"auth":      data,
+	}
+	return c.writeJSON("auth.json", entry)
+}
+
+// captureClockSkew estimates the skew between the local clock and the server
+// clock using the server time reported by the health endpoint.
+func (c *DebugCommand) captureClockSkew(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	var health api.HealthResponse
+	start := time.Now()
+	if err := c.requestJSON(ctx, "/v1/sys/health", debugHealthParams(), &health); err != nil {
+		return err
+	}
+	rtt := time.Since(start)
+
+	if health.ServerTimeUTC == 0 {
+		return fmt.Errorf("server did not report its time")
+	}
+
+	localTime := start.Add(rtt / 2).UTC()
+	serverTime := time.Unix(health.ServerTimeUTC, 0).UTC()
+
+	entry := &clockSkew{
+		LocalTime:         localTime,
+		ServerTime:        serverTime,
+		RoundTripSeconds:  rtt.Seconds(),
+		SkewSeconds:       serverTime.Sub(localTime).Seconds(),
+		ErrorBoundSeconds: (rtt / 2).Seconds() + 1,
+	}
+	return c.writeJSON("clock_skew.json", entry)
+}
+
+func (c *DebugCommand) captureConfig(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	data, err := c.requestData(ctx, "/v1/sys/config/state/sanitized", nil)
+	if err != nil {
+		return err
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"config":    data,
+	}
+	return c.writeJSON("config.json", entry)
+}
+
+// captureEntropy captures the seal wrap rewrap status along with the seal,
+// seal wrap, and entropy augmentation configuration. Seal wrapping is only
+// available on Enterprise, so a note is written in place of the status if the
+// endpoint doesn't exist.
+func (c *DebugCommand) captureEntropy(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	rewrap, err := c.requestData(ctx, "/v1/sys/sealwrap/rewrap", nil)
+	if err != nil {
+		switch {
+		case isResponseStatus(err, http.StatusNotFound):
+			return c.writeNote("sealwrap_status.txt", "Seal wrapping is unavailable on this server.")
+		case isResponseStatus(err, http.StatusForbidden):
+			return c.writeNote("sealwrap_status.txt", "Permission denied reading the seal wrap status, it was not captured.")
+		}
+		return err
+	}
+
+	config, err := c.requestData(ctx, "/v1/sys/config/state/sanitized", nil)
+	if err != nil {
+		return err
+	}
+	sealConfig := map[string]interface{}{}
+	for _, k := range debugSealWrapKeys {
+		if v, ok := config[k]; ok {
+			sealConfig[k] = v
+		}
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"rewrap":    rewrap,
+		"config":    sealConfig,
+	}
+	return c.writeJSON("sealwrap_status.json", entry)
+}
+
+// captureLicense captures the license status of the server. License
+// reporting is only available on Enterprise, so a note is written in place of
+// the status if the endpoint doesn't exist.
+func (c *DebugCommand) captureLicense(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	data, err := c.requestData(ctx, "/v1/sys/license/status", nil)
+	if err != nil {
+		if isResponseStatus(err, http.StatusNotFound) {
+			return c.writeNote("license_status.txt", "License reporting is unavailable on this server.")
+		}
+		return err
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"license":   redactLicense(data),
+	}
+	return c.writeJSON("license_status.json", entry)
+}
+
+// captureMounts captures the secrets engine mount table, including each
+// mount's type, options, and configuration. If the token used for the run is
+// not permitted to read the mount table, a note is written in its place.
+func (c *DebugCommand) captureMounts(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	data, err := c.requestData(ctx, "/v1/sys/mounts", nil)
+	if err != nil {
+		if isResponseStatus(err, http.StatusForbidden) {
+			return c.writeNote("mounts.txt", "Permission denied reading the mount table, mounts were not captured.")
+		}
+		return err
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"mounts":    data,
+	}
+	return c.writeJSON("mounts.json", entry)
+}
+
+// captureOpenAPI captures the OpenAPI document describing every path exposed
+// by the server, including those of mounted plugins. The document can be
+// large, so it is streamed straight to disk.
+func (c *DebugCommand) captureOpenAPI(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	return c.requestFile(ctx, "/v1/sys/internal/specs/openapi", nil, "openapi.json")
+}
+
+// captureAuditProbe hashes debugAuditProbeInput with every audit device,
+// proving that each device is reachable and hashing. The server only lists the
+// enabled devices, so disabled devices are never probed. If the token used for
+// the run is not permitted to list the devices, a note is written in place of
+// the probe, and a device it is not permitted to hash with is recorded with a
+// note instead of a hash.
+func (c *DebugCommand) captureAuditProbe(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	list, err := c.requestData(ctx, "/v1/sys/audit", nil)
+	if err != nil {
+		if isResponseStatus(err, http.StatusForbidden) {
+			return c.writeNote("audit_probe.txt", "Permission denied listing the audit devices, audit devices were not probed.")
+		}
+		return err
+	}
+
+	paths := make([]string, 0, len(list))
+	for path := range list {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	devices := []map[string]interface{}{}
+	var errs []string
+	for _, path := range paths {
+		device, _ := list[path].(map[string]interface{})
+		entry := map[string]interface{}{
+			"path": path,
+			"type": device["type"],
+		}
+
+		body := map[string]interface{}{"input": debugAuditProbeInput}
+		hash, err := c.requestWriteData(ctx, "/v1/sys/audit-hash/"+strings.TrimSuffix(path, "/"), body)
+		switch {
+		case isResponseStatus(err, http.StatusForbidden):
+			entry["note"] = "Permission denied hashing with the audit device"
+		case err != nil:
+			errs = append(errs, fmt.Sprintf("%s: %s", path, err))
+			continue
+		default:
+			entry["hash"] = hash["hash"]
+		}
+		devices = append(devices, entry)
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"input":     debugAuditProbeInput,
+		"devices":   devices,
+	}
+	if err := c.writeJSON("audit_probe.json", entry); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to probe audit devices: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// captureAuditStatus captures the enabled audit devices along with their
+// health over the last metrics interval of the server: how many requests and
+// responses each device logged and how long logging them took, and how many
+// requests and responses could not be logged at all. A device that took
+// debugAuditSlowThreshold or longer to log is reported as slow, which is
+// usually the sign of a blocked device, and a device that logged nothing as
+// idle. The server does not expose the last error of a device, which can only
+// be found in the server logs. If the token used for the run is not permitted
+// to list the devices, a note is written in place of the status, and if it is
+// not permitted to read the metrics, the devices are listed without their
+// health.
+func (c *DebugCommand) captureAuditStatus(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	list, err := c.requestData(ctx, "/v1/sys/audit", nil)
+	if err != nil {
+		if isResponseStatus(err, http.StatusForbidden) {
+			return c.writeNote(filepath.Join(frameDir, "audit_status.txt"), "Permission denied listing the audit devices, the audit status was not captured.")
+		}
+		return err
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+	}
+
+	var summary debugMetricsSummary
+	health := true
+	switch err := c.requestJSON(ctx, "/v1/sys/metrics", nil, &summary); {
+	case isResponseStatus(err, http.StatusForbidden):
+		health = false
+		entry["note"] = "Permission denied reading the metrics, the health of the audit devices was not captured."
+	case err != nil:
+		return fmt.Errorf("failed to read audit metrics: %s", err)
+	}
+
+	operations := []string{"log_request", "log_response"}
+	if health {
+		// The failures are counted by the broker rather than per device, and
+		// only when every device failed to log
+		failures := map[string]float64{}
+		var failing bool
+		for _, op := range operations {
+			for _, counter := range summary.Counters {
+				if counter.Name == "vault.audit."+op+"_failure" {
+					failures[op] += counter.Sum
+				}
+			}
+			failing = failing || failures[op] > 0
+		}
+		entry["failures"] = failures
+		entry["failing"] = failing
+	}
+
+	samples := make(map[string]debugMetricsValue, len(summary.Samples))
+	for _, sample := range summary.Samples {
+		samples[sample.Name] = sample
+	}
+
+	paths := make([]string, 0, len(list))
+	for path := range list {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	devices := []map[string]interface{}{}
+	for _, path := range paths {
+		device, _ := list[path].(map[string]interface{})
+		status := map[string]interface{}{
+			"path":        path,
+			"type":        device["type"],
+			"description": device["description"],
+			"local":       device["local"],
+		}
+
+		if health {
+			state := "idle"
+			for _, op := range operations {
+				sample, ok := samples["vault.audit."+path+"."+op]
+				if !ok || sample.Count == 0 {
+					continue
+				}
+				status[op] = map[string]interface{}{
+					"count":   sample.Count,
+					"mean_ms": sample.Sum / float64(sample.Count),
+					"max_ms":  sample.Max,
+				}
+				if state == "idle" {
+					state = "ok"
+				}
+				if time.Duration(sample.Max*float64(time.Millisecond)) >= debugAuditSlowThreshold {
+					state = "slow"
+				}
+			}
+			status["status"] = state
+		}
+		devices = append(devices, status)
+	}
+	entry["devices"] = devices
+
+	return c.writeJSON(filepath.Join(frameDir, "audit_status.json"), entry)
+}
+
+// capturePlugins captures the plugin catalog grouped by plugin type, with the
+// name, version, and SHA256 of each plugin. If the token used for the run is
+// not permitted to read the catalog, a note is written in its place.
+func (c *DebugCommand) capturePlugins(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	catalog, err := c.requestData(ctx, "/v1/sys/plugins/catalog", nil)
+	if err != nil {
+		if isResponseStatus(err, http.StatusForbidden) {
+			return c.writeNote("plugins.txt", "Permission denied reading the plugin catalog, plugins were not captured.")
+		}
+		return err
+	}
+
+	types := make([]string, 0, len(catalog))
+	for pluginType := range catalog {
+		types = append(types, pluginType)
+	}
+	sort.Strings(types)
+
+	plugins := map[string][]map[string]interface{}{}
+	var errs []string
+	for _, pluginType := range types {
+		names, _ := catalog[pluginType].([]interface{})
+		entries := []map[string]interface{}{}
+		for _, n := range names {
+			name, ok := n.(string)
+			if !ok {
+				continue
+			}
+
+			plugin, err := c.requestData(ctx, "/v1/sys/plugins/catalog/"+url.PathEscape(pluginType)+"/"+url.PathEscape(name), nil)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s/%s: %s", pluginType, name, err))
+				continue
+			}
+
+			entry := map[string]interface{}{}
+			for _, k := range debugPluginKeys {
+				if v, ok := plugin[k]; ok {
+					entry[k] = v
+				}
+			}
+			entries = append(entries, entry)
+		}
+		plugins[pluginType] = entries
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"plugins":   plugins,
+	}
+	if err := c.writeJSON("plugins.json", entry); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to read plugins: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// capturePolicies captures the names of the ACL policies and, if requested,
+// the body of each policy under the policies sub-directory. If the token used
+// for the run is not permitted to list policies, a note is written in place of
+// the list.
+func (c *DebugCommand) capturePolicies(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	params := url.Values{}
+	params.Add("list", "true")
+	data, err := c.requestData(ctx, "/v1/sys/policies/acl", params)
+	if err != nil {
+		if isResponseStatus(err, http.StatusForbidden) {
+			return c.writeNote("policies.txt", "Permission denied listing ACL policies, policies were not captured.")
+		}
+		return err
+	}
+
+	var names []string
+	if keys, ok := data["keys"].([]interface{}); ok {
+		for _, key := range keys {
+			if name, ok := key.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"policies":  names,
+	}
+	if err := c.writeJSON("policies.json", entry); err != nil {
+		return err
+	}
+
+	if !c.cfg.IncludePolicyBodies {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(c.cfg.Output, "policies"), 0755); err != nil {
+		return fmt.Errorf("unable to create policies directory: %s", err)
+	}
+
+	var errs []string
+	for _, name := range names {
+		// The root policy has no body
+		if name == "root" {
+			continue
+		}
+
+		policy, err := c.requestData(ctx, "/v1/sys/policies/acl/"+url.PathEscape(name), nil)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+
+		body, _ := policy["policy"].(string)
+		if err := c.writeFile(filepath.Join("policies", name+".hcl"), []byte(body)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to capture policy bodies: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// captureRaftSnapshotInfo captures the metadata needed to judge the freshness
+// of a raft snapshot, namely the raft configuration and the last index and
+// term of every server as reported by autopilot, without downloading a
+// snapshot. A note is written in place of the file on servers that don't use
+// Integrated Storage.
+func (c *DebugCommand) captureRaftSnapshotInfo(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	config, err := c.requestData(ctx, "/v1/sys/storage/raft/configuration", nil)
+	switch {
+	case isResponseStatus(err, http.StatusBadRequest), isResponseStatus(err, http.StatusNotFound):
+		return c.writeNote("raft_snapshot_info.txt", "Integrated Storage is not in use on this server, raft snapshot info was not captured.")
+	case isResponseStatus(err, http.StatusForbidden):
+		return c.writeNote("raft_snapshot_info.txt", "Permission denied reading the raft configuration, raft snapshot info was not captured.")
+	case err != nil:
+		return err
+	}
+
+	entry := map[string]interface{}{
+		"timestamp":     time.Now().UTC(),
+		"configuration": config["config"],
+	}
+
+	// Autopilot reports the last index and term of every server, which is
+	// only available on newer servers
+	autopilot, err := c.requestData(ctx, "/v1/sys/storage/raft/autopilot/state", nil)
+	switch {
+	case isResponseStatus(err, http.StatusNotFound):
+		entry["notes"] = []string{"Autopilot state is unavailable on this server, the last index and term were not captured."}
+	case isResponseStatus(err, http.StatusForbidden):
+		entry["notes"] = []string{"Permission denied reading the autopilot state, the last index and term were not captured."}
+	case err != nil:
+		return fmt.Errorf("failed to read autopilot state: %s", err)
+	default:
+		servers := map[string]interface{}{}
+		raw, _ := autopilot["servers"].(map[string]interface{})
+		for id, s := range raw {
+			server, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			filtered := map[string]interface{}{}
+			for _, k := range []string{"name", "address", "status", "healthy", "last_index", "last_term", "last_contact"} {
+				if v, ok := server[k]; ok {
+					filtered[k] = v
+				}
+			}
+			servers[id] = filtered
+		}
+		entry["servers"] = servers
+
+		// The leader's last index and term are those a snapshot taken now
+		// would be at
+		leader, _ := autopilot["leader"].(string)
+		if server, ok := servers[leader].(map[string]interface{}); ok {
+			entry["leader"] = leader
+			entry["last_index"] = server["last_index"]
+			entry["last_term"] = server["last_term"]
+		}
+	}
+
+	return c.writeJSON("raft_snapshot_info.json", entry)
+}
+
+// captureQuotas captures the quota configuration along with every rate limit
+// quota. Quotas are not available on all servers, so a note is written in
+// place of the quotas if the endpoints don't exist.
+func (c *DebugCommand) captureQuotas(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	config, err := c.requestData(ctx, "/v1/sys/quotas/config", nil)
+	if err != nil {
+		if isResponseStatus(err, http.StatusNotFound) {
+			return c.writeNote("quotas.txt", "Quota reporting is unavailable on this server.")
+		}
+		return err
+	}
+
+	configEntry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"config":    config,
+	}
+	if err := c.writeJSON("quota_config.json", configEntry); err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Add("list", "true")
+	list, err := c.requestData(ctx, "/v1/sys/quotas/rate-limit", params)
+
+	quotas := map[string]interface{}{}
+	switch {
+	case isResponseStatus(err, http.StatusNotFound):
+		// An empty list results in a 404, which means there are no quotas
+	case err != nil:
+		return err
+	default:
+		keys, _ := list["keys"].([]interface{})
+		for _, key := range keys {
+			name, ok := key.(string)
+			if !ok {
+				continue
+			}
+
+			quota, err := c.requestData(ctx, "/v1/sys/quotas/rate-limit/"+url.PathEscape(name), nil)
+			if err != nil {
+				return fmt.Errorf("failed to read rate limit quota %q: %s", name, err)
+			}
+			quotas[name] = quota
+		}
+	}
+
+	quotasEntry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"quotas":    quotas,
+	}
+	return c.writeJSON("rate_limit_quotas.json", quotasEntry)
+}
+
+// captureStorage captures the type of the storage backend and the HA backend,
+// if any, along with whether HA is enabled. Only the non-secret fields of the
+// sanitized configuration are captured.
+func (c *DebugCommand) captureStorage(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	config, err := c.requestData(ctx, "/v1/sys/config/state/sanitized", nil)
+	if err != nil {
+		return err
+	}
+
+	var leader api.LeaderResponse
+	if err := c.requestJSON(ctx, "/v1/sys/leader", nil, &leader); err != nil {
+		return err
+	}
+
+	filter := func(section interface{}) map[string]interface{} {
+		stanza, ok := section.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		filtered := map[string]interface{}{}
+		for _, k := range debugStorageKeys {
+			if v, ok := stanza[k]; ok {
+				filtered[k] = v
+			}
+		}
+		return filtered
+	}
+
+	storage := filter(config["storage"])
+	haStorage := filter(config["ha_storage"])
+
+	// HA is provided by the dedicated HA backend if one is configured, and by
+	// the storage backend otherwise
+	var haBackend interface{}
+	switch {
+	case !leader.HAEnabled:
+	case haStorage != nil:
+		haBackend = haStorage["type"]
+	case storage != nil:
+		haBackend = storage["type"]
+	}
+
+	entry := map[string]interface{}{
+		"timestamp":  time.Now().UTC(),
+		"storage":    storage,
+		"ha_storage": haStorage,
+		"ha_enabled": leader.HAEnabled,
+		"ha_backend": haBackend,
+	}
+	return c.writeJSON("storage.json", entry)
+}
+
+// captureTokenSelf captures the properties of the token used for the run, such
+// as its policies and TTL, with any identifying values redacted.
+func (c *DebugCommand) captureTokenSelf(ctx context.Context) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	data, err := c.requestData(ctx, "/v1/auth/token/lookup-self", nil)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range debugTokenRedactKeys {
+		if _, ok := data[k]; ok {
+			data[k] = debugRedactedValue
+		}
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"token":     data,
+	}
+	return c.writeJSON("token_self.json", entry)
+}
+
+// redactLicense walks the license status data and replaces any raw license
+// blob with a placeholder, leaving metadata such as the expiration time,
+// features, and license ID in place.
+func redactLicense(data map[string]interface{}) map[string]interface{} {
+	for k, v := range data {
+		if strutil.StrListContains(debugLicenseRedactKeys, k) {
+			if _, ok := v.(string); ok {
+				data[k] = debugRedactedValue
+				continue
+			}
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			data[k] = redactLicense(nested)
+		}
+	}
+	return data
+}
+
+// capturePollingTargets captures all dynamic targets over the given duration
+// and the specified interval. Each interval produces a frame, which is written
+// to its own numbered sub-directory in the output directory.
+func (c *DebugCommand) capturePollingTargets(ctx context.Context, duration time.Duration) error {
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	// The server log is streamed for the duration and until every other
+	// target is captured
+	logCtx, stopLog := context.WithCancel(ctx)
+	defer stopLog()
+	var logDone chan struct{}
+	if strutil.StrListContains(c.cfg.Targets, "log") {
+		logDone = make(chan struct{})
+		go func() {
+			defer close(logDone)
+			c.runCapture(logCtx, "log", debugStaticFrame, c.captureLog)
+		}()
+	}
+
+	// Metrics are collected on their own interval, independent of frames
+	if strutil.StrListContains(c.cfg.Targets, "metrics") {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.collectMetrics(ctx, duration)
+		}()
+	}
+
+	// Custom paths and mount health paths are collected on the metrics
+	// interval as well
+	for _, custom := range append(append([]*DebugCustomPath{}, c.customPaths...), c.mountHealth...) {
+		wg.Add(1)
+		go func(custom *DebugCustomPath) {
+			defer wg.Done()
+			c.collectCustomPath(ctx, duration, custom)
+		}(custom)
+	}
+
+	frames := frameCount(duration, c.cfg.Interval)
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+POLL:
+	for idx := 0; idx < frames; idx++ {
+		if idx > 0 {
+			select {
+			case <-ctx.Done():
+				break POLL
+			case <-ticker.C:
+			}
+		}
+
+		// The extend condition is evaluated alongside every frame
+		if c.extendCondition != nil {
+			wg.Add(1)
+			go func(frame int) {
+				defer wg.Done()
+				c.observeExtendCondition(withCaptureTarget(ctx, "extend-on", frame))
+			}(c.frameOffset + idx)
+		}
+
+		c.captureFrame(ctx, idx, frames)
+	}
+
+	wg.Wait()
+
+	// The log covers the whole duration, even if the last frame was
+	// captured before it elapsed
+	if logDone != nil {
+		timer := time.NewTimer(time.Until(start.Add(duration)))
+		select {
+		case <-ctx.Done():
+		case <-logDone:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	stopLog()
+	if logDone != nil {
+		<-logDone
+	}
+
+	return nil
+}
+
+// captureFrame captures all frame-based targets concurrently for the given
+// frame index.
+func (c *DebugCommand) captureFrame(ctx context.Context, idx, frames int) {
+	c.UI.Info(fmt.Sprintf("    - Capturing frame %d of %d", idx+1, frames))
+
+	// Frames of a resumed capture are numbered after those of the bundle
+	frame := c.frameOffset + idx
+
+	start := time.Now().UTC()
+	frameDir := fmt.Sprintf("%03d", frame)
+	if c.cfg.TimestampDirs {
+		frameDir = start.Format(fileFriendlyTimeFormat)
+	}
+	if err := os.MkdirAll(filepath.Join(c.cfg.Output, frameDir), 0755); err != nil {
+		c.recordCapture("frame", frame, fmt.Errorf("unable to create sub-directory: %s", err))
+		return
+	}
+
+	c.errLock.Lock()
+	c.debugIndex.Frames = append(c.debugIndex.Frames, DebugFrame{
+		Frame:     frame,
+		Directory: frameDir,
+		Timestamp: start,
+	})
+	c.errLock.Unlock()
+
+	captureFrame := &CaptureFrame{
+		Frame: frame,
+		Dir:   frameDir,
+		Index: idx,
+		Count: frames,
+		c:     c,
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range c.selectedTargets(debugTargetFrame) {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			c.runRegisteredCapture(ctx, target, captureFrame)
+		}(target)
+	}
+	wg.Wait()
+
+	c.errLock.Lock()
+	for i := range c.debugIndex.Frames {
+		if c.debugIndex.Frames[i].Frame == frame {
+			c.debugIndex.Frames[i].EndTimestamp = time.Now().UTC()
+		}
+	}
+	c.errLock.Unlock()
+
+	c.frameDone(frame)
+}
+
+// frameDone is called once the given frame is captured. It rewrites the index
+// file so that the output directory describes every frame captured so far,
+// even if the run is killed before the capture completes.
+func (c *DebugCommand) frameDone(idx int) {
+	if c.parent != nil {
+		c.parent.frameDone(idx)
+		return
+	}
+
+	if err := c.writePartialIndex(); err != nil {
+		c.UI.Warn(fmt.Sprintf("Error writing partial index file: %s", err))
+	}
+	if c.frameHook != nil {
+		c.frameHook(idx)
+	}
+}
+
+// captureHostInfo captures information about the host running the server,
+// along with the memory and CPU limits of the container the command runs in
+// and the open file descriptors and threads of the server process.
+func (c *DebugCommand) captureHostInfo(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	data, err := c.requestData(ctx, "/v1/sys/host-info", nil)
+	if err != nil {
+		return err
+	}
+
+	// The host information reports the totals of the node, so the limits of
+	// the container are recorded alongside it if there are any. They are read
+	// from the machine running the command, so only for a local server.
+	if c.localServer() {
+		cgroupRoot := c.cgroupRoot
+		if cgroupRoot == "" {
+			cgroupRoot = debugCgroupRoot
+		}
+		if limits := readContainerLimits(cgroupRoot); limits != nil {
+			data["container_limits"] = limits
+		}
+	}
+
+	procRoot := c.procRoot
+	if procRoot == "" {
+		procRoot = debugProcRoot
+	}
+	if c.localServer() {
+		data["process_limits"] = readProcessLimits(procRoot)
+	} else {
+		data["process_limits"] = &debugProcessLimits{Note: debugRemoteNote}
+	}
+	if c.cfg.CaptureProcessEnv {
+		if c.localServer() {
+			data["process"] = readVaultProcess(procRoot)
+		} else {
+			data["process"] = &debugProcess{Note: debugRemoteNote}
+		}
+	}
+
+	return c.writeJSON(filepath.Join(frameDir, "host_info.json"), data)
+}
+
+// localServer returns whether the server being captured runs on the machine
+// running the command, so that what is read from the local host can be
+// attributed to it. This is the case for a unix socket or loopback address,
+// but never for the nodes of -cluster or -raft-peers, since several nodes on
+// a loopback address cannot be told apart.
+func (c *DebugCommand) localServer() bool {
+	switch {
+	case c.parent != nil:
+		return false
+	case c.unixSocket != "":
+		return true
+	case c.cachedClient == nil:
+		return false
+	}
+	return debugLoopbackAddress(c.cachedClient.Address())
+}
+
+// debugLoopbackAddress returns whether the host of the given address is
+// localhost or a loopback IP address.
+func debugLoopbackAddress(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c *DebugCommand) captureReplicationStatus(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	data, err := c.requestData(ctx, "/v1/sys/replication/status", nil)
+	if err != nil {
+		return err
+	}
+	data["timestamp"] = time.Now().UTC()
+
+	return c.writeJSON(filepath.Join(frameDir, "replication_status.json"), data)
+}
+
+// captureReplicationPerf captures the detailed performance and DR replication
+// status, including the WAL positions and merkle sync state. A note is written
+// in place of the status if the replication mode is unavailable on the server,
+// such as on Vault OSS, or disabled.
+func (c *DebugCommand) captureReplicationPerf(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	modes := []struct {
+		name string
+		desc string
+		path string
+	}{
+		{"performance", "Performance", "/v1/sys/replication/performance/status"},
+		{"dr", "DR", "/v1/sys/replication/dr/status"},
+	}
+
+	for _, mode := range modes {
+		file := filepath.Join(frameDir, "replication_"+mode.name)
+
+		data, err := c.requestData(ctx, mode.path, nil)
+		switch {
+		case isResponseStatus(err, http.StatusNotFound):
+			note := fmt.Sprintf("%s replication is unavailable on this server.", mode.desc)
+			if err := c.writeNote(file+".txt", note); err != nil {
+				return err
+			}
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to read %s replication status: %s", mode.name, err)
+		case data["mode"] == "disabled":
+			note := fmt.Sprintf("%s replication is disabled on this server.", mode.desc)
+			if err := c.writeNote(file+".txt", note); err != nil {
+				return err
+			}
+			continue
+		}
+		data["timestamp"] = time.Now().UTC()
+
+		if err := c.writeJSON(file+".json", data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// captureCounters captures the activity and token counters. Reading the
+// counters may require permissions beyond those of the token used for the run,
+// in which case a note is written in place of the counter.
+func (c *DebugCommand) captureCounters(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	counters := []struct {
+		name string
+		path string
+	}{
+		{"activity", "/v1/sys/internal/counters/activity"},
+		{"tokens", "/v1/sys/internal/counters/tokens"},
+	}
+
+	for _, counter := range counters {
+		file := filepath.Join(frameDir, "counters_"+counter.name)
+
+		data, err := c.requestData(ctx, counter.path, nil)
+		switch {
+		case isResponseStatus(err, http.StatusForbidden):
+			note := fmt.Sprintf("Permission denied reading the %s counters, they were not captured.", counter.name)
+			if err := c.writeNote(file+".txt", note); err != nil {
+				return err
+			}
+			continue
+		case isResponseStatus(err, http.StatusNotFound):
+			note := fmt.Sprintf("The %s counters are unavailable on this server.", counter.name)
+			if err := c.writeNote(file+".txt", note); err != nil {
+				return err
+			}
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to read %s counters: %s", counter.name, err)
+		}
+		data["timestamp"] = time.Now().UTC()
+
+		if err := c.writeJSON(file+".json", data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// captureLeases captures the token and lease counts, so that runaway lease
+// creation can be followed from frame to frame. A count that is unavailable
+// or not permitted is listed under notes in place of the count, and a note is
+// written in place of the file if neither count could be read.
+func (c *DebugCommand) captureLeases(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	counts := []struct {
+		name string
+		path string
+	}{
+		{"tokens", "/v1/sys/internal/counters/tokens"},
+		{"leases", "/v1/sys/leases/count"},
+	}
+
+	data := map[string]interface{}{}
+	var notes []string
+	for _, count := range counts {
+		resp, err := c.requestData(ctx, count.path, nil)
+		switch {
+		case isResponseStatus(err, http.StatusForbidden):
+			notes = append(notes, fmt.Sprintf("Permission denied reading the %s count, it was not captured.", count.name))
+			continue
+		case isResponseStatus(err, http.StatusNotFound):
+			notes = append(notes, fmt.Sprintf("The %s count is unavailable on this server.", count.name))
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to read %s count: %s", count.name, err)
+		}
+		data[count.name] = resp
+	}
+
+	if len(data) == 0 {
+		return c.writeNote(filepath.Join(frameDir, "leases.txt"), strings.Join(notes, " "))
+	}
+	if len(notes) > 0 {
+		data["notes"] = notes
+	}
+	data["timestamp"] = time.Now().UTC()
+
+	return c.writeJSON(filepath.Join(frameDir, "leases.json"), data)
+}
+
+// captureGoroutineCount samples the number of goroutines of the server,
+// without capturing a full profile. The count is read from the
+// vault.runtime.num_goroutines gauge of the metrics, falling back to the
+// header of the goroutine profile if the gauge is unavailable. Samples are
+// kept in memory and written to goroutine_counts.json at the end.
+func (c *DebugCommand) captureGoroutineCount(ctx context.Context, frame int) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	sample := goroutineCount{
+		Frame:     frame,
+		Timestamp: time.Now().UTC(),
+		Count:     -1,
+		Source:    "metrics",
+	}
+
+	var summary debugMetricsSummary
+	if err := c.requestJSON(ctx, "/v1/sys/metrics", nil, &summary); err == nil {
+		for _, gauge := range summary.Gauges {
+			if gauge.Name == "vault.runtime.num_goroutines" {
+				sample.Count = int(gauge.Value)
+				break
+			}
+		}
+	}
+
+	if sample.Count < 0 {
+		params := url.Values{}
+		params.Add("debug", "1")
+		data, err := c.requestRaw(ctx, "/v1/sys/pprof/goroutine", params)
+		if err != nil {
+			return fmt.Errorf("goroutine count is unavailable from both the metrics and the goroutine profile: %s", err)
+		}
+		if _, err := fmt.Sscanf(string(data), "goroutine profile: total %d", &sample.Count); err != nil {
+			return fmt.Errorf("failed to parse goroutine profile header: %s", err)
+		}
+		sample.Source = "pprof"
+	}
+
+	c.goroutineLock.Lock()
+	defer c.goroutineLock.Unlock()
+	c.goroutineCounts = append(c.goroutineCounts, sample)
+	return nil
+}
+
+// writeGoroutineCounts writes the goroutine counts sampled on every frame to
+// the output directory, ordered by frame, and resets them for the next
+// capture.
+func (c *DebugCommand) writeGoroutineCounts() error {
+	c.goroutineLock.Lock()
+	counts := c.goroutineCounts
+	c.goroutineCounts = nil
+	c.goroutineLock.Unlock()
+
+	// The counts of a resumed capture are appended to those of the bundle
+	if c.cfg.Resume != "" {
+		var existing []goroutineCount
+		content, err := ioutil.ReadFile(filepath.Join(c.cfg.Output, "goroutine_counts.json"))
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(content, &existing); err != nil {
+				return fmt.Errorf("failed to parse existing goroutine counts: %s", err)
+			}
+		}
+		counts = append(existing, counts...)
+	}
+
+	if counts == nil {
+		counts = []goroutineCount{}
+	}
+	sort.SliceStable(counts, func(i, j int) bool { return counts[i].Frame < counts[j].Frame })
+
+	return c.writeJSON("goroutine_counts.json", counts)
+}
+
+// captureHALock captures the holder of the HA lock as seen by the server, so
+// that changes of leadership can be traced from frame to frame. A note is
+// written in place of the file on servers without HA, such as a single-node
+// dev server.
+func (c *DebugCommand) captureHALock(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	var leader map[string]interface{}
+	if err := c.requestJSON(ctx, "/v1/sys/leader", nil, &leader); err != nil {
+		return err
+	}
+	if enabled, _ := leader["ha_enabled"].(bool); !enabled {
+		return c.writeNote(filepath.Join(frameDir, "ha_lock.txt"), "HA is not enabled on this server, so there is no lock holder.")
+	}
+	leader["timestamp"] = time.Now().UTC()
+
+	return c.writeJSON(filepath.Join(frameDir, "ha_lock.json"), leader)
+}
+
+// debugInFlightRequest is a request being served by the server, as listed by
+// sys/in-flight-req.
+type debugInFlightRequest struct {
+	ID               string    `json:"id"`
+	StartTime        time.Time `json:"start_time"`
+	Method           string    `json:"request_method"`
+	Path             string    `json:"request_path"`
+	ClientRemoteAddr string    `json:"client_remote_address"`
+}
+
+// captureRequests captures the requests being served by the server, oldest
+// first, so that the frames show what the server was busy with when latency
+// spiked. The requests listing them, including the one made by the command,
+// are left out. A note is written in place of the file on servers that don't
+// track in-flight requests, or if the token used for the run is not permitted
+// to list them.
+func (c *DebugCommand) captureRequests(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	var resp struct {
+		Data map[string]debugInFlightRequest `json:"data"`
+	}
+	err := c.requestJSON(ctx, "/v1/sys/in-flight-req", nil, &resp)
+	switch {
+	case isResponseStatus(err, http.StatusNotFound):
+		return c.writeNote(filepath.Join(frameDir, "requests.txt"), "In-flight requests are not tracked by this server.")
+	case isResponseStatus(err, http.StatusForbidden):
+		return c.writeNote(filepath.Join(frameDir, "requests.txt"), "Permission denied listing the in-flight requests, they were not captured.")
+	case err != nil:
+		return err
+	}
+
+	requests := []debugInFlightRequest{}
+	for id, req := range resp.Data {
+		if strings.TrimSuffix(req.Path, "/") == "/v1/sys/in-flight-req" {
+			continue
+		}
+		req.ID = id
+		requests = append(requests, req)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].StartTime.Equal(requests[j].StartTime) {
+			return requests[i].StartTime.Before(requests[j].StartTime)
+		}
+		return requests[i].ID < requests[j].ID
+	})
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"count":     len(requests),
+		"requests":  requests,
+	}
+	return c.writeJSON(filepath.Join(frameDir, "requests.json"), entry)
+}
+
+func (c *DebugCommand) captureHealth(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	var health api.HealthResponse
+	if err := c.requestJSON(ctx, "/v1/sys/health", debugHealthParams(), &health); err != nil {
+		return err
+	}
+
+	return c.writeJSON(filepath.Join(frameDir, "health.json"), health)
+}
+
+func (c *DebugCommand) captureServerStatus(ctx context.Context, frameDir string) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	entry := &serverStatus{
+		Timestamp: time.Now().UTC(),
+	}
+
+	if err := c.requestJSON(ctx, "/v1/sys/health", debugHealthParams(), &entry.Health); err != nil {
+		return err
+	}
+	if err := c.requestJSON(ctx, "/v1/sys/seal-status", nil, &entry.Seal); err != nil {
+		return err
+	}
+
+	return c.writeJSON(filepath.Join(frameDir, "server_status.json"), entry)
+}
+
+// pprofSnapshot returns whether heap and goroutine profiles are captured on
+// the given frame. By default these are only captured on the first and last
+// frames, or on the first -pprof-max-frames frames with -pprof-every-frame.
+func (c *DebugCommand) pprofSnapshot(idx, frames int) bool {
+	if c.cfg.PprofEveryFrame {
+		return c.cfg.PprofMaxFrames == 0 || idx < c.cfg.PprofMaxFrames
+	}
+	return idx == 0 || idx == frames-1
+}
+
+// rotatePprofRing adds the frame to the pprof ring and removes the pprof output
+// of the oldest frame once the ring holds more than -pprof-ring frames.
+func (c *DebugCommand) rotatePprofRing(idx int, frameDir string) {
+	c.pprofLock.Lock()
+	defer c.pprofLock.Unlock()
+
+	c.pprofRing = append(c.pprofRing, DebugFrame{Frame: idx, Directory: frameDir})
+	sort.Slice(c.pprofRing, func(i, j int) bool {
+		return c.pprofRing[i].Frame < c.pprofRing[j].Frame
+	})
+	if len(c.pprofRing) <= c.cfg.PprofRing {
+		return
+	}
+
+	oldest := c.pprofRing[0]
+	c.pprofRing = c.pprofRing[1:]
+
+	for _, file := range []string{"block.prof", "block.prof.gz", "block.txt", "goroutine.prof", "goroutine.prof.gz", "goroutines.txt", "heap.prof", "heap.prof.gz", "mutex.prof", "mutex.prof.gz", "mutex.txt", "profile.prof", "profile.prof.gz", "trace.out", "trace.txt"} {
+		if err := os.Remove(filepath.Join(c.cfg.Output, oldest.Directory, file)); err != nil && !os.IsNotExist(err) {
+			c.UI.Warn(fmt.Sprintf("Error removing pprof output of frame %d: %s", oldest.Frame, err))
+		}
+	}
+}
+
+// pprofProfile returns whether the given pprof profile is selected with
+// -pprof-profiles.
+func (c *DebugCommand) pprofProfile(name string) bool {
+	return len(c.pprofProfiles) == 0 || strutil.StrListContains(c.pprofProfiles, name)
+}
+
+// capturePprof captures the pprof profiles for a single frame. Heap and
+// goroutine snapshots, along with the full goroutine stack dump, are only
+// captured when snapshot is set, which is the case on the first and last
+// frames. The CPU profile and trace block for the duration of an interval and
+// are therefore skipped on the last frame.
+func (c *DebugCommand) capturePprof(ctx context.Context, frameDir string, snapshot, polling bool) error {
+	type profile struct {
+		path     string
+		file     string
+		params   url.Values
+		duration time.Duration
+
+		// note is written in place of the profile if the endpoint doesn't
+		// exist on the server
+		note string
+	}
+
+	var profiles []profile
+	if snapshot {
+		if c.pprofProfile("goroutine") {
+			profiles = append(profiles, profile{path: "/v1/sys/pprof/goroutine", file: "goroutine.prof"})
+			if c.cfg.GoroutineDump {
+				profiles = append(profiles, profile{
+					path:   "/v1/sys/pprof/goroutine",
+					file:   "goroutines.txt",
+					params: url.Values{"debug": []string{"2"}},
+				})
+			}
+		}
+		if c.pprofProfile("heap") {
+			profiles = append(profiles, profile{path: "/v1/sys/pprof/heap", file: "heap.prof"})
+		}
+		if c.pprofProfile("block") {
+			profiles = append(profiles, profile{
+				path: "/v1/sys/pprof/block",
+				file: "block.prof",
+				note: "Block profiling is unavailable on this server.",
+			})
+		}
+		if c.pprofProfile("mutex") {
+			profiles = append(profiles, profile{
+				path: "/v1/sys/pprof/mutex",
+				file: "mutex.prof",
+				note: "Mutex profiling is unavailable on this server.",
+			})
+		}
+	}
+	if polling {
+		if c.pprofProfile("profile") {
+			profiles = append(profiles,
+				profile{path: "/v1/sys/pprof/profile", file: "profile.prof", duration: c.cfg.Interval},
+			)
+		}
+
+		// Only a single trace can run at a time, so skip this frame's trace if
+		// a longer trace from a previous frame is still in flight
+		if c.pprofProfile("trace") && atomic.CompareAndSwapInt32(&c.traceInFlight, 0, 1) {
+			traceDuration := c.cfg.Interval
+			if c.cfg.PprofTraceDuration > 0 {
+				traceDuration = c.cfg.PprofTraceDuration
+			}
+			profiles = append(profiles, profile{
+				path:     "/v1/sys/pprof/trace",
+				file:     "trace.out",
+				duration: traceDuration,
+				note:     "Execution tracing is unavailable on this server.",
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(profiles))
+	for _, p := range profiles {
+		wg.Add(1)
+		go func(p profile) {
+			defer wg.Done()
+
+			ctx, cancel := c.targetContext(ctx, p.duration)
+			defer cancel()
+
+			if p.file == "trace.out" {
+				defer atomic.StoreInt32(&c.traceInFlight, 0)
+			}
+
+			params := p.params
+			if p.duration > 0 {
+				seconds := int(p.duration.Seconds())
+				if seconds < 1 {
+					seconds = 1
+				}
+				params = url.Values{}
+				params.Add("seconds", strconv.Itoa(seconds))
+			}
+
+			request := c.requestFile
+			if c.cfg.CompressProfiles && filepath.Ext(p.file) == ".prof" {
+				request = c.requestGzipFile
+			}
+			if err := request(ctx, p.path, params, filepath.Join(frameDir, p.file)); err != nil {
+				if p.note != "" && isResponseStatus(err, http.StatusNotFound) {
+					noteFile := strings.TrimSuffix(p.file, filepath.Ext(p.file)) + ".txt"
+					if err := c.writeNote(filepath.Join(frameDir, noteFile), p.note); err != nil {
+						errCh <- fmt.Errorf("%s: %s", p.file, err)
+					}
+					return
+				}
+				errCh <- fmt.Errorf("%s: %s", p.file, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(errCh)
+
+	var errs []string
+	for err := range errCh {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// collectMetrics captures the metrics target on every metrics interval,
+// writing each capture under the metrics sub-directory, and under the
+// metrics_prometheus sub-directory if -prometheus-metrics is set.
+func (c *DebugCommand) collectMetrics(ctx context.Context, duration time.Duration) {
+	if err := os.MkdirAll(filepath.Join(c.cfg.Output, "metrics"), 0755); err != nil {
+		c.recordCapture("metrics", 0, fmt.Errorf("unable to create sub-directory: %s", err))
+		return
+	}
+	if c.cfg.PrometheusMetrics {
+		if err := os.MkdirAll(filepath.Join(c.cfg.Output, "metrics_prometheus"), 0755); err != nil {
+			c.recordCapture("metrics", 0, fmt.Errorf("unable to create sub-directory: %s", err))
+			return
+		}
+	}
+
+	frames := frameCount(duration, c.cfg.MetricsInterval)
+	ticker := time.NewTicker(c.cfg.MetricsInterval)
+	defer ticker.Stop()
+
+	for idx := 0; idx < frames; idx++ {
+		if idx > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		// Captures of a resumed capture are numbered after those of the bundle
+		metricsIdx := c.metricsOffset + idx
+		c.runCapture(ctx, "metrics", metricsIdx, func(ctx context.Context) error {
+			return c.captureMetrics(ctx, metricsIdx)
+		})
+		if c.cfg.PrometheusMetrics {
+			c.runCapture(ctx, "metrics", metricsIdx, func(ctx context.Context) error {
+				return c.capturePrometheusMetrics(ctx, metricsIdx)
+			})
+		}
+	}
+}
+
+func (c *DebugCommand) captureMetrics(ctx context.Context, idx int) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	return c.requestFile(ctx, "/v1/sys/metrics", nil, filepath.Join("metrics", fmt.Sprintf("%03d.json", idx)))
+}
+
+// capturePrometheusMetrics captures the metrics in the Prometheus exposition
+// format, numbered the same as the JSON capture of the same interval.
+func (c *DebugCommand) capturePrometheusMetrics(ctx context.Context, idx int) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	params := url.Values{"format": []string{"prometheus"}}
+	return c.requestFile(ctx, "/v1/sys/metrics", params, filepath.Join("metrics_prometheus", fmt.Sprintf("%03d.prom", idx)))
+}
+
+// captureLog streams the server log at -log-level from sys/monitor into
+// vault.log until the context is canceled, once the duration has elapsed and
+// every other target of the run is captured. The log is appended to, so that
+// an extended or resumed capture adds to the log of the bundle. The stream is
+// opened without the client timeout and outside of -max-concurrent-requests,
+// since it stays open for the whole capture. A note is written in place of the
+// log on servers without sys/monitor, or if the token is not permitted to use
+// it.
+func (c *DebugCommand) captureLog(ctx context.Context) error {
+	base := c.clientFor(ctx)
+	client, err := base.Clone()
+	if err != nil {
+		return err
+	}
+	client.SetToken(base.Token())
+	client.SetHeaders(base.Headers())
+	client.SetClientTimeout(0)
+
+	r := client.NewRequest("GET", "/v1/sys/monitor")
+	r.Params.Set("log_level", c.cfg.LogLevel)
+
+	start := time.Now()
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		c.recordTiming(ctx, "/v1/sys/monitor", status, start)
+
+		switch {
+		case isResponseStatus(err, http.StatusNotFound), isResponseStatus(err, http.StatusMethodNotAllowed):
+			return c.writeNote("vault.log", "Log streaming is unavailable on this server, which doesn't serve sys/monitor, the server log was not captured.")
+		case isResponseStatus(err, http.StatusForbidden):
+			return c.writeNote("vault.log", "Permission denied streaming the server log from sys/monitor, which requires sudo, the server log was not captured.")
+		case ctx.Err() != nil:
+			// The capture completed before the stream was opened
+			return nil
+		}
+		return err
+	}
+
+	dst := filepath.Join(c.cfg.Output, "vault.log")
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	c.recordTiming(ctx, "/v1/sys/monitor", resp.StatusCode, start)
+	if closeErr := f.Close(); closeErr != nil {
+		return closeErr
+	}
+	c.recordFileTime(dst)
+
+	// The stream only ends early if the server goes away
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("server log stream interrupted: %s", err)
+	}
+	return nil
+}
+
+// debugMetricsValue is a gauge, counter, or sample in a metrics capture.
+type debugMetricsValue struct {
+	Name   string            `json:"Name"`
+	Value  float64           `json:"Value"`
+	Count  int               `json:"Count"`
+	Sum    float64           `json:"Sum"`
+	Min    float64           `json:"Min"`
+	Max    float64           `json:"Max"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// series returns the name of the series the value belongs to, which includes
+// its labels.
+func (m debugMetricsValue) series() string {
+	if len(m.Labels) == 0 {
+		return m.Name
+	}
+	labels := make([]string, 0, len(m.Labels))
+	for k, v := range m.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return m.Name + ";" + strings.Join(labels, ";")
+}
+
+// debugMetricsSummary is a single metrics capture.
+type debugMetricsSummary struct {
+	Timestamp string              `json:"Timestamp"`
+	Gauges    []debugMetricsValue `json:"Gauges"`
+	Counters  []debugMetricsValue `json:"Counters"`
+	Samples   []debugMetricsValue `json:"Samples"`
+}
+
+// readMetricsCaptures reads every metrics capture of the bundle, in the order
+// they were captured.
+func (c *DebugCommand) readMetricsCaptures() ([]debugMetricsSummary, error) {
+	files, err := filepath.Glob(filepath.Join(c.cfg.Output, "metrics", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	summaries := make([]debugMetricsSummary, 0, len(files))
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var summary debugMetricsSummary
+		if err := json.Unmarshal(content, &summary); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", filepath.Base(file), err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// writeMetricsCSV rolls up the gauges and counters of every metrics capture
+// into metrics.csv, with one row per capture. Gauges are reported by their
+// value and counters by their sum over the interval. Series are named after
+// the metric, followed by its labels if it has any, and are limited to
+// -metrics-csv-keys if set.
+func (c *DebugCommand) writeMetricsCSV() error {
+	summaries, err := c.readMetricsCaptures()
+	if err != nil {
+		return err
+	}
+
+	var timestamps []string
+	var rows []map[string]float64
+	columns := map[string]struct{}{}
+	for _, summary := range summaries {
+		row := map[string]float64{}
+		add := func(m debugMetricsValue, value float64) {
+			if len(c.cfg.MetricsCSVKeys) > 0 && !strutil.StrListContains(c.cfg.MetricsCSVKeys, m.Name) {
+				return
+			}
+			name := m.series()
+			row[name] = value
+			columns[name] = struct{}{}
+		}
+		for _, gauge := range summary.Gauges {
+			add(gauge, gauge.Value)
+		}
+		for _, counter := range summary.Counters {
+			add(counter, counter.Sum)
+		}
+
+		timestamps = append(timestamps, summary.Timestamp)
+		rows = append(rows, row)
+	}
+
+	header := make([]string, 0, len(columns))
+	for name := range columns {
+		header = append(header, name)
+	}
+	sort.Strings(header)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(append([]string{"timestamp"}, header...)); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		record := []string{timestamps[i]}
+		for _, name := range header {
+			value, ok := row[name]
+			if !ok {
+				record = append(record, "")
+				continue
+			}
+			record = append(record, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return c.writeFile("metrics.csv", buf.Bytes())
+}
+
+// debugCounterDelta is the change of a counter between two consecutive
+// metrics captures.
+type debugCounterDelta struct {
+	Delta         float64 `json:"delta"`
+	RatePerSecond float64 `json:"rate_per_second"`
+}
+
+// debugMetricsDelta holds the counter deltas between a metrics capture and
+// the one before it, along with the gauges of the capture.
+type debugMetricsDelta struct {
+	From      int                          `json:"from"`
+	To        int                          `json:"to"`
+	Timestamp string                       `json:"timestamp"`
+	Counters  map[string]debugCounterDelta `json:"counters"`
+	Gauges    map[string]float64           `json:"gauges"`
+}
+
+// writeMetricsDelta computes the change of every counter between consecutive
+// metrics captures into metrics_delta.json, along with its rate per second
+// over the metrics interval. Counters are compared by their sum, and only
+// counters present in both captures are reported. Gauges are passed through
+// unchanged.
+func (c *DebugCommand) writeMetricsDelta() error {
+	summaries, err := c.readMetricsCaptures()
+	if err != nil {
+		return err
+	}
+
+	interval := c.cfg.MetricsInterval.Seconds()
+	deltas := []debugMetricsDelta{}
+	for i := 1; i < len(summaries); i++ {
+		previous := map[string]float64{}
+		for _, counter := range summaries[i-1].Counters {
+			previous[counter.series()] = counter.Sum
+		}
+
+		delta := debugMetricsDelta{
+			From:      i - 1,
+			To:        i,
+			Timestamp: summaries[i].Timestamp,
+			Counters:  map[string]debugCounterDelta{},
+			Gauges:    map[string]float64{},
+		}
+		for _, counter := range summaries[i].Counters {
+			name := counter.series()
+			prev, ok := previous[name]
+			if !ok {
+				continue
+			}
+			d := counter.Sum - prev
+			delta.Counters[name] = debugCounterDelta{
+				Delta:         d,
+				RatePerSecond: d / interval,
+			}
+		}
+		for _, gauge := range summaries[i].Gauges {
+			delta.Gauges[gauge.series()] = gauge.Value
+		}
+		deltas = append(deltas, delta)
+	}
+
+	return c.writeJSON("metrics_delta.json", map[string]interface{}{
+		"interval_seconds": interval,
+		"deltas":           deltas,
+	})
+}
+
+// debugSealTiming is the roll-up of a seal or barrier timer across every
+// metrics capture it was reported in. Times are in milliseconds.
+type debugSealTiming struct {
+	Captures int     `json:"captures"`
+	Count    int     `json:"count"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Mean     float64 `json:"mean"`
+}
+
+// writeSealTiming rolls up the timers matching debugSealTimingPrefixes from
+// every metrics capture into seal_timing.json. The mean is weighted by the
+// number of samples of each capture, and captures in which a timer has no
+// samples are skipped. A note is written in its place if the metrics target
+// isn't captured.
+func (c *DebugCommand) writeSealTiming() error {
+	if !strutil.StrListContains(c.cfg.Targets, "metrics") {
+		return c.writeNote("seal_timing.txt", "Seal timing is derived from the metrics captures, and the metrics target was not captured")
+	}
+
+	summaries, err := c.readMetricsCaptures()
+	if err != nil {
+		return err
+	}
+
+	sums := map[string]float64{}
+	timings := map[string]debugSealTiming{}
+	for _, summary := range summaries {
+		for _, sample := range summary.Samples {
+			if sample.Count == 0 || !debugSealTimer(sample.Name) {
+				continue
+			}
+
+			name := sample.series()
+			timing, ok := timings[name]
+			if !ok || sample.Min < timing.Min {
+				timing.Min = sample.Min
+			}
+			if !ok || sample.Max > timing.Max {
+				timing.Max = sample.Max
+			}
+			timing.Captures++
+			timing.Count += sample.Count
+			sums[name] += sample.Sum
+			timing.Mean = sums[name] / float64(timing.Count)
+			timings[name] = timing
+		}
+	}
+
+	return c.writeJSON("seal_timing.json", map[string]interface{}{
+		"metrics": timings,
+	})
+}
+
+// debugSealTimer returns whether the named timer is rolled up by the
+// seal-timing target.
+func debugSealTimer(name string) bool {
+	for _, prefix := range debugSealTimingPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// debugHotPath is the number of requests to a path across every metrics
+// capture, along with its rate per second.
+type debugHotPath struct {
+	Path          string  `json:"path"`
+	Count         int     `json:"count"`
+	RatePerSecond float64 `json:"rate_per_second"`
+}
+
+// writeHotPaths rolls up the request metrics of every metrics capture into
+// hot_paths.json, listing the -hot-paths-n busiest paths by their rate over
+// the captured intervals. The route metrics starting with debugHotPathsPrefix
+// are counted, along with any counter labeled with a path. Paths are named
+// after the metric, followed by its labels if it has any. A note is written in
+// its place if the metrics target isn't captured.
+func (c *DebugCommand) writeHotPaths() error {
+	if !strutil.StrListContains(c.cfg.Targets, "metrics") {
+		return c.writeNote("hot_paths.txt", "Hot paths are derived from the metrics captures, and the metrics target was not captured")
+	}
+
+	summaries, err := c.readMetricsCaptures()
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, summary := range summaries {
+		values := append(append([]debugMetricsValue{}, summary.Counters...), summary.Samples...)
+		for _, value := range values {
+			if _, ok := value.Labels["path"]; !ok && !strings.HasPrefix(value.Name, debugHotPathsPrefix) {
+				continue
+			}
+			counts[value.series()] += value.Count
+		}
+	}
+
+	// Each capture covers one metrics interval
+	seconds := float64(len(summaries)) * c.cfg.MetricsInterval.Seconds()
+	paths := make([]debugHotPath, 0, len(counts))
+	for path, count := range counts {
+		hot := debugHotPath{Path: path, Count: count}
+		if seconds > 0 {
+			hot.RatePerSecond = float64(count) / seconds
+		}
+		paths = append(paths, hot)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Count != paths[j].Count {
+			return paths[i].Count > paths[j].Count
+		}
+		return paths[i].Path < paths[j].Path
+	})
+	if len(paths) > c.cfg.HotPathsN {
+		paths = paths[:c.cfg.HotPathsN]
+	}
+
+	return c.writeJSON("hot_paths.json", map[string]interface{}{
+		"captures":         len(summaries),
+		"interval_seconds": c.cfg.MetricsInterval.Seconds(),
+		"paths":            paths,
+	})
+}
+
+// debugReplicationSample is the WAL position of a replication mode in a
+// single frame.
+type debugReplicationSample struct {
+	Frame         int       `json:"frame"`
+	Timestamp     time.Time `json:"timestamp"`
+	LastWAL       uint64    `json:"last_wal"`
+	LastRemoteWAL uint64    `json:"last_remote_wal"`
+	Lag           uint64    `json:"lag"`
+}
+
+// debugReplicationProgress is the estimated lag of a replication mode across
+// the frames of the capture.
+type debugReplicationProgress struct {
+	Mode        string                   `json:"mode"`
+	Samples     []debugReplicationSample `json:"samples"`
+	Lag         uint64                   `json:"lag"`
+	SyncPercent float64                  `json:"sync_percent"`
+	LagChange   int64                    `json:"lag_change"`
+	Trend       string                   `json:"trend"`
+}
+
+// writeReplicationProgress rolls up the replication status of every frame
+// into replication_progress.json. For each replication mode that reports
+// both last_wal and last_remote_wal, the lag is estimated as the difference
+// between the two, and the trend reports whether the lag shrank or grew
+// between the first and the last frame. Nothing is written if no frame
+// reports both positions, such as when replication is disabled.
+func (c *DebugCommand) writeReplicationProgress() error {
+	type modeStatus struct {
+		Mode          string       `json:"mode"`
+		LastWAL       *json.Number `json:"last_wal"`
+		LastRemoteWAL *json.Number `json:"last_remote_wal"`
+	}
+
+	c.errLock.Lock()
+	frames := append([]DebugFrame{}, c.debugIndex.Frames...)
+	c.errLock.Unlock()
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Frame < frames[j].Frame })
+
+	progress := map[string]*debugReplicationProgress{}
+	for _, frame := range frames {
+		content, err := ioutil.ReadFile(filepath.Join(c.cfg.Output, frame.Directory, "replication_status.json"))
+		if err != nil {
+			// The capture may have failed on this frame, which is already
+			// recorded in the index
+			continue
+		}
+
+		var status map[string]json.RawMessage
+		if err := json.Unmarshal(content, &status); err != nil {
+			return fmt.Errorf("failed to parse %s/replication_status.json: %s", frame.Directory, err)
+		}
+
+		for _, name := range []string{"dr", "performance"} {
+			var mode modeStatus
+			if raw, ok := status[name]; !ok || json.Unmarshal(raw, &mode) != nil {
+				continue
+			}
+			if mode.LastWAL == nil || mode.LastRemoteWAL == nil {
+				continue
+			}
+			lastWAL, err := strconv.ParseUint(mode.LastWAL.String(), 10, 64)
+			if err != nil {
+				continue
+			}
+			lastRemoteWAL, err := strconv.ParseUint(mode.LastRemoteWAL.String(), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			var lag uint64
+			if lastWAL > lastRemoteWAL {
+				lag = lastWAL - lastRemoteWAL
+			}
+
+			p, ok := progress[name]
+			if !ok {
+				p = &debugReplicationProgress{}
+				progress[name] = p
+			}
+			p.Mode = mode.Mode
+			p.Samples = append(p.Samples, debugReplicationSample{
+				Frame:         frame.Frame,
+				Timestamp:     frame.Timestamp,
+				LastWAL:       lastWAL,
+				LastRemoteWAL: lastRemoteWAL,
+				Lag:           lag,
+			})
+		}
+	}
+
+	if len(progress) == 0 {
+		return nil
+	}
+
+	for _, p := range progress {
+		first, last := p.Samples[0], p.Samples[len(p.Samples)-1]
+		p.Lag = last.Lag
+		p.SyncPercent = 100
+		if last.LastWAL > 0 && last.Lag > 0 {
+			p.SyncPercent = math.Round(float64(last.LastWAL-last.Lag)/float64(last.LastWAL)*10000) / 100
+		}
+		p.LagChange = int64(last.Lag) - int64(first.Lag)
+
+		switch {
+		case len(p.Samples) < 2:
+			p.Trend = "unknown"
+		case p.LagChange < 0:
+			p.Trend = "shrinking"
+		case p.LagChange > 0:
+			p.Trend = "growing"
+		default:
+			p.Trend = "steady"
+		}
+	}
+
+	return c.writeJSON("replication_progress.json", progress)
+}
+
+// debugHealthParams returns the query parameters for the health endpoint with
+// status codes overridden so that an uninitialized, sealed, or standby node
+// doesn't result in an error.
+func debugHealthParams() url.Values {
+	params := url.Values{}
+	params.Add("uninitcode", "200")
+	params.Add("sealedcode", "200")
+	params.Add("standbycode", "200")
+	params.Add("drsecondarycode", "200")
+	params.Add("performancestandbycode", "200")
+	return params
+}
+
+// connect ensures that the server the client points at can be reached and
+// returns the client to use for cluster-wide targets, which points at the
+// active node with -follow-active, along with the health status of the server.
+// Capturing from a standby only warns unless -require-active is set.
+func (c *DebugCommand) connect(client *api.Client) (*api.Client, *api.HealthResponse, error) {
+	health, err := client.Sys().Health()
+	if err != nil {
+		if _, ok := err.(*api.ResponseError); ok {
+			return nil, nil, fmt.Errorf("health check of %s failed: %s", client.Address(), err)
+		}
+		return nil, nil, fmt.Errorf("unable to reach %s: %s", client.Address(), err)
+	}
+
+	// A dry run only checks that the server can be reached, so the token is
+	// not looked up and the active node is not resolved
+	if !c.skipPreflight && !c.flagDryRun {
+		if err := checkDebugToken(client); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if health.Standby || health.PerformanceStandby {
+		if c.cfg.RequireActive {
+			return nil, nil, fmt.Errorf("%s is a standby node and -require-active is set", client.Address())
+		}
+		c.UI.Warn(wrapAtLength(fmt.Sprintf("WARNING! %s is a standby node. Some "+
+			"targets may return stale or partial data when captured from a "+
+			"standby. Use -follow-active to capture cluster-wide targets from the "+
+			"active node, or -require-active to refuse to capture from a standby.",
+			client.Address())) + "\n")
+	}
+
+	if c.anonymizer != nil {
+		// Seed the anonymizer with the cluster identity, which is known even
+		// if no captured file contains it
+		c.anonymizer.collect(map[string]interface{}{
+			"cluster_id":   health.ClusterID,
+			"cluster_name": health.ClusterName,
+		})
+	}
+
+	if !c.cfg.FollowActive || c.flagDryRun {
+		return client, health, nil
+	}
+
+	activeClient, err := resolveActiveClient(client)
+	if err != nil {
+		c.UI.Warn(fmt.Sprintf("Unable to resolve the active node, using %s for all targets: %s", client.Address(), err))
+		return client, health, nil
+	}
+	return activeClient, health, nil
+}
+
+// checkDebugToken looks up the token of the client, so that an invalid or
+// expired token fails the run before anything is captured, rather than
+// failing every target on its own.
+func checkDebugToken(client *api.Client) error {
+	_, err := client.Auth().Token().LookupSelf()
+	if err == nil {
+		return nil
+	}
+	if isResponseStatus(err, http.StatusForbidden) {
+		return fmt.Errorf("permission denied looking up the token on %s, the token may be invalid or expired", client.Address())
+	}
+	if _, ok := err.(*api.ResponseError); ok {
+		return fmt.Errorf("unable to look up the token on %s: %s", client.Address(), err)
+	}
+	return fmt.Errorf("unable to reach %s: %s", client.Address(), err)
+}
+
+// transportClient returns a copy of the given client that sends every
+// request through -proxy, and skips TLS verification with -insecure. The
+// transport is built from scratch with the TLS settings of the command, so
+// that the client of the command itself is left untouched.
+func (c *DebugCommand) transportClient(base *api.Client) (*api.Client, error) {
+	var proxyURL *url.URL
+	if c.cfg.Proxy != "" {
+		var err error
+		proxyURL, err = url.Parse(c.cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %s", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy URL %q, scheme must be one of: http, https, socks5", c.cfg.Proxy)
+		}
+	}
+
+	config := api.DefaultConfig()
+	if err := config.ReadEnvironment(); err != nil {
+		return nil, fmt.Errorf("failed to read environment: %s", err)
+	}
+	config.Address = base.Address()
+
+	if c.flagCACert != "" || c.flagCAPath != "" || c.flagClientCert != "" ||
+		c.flagClientKey != "" || c.flagTLSServerName != "" || c.flagTLSSkipVerify || c.cfg.Insecure {
+		t := &api.TLSConfig{
+			CACert:        c.flagCACert,
+			CAPath:        c.flagCAPath,
+			ClientCert:    c.flagClientCert,
+			ClientKey:     c.flagClientKey,
+			TLSServerName: c.flagTLSServerName,
+			Insecure:      c.flagTLSSkipVerify || c.cfg.Insecure,
+		}
+		if err := config.ConfigureTLS(t); err != nil {
+			return nil, fmt.Errorf("failed to configure TLS: %s", err)
+		}
+	}
+	if proxyURL != nil {
+		config.HttpClient.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyURL)
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %s", err)
+	}
+	if os.Getenv(api.EnvVaultMaxRetries) == "" {
+		client.SetMaxRetries(0)
+	}
+	client.SetToken(base.Token())
+	client.SetHeaders(base.Headers())
+
+	return client, nil
+}
+
+// unixClient returns a copy of the given client that connects to the server
+// over the unix socket at the given path. The API client dials the socket
+// without the context of the request, so the transport is given a dialer of
+// its own, to have -target-timeout and interrupts apply to connecting as well.
+// The TLS settings of the command don't apply to the socket and are left out.
+func (c *DebugCommand) unixClient(base *api.Client, socket string) (*api.Client, error) {
+	config := api.DefaultConfig()
+	if err := config.ReadEnvironment(); err != nil {
+		return nil, fmt.Errorf("failed to read environment: %s", err)
+	}
+	config.Address = "unix://" + socket
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %s", err)
+	}
+	var dialer net.Dialer
+	config.HttpClient.Transport.(*http.Transport).DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+	if os.Getenv(api.EnvVaultMaxRetries) == "" {
+		client.SetMaxRetries(0)
+	}
+	client.SetToken(base.Token())
+	client.SetHeaders(base.Headers())
+
+	return client, nil
+}
+
+// configuredAddress returns the address the client of the command is created
+// with, which is the agent address when one is set.
+func (c *DebugCommand) configuredAddress() string {
+	if c.flagAgentAddress != "" {
+		return c.flagAgentAddress
+	}
+	return c.flagAddress
+}
+
+// debugUnixSocket returns the path of the socket a unix:// address points to.
+func debugUnixSocket(address string) (string, bool) {
+	if !strings.HasPrefix(address, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(address, "unix://"), true
+}
+
+// resolveActiveClient returns a client pointed at the active node of the
+// cluster the given client is connected to. If the node is the active node,
+// the client itself is returned.
+func resolveActiveClient(client *api.Client) (*api.Client, error) {
+	leader, err := client.Sys().Leader()
+	if err != nil {
+		return nil, err
+	}
+	if leader.IsSelf {
+		return client, nil
+	}
+	if leader.LeaderAddress == "" {
+		return nil, fmt.Errorf("no active node found")
+	}
+
+	activeClient, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := activeClient.SetAddress(leader.LeaderAddress); err != nil {
+		return nil, err
+	}
+	activeClient.SetToken(client.Token())
+	activeClient.SetHeaders(client.Headers())
+
+	return activeClient, nil
+}
+
+// clientFor returns the client to use for requests made by the capture target
+// carried in the context.
+func (c *DebugCommand) clientFor(ctx context.Context) *api.Client {
+	if t, ok := ctx.Value(captureTargetKey{}).(captureTarget); ok {
+		if strutil.StrListContains(debugActiveTargets, t.target) {
+			return c.activeClient
+		}
+	}
+	return c.cachedClient
+}
+
+// requestRaw performs a GET request against the given path and returns the
+// raw response body.
+func (c *DebugCommand) requestRaw(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.requestStream(ctx, path, params, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// requestFile performs a GET request against the given path and streams the
+// response body to the given file relative to the output directory, so that
+// large responses are never held in memory. The file is removed if the
+// request fails.
+func (c *DebugCommand) requestFile(ctx context.Context, path string, params url.Values, file string) error {
+	dst := filepath.Join(c.cfg.Output, file)
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := c.requestStream(ctx, path, params, f); err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.recordFileTime(dst)
+	return c.appendStreamFile(file)
+}
+
+// requestGzipFile performs a GET request against the given path and writes
+// the response body to the given file with a .gz suffix, gzip-compressed.
+func (c *DebugCommand) requestGzipFile(ctx context.Context, path string, params url.Values, file string) error {
+	dst := filepath.Join(c.cfg.Output, file+".gz")
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := &debugGzipWriter{w: f}
+	if err := c.requestStream(ctx, path, params, w); err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.recordFileTime(dst)
+	return nil
+}
+
+// debugGzipWriter gzip-compresses the data written to it, unless the data is
+// already a gzip stream, such as a pprof profile in the protobuf format, in
+// which case it is passed through as is so that it isn't compressed twice.
+type debugGzipWriter struct {
+	w io.Writer
+
+	// head buffers the start of the data until the gzip header can be
+	// detected
+	head []byte
+	out  io.Writer
+	gz   *gzip.Writer
+}
+
+func (g *debugGzipWriter) Write(p []byte) (int, error) {
+	if g.out != nil {
+		return g.out.Write(p)
+	}
+
+	g.head = append(g.head, p...)
+	if len(g.head) < 2 {
+		return len(p), nil
+	}
+	if err := g.start(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// start selects the output once the start of the data is known, and writes
+// the buffered data to it.
+func (g *debugGzipWriter) start() error {
+	g.out = g.w
+	if len(g.head) < 2 || g.head[0] != 0x1f || g.head[1] != 0x8b {
+		g.gz = gzip.NewWriter(g.w)
+		g.out = g.gz
+	}
+
+	_, err := g.out.Write(g.head)
+	g.head = nil
+	return err
+}
+
+// Close flushes the data written so far, and terminates the gzip stream if
+// the data was compressed.
+func (g *debugGzipWriter) Close() error {
+	if g.out == nil {
+		if err := g.start(); err != nil {
+			return err
+		}
+	}
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	return nil
+}
+
+// requestStream performs a GET request against the given path and copies the
+// response body to w.
+func (c *DebugCommand) requestStream(ctx context.Context, path string, params url.Values, w io.Writer) error {
+	return c.requestMethodStream(ctx, "GET", path, params, nil, w)
+}
+
+// requestMethodStream performs a request with the given method against the
+// given path, with the body encoded as JSON if it is not nil, and copies the
+// response body to w.
+func (c *DebugCommand) requestMethodStream(ctx context.Context, method, path string, params url.Values, body interface{}, w io.Writer) error {
+	select {
+	case c.requestSem <- struct{}{}:
+		defer func() { <-c.requestSem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Waiting fails right away if the next request would not be allowed
+	// before the context's deadline
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	client := c.clientFor(ctx)
+	r := client.NewRequest(method, path)
+	for k, v := range params {
+		r.Params[k] = v
+	}
+	if body != nil {
+		if err := r.SetJSONBody(body); err != nil {
+			return err
+		}
+	}
+
+	// The headers of the request are shared with the client, so they are
+	// copied before the namespace is removed for global targets
+	if t, ok := ctx.Value(captureTargetKey{}).(captureTarget); ok && strutil.StrListContains(debugGlobalTargets, t.target) && r.Headers.Get(consts.NamespaceHeaderName) != "" {
+		headers := make(http.Header, len(r.Headers))
+		for k, v := range r.Headers {
+			headers[k] = v
+		}
+		headers.Del(consts.NamespaceHeaderName)
+		r.Headers = headers
+	}
+
+	start := time.Now()
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		c.recordTiming(ctx, path, status, start)
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	c.recordTiming(ctx, path, resp.StatusCode, start)
+	return err
+}
+
+// requestJSON performs a GET request against the given path and decodes the
+// response body onto out.
+func (c *DebugCommand) requestJSON(ctx context.Context, path string, params url.Values, out interface{}) error {
+	data, err := c.requestRaw(ctx, path, params)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// requestData performs a GET request against the given path and returns the
+// data portion of the response.
+func (c *DebugCommand) requestData(ctx context.Context, path string, params url.Values) (map[string]interface{}, error) {
+	var secret api.Secret
+	if err := c.requestJSON(ctx, path, params, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Data == nil {
+		return nil, fmt.Errorf("no data returned from %s", path)
+	}
+
+	return secret.Data, nil
+}
+
+// requestWriteData performs a PUT request against the given path with the
+// body encoded as JSON, and returns the data portion of the response.
+func (c *DebugCommand) requestWriteData(ctx context.Context, path string, body interface{}) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	if err := c.requestMethodStream(ctx, "PUT", path, nil, body, &buf); err != nil {
+		return nil, err
+	}
+
+	var secret api.Secret
+	if err := json.Unmarshal(buf.Bytes(), &secret); err != nil {
+		return nil, err
+	}
+	if secret.Data == nil {
+		return nil, fmt.Errorf("no data returned from %s", path)
+	}
+
+	return secret.Data, nil
+}
+
+// isResponseStatus returns whether the error is an API response error with the
+// given HTTP status code.
+func isResponseStatus(err error, code int) bool {
+	respErr, ok := err.(*api.ResponseError)
+	return ok && respErr.StatusCode == code
+}
+
+// writeJSON marshals the value and writes it to the given path relative to the
+// output directory.
+func (c *DebugCommand) writeJSON(path string, v interface{}) error {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return c.writeFile(path, bytes)
+}
+
+// writeFile writes the data to the given path relative to the output
+// directory.
+func (c *DebugCommand) writeFile(path string, data []byte) error {
+	dst := filepath.Join(c.cfg.Output, path)
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		return err
+	}
+
+	c.recordFileTime(dst)
+	return c.appendStream(path, data)
+}
+
+// recordFileTime records the current time as the time the file at the given
+// path was written, which is listed in the index.
+func (c *DebugCommand) recordFileTime(path string) {
+	c.recordFileTimeAt(path, time.Now().UTC())
+}
+
+func (c *DebugCommand) recordFileTimeAt(path string, t time.Time) {
+	if c.parent != nil {
+		c.parent.recordFileTimeAt(path, t)
+		return
+	}
+
+	c.fileTimesLock.Lock()
+	defer c.fileTimesLock.Unlock()
+	if c.fileTimes == nil {
+		c.fileTimes = make(map[string]time.Time)
+	}
+	c.fileTimes[path] = t
+}
+
+// fileTime returns the time the file at the given path was written, falling
+// back to its modification time for files that weren't written by this run.
+func (c *DebugCommand) fileTime(path string, info os.FileInfo) time.Time {
+	c.fileTimesLock.Lock()
+	defer c.fileTimesLock.Unlock()
+	if t, ok := c.fileTimes[path]; ok {
+		return t
+	}
+	return info.ModTime().UTC()
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it to the
+// given path relative to the output directory, so that the file is never
+// observed partially written.
+func (c *DebugCommand) writeFileAtomic(path string, data []byte) error {
+	dst := filepath.Join(c.cfg.Output, path)
+	tmp := dst + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeNote writes a plain text note to the given path relative to the output
+// directory, used in place of a target's output when it cannot be captured.
+func (c *DebugCommand) writeNote(path, note string) error {
+	return c.writeFile(path, []byte(note+"\n"))
+}
+
+// writePartialIndex writes the index file with the frames and errors captured
+// so far. The output listing and checksums are only generated once the
+// capture completes, so they are left out, and the index is marked as partial.
+func (c *DebugCommand) writePartialIndex() error {
+	c.indexLock.Lock()
+	defer c.indexLock.Unlock()
+
+	c.errLock.Lock()
+	index := *c.debugIndex
+	index.Errors = append([]CaptureError{}, c.debugIndex.Errors...)
+	index.Frames = append([]DebugFrame{}, c.debugIndex.Frames...)
+	c.errLock.Unlock()
+
+	if len(c.clusterCmds) > 0 {
+		index.Clusters = c.clusterIndex()
+	}
+	index.Partial = true
+
+	bytes, err := json.MarshalIndent(&index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if c.anonymizer != nil {
+		bytes = c.anonymizer.replace(bytes)
+	}
+	if c.cfg.ValidateIndex {
+		if err := validateDebugIndex(bytes); err != nil {
+			return err
+		}
+	}
+
+	return c.writeFileAtomic("index.json", bytes)
+}
+
+// generateIndex walks the output directory and writes the index file with the
+// resulting output layout.
+func (c *DebugCommand) generateIndex() error {
+	output := []DebugOutputFile{}
+	checksums := map[string]string{}
+
+	err := filepath.Walk(c.cfg.Output, func(path string, info os.FileInfo, err error) error {
+		// Prevent panic by handling failure accessing a path
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(c.cfg.Output, path)
+		if err != nil {
+			return err
+		}
+		// Skip the partial index written as frames completed, which is
+		// replaced below
+		if filepath.ToSlash(relPath) == "index.json" {
+			return nil
+		}
+		output = append(output, DebugOutputFile{
+			Path:      filepath.ToSlash(relPath),
+			Timestamp: c.fileTime(path, info),
+		})
+
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		checksums[filepath.ToSlash(relPath)] = sum
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Marshal and write index file
+	c.debugIndex.Output = output
+	c.debugIndex.Checksums = checksums
+
+	bytes, err := json.MarshalIndent(c.debugIndex, "", "  ")
+	if err != nil {
+		return err
+	}
+	if c.anonymizer != nil {
+		bytes = c.anonymizer.replace(bytes)
+	}
+	if c.cfg.ValidateIndex {
+		if err := validateDebugIndex(bytes); err != nil {
+			return err
+		}
+	}
+
+	c.indexLock.Lock()
+	defer c.indexLock.Unlock()
+	return c.writeFileAtomic("index.json", bytes)
+}
+
+// compress archives the output directory into an archive of -archive-format
+// at the given destination, removing the directory on success unless
+// -keep-dir is set.
+func (c *DebugCommand) compress(dst string) error {
+	if c.outputPipe != "" {
+		if err := writeArchivePipe(c.cfg.Output, c.outputPipe, c.cfg.ArchiveFormat, true); err != nil {
+			return fmt.Errorf("failed to stream data to named pipe: %s", err)
+		}
+
+		if err := os.RemoveAll(c.stagingDir); err != nil {
+			return fmt.Errorf("failed to remove staging directory: %s", err)
+		}
+		return nil
+	}
+
+	if c.cfg.KeepDir {
+		if err := writeArchive(c.cfg.Output, dst, c.cfg.ArchiveFormat, false); err != nil {
+			return fmt.Errorf("failed to compress data: %s", err)
+		}
+		return nil
+	}
+
+	// Each file is removed once it's been archived, so that the data
+	// directory and the archive don't have to fit on disk at the same time.
+	// The files archived before a failure are therefore only in the archive.
+	if err := writeArchive(c.cfg.Output, dst, c.cfg.ArchiveFormat, true); err != nil {
+		return fmt.Errorf("failed to compress data, files archived before the failure are only in %s: %s", dst, err)
+	}
+
+	// If everything is fine up to this point, remove the directories left
+	// behind
+	if err := os.RemoveAll(c.cfg.Output); err != nil {
+		return fmt.Errorf("failed to remove data directory: %s", err)
+	}
+
+	return nil
+}
+
+// fileChecksum returns the hex-encoded SHA256 checksum of the file at the given
+// path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return readerChecksum(f)
+}
+
+// readerChecksum returns the hex-encoded SHA256 checksum of the data read
+// from r.
+func readerChecksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
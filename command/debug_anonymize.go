@@ -0,0 +1,200 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// debugAnonymizeKeys maps the fields that hold identifying values to the kind
+// of pseudonym their values are replaced with.
+var debugAnonymizeKeys = map[string]string{
+	"cluster_id":   "cluster-id",
+	"cluster_name": "cluster",
+	"hostname":     "node",
+	"node_id":      "node",
+}
+
+// debugAnonymizeExts are the extensions of the captured files that are
+// rewritten by the anonymizer. Binary files such as the pprof profiles are
+// left untouched.
+var debugAnonymizeExts = []string{".csv", ".hcl", ".json", ".jsonl", ".prom", ".txt"}
+
+// debugAnonymizer replaces identifying values, such as hostnames and cluster
+// IDs, with stable pseudonyms. The same value always maps to the same
+// pseudonym over the lifetime of the anonymizer, so that values can still be
+// correlated across files and bundles. The mapping itself is never written
+// out.
+type debugAnonymizer struct {
+	lock       sync.Mutex
+	pseudonyms map[string]string
+	counts     map[string]int
+}
+
+func newDebugAnonymizer() *debugAnonymizer {
+	return &debugAnonymizer{
+		pseudonyms: map[string]string{},
+		counts:     map[string]int{},
+	}
+}
+
+// add registers the value under the given kind, returning its pseudonym.
+func (a *debugAnonymizer) add(kind, value string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if pseudonym, ok := a.pseudonyms[value]; ok {
+		return pseudonym
+	}
+
+	a.counts[kind]++
+	n := a.counts[kind]
+
+	var pseudonym string
+	switch kind {
+	case "cluster":
+		pseudonym = "cluster-" + debugAlphaIndex(n)
+	default:
+		pseudonym = fmt.Sprintf("%s-%d", kind, n)
+	}
+	a.pseudonyms[value] = pseudonym
+
+	return pseudonym
+}
+
+// collect walks decoded JSON data and registers the value of every
+// identifying field. Keys are visited in sorted order so that pseudonyms are
+// assigned deterministically.
+func (a *debugAnonymizer) collect(data interface{}) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if s, ok := v[k].(string); ok {
+				if kind, ok := debugAnonymizeKeys[k]; ok && s != "" {
+					a.add(kind, s)
+				}
+				continue
+			}
+			a.collect(v[k])
+		}
+	case []interface{}:
+		for _, item := range v {
+			a.collect(item)
+		}
+	}
+}
+
+// replace replaces every registered value in data with its pseudonym.
+func (a *debugAnonymizer) replace(data []byte) []byte {
+	a.lock.Lock()
+	values := make([]string, 0, len(a.pseudonyms))
+	for value := range a.pseudonyms {
+		values = append(values, value)
+	}
+
+	// Longer values are replaced first so that a value containing another
+	// registered value is replaced as a whole
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	pairs := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		pairs = append(pairs, value, a.pseudonyms[value])
+	}
+	a.lock.Unlock()
+
+	if len(pairs) == 0 {
+		return data
+	}
+
+	return []byte(strings.NewReplacer(pairs...).Replace(string(data)))
+}
+
+// anonymizeDir registers the identifying values found in the JSON files under
+// dir, then rewrites every text file with those values replaced.
+func (a *debugAnonymizer) anonymizeDir(dir string) error {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		ext := filepath.Ext(path)
+		for _, e := range debugAnonymizeExts {
+			if ext == e {
+				files = append(files, path)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if filepath.Ext(file) != ".json" {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var data interface{}
+		if err := json.Unmarshal(content, &data); err != nil {
+			// Not every captured file is guaranteed to be valid JSON, such as
+			// a partially written response, so skip those that aren't
+			continue
+		}
+		a.collect(data)
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		replaced := a.replace(content)
+		if string(replaced) == string(content) {
+			continue
+		}
+		if err := ioutil.WriteFile(file, replaced, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// debugAlphaIndex returns the one-based index n as a lowercase alphabetic
+// sequence, e.g. 1 is "a", 26 is "z", and 27 is "aa".
+func debugAlphaIndex(n int) string {
+	var s string
+	for n > 0 {
+		n--
+		s = string(rune('a'+n%26)) + s
+		n /= 26
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugCommand_Anonymize(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	const hostname = "vault-prod-01.internal.example.com"
+	const clusterID = "5f3c0b5e-3c1a-4b2e-9d7e-2f0c6e1a9b4d"
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/host-info":
+			fmt.Fprintf(w, `{"data":{"host":{"hostname":%q}}}`, hostname)
+		case "/v1/sys/replication/status":
+			fmt.Fprintf(w, `{"data":{"dr":{"cluster_id":%q,"primary_cluster_addr":"https://%s:8201"}}}`, clusterID, hostname)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "anonymize")
+	args := []string{
+		"-anonymize",
+		"-duration=1s",
+		"-target=host",
+		"-target=replication-status",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	for _, file := range []string{"000/host_info.json", "000/replication_status.json"} {
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(content), hostname) {
+			t.Fatalf("expected hostname to be replaced in %s: %s", file, content)
+		}
+		if !strings.Contains(string(content), "node-1") {
+			t.Fatalf("expected hostname to be replaced with node-1 in %s: %s", file, content)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "000/replication_status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), clusterID) {
+		t.Fatalf("expected cluster ID to be replaced: %s", content)
+	}
+	if !strings.Contains(string(content), "cluster-id-1") {
+		t.Fatalf("expected cluster ID to be replaced with cluster-id-1: %s", content)
+	}
+}
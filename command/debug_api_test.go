@@ -0,0 +1,197 @@
+package command_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command"
+	"github.com/mitchellh/cli"
+)
+
+// testDebugAPIServer returns a client of a server stub answering the
+// pre-flight checks of a capture along with the given handler.
+func testDebugAPIServer(tb testing.TB, handler http.HandlerFunc) (*api.Client, func()) {
+	tb.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.4.0"}`))
+		case "/v1/auth/token/lookup-self":
+			w.Write([]byte(`{"data":{"policies":["root"]}}`))
+		default:
+			handler(w, r)
+		}
+	}))
+
+	client, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return client, ts.Close
+}
+
+// testDebugIndexFiles returns the paths of the files listed in the index.
+func testDebugIndexFiles(index *command.DebugIndex) map[string]command.DebugOutputFile {
+	files := make(map[string]command.DebugOutputFile, len(index.Output))
+	for _, file := range index.Output {
+		files[file.Path] = file
+	}
+	return files
+}
+
+func TestCapture_API(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/config/state/sanitized" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":{"disable_mlock":true}}`))
+	})
+	defer closer()
+
+	cfg := command.DefaultCaptureConfig()
+	cfg.Client = client
+	cfg.Count = 1
+	cfg.Output = filepath.Join(testDir, "capture")
+	cfg.Compress = false
+	cfg.Targets = []string{"config"}
+
+	bundle, err := command.Capture(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index and the types of its fields can be named outside of the
+	// package, so that it can be passed around by callers
+	var index *command.DebugIndex = bundle.Index
+	var errs []command.CaptureError = index.Errors
+	var frames []command.DebugFrame = index.Frames
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors, got: %#v", errs)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("expected 1 frame, got: %#v", frames)
+	}
+	if file, ok := testDebugIndexFiles(index)["config.json"]; !ok || file.Timestamp.IsZero() {
+		t.Fatalf("expected config.json in the index, got: %#v", index.Output)
+	}
+}
+
+// The test is not run in parallel, since it registers targets that would
+// otherwise be captured by every other test capturing the default targets.
+func TestRegisterTarget(t *testing.T) {
+	defer command.SaveDebugTargets()()
+
+	var onceFrame command.CaptureFrame
+	command.RegisterTarget("custom-once", "Custom status, captured once", func(ctx context.Context, frame *command.CaptureFrame) error {
+		onceFrame = *frame
+		data, err := frame.RequestData(ctx, "/v1/sys/custom", nil)
+		if err != nil {
+			return err
+		}
+		return frame.WriteJSON("custom_once.json", data)
+	})
+	command.RegisterFrameTarget("custom-frame", "Custom counter, captured on every frame", func(ctx context.Context, frame *command.CaptureFrame) error {
+		return frame.WriteJSON("custom_frame.json", map[string]int{"frame": frame.Frame})
+	})
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("expected registering a target twice to panic")
+			}
+		}()
+		command.RegisterTarget("custom-once", "", func(context.Context, *command.CaptureFrame) error {
+			return nil
+		})
+	}()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		case "/v1/sys/custom":
+			w.Write([]byte(`{"data":{"status":"ok"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	cfg := command.DefaultCaptureConfig()
+	cfg.Client = client
+	cfg.Count = 1
+	cfg.Output = filepath.Join(testDir, "custom")
+	cfg.Compress = false
+	cfg.Targets = []string{"config", "custom-once", "custom-frame"}
+
+	bundle, err := command.Capture(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Index.Errors) != 0 {
+		t.Fatalf("expected no errors, got: %#v", bundle.Index.Errors)
+	}
+	if onceFrame.Frame != -1 || onceFrame.Dir != "" {
+		t.Fatalf("expected a target captured once to be given the root frame, got: %#v", onceFrame)
+	}
+
+	files := testDebugIndexFiles(bundle.Index)
+	for _, file := range []string{"config.json", "custom_once.json", "000/custom_frame.json"} {
+		if _, ok := files[file]; !ok {
+			t.Fatalf("expected %s in the index, got: %v", file, bundle.Index.Output)
+		}
+	}
+	content, err := ioutil.ReadFile(filepath.Join(cfg.Output, "custom_once.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `"status": "ok"`; !strings.Contains(string(content), expected) {
+		t.Fatalf("expected %q to contain %q", content, expected)
+	}
+
+	// The registered targets are listed with their description and captured
+	// by default
+	ui := cli.NewMockUi()
+	cmd := &command.DebugCommand{BaseCommand: &command.BaseCommand{UI: ui}}
+	if code := cmd.Run([]string{"-list-targets"}); code != 0 {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, 0)
+	}
+	for target, description := range map[string]string{
+		"custom-frame": "Custom counter, captured on every frame",
+		"custom-once":  "Custom status, captured once",
+	} {
+		var found bool
+		for _, line := range strings.Split(ui.OutputWriter.String(), "\n") {
+			fields := strings.Fields(line)
+			found = found || (len(fields) >= 2 && fields[0] == target && fields[1] == "yes" && strings.HasSuffix(line, description))
+		}
+		if !found {
+			t.Fatalf("expected target %s to be listed:\n%s", target, ui.OutputWriter.String())
+		}
+	}
+}
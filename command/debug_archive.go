@@ -0,0 +1,324 @@
+package command
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/ulikunitz/xz"
+)
+
+// debugArchiveExts maps each format of -archive-format that bundles the
+// output into an archive to the extensions that the output path may already
+// end with. The first one is appended to the output path otherwise.
+var debugArchiveExts = map[string][]string{
+	"tgz":  {debugCompressionExt, ".tgz"},
+	"tbz2": {".tar.bz2", ".tbz2"},
+	"txz":  {".tar.xz", ".txz"},
+	"tar":  {".tar"},
+	"zip":  {".zip"},
+}
+
+// debugTarFormats is the set of formats of -archive-format that bundle the
+// output into a tarball, whose compression can be set with -compression.
+var debugTarFormats = map[string]bool{
+	"tgz":  true,
+	"tbz2": true,
+	"txz":  true,
+	"tar":  true,
+}
+
+// debugTarCompressions maps each value of -compression to the format of the
+// tarball compressed with it.
+var debugTarCompressions = map[string]string{
+	"gzip":  "tgz",
+	"bzip2": "tbz2",
+	"xz":    "txz",
+	"none":  "tar",
+}
+
+// debugArchiveContentTypes maps each archive format to the content type its
+// archive is uploaded with.
+var debugArchiveContentTypes = map[string]string{
+	"tgz":  "application/gzip",
+	"tbz2": "application/x-bzip2",
+	"txz":  "application/x-xz",
+	"tar":  "application/x-tar",
+	"zip":  "application/zip",
+}
+
+// debugArchiveWriter writes the entries of an archive in one of the formats
+// of -archive-format.
+type debugArchiveWriter interface {
+	// add writes the entry of the file or directory with the given info
+	// under name, reading the content of a file from r.
+	add(name string, info os.FileInfo, r io.Reader) error
+
+	// Close finishes the archive, without closing the underlying writer.
+	Close() error
+}
+
+// newDebugArchiveWriter returns a writer of an archive in the given format
+// to w.
+func newDebugArchiveWriter(w io.Writer, format string) (debugArchiveWriter, error) {
+	var cw io.WriteCloser
+	switch format {
+	case "zip":
+		return &debugZipWriter{zw: zip.NewWriter(w)}, nil
+	case "tar":
+		return &debugTarWriter{tw: tar.NewWriter(w)}, nil
+	case "tbz2":
+		bzw, err := bzip2.NewWriter(w, nil)
+		if err != nil {
+			return nil, err
+		}
+		cw = bzw
+	case "txz":
+		xzw, err := xz.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		cw = xzw
+	default:
+		cw = gzip.NewWriter(w)
+	}
+	return &debugTarWriter{tw: tar.NewWriter(cw), cw: cw}, nil
+}
+
+// debugTarWriter writes a tarball, compressed by cw if it is set.
+type debugTarWriter struct {
+	tw *tar.Writer
+	cw io.WriteCloser
+}
+
+func (w *debugTarWriter) add(name string, info os.FileInfo, r io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	_, err = io.Copy(w.tw, r)
+	return err
+}
+
+func (w *debugTarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.cw != nil {
+		return w.cw.Close()
+	}
+	return nil
+}
+
+// debugZipWriter writes a zip archive, with every file deflated.
+type debugZipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *debugZipWriter) add(name string, info os.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if !info.IsDir() {
+		header.Method = zip.Deflate
+	}
+	entry, err := w.zw.CreateHeader(header)
+	if err != nil || info.IsDir() {
+		return err
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
+
+func (w *debugZipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// writeArchive writes the contents of the source directory into an archive
+// of the given format at dst. Entries are rooted at the base name of the
+// source directory. If remove is set, each source file is removed once it has
+// been archived, and a partial archive is left in place on failure since it
+// holds the only copy of those files.
+func writeArchive(src, dst, format string, remove bool) error {
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeArchiveTo(f, src, format, remove); err != nil {
+		if !remove {
+			f.Close()
+			os.Remove(dst)
+		}
+		return err
+	}
+	return f.Close()
+}
+
+// writeArchivePipe streams the contents of the source directory as an
+// archive of the given format into an existing named pipe. Opening the pipe
+// blocks until a reader opens the other end. If remove is set, each source
+// file is removed once it has been streamed.
+func writeArchivePipe(src, pipe, format string, remove bool) error {
+	f, err := os.OpenFile(pipe, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeArchiveTo(f, src, format, remove); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeArchiveTo writes the contents of the source directory as an archive
+// of the given format to w, rooted at the base name of the source. If remove
+// is set, each source file is removed once it has been written. Directories
+// are left in place.
+func writeArchiveTo(w io.Writer, src, format string, remove bool) error {
+	aw, err := newDebugArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Dir(src)
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relPath)
+		if info.IsDir() {
+			return aw.add(name+"/", info, nil)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = aw.add(name, info, file)
+		file.Close()
+		if err != nil || !remove {
+			return err
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	return aw.Close()
+}
+
+// walkDebugArchive calls fn with the name and content of every file in the
+// archive at the given path, in any of the formats of -archive-format. The
+// format is detected from the first bytes of the archive rather than its
+// extension, so that a renamed archive can still be read.
+func walkDebugArchive(file string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, entry := range zr.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			r, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(entry.Name, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		return walkDebugTar(tar.NewReader(gzr), fn)
+
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		bzr, err := bzip2.NewReader(br, nil)
+		if err != nil {
+			return err
+		}
+		defer bzr.Close()
+		return walkDebugTar(tar.NewReader(bzr), fn)
+
+	case bytes.HasPrefix(magic, []byte("\xfd7zXZ\x00")):
+		xzr, err := xz.NewReader(br)
+		if err != nil {
+			return err
+		}
+		return walkDebugTar(tar.NewReader(xzr), fn)
+
+	default:
+		return walkDebugTar(tar.NewReader(br), fn)
+	}
+}
+
+// walkDebugTar calls fn with the name and content of every regular file read
+// from the tarball.
+func walkDebugTar(tr *tar.Reader, fn func(name string, r io.Reader) error) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %s", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+func TestWriteArchive(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"index.json":             `{"version":1}`,
+		"000/server_status.json": `{"health":{}}`,
+		"metrics/000.json":       `{"Gauges":[]}`,
+	}
+
+	for _, format := range []string{"tgz", "tbz2", "txz", "zip", "tar"} {
+		for _, remove := range []bool{false, true} {
+			format, remove := format, remove
+
+			t.Run(fmt.Sprintf("%s_remove_%t", format, remove), func(t *testing.T) {
+				t.Parallel()
+
+				testDir, err := ioutil.TempDir("", "vault-debug")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(testDir)
+
+				src := filepath.Join(testDir, "bundle")
+				for name, content := range files {
+					path := filepath.Join(src, name)
+					if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				dst := filepath.Join(testDir, "bundle"+debugArchiveExts[format][0])
+				if err := writeArchive(src, dst, format, remove); err != nil {
+					t.Fatal(err)
+				}
+
+				// The archive holds the same contents either way
+				archived := map[string]string{}
+				err = walkDebugArchive(dst, func(name string, r io.Reader) error {
+					content, err := ioutil.ReadAll(r)
+					if err != nil {
+						return err
+					}
+					archived[strings.TrimPrefix(name, "bundle/")] = string(content)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(archived, files) {
+					t.Fatalf("expected archive to contain %v, got: %v", files, archived)
+				}
+
+				var remaining []string
+				err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if !info.IsDir() {
+						remaining = append(remaining, path)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch {
+				case remove && len(remaining) != 0:
+					t.Fatalf("expected source files to be removed, got: %v", remaining)
+				case !remove && len(remaining) != len(files):
+					t.Fatalf("expected source files to be kept, got: %v", remaining)
+				}
+			})
+		}
+	}
+}
+
+func TestDebugCommand_ArchiveFormat(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	// A zip bundle can be verified the same way as a tarball
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "zip")
+	code := cmd.Run([]string{
+		"-duration=1s",
+		"-target=config",
+		"-archive-format=zip",
+		fmt.Sprintf("-output=%s", outputPath),
+	})
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	bundle := outputPath + ".zip"
+	if files := testDebugArchiveFiles(t, bundle); !strutil.StrListContains(files, "zip/config.json") {
+		t.Fatalf("expected config.json in the archive, got: %v", files)
+	}
+	verifyUI, verifyCmd := testDebugVerifyCommand(t)
+	if code := verifyCmd.Run([]string{bundle}); code != 0 {
+		t.Log(verifyUI.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, 0)
+	}
+
+	// The compression takes precedence over that of the archive format, and
+	// the archive format can select the compression on its own
+	compressions := []struct {
+		name string
+		args []string
+		ext  string
+	}{
+		{"bzip2", []string{"-archive-format=tar", "-compression=bzip2"}, ".tar.bz2"},
+		{"txz", []string{"-archive-format=txz"}, ".tar.xz"},
+	}
+	for _, tc := range compressions {
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+
+		outputPath := filepath.Join(testDir, tc.name)
+		code := cmd.Run(append([]string{
+			"-duration=1s",
+			"-target=config",
+			fmt.Sprintf("-output=%s", outputPath),
+		}, tc.args...))
+		if exp := 0; code != exp {
+			t.Log(ui.ErrorWriter.String())
+			t.Fatalf("%s: expected %d to be %d", tc.name, code, exp)
+		}
+
+		bundle := outputPath + tc.ext
+		if files := testDebugArchiveFiles(t, bundle); !strutil.StrListContains(files, tc.name+"/config.json") {
+			t.Fatalf("%s: expected config.json in the archive, got: %v", tc.name, files)
+		}
+		verifyUI, verifyCmd := testDebugVerifyCommand(t)
+		if code := verifyCmd.Run([]string{bundle}); code != 0 {
+			t.Log(verifyUI.ErrorWriter.String())
+			t.Fatalf("%s: expected %d to be %d", tc.name, code, 0)
+		}
+	}
+
+	// With none, the output is left as a directory
+	ui, cmd = testDebugCommand(t)
+	cmd.client = client
+
+	outputPath = filepath.Join(testDir, "none")
+	code = cmd.Run([]string{
+		"-duration=1s",
+		"-target=config",
+		"-archive-format=none",
+		fmt.Sprintf("-output=%s", outputPath),
+	})
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, "config.json")); err != nil {
+		t.Fatalf("expected the output to be left as a directory: %s", err)
+	}
+}
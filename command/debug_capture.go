@@ -0,0 +1,153 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/cli"
+)
+
+// CaptureConfig configures a capture run with Capture. Apart from Client and
+// UI, each field mirrors the debug command flag of the same name, and
+// DefaultCaptureConfig returns a configuration with the defaults of those
+// flags.
+type CaptureConfig struct {
+	// Client is the client the capture is run with. If nil, a client is
+	// created from the environment, as with the debug command.
+	Client *api.Client
+
+	// UI receives the progress output of the capture. If nil, the output is
+	// discarded.
+	UI cli.Ui
+
+	Anonymize             bool
+	ArchiveFormat         string
+	CaptureProcessEnv     bool
+	Clusters              map[string]string
+	Compress              bool
+	CompressProfiles      bool
+	Compression           string
+	Count                 int
+	Duration              time.Duration
+	ExtendBy              time.Duration
+	ExtendOn              string
+	FollowActive          bool
+	Force                 bool
+	Format                string
+	GoroutineDump         bool
+	Grace                 time.Duration
+	HotPathsN             int
+	IncludePolicyBodies   bool
+	Insecure              bool
+	Interval              time.Duration
+	KeepDir               bool
+	LogFile               string
+	LogInBundle           bool
+	LogLevel              string
+	MaxConcurrentRequests int
+	MaxDuration           time.Duration
+	Metadata              []string
+	MetricsCSV            bool
+	MetricsCSVKeys        []string
+	MetricsDelta          bool
+	MetricsInterval       time.Duration
+	MinFreeSpace          string
+	MountHealth           map[string]string
+	Output                string
+	OutputCompressAfter   time.Duration
+	OutputFormat          string
+	OutputTemplate        string
+	PollPaths             []string
+	PprofEveryFrame       bool
+	PprofMaxFrames        int
+	PprofProfiles         string
+	PprofRing             int
+	PprofTraceDuration    time.Duration
+	PrometheusMetrics     bool
+	Proxy                 string
+	RaftPeers             bool
+	RateLimit             float64
+	Redact                bool
+	RedactFile            string
+	RequireActive         bool
+	Resume                string
+	Rotate                time.Duration
+	SignKey               string
+	SkipPollingProfiles   bool
+	Targets               []string
+	TargetTimeout         time.Duration
+	TimestampDirs         bool
+	TimingsCSV            bool
+	TmpDir                string
+	UploadHeaders         map[string]string
+	UploadMethod          string
+	UploadPartSize        string
+	UploadRemove          bool
+	UploadURL             string
+	ValidateIndex         bool
+	WaitTimeout           time.Duration
+	WaitUntil             string
+
+	// skipTimingChecks bypasses timing-related checks, used primarily for
+	// tests
+	skipTimingChecks bool
+}
+
+// Bundle is the result of a capture run with Capture.
+type Bundle struct {
+	// Path is the archive or directory the bundle was written to. With
+	// Rotate, it is the base path of the windows instead.
+	Path string
+
+	// Windows lists the bundle of every window when Rotate is set.
+	Windows []string
+
+	// Index is the index of the bundle, or of the last window with Rotate.
+	Index *DebugIndex
+}
+
+// DefaultCaptureConfig returns a configuration with the same defaults as the
+// debug command, including the targets set with VAULT_DEBUG_TARGETS.
+func DefaultCaptureConfig() CaptureConfig {
+	c := &DebugCommand{BaseCommand: &BaseCommand{}}
+	c.Flags().Parse(nil)
+	return c.cfg
+}
+
+// Capture runs a capture with the given configuration, as the debug command
+// does, and returns the resulting bundle. Cancelling the context stops the
+// capture early the same way an interrupt does, and the bundle with what was
+// captured so far is still returned. If the capture failed after it started,
+// such as when every target failed, the bundle is returned along with the
+// error.
+func Capture(ctx context.Context, cfg CaptureConfig) (*Bundle, error) {
+	ui := cfg.UI
+	if ui == nil {
+		ui = &cli.BasicUi{
+			Writer:      ioutil.Discard,
+			ErrorWriter: ioutil.Discard,
+		}
+	}
+
+	c := &DebugCommand{
+		BaseCommand: &BaseCommand{
+			UI:     ui,
+			client: cfg.Client,
+		},
+		ShutdownCh: make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(c.ShutdownCh)
+		case <-done:
+		}
+	}()
+
+	return c.execute(cfg, nil)
+}
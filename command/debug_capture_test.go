@@ -0,0 +1,73 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapture(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	cfg := DefaultCaptureConfig()
+	cfg.Client = client
+	cfg.Duration = time.Second
+	cfg.Output = filepath.Join(testDir, "capture")
+	cfg.Compress = false
+	cfg.Targets = []string{"config", "server-status"}
+	cfg.skipTimingChecks = true
+
+	bundle, err := Capture(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle.Path != cfg.Output {
+		t.Fatalf("expected bundle path %q, got: %q", cfg.Output, bundle.Path)
+	}
+	if !reflect.DeepEqual(bundle.Index.Targets, cfg.Targets) {
+		t.Fatalf("expected targets %v, got: %v", cfg.Targets, bundle.Index.Targets)
+	}
+	if len(bundle.Index.Errors) != 0 {
+		t.Fatalf("expected no errors, got: %#v", bundle.Index.Errors)
+	}
+
+	var written []string
+	for _, output := range bundle.Index.Output {
+		written = append(written, output.Path)
+	}
+	for _, exp := range []string{"config.json", "000/server_status.json"} {
+		var found bool
+		for _, path := range written {
+			found = found || path == exp
+		}
+		if !found {
+			t.Fatalf("expected %s in the index output, got: %v", exp, written)
+		}
+		if _, err := os.Stat(filepath.Join(bundle.Path, filepath.FromSlash(exp))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Validation errors are returned rather than written to the UI only
+	cfg.Output = filepath.Join(testDir, "invalid")
+	cfg.OutputFormat = "zip"
+	bundle, err = Capture(context.Background(), cfg)
+	if err == nil || bundle != nil || !strings.Contains(err.Error(), `invalid output format "zip"`) {
+		t.Fatalf("expected validation error, got: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// debugCgroupRoot is the mount point of the cgroup filesystem that container
+// resource limits are read from.
+const debugCgroupRoot = "/sys/fs/cgroup"
+
+// debugCgroupUnlimited is the threshold above which a cgroup v1 memory limit
+// is treated as unlimited, since cgroup v1 reports no limit as a very large
+// page-aligned value rather than a sentinel.
+const debugCgroupUnlimited = 1 << 62
+
+// containerLimits holds the memory and CPU limits of the cgroup the command
+// runs in.
+type containerLimits struct {
+	CgroupVersion    int     `json:"cgroup_version"`
+	MemoryLimitBytes int64   `json:"memory_limit_bytes,omitempty"`
+	CPUQuotaUS       int64   `json:"cpu_quota_us,omitempty"`
+	CPUPeriodUS      int64   `json:"cpu_period_us,omitempty"`
+	CPUs             float64 `json:"cpus,omitempty"`
+}
+
+// readContainerLimits reads the cgroup v2 or v1 memory and CPU limits under
+// the given cgroup root. It returns nil if no limit is applied, which is the
+// case when not running in a container.
+func readContainerLimits(root string) *containerLimits {
+	limits := &containerLimits{}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		limits.CgroupVersion = 2
+
+		// memory.max holds either a number of bytes or "max"
+		if v, ok := readCgroupInt(filepath.Join(root, "memory.max")); ok {
+			limits.MemoryLimitBytes = v
+		}
+
+		// cpu.max holds the quota, or "max", followed by the period
+		if content, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+			fields := strings.Fields(string(content))
+			if len(fields) == 2 {
+				quota, qErr := strconv.ParseInt(fields[0], 10, 64)
+				period, pErr := strconv.ParseInt(fields[1], 10, 64)
+				if qErr == nil && pErr == nil {
+					limits.CPUQuotaUS, limits.CPUPeriodUS = quota, period
+				}
+			}
+		}
+	} else {
+		limits.CgroupVersion = 1
+
+		if v, ok := readCgroupInt(filepath.Join(root, "memory", "memory.limit_in_bytes")); ok && v < debugCgroupUnlimited {
+			limits.MemoryLimitBytes = v
+		}
+
+		// A quota of -1 means that no quota is applied
+		quota, qOk := readCgroupInt(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+		period, pOk := readCgroupInt(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+		if qOk && pOk && quota > 0 {
+			limits.CPUQuotaUS, limits.CPUPeriodUS = quota, period
+		}
+	}
+
+	if limits.CPUQuotaUS > 0 && limits.CPUPeriodUS > 0 {
+		limits.CPUs = float64(limits.CPUQuotaUS) / float64(limits.CPUPeriodUS)
+	}
+
+	if limits.MemoryLimitBytes == 0 && limits.CPUQuotaUS == 0 {
+		return nil
+	}
+	return limits
+}
+
+// readCgroupInt reads a cgroup file holding a single integer. It returns false
+// if the file doesn't exist or doesn't hold an integer, such as "max".
+func readCgroupInt(path string) (int64, bool) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	v, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,343 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/cli"
+)
+
+// debugCluster is a cluster captured with -cluster, or a raft peer captured
+// with -raft-peers.
+type debugCluster struct {
+	name          string
+	client        *api.Client
+	activeClient  *api.Client
+	serverVersion string
+	versionErr    error
+	standby       bool
+}
+
+// DebugClusterIndex is the portion of the index file that describes the
+// capture of a single cluster.
+type DebugClusterIndex struct {
+	VaultAddress           string         `json:"vault_address"`
+	ActiveAddress          string         `json:"active_address,omitempty"`
+	ServerVersion          string         `json:"server_version,omitempty"`
+	Directory              string         `json:"directory"`
+	Errors                 []CaptureError `json:"errors"`
+	Frames                 []DebugFrame   `json:"frames"`
+	PprofFrames            []int          `json:"pprof_frames,omitempty"`
+	CapturedAgainstStandby bool           `json:"captured_against_standby,omitempty"`
+}
+
+// parseClusters creates a client for every cluster passed with -cluster,
+// based on the given client, and ensures that each one can be reached.
+// Clusters are returned sorted by name.
+func (c *DebugCommand) parseClusters(base *api.Client) ([]*debugCluster, error) {
+	names := make([]string, 0, len(c.cfg.Clusters))
+	for name := range c.cfg.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clusters := make([]*debugCluster, 0, len(names))
+	for _, name := range names {
+		cluster, err := c.newCluster(base, "cluster", name, c.cfg.Clusters[name])
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, nil
+}
+
+// parseRaftPeers creates a client for every peer listed in the raft
+// configuration of the server at the given client, for -raft-peers. The API
+// address of each peer is derived from its raft address with raftPeerAddress.
+// Peers that can't be reached are skipped, so that the rest of the cluster is
+// still captured while a node is down, and returned as errors along with the
+// peers that can. Peers are returned sorted by node ID.
+func (c *DebugCommand) parseRaftPeers(base *api.Client) ([]*debugCluster, []error, error) {
+	var resp struct {
+		Data struct {
+			Config struct {
+				Servers []struct {
+					NodeID  string `json:"node_id"`
+					Address string `json:"address"`
+				} `json:"servers"`
+			} `json:"config"`
+		} `json:"data"`
+	}
+	ctx := withCaptureTarget(context.Background(), "raft-peers", debugStaticFrame)
+	err := c.requestJSON(ctx, "/v1/sys/storage/raft/configuration", nil, &resp)
+	switch {
+	case isResponseStatus(err, http.StatusBadRequest), isResponseStatus(err, http.StatusNotFound):
+		return nil, nil, fmt.Errorf("raft-peers requires Integrated Storage, which is not in use on %s", base.Address())
+	case isResponseStatus(err, http.StatusForbidden):
+		return nil, nil, fmt.Errorf("permission denied reading the raft configuration on %s", base.Address())
+	case err != nil:
+		return nil, nil, fmt.Errorf("unable to read the raft configuration on %s: %s", base.Address(), err)
+	case len(resp.Data.Config.Servers) == 0:
+		return nil, nil, fmt.Errorf("no raft peers listed in the raft configuration on %s", base.Address())
+	}
+
+	servers := resp.Data.Config.Servers
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].NodeID < servers[j].NodeID
+	})
+
+	var peers []*debugCluster
+	var errs []error
+	for _, server := range servers {
+		address, err := raftPeerAddress(base.Address(), server.Address)
+		if err == nil {
+			var peer *debugCluster
+			peer, err = c.newCluster(base, "raft peer", server.NodeID, address)
+			if err == nil {
+				peers = append(peers, peer)
+				continue
+			}
+		}
+
+		c.UI.Warn(fmt.Sprintf("Skipping raft peer %q: %s", server.NodeID, err))
+		errs = append(errs, fmt.Errorf("skipped raft peer %q: %s", server.NodeID, err))
+	}
+	if len(peers) == 0 {
+		return nil, nil, fmt.Errorf("none of the %d raft peers could be reached", len(servers))
+	}
+
+	return peers, errs, nil
+}
+
+// raftPeerAddress returns the API address of the peer at the given raft
+// address, which is the cluster address of the peer rather than its API
+// address. The peer is assumed to serve the API with the same scheme and on
+// the same port as the configured address, so only the host is taken from the
+// raft address.
+func raftPeerAddress(base, raftAddress string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	host, _, err := net.SplitHostPort(raftAddress)
+	if err != nil {
+		return "", fmt.Errorf("invalid raft address %q: %s", raftAddress, err)
+	}
+
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	return u.String(), nil
+}
+
+// newCluster creates a client for the cluster of the given kind, such as a
+// cluster passed with -cluster or a raft peer, based on the given client, and
+// ensures that it can be reached.
+func (c *DebugCommand) newCluster(base *api.Client, kind, name, address string) (*debugCluster, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("%s %q is missing an address", kind, name)
+	}
+
+	client, err := base.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client for %s %q: %s", kind, name, err)
+	}
+	if err := client.SetAddress(address); err != nil {
+		return nil, fmt.Errorf("invalid address for %s %q: %s", kind, name, err)
+	}
+	client.SetToken(base.Token())
+	client.SetHeaders(base.Headers())
+
+	activeClient, health, err := c.connect(client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s %q: %s", kind, name, err)
+	}
+	serverVersion := health.Version
+
+	// Fall back to the seal status on servers whose health endpoint does not
+	// report the version, unless this is a dry run
+	var versionErr error
+	if serverVersion == "" && !c.flagDryRun {
+		status, err := client.Sys().SealStatus()
+		switch {
+		case err != nil:
+			versionErr = err
+		case status.Version == "":
+			versionErr = fmt.Errorf("version not reported by the server")
+		}
+		if status != nil {
+			serverVersion = status.Version
+		}
+	}
+
+	return &debugCluster{
+		name:          name,
+		client:        client,
+		activeClient:  activeClient,
+		serverVersion: serverVersion,
+		versionErr:    versionErr,
+		standby:       health.Standby || health.PerformanceStandby,
+	}, nil
+}
+
+// clusterCommand returns a command that captures the given cluster into its
+// own sub-directory of the output directory. The request limit is shared with
+// c so that it applies across every cluster.
+func (c *DebugCommand) clusterCommand(cluster *debugCluster) *DebugCommand {
+	prefix := fmt.Sprintf("[%s] ", cluster.name)
+
+	return &DebugCommand{
+		BaseCommand: &BaseCommand{
+			UI: &cli.PrefixedUi{
+				InfoPrefix:   prefix,
+				OutputPrefix: prefix,
+				WarnPrefix:   prefix,
+				ErrorPrefix:  prefix,
+				Ui:           c.UI,
+			},
+		},
+
+		cfg: CaptureConfig{
+			Format:              c.cfg.Format,
+			GoroutineDump:       c.cfg.GoroutineDump,
+			IncludePolicyBodies: c.cfg.IncludePolicyBodies,
+			Interval:            c.cfg.Interval,
+			LogLevel:            c.cfg.LogLevel,
+			MetricsCSV:          c.cfg.MetricsCSV,
+			MetricsCSVKeys:      c.cfg.MetricsCSVKeys,
+			MetricsInterval:     c.cfg.MetricsInterval,
+			Output:              filepath.Join(c.cfg.Output, "clusters", cluster.name),
+			PollPaths:           c.cfg.PollPaths,
+			PprofEveryFrame:     c.cfg.PprofEveryFrame,
+			PprofMaxFrames:      c.cfg.PprofMaxFrames,
+			PprofRing:           c.cfg.PprofRing,
+			PprofTraceDuration:  c.cfg.PprofTraceDuration,
+			PrometheusMetrics:   c.cfg.PrometheusMetrics,
+			Redact:              c.cfg.Redact,
+			RedactFile:          c.cfg.RedactFile,
+			SkipPollingProfiles: c.cfg.SkipPollingProfiles,
+			Targets:             c.cfg.Targets,
+			TargetTimeout:       c.cfg.TargetTimeout,
+			TimestampDirs:       c.cfg.TimestampDirs,
+			skipTimingChecks:    c.cfg.skipTimingChecks,
+		},
+
+		debugIndex: &DebugIndex{
+			Errors: []CaptureError{},
+			Frames: []DebugFrame{},
+		},
+		cachedClient:  cluster.client,
+		activeClient:  cluster.activeClient,
+		requestSem:    c.requestSem,
+		limiter:       c.limiter,
+		pprofProfiles: c.pprofProfiles,
+		cgroupRoot:    c.cgroupRoot,
+		redactor:      c.redactor,
+		customPaths:   c.customPaths,
+		mountHealth:   c.mountHealth,
+		parent:        c,
+	}
+}
+
+// captureClusters captures every cluster concurrently, each into its own
+// sub-directory, and merges the result of each capture into the index.
+func (c *DebugCommand) captureClusters(ctx context.Context, duration time.Duration) error {
+	cmds := make([]*DebugCommand, len(c.clusters))
+	errs := make([]error, len(c.clusters))
+
+	for i, cluster := range c.clusters {
+		cmd := c.clusterCommand(cluster)
+		if err := os.MkdirAll(cmd.cfg.Output, 0755); err != nil {
+			return fmt.Errorf("error creating output directory for cluster %q: %s", cluster.name, err)
+		}
+		cmds[i] = cmd
+	}
+	c.clusterCmds = cmds
+
+	var wg sync.WaitGroup
+	for i := range cmds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = cmds[i].captureTargets(ctx, duration)
+		}(i)
+	}
+	wg.Wait()
+
+	c.debugIndex.Clusters = c.clusterIndex()
+	var result *multierror.Error
+	var failed bool
+	for i := range c.clusters {
+		cmd := cmds[i]
+
+		c.errLock.Lock()
+		c.captureCount += cmd.captureCount
+		for target := range cmd.capturedTargets {
+			if c.capturedTargets == nil {
+				c.capturedTargets = make(map[string]bool)
+			}
+			c.capturedTargets[target] = true
+		}
+		c.errLock.Unlock()
+
+		if errs[i] != nil {
+			result = multierror.Append(result, fmt.Errorf("cluster %q: %s", c.clusters[i].name, errs[i]))
+			if _, ok := errs[i].(*debugFailedError); ok {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		return &debugFailedError{err: result}
+	}
+	return result.ErrorOrNil()
+}
+
+// clusterIndex returns the index of every cluster captured so far.
+func (c *DebugCommand) clusterIndex() map[string]*DebugClusterIndex {
+	clusters := make(map[string]*DebugClusterIndex, len(c.clusterCmds))
+	for i, cluster := range c.clusters {
+		cmd := c.clusterCmds[i]
+
+		var activeAddress string
+		if cluster.activeClient != cluster.client {
+			activeAddress = cluster.activeClient.Address()
+		}
+
+		cmd.errLock.Lock()
+		clusters[cluster.name] = &DebugClusterIndex{
+			VaultAddress:           cluster.client.Address(),
+			ActiveAddress:          activeAddress,
+			ServerVersion:          cluster.serverVersion,
+			Directory:              filepath.ToSlash(filepath.Join("clusters", cluster.name)),
+			Errors:                 append([]CaptureError{}, cmd.debugIndex.Errors...),
+			Frames:                 append([]DebugFrame{}, cmd.debugIndex.Frames...),
+			PprofFrames:            cmd.debugIndex.PprofFrames,
+			CapturedAgainstStandby: cluster.standby,
+		}
+		cmd.errLock.Unlock()
+	}
+
+	return clusters
+}
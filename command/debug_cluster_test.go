@@ -0,0 +1,247 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestDebugCommand_Clusters(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	primary, primaryCloser := testVaultServer(t)
+	defer primaryCloser()
+
+	secondary, secondaryCloser := testVaultServer(t)
+	defer secondaryCloser()
+
+	// The same token is used for every cluster, so create it on both
+	const token = "debug-cluster-token"
+	for _, client := range []*api.Client{primary, secondary} {
+		_, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+			ID:       token,
+			Policies: []string{"root"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each test server has its own CA, so skip verification as with
+	// -tls-skip-verify
+	config := api.DefaultConfig()
+	config.Address = primary.Address()
+	if err := config.ConfigureTLS(&api.TLSConfig{Insecure: true}); err != nil {
+		t.Fatal(err)
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "clusters")
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		"-target=server-status",
+		fmt.Sprintf("-cluster=primary=%s", primary.Address()),
+		fmt.Sprintf("-cluster=secondary=%s", secondary.Address()),
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	for _, name := range []string{"primary", "secondary"} {
+		for _, file := range []string{"config.json", "000/server_status.json", "request_timings.json"} {
+			if _, err := os.Stat(filepath.Join(outputPath, "clusters", name, file)); err != nil {
+				t.Fatalf("expected %s to be captured for cluster %s: %s", file, name, err)
+			}
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var index DebugIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"primary":   primary.Address(),
+		"secondary": secondary.Address(),
+	}
+	if len(index.Clusters) != len(expected) {
+		t.Fatalf("expected %d clusters in the index, got: %v", len(expected), index.Clusters)
+	}
+	for name, address := range expected {
+		cluster, ok := index.Clusters[name]
+		if !ok {
+			t.Fatalf("expected cluster %s in the index", name)
+		}
+		if cluster.VaultAddress != address {
+			t.Fatalf("expected cluster %s address %s, got %s", name, address, cluster.VaultAddress)
+		}
+		if cluster.Directory != "clusters/"+name {
+			t.Fatalf("expected cluster %s directory clusters/%s, got %s", name, name, cluster.Directory)
+		}
+		if len(cluster.Frames) != 1 {
+			t.Fatalf("expected 1 frame for cluster %s, got %d", name, len(cluster.Frames))
+		}
+		if len(cluster.Errors) != 0 {
+			t.Fatalf("expected no errors for cluster %s, got: %v", name, cluster.Errors)
+		}
+	}
+
+	if _, ok := index.Checksums["clusters/secondary/config.json"]; !ok {
+		t.Fatalf("expected cluster files to be checksummed, got: %v", index.Checksums)
+	}
+}
+
+func TestRaftPeerAddress(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		base     string
+		raft     string
+		expected string
+		ok       bool
+	}{
+		{"https://vault-0.example.com:8200", "vault-1.example.com:8201", "https://vault-1.example.com:8200", true},
+		{"http://127.0.0.1:8200", "10.0.0.2:8201", "http://10.0.0.2:8200", true},
+		{"https://vault.example.com", "10.0.0.2:8201", "https://10.0.0.2", true},
+		{"https://vault.example.com:8200", "[fd00::2]:8201", "https://[fd00::2]:8200", true},
+		{"https://vault.example.com:8200", "vault-1", "", false},
+	}
+
+	for _, tc := range cases {
+		address, err := raftPeerAddress(tc.base, tc.raft)
+		if address != tc.expected || (err == nil) != tc.ok {
+			t.Fatalf("expected %s from %s to be %q (%t), got: %q (%v)", tc.raft, tc.base, tc.expected, tc.ok, address, err)
+		}
+	}
+}
+
+func TestDebugCommand_RaftPeers(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Both peers resolve to the stub server, on the port of the configured
+	// address, while the third one can't be reached
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/storage/raft/configuration" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"config":{"index":1,"servers":[` +
+			`{"node_id":"node2","address":"localhost:8201","leader":false,"voter":true},` +
+			`{"node_id":"node1","address":"127.0.0.1:8201","leader":true,"voter":true},` +
+			`{"node_id":"node3","address":"node3","leader":false,"voter":true}]}}}`))
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "peers")
+	args := []string{
+		"-duration=1s",
+		"-target=health",
+		"-raft-peers",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, `Skipping raft peer "node3"`) {
+		t.Fatalf("expected the unreachable peer to be skipped, got: %s", errOut)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index DebugIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+
+	port := client.Address()[strings.LastIndex(client.Address(), ":"):]
+	expected := map[string]string{
+		"node1": "http://127.0.0.1" + port,
+		"node2": "http://localhost" + port,
+	}
+	if len(index.Clusters) != len(expected) {
+		t.Fatalf("expected %d peers in the index, got: %v", len(expected), index.Clusters)
+	}
+	for name, address := range expected {
+		peer, ok := index.Clusters[name]
+		if !ok {
+			t.Fatalf("expected peer %s in the index", name)
+		}
+		if peer.VaultAddress != address {
+			t.Fatalf("expected peer %s address %s, got %s", name, address, peer.VaultAddress)
+		}
+		if _, err := os.Stat(filepath.Join(outputPath, "clusters", name, "000", "health.json")); err != nil {
+			t.Fatalf("expected health to be captured for peer %s: %s", name, err)
+		}
+	}
+
+	var skipped bool
+	for _, captureErr := range index.Errors {
+		skipped = skipped || (captureErr.Target == "raft-peers" && strings.Contains(captureErr.TargetError, "node3"))
+	}
+	if !skipped {
+		t.Fatalf("expected the skipped peer to be recorded, got: %#v", index.Errors)
+	}
+
+	// Servers that don't use Integrated Storage can't list their peers
+	client, closer = testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer closer()
+
+	ui, cmd = testDebugCommand(t)
+	cmd.client = client
+	code = cmd.Run([]string{"-raft-peers", fmt.Sprintf("-output=%s", filepath.Join(testDir, "no-raft"))})
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, "requires Integrated Storage") {
+		t.Fatalf("expected Integrated Storage error, got: %s", errOut)
+	}
+}
@@ -0,0 +1,214 @@
+// +build !windows
+
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestDebugCommand_NamedPipe(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	pipePath := filepath.Join(testDir, "pipe")
+	if err := syscall.Mkfifo(pipePath, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drain the other end of the pipe, as a sidecar would
+	type result struct {
+		data []byte
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		f, err := os.Open(pipePath)
+		if err != nil {
+			resultCh <- result{err: err}
+			return
+		}
+		defer f.Close()
+
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, f)
+		resultCh <- result{data: buf.Bytes(), err: err}
+	}()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	args := []string{
+		"-duration=1s",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s", pipePath),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	res := <-resultCh
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	archivePath := filepath.Join(testDir, "bundle"+debugCompressionExt)
+	if err := ioutil.WriteFile(archivePath, res.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, file := range testDebugArchiveFiles(t, archivePath) {
+		if strings.HasSuffix(file, "/index.json") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected index.json in the streamed archive")
+	}
+
+	// The staging directory is removed once the archive is streamed
+	if _, err := os.Stat(cmd.stagingDir); !os.IsNotExist(err) {
+		t.Fatalf("expected staging directory to be removed, got: %v", err)
+	}
+}
+
+func TestDebugCommand_TmpDir(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	tmpDir := filepath.Join(testDir, "staging")
+	if err := os.Mkdir(tmpDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	pipePath := filepath.Join(testDir, "pipe")
+	if err := syscall.Mkfifo(pipePath, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dataCh := make(chan []byte, 1)
+	go func() {
+		f, err := os.Open(pipePath)
+		if err != nil {
+			dataCh <- nil
+			return
+		}
+		defer f.Close()
+
+		data, _ := ioutil.ReadAll(f)
+		dataCh <- data
+	}()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	args := []string{
+		"-duration=1s",
+		"-target=server-status",
+		fmt.Sprintf("-tmp-dir=%s", tmpDir),
+		fmt.Sprintf("-output=%s", pipePath),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	data := <-dataCh
+
+	// The output is staged under the directory, and the staged files are
+	// what gets streamed into the pipe
+	if filepath.Dir(cmd.stagingDir) != tmpDir || filepath.Dir(cmd.cfg.Output) != cmd.stagingDir {
+		t.Fatalf("expected output to be staged under %s, got: %s", tmpDir, cmd.cfg.Output)
+	}
+	archivePath := filepath.Join(testDir, "bundle"+debugCompressionExt)
+	if err := ioutil.WriteFile(archivePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, file := range testDebugArchiveFiles(t, archivePath) {
+		found = found || strings.HasSuffix(file, "/index.json")
+	}
+	if !found {
+		t.Fatal("expected the staged index.json in the streamed archive")
+	}
+	if _, err := os.Stat(cmd.stagingDir); !os.IsNotExist(err) {
+		t.Fatalf("expected staging directory to be removed, got: %v", err)
+	}
+
+	// An unwritable directory is rejected before anything is captured
+	_, cmd = testDebugCommand(t)
+	cmd.client = client
+	code = cmd.Run([]string{
+		"-duration=1s",
+		"-target=server-status",
+		fmt.Sprintf("-tmp-dir=%s", filepath.Join(testDir, "missing")),
+		fmt.Sprintf("-output=%s", filepath.Join(testDir, "output")),
+	})
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+}
+
+func TestDebugCommand_NamedPipeDirFormat(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	pipePath := filepath.Join(testDir, "pipe")
+	if err := syscall.Mkfifo(pipePath, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	args := []string{
+		"-duration=1s",
+		"-output-format=dir",
+		fmt.Sprintf("-output=%s", pipePath),
+	}
+
+	code := cmd.Run(args)
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	expected := "requires the archive output format"
+	if combined := ui.OutputWriter.String() + ui.ErrorWriter.String(); !strings.Contains(combined, expected) {
+		t.Fatalf("expected %q to contain %q", combined, expected)
+	}
+}
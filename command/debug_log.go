@@ -0,0 +1,89 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// debugRunLog writes the steps of a run to the file set with -log-file, one
+// JSON object per line. Every line has the time and the event it records, such
+// as the start or end of a target, along with the fields of the event. The
+// methods of a nil log do nothing, so that steps are logged unconditionally.
+type debugRunLog struct {
+	l   sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// newDebugRunLog creates the log file at the given path, truncating it if it
+// already exists.
+func newDebugRunLog(path string) (*debugRunLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &debugRunLog{
+		f:   f,
+		enc: json.NewEncoder(f),
+	}, nil
+}
+
+// log writes a line for the event with the given fields. Failing to write
+// the log never fails the run.
+func (l *debugRunLog) log(event string, fields map[string]interface{}) {
+	if l == nil {
+		return
+	}
+
+	entry := map[string]interface{}{
+		"timestamp": time.Now().UTC(),
+		"event":     event,
+	}
+	for k, v := range fields {
+		entry[k] = v
+	}
+
+	l.l.Lock()
+	defer l.l.Unlock()
+	l.enc.Encode(entry)
+}
+
+// Close closes the log file.
+func (l *debugRunLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.l.Lock()
+	defer l.l.Unlock()
+	return l.f.Close()
+}
+
+// runCapture captures a single target of the given frame with the capture
+// function and records the result, logging the start and end of the target to
+// the run log along with how long it took.
+func (c *DebugCommand) runCapture(ctx context.Context, target string, frame int, capture func(context.Context) error) {
+	c.runLog.log("target_start", map[string]interface{}{
+		"target": target,
+		"frame":  frame,
+	})
+
+	start := time.Now()
+	err := capture(withCaptureTarget(ctx, target, frame))
+
+	fields := map[string]interface{}{
+		"target":      target,
+		"frame":       frame,
+		"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	c.runLog.log("target_end", fields)
+
+	c.recordCapture(target, frame, err)
+}
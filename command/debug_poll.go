@@ -0,0 +1,204 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// debugCustomDir is the sub-directory that the paths passed with -poll-path
+// are captured under.
+const debugCustomDir = "custom"
+
+// debugMountDir is the sub-directory that the health paths passed with
+// -mount-health are captured under.
+const debugMountDir = "mounts"
+
+// debugSanitizeRe matches the runs of characters that are replaced to make a
+// name safe for filesystem use.
+var debugSanitizeRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// DebugCustomPath is an API path passed with -poll-path or -mount-health,
+// captured on every metrics interval into its own sub-directory.
+type DebugCustomPath struct {
+	Path      string `json:"path"`
+	Directory string `json:"directory"`
+
+	params url.Values
+
+	// target is the target the captures are recorded under
+	target string
+
+	// file is the name of the file each capture is written to, in a
+	// sub-directory named after its index. If empty, each capture is written
+	// to <index>.json instead.
+	file string
+}
+
+// capturePath returns the path of the capture with the given index relative
+// to the bundle.
+func (p *DebugCustomPath) capturePath(index string) string {
+	if p.file != "" {
+		return path.Join(p.Directory, index, p.file)
+	}
+	return path.Join(p.Directory, index+".json")
+}
+
+// parseDebugPollPaths validates the paths passed with -poll-path. Each path
+// may be given with or without the /v1/ prefix and may include a query
+// string, but must resolve to a path under /v1/. The directory of each path
+// is derived from the path itself, so paths that map to the same directory
+// are rejected.
+func parseDebugPollPaths(raw []string) ([]*DebugCustomPath, error) {
+	var paths []*DebugCustomPath
+	dirs := map[string]string{}
+
+	for _, p := range raw {
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poll path %q: %s", p, err)
+		}
+		if u.Scheme != "" || u.Host != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid poll path %q, must be an API path such as /v1/sys/health", p)
+		}
+
+		apiPath := u.Path
+		if !strings.HasPrefix(apiPath, "/") {
+			apiPath = "/v1/" + apiPath
+		}
+		apiPath = path.Clean(apiPath)
+		if !strings.HasPrefix(apiPath, "/v1/") {
+			return nil, fmt.Errorf("invalid poll path %q, must be under /v1/", p)
+		}
+
+		name := strings.TrimPrefix(apiPath, "/v1/")
+		if u.RawQuery != "" {
+			name += "_" + u.RawQuery
+		}
+		dir := strings.Trim(debugSanitizeRe.ReplaceAllString(name, "_"), "_")
+		if other, ok := dirs[dir]; ok {
+			return nil, fmt.Errorf("poll paths %q and %q would be written to the same directory", other, p)
+		}
+		dirs[dir] = p
+
+		paths = append(paths, &DebugCustomPath{
+			Path:      apiPath,
+			Directory: filepath.ToSlash(filepath.Join(debugCustomDir, dir)),
+			params:    u.Query(),
+			target:    "poll-path",
+		})
+	}
+
+	return paths, nil
+}
+
+// parseDebugMountHealth validates the health paths passed with -mount-health,
+// each mapping the path of a mount to the path under it to capture, such as
+// database=config/postgres. The path may include a query string, but must
+// resolve under the mount. The captures of a mount are written to
+// mounts/<mount>/<index>/health.json, so mounts that map to the same directory
+// are rejected.
+func parseDebugMountHealth(raw map[string]string) ([]*DebugCustomPath, error) {
+	mounts := make([]string, 0, len(raw))
+	for mount := range raw {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	var paths []*DebugCustomPath
+	dirs := map[string]string{}
+
+	for _, mount := range mounts {
+		p := raw[mount]
+		name := strings.Trim(mount, "/")
+		if name == "" || path.Clean("/"+name) != "/"+name {
+			return nil, fmt.Errorf("invalid mount %q for mount health", mount)
+		}
+
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount health path %q: %s", p, err)
+		}
+		if u.Scheme != "" || u.Host != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid mount health path %q, must be a path under the mount %q", p, mount)
+		}
+
+		mountPath := "/v1/" + name + "/"
+		apiPath := path.Clean(mountPath + u.Path)
+		if !strings.HasPrefix(apiPath, mountPath) {
+			return nil, fmt.Errorf("invalid mount health path %q, must resolve under the mount %q", p, mount)
+		}
+
+		dir := strings.Trim(debugSanitizeRe.ReplaceAllString(name, "_"), "_")
+		if other, ok := dirs[dir]; ok {
+			return nil, fmt.Errorf("mounts %q and %q would be written to the same directory", other, mount)
+		}
+		dirs[dir] = mount
+
+		paths = append(paths, &DebugCustomPath{
+			Path:      apiPath,
+			Directory: path.Join(debugMountDir, dir),
+			params:    u.Query(),
+			target:    "mount-health",
+			file:      "health.json",
+		})
+	}
+
+	return paths, nil
+}
+
+// collectCustomPath captures the given path on every metrics interval. The
+// captures of a resumed bundle are numbered after those already in the
+// path's directory.
+func (c *DebugCommand) collectCustomPath(ctx context.Context, duration time.Duration, custom *DebugCustomPath) {
+	dir := filepath.Join(c.cfg.Output, filepath.FromSlash(custom.Directory))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.recordCapture(custom.target, 0, fmt.Errorf("unable to create sub-directory for %s: %s", custom.Path, err))
+		return
+	}
+
+	offset := nextCaptureIndex(dir)
+
+	frames := frameCount(duration, c.cfg.MetricsInterval)
+	ticker := time.NewTicker(c.cfg.MetricsInterval)
+	defer ticker.Stop()
+
+	for idx := 0; idx < frames; idx++ {
+		if idx > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		frame := offset + idx
+		c.runCapture(ctx, custom.target, frame, func(ctx context.Context) error {
+			if err := c.captureCustomPath(ctx, custom, frame); err != nil {
+				return fmt.Errorf("%s: %s", custom.Path, err)
+			}
+			return nil
+		})
+	}
+}
+
+func (c *DebugCommand) captureCustomPath(ctx context.Context, custom *DebugCustomPath, idx int) error {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	file := filepath.FromSlash(custom.capturePath(fmt.Sprintf("%03d", idx)))
+	if custom.file != "" {
+		if err := os.MkdirAll(filepath.Join(c.cfg.Output, filepath.Dir(file)), 0755); err != nil {
+			return err
+		}
+	}
+
+	return c.requestFile(ctx, custom.Path, custom.params, file)
+}
@@ -0,0 +1,201 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugCommand_PollPath(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method != http.MethodGet:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		case r.URL.Path == "/v1/sys/internal/counters/config":
+			w.Write([]byte(`{"data":{"enabled":"default-disabled"}}`))
+		case r.URL.Path == "/v1/diag/status" && r.URL.Query().Get("verbose") == "true":
+			w.Write([]byte(`{"data":{"verbose":true}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "poll-path")
+	args := []string{
+		"-duration=2s",
+		"-metrics-interval=1s",
+		"-target=config",
+		"-poll-path=/v1/sys/internal/counters/config",
+		"-poll-path=diag/status?verbose=true",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	expected := map[string]string{
+		"custom/sys_internal_counters_config": "default-disabled",
+		"custom/diag_status_verbose_true":     `"verbose":true`,
+	}
+	for dir, contains := range expected {
+		for idx := 0; idx < 2; idx++ {
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, filepath.FromSlash(dir), fmt.Sprintf("%03d.json", idx)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), contains) {
+				t.Fatalf("expected %s capture %d to contain %q, got: %s", dir, idx, contains, content)
+			}
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index DebugIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.CustomPaths) != 2 {
+		t.Fatalf("expected 2 custom paths in the index, got: %#v", index.CustomPaths)
+	}
+	for _, custom := range index.CustomPaths {
+		if _, ok := expected[custom.Directory]; !ok {
+			t.Fatalf("unexpected custom path directory %q", custom.Directory)
+		}
+	}
+	if index.CustomPaths[0].Path != "/v1/sys/internal/counters/config" || index.CustomPaths[1].Path != "/v1/diag/status" {
+		t.Fatalf("unexpected custom paths in the index: %#v", index.CustomPaths)
+	}
+	if len(index.Errors) != 0 {
+		t.Fatalf("expected no errors, got: %#v", index.Errors)
+	}
+}
+
+func TestParseDebugPollPaths(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		paths []string
+		err   string
+	}{
+		{[]string{"/v1/sys/health", "sys/leader"}, ""},
+		{[]string{"/v2/sys/health"}, "must be under /v1/"},
+		{[]string{"/v1/../sys/health"}, "must be under /v1/"},
+		{[]string{"https://vault.example.com/v1/sys/health"}, "must be an API path"},
+		{[]string{"sys/health", "/v1/sys/health"}, "same directory"},
+	}
+
+	for _, tc := range cases {
+		_, err := parseDebugPollPaths(tc.paths)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Fatalf("%v: unexpected error: %s", tc.paths, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Fatalf("%v: expected error containing %q, got: %v", tc.paths, tc.err, err)
+		}
+	}
+}
+
+func TestDebugCommand_MountHealth(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		case "/v1/database/prod/config/postgres":
+			w.Write([]byte(`{"data":{"connection_details":{"max_open_connections":4}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "mount-health")
+	args := []string{
+		"-duration=2s",
+		"-metrics-interval=1s",
+		"-target=config",
+		"-mount-health=database/prod=config/postgres",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	for idx := 0; idx < 2; idx++ {
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, "mounts", "database_prod", fmt.Sprintf("%03d", idx), "health.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "max_open_connections") {
+			t.Fatalf("expected health capture %d to contain the connection details, got: %s", idx, content)
+		}
+	}
+}
+
+func TestParseDebugMountHealth(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		paths map[string]string
+		err   string
+	}{
+		{map[string]string{"database": "config/postgres", "pki/": "/cert/ca"}, ""},
+		{map[string]string{"database": "../sys/health"}, "must resolve under the mount"},
+		{map[string]string{"database": ""}, "must resolve under the mount"},
+		{map[string]string{"database/../sys": "health"}, "invalid mount"},
+		{map[string]string{"database": "https://vault.example.com/v1/sys/health"}, "must be a path under the mount"},
+		{map[string]string{"db/prod": "health", "db_prod": "health"}, "same directory"},
+	}
+
+	for _, tc := range cases {
+		_, err := parseDebugMountHealth(tc.paths)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Fatalf("%v: unexpected error: %s", tc.paths, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Fatalf("%v: expected error containing %q, got: %v", tc.paths, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// debugPreset is a curated combination of targets and flags selected with
+// -preset. Zero values leave the flag at its default.
+type debugPreset struct {
+	targets         []string
+	allTargets      bool
+	duration        time.Duration
+	interval        time.Duration
+	metricsInterval time.Duration
+	pprofEveryFrame bool
+}
+
+// debugPresets are the presets available to -preset.
+var debugPresets = map[string]debugPreset{
+	// quick captures a single frame and metrics snapshot for a fast triage
+	"quick": {
+		targets:         []string{"health", "metrics", "server-status"},
+		duration:        debugMinInterval,
+		interval:        debugMinInterval,
+		metricsInterval: debugMinInterval,
+	},
+	// full captures every target, with profiles on every frame
+	"full": {
+		allTargets:      true,
+		pprofEveryFrame: true,
+	},
+	// pprof focuses on profiling, capturing every profile on every frame
+	"pprof": {
+		targets:         []string{"pprof"},
+		pprofEveryFrame: true,
+	},
+}
+
+// debugPresetNames returns the sorted names of the available presets.
+func debugPresetNames() []string {
+	names := make([]string, 0, len(debugPresets))
+	for name := range debugPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPreset applies the values of the preset passed with -preset to any
+// flags that were not explicitly provided on the command line. It is applied
+// before the configuration file, so that the values in the file take
+// precedence over those of the preset as well.
+func (c *DebugCommand) applyPreset(f *FlagSets) error {
+	preset, ok := debugPresets[c.flagPreset]
+	if !ok {
+		return fmt.Errorf("invalid preset %q, must be one of: %s", c.flagPreset, strings.Join(debugPresetNames(), ", "))
+	}
+
+	setFlags := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) {
+		setFlags[fl.Name] = true
+	})
+
+	// Targets from the environment are treated as explicitly provided
+	if !setFlags["target"] && os.Getenv(EnvVaultDebugTargets) == "" {
+		c.cfg.Targets = append([]string{}, preset.targets...)
+		if preset.allTargets {
+			c.cfg.Targets = debugTargetNames()
+		}
+	}
+
+	durations := []struct {
+		name   string
+		value  time.Duration
+		target *time.Duration
+	}{
+		{"duration", preset.duration, &c.cfg.Duration},
+		{"interval", preset.interval, &c.cfg.Interval},
+		{"metrics-interval", preset.metricsInterval, &c.cfg.MetricsInterval},
+	}
+	for _, d := range durations {
+		if d.value > 0 && !setFlags[d.name] {
+			*d.target = d.value
+		}
+	}
+
+	if preset.pprofEveryFrame && !setFlags["pprof-every-frame"] {
+		c.cfg.PprofEveryFrame = true
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugCommand_Preset(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "quick")
+	args := []string{
+		"-preset=quick",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	var files []string
+	err = filepath.Walk(outputPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(outputPath, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	expected := []string{
+		"000/health.json",
+		"000/server_status.json",
+		"README.txt",
+		"index.json",
+		"metrics/000.json",
+		"request_timings.json",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected files %v, got: %v", expected, files)
+	}
+}
+
+func TestDebugCommand_PresetOverrides(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		preset   string
+		args     []string
+		targets  []string
+		duration time.Duration
+	}{
+		{
+			"quick",
+			"quick",
+			nil,
+			[]string{"health", "metrics", "server-status"},
+			debugMinInterval,
+		},
+		{
+			"explicit_target",
+			"quick",
+			[]string{"-target=config"},
+			[]string{"config"},
+			debugMinInterval,
+		},
+		{
+			"explicit_duration",
+			"quick",
+			[]string{"-duration=1m"},
+			[]string{"health", "metrics", "server-status"},
+			time.Minute,
+		},
+		{
+			"full",
+			"full",
+			nil,
+			debugTargetNames(),
+			2 * time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, cmd := testDebugCommand(t)
+			f := cmd.Flags()
+			if err := f.Parse(append([]string{"-preset=" + tc.preset}, tc.args...)); err != nil {
+				t.Fatal(err)
+			}
+			if err := cmd.applyPreset(f); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(cmd.cfg.Targets, tc.targets) {
+				t.Fatalf("expected targets %v, got: %v", tc.targets, cmd.cfg.Targets)
+			}
+			if cmd.cfg.Duration != tc.duration {
+				t.Fatalf("expected duration %s, got: %s", tc.duration, cmd.cfg.Duration)
+			}
+		})
+	}
+
+	_, cmd := testDebugCommand(t)
+	f := cmd.Flags()
+	if err := f.Parse([]string{"-preset=slow"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.applyPreset(f); err == nil || !strings.Contains(err.Error(), "invalid preset") {
+		t.Fatalf("expected invalid preset error, got: %v", err)
+	}
+}
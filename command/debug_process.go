@@ -0,0 +1,249 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// debugProcRoot is the mount point of the proc filesystem that the Vault
+// server process is read from.
+const debugProcRoot = "/proc"
+
+const (
+	debugNoProcTableNote = "the process table is unavailable on the machine running vault debug"
+	debugNoProcessNote   = "no Vault server process was found on the machine running vault debug"
+	debugRemoteNote      = "the server is not local to the machine running vault debug, so its process is not read"
+)
+
+// debugProcessSecretPatterns are the patterns of environment variable and
+// flag names whose values are redacted from the captured process. Flag names
+// are matched in the same form as environment variables, so that
+// -dev-root-token-id is matched as DEV_ROOT_TOKEN_ID.
+var debugProcessSecretPatterns = []string{
+	"*KEY*",
+	"*PASSWORD*",
+	"*SECRET*",
+	"*TOKEN*",
+}
+
+// debugProcess is the command line and environment of the Vault server
+// process, with secret-like values redacted.
+type debugProcess struct {
+	PID  int               `json:"pid,omitempty"`
+	Args []string          `json:"args,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+	Note string            `json:"note,omitempty"`
+}
+
+// debugProcessLimits is the number of open file descriptors and threads of
+// the Vault server process, along with its limit on open files. A limit of -1
+// means the process has no limit.
+type debugProcessLimits struct {
+	PID              int    `json:"pid,omitempty"`
+	OpenFDs          *int   `json:"open_fds,omitempty"`
+	MaxOpenFilesSoft *int64 `json:"max_open_files_soft,omitempty"`
+	MaxOpenFilesHard *int64 `json:"max_open_files_hard,omitempty"`
+	Threads          *int   `json:"threads,omitempty"`
+	Note             string `json:"note,omitempty"`
+}
+
+// findVaultProcess returns the PID of the Vault server process in the proc
+// filesystem under the given root. The process is only found if it runs on
+// the machine running the command, and in the same PID namespace, so a note
+// explaining why is returned otherwise. If several server processes are
+// running, none of them is returned, since the one being captured cannot be
+// told apart.
+func findVaultProcess(root string) (int, string) {
+	dirs, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, debugNoProcTableNote
+	}
+
+	var pids []int
+	for _, dir := range dirs {
+		if pid, err := strconv.Atoi(dir.Name()); err == nil && dir.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	var servers []string
+	for _, pid := range pids {
+		cmdline, err := ioutil.ReadFile(filepath.Join(root, strconv.Itoa(pid), "cmdline"))
+		if err != nil {
+			continue
+		}
+		args := splitNul(cmdline)
+		if len(args) >= 2 && filepath.Base(args[0]) == "vault" && args[1] == "server" {
+			servers = append(servers, strconv.Itoa(pid))
+		}
+	}
+
+	switch len(servers) {
+	case 0:
+		return 0, debugNoProcessNote
+	case 1:
+		pid, _ := strconv.Atoi(servers[0])
+		return pid, ""
+	}
+	return 0, fmt.Sprintf("several Vault server processes were found on the machine running vault debug (PIDs %s), so none is attributed to the server", strings.Join(servers, ", "))
+}
+
+// readVaultProcess reads the command line and environment of the Vault server
+// process found with findVaultProcess, or returns a note in its place.
+func readVaultProcess(root string) *debugProcess {
+	pid, note := findVaultProcess(root)
+	if pid == 0 {
+		return &debugProcess{Note: note}
+	}
+
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return &debugProcess{PID: pid, Note: "the command line of the process is not readable by the user running vault debug"}
+	}
+
+	process := &debugProcess{
+		PID:  pid,
+		Args: redactProcessArgs(splitNul(cmdline)),
+		Env:  map[string]string{},
+	}
+
+	// The environment is only readable by the owner of the process
+	environ, err := ioutil.ReadFile(filepath.Join(dir, "environ"))
+	if err != nil {
+		process.Note = "the environment of the process is not readable by the user running vault debug"
+		return process
+	}
+	for _, kv := range splitNul(environ) {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			continue
+		}
+		key, value := kv[:idx], kv[idx+1:]
+		if debugProcessSecret(key) {
+			value = debugRedactedValue
+		}
+		process.Env[key] = value
+	}
+	return process
+}
+
+// readProcessLimits reads the open file descriptors, threads, and open file
+// limits of the Vault server process found with findVaultProcess, or returns
+// a note in its place. The file descriptors are only listable by the owner of
+// the process, so the count is omitted with a note when they are not, while
+// the limits and threads are still read.
+func readProcessLimits(root string) *debugProcessLimits {
+	pid, note := findVaultProcess(root)
+	if pid == 0 {
+		return &debugProcessLimits{Note: note}
+	}
+
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	limits := &debugProcessLimits{PID: pid}
+
+	if fds, err := ioutil.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		count := len(fds)
+		limits.OpenFDs = &count
+	} else {
+		limits.Note = "the open file descriptors of the process are not readable by the user running vault debug"
+	}
+
+	// The limits file is a table with the soft and hard limits following the
+	// name of each limit, such as:
+	//   Max open files            1024                 524288               files
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "limits")); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			if !strings.HasPrefix(line, "Max open files") {
+				continue
+			}
+			fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+			if len(fields) >= 2 {
+				limits.MaxOpenFilesSoft = parseProcessLimit(fields[0])
+				limits.MaxOpenFilesHard = parseProcessLimit(fields[1])
+			}
+		}
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			if !strings.HasPrefix(line, "Threads:") {
+				continue
+			}
+			if threads, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Threads:"))); err == nil {
+				limits.Threads = &threads
+			}
+		}
+	}
+
+	return limits
+}
+
+// parseProcessLimit parses a limit of the proc limits file, returning -1 for
+// an unlimited value and nil if the value is not a number.
+func parseProcessLimit(value string) *int64 {
+	if value == "unlimited" {
+		v := int64(-1)
+		return &v
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// redactProcessArgs redacts the value of every flag of the command line whose
+// name matches debugProcessSecretPatterns. Only values passed in the
+// -name=value form are redacted, since the value of a flag passed as a
+// separate argument cannot be told apart from a positional argument.
+func redactProcessArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		idx := strings.Index(arg, "=")
+		if idx == -1 {
+			continue
+		}
+		name := strings.Replace(strings.TrimLeft(arg[:idx], "-"), "-", "_", -1)
+		if debugProcessSecret(name) {
+			redacted[i] = arg[:idx+1] + debugRedactedValue
+		}
+	}
+	return redacted
+}
+
+// debugProcessSecret returns whether the environment variable or flag name
+// matches debugProcessSecretPatterns, ignoring case.
+func debugProcessSecret(name string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range debugProcessSecretPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitNul splits the NUL-separated contents of a proc file, such as cmdline
+// or environ.
+func splitNul(content []byte) []string {
+	var fields []string
+	for _, field := range bytes.Split(bytes.TrimRight(content, "\x00"), []byte{0}) {
+		if len(field) > 0 {
+			fields = append(fields, string(field))
+		}
+	}
+	return fields
+}
@@ -0,0 +1,292 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// debugFileDescriptions describes the files written to a bundle, keyed by the
+// file's path relative to the bundle, or to its frame directory for files
+// captured on every frame.
+var debugFileDescriptions = map[string]string{
+	"README.txt":                      "This file",
+	"audit_probe.json":                "Hash of a known input computed by every enabled audit device",
+	"audit_probe.txt":                 "Note explaining why the audit devices were not probed",
+	"auth.json":                       "Enabled auth methods and their configuration",
+	"auth.txt":                        "Note explaining why the auth methods were not captured",
+	"capture_log.jsonl":               "Log of every step of the run, written with -log-file",
+	"clock_skew.json":                 "Estimated skew between the local clock and the server clock",
+	"config.json":                     "Sanitized configuration state",
+	"custom/<path>/<index>.json":      "Response of a path passed with -poll-path, captured on every metrics interval",
+	"goroutine_counts.json":           "Number of goroutines of the server on every frame",
+	"hot_paths.json":                  "Busiest request paths by rate across the metrics captures",
+	"hot_paths.txt":                   "Note explaining why the hot paths were not captured",
+	"index.json":                      "Index of the capture, including the checksum of every file",
+	"license_status.json":             "License status, with the raw license redacted",
+	"license_status.txt":              "Note explaining why the license status was not captured",
+	"metrics.csv":                     "Gauges and counters of every metrics capture, one row per capture",
+	"metrics_delta.json":              "Change and rate per second of every counter between consecutive metrics captures",
+	"replication_progress.json":       "Estimated replication lag and whether it shrank or grew across the frames",
+	"metrics/<index>.json":            "Telemetry captured on every metrics interval",
+	"metrics_prometheus/<index>.prom": "Telemetry in the Prometheus exposition format, captured on every metrics interval",
+	"mounts.json":                     "Secrets engine mount table",
+	"mounts.txt":                      "Note explaining why the mount table was not captured",
+	"openapi.json":                    "OpenAPI document describing every path exposed by the server",
+	"plugins.json":                    "Plugin catalog, with the name, version, and SHA256 of each plugin",
+	"plugins.txt":                     "Note explaining why the plugin catalog was not captured",
+	"policies.json":                   "Names of the ACL policies",
+	"policies.txt":                    "Note explaining why the ACL policies were not captured",
+	"policies/<name>.hcl":             "Body of an ACL policy",
+	"quota_config.json":               "Quota configuration",
+	"quotas.txt":                      "Note explaining why the quotas were not captured",
+	"raft_snapshot_info.json":         "Raft configuration and the last index and term of every server, without the snapshot itself",
+	"raft_snapshot_info.txt":          "Note explaining why the raft snapshot info was not captured",
+	"rate_limit_quotas.json":          "Rate limit quotas",
+	"request_timings.csv":             "Request timings as CSV, one row per request",
+	"request_timings.json":            "Status code and latency of every API request made during the capture",
+	"seal_timing.json":                "Minimum, maximum, and mean time of the seal, unseal, and barrier operations across the metrics captures",
+	"seal_timing.txt":                 "Note explaining why the seal timing was not captured",
+	"sealwrap_status.json":            "Seal wrap rewrap status, with the seal and entropy augmentation configuration",
+	"sealwrap_status.txt":             "Note explaining why the seal wrap status was not captured",
+	"storage.json":                    "Storage backend type and HA status",
+	"token_self.json":                 "Properties of the token used for the capture, with identifying values redacted",
+	"vault.log":                       "Server log streamed from sys/monitor at -log-level, or a note explaining why it was not captured",
+
+	"mounts/<mount>/<index>/health.json": "Response of the health path of a mount passed with -mount-health, captured on every metrics interval",
+
+	"<frame>/audit_status.json":            "Audit devices and the latency and failures of their logging over the last metrics interval",
+	"<frame>/audit_status.txt":             "Note explaining why the audit status was not captured",
+	"<frame>/block.prof":                   "Block profile",
+	"<frame>/block.prof.gz":                "Block profile, gzip-compressed",
+	"<frame>/block.txt":                    "Note explaining why the block profile was not captured",
+	"<frame>/counters_activity.json":       "Activity counters",
+	"<frame>/counters_activity.txt":        "Note explaining why the activity counters were not captured",
+	"<frame>/counters_tokens.json":         "Token counters",
+	"<frame>/counters_tokens.txt":          "Note explaining why the token counters were not captured",
+	"<frame>/goroutine.prof":               "Goroutine profile",
+	"<frame>/goroutine.prof.gz":            "Goroutine profile, gzip-compressed",
+	"<frame>/goroutines.txt":               "Full goroutine stack dump",
+	"<frame>/ha_lock.json":                 "Holder of the HA lock, as seen by the server",
+	"<frame>/ha_lock.txt":                  "Note explaining why the HA lock holder was not captured",
+	"<frame>/health.json":                  "Health status",
+	"<frame>/heap.prof":                    "Heap profile",
+	"<frame>/heap.prof.gz":                 "Heap profile, gzip-compressed",
+	"<frame>/host_info.json":               "Information about the host running the server, with any container limits, server process limits, and server process",
+	"<frame>/leases.json":                  "Token and lease counts",
+	"<frame>/leases.txt":                   "Note explaining why the token and lease counts were not captured",
+	"<frame>/mutex.prof":                   "Mutex profile",
+	"<frame>/mutex.prof.gz":                "Mutex profile, gzip-compressed",
+	"<frame>/mutex.txt":                    "Note explaining why the mutex profile was not captured",
+	"<frame>/profile.prof":                 "CPU profile",
+	"<frame>/profile.prof.gz":              "CPU profile, gzip-compressed",
+	"<frame>/replication_dr.json":          "DR replication status, including WAL positions and merkle sync state",
+	"<frame>/replication_dr.txt":           "Note explaining why the DR replication status was not captured",
+	"<frame>/replication_performance.json": "Performance replication status, including WAL positions and merkle sync state",
+	"<frame>/replication_performance.txt":  "Note explaining why the performance replication status was not captured",
+	"<frame>/replication_status.json":      "Replication status",
+	"<frame>/requests.json":                "Requests being served by the server, oldest first",
+	"<frame>/requests.txt":                 "Note explaining why the in-flight requests were not captured",
+	"<frame>/server_status.json":           "Health and seal status",
+	"<frame>/trace.out":                    "Execution trace",
+	"<frame>/trace.txt":                    "Note explaining why the execution trace was not captured",
+}
+
+var (
+	debugMetricsFileRe           = regexp.MustCompile(`^metrics/\d+\.json$`)
+	debugPrometheusMetricsFileRe = regexp.MustCompile(`^metrics_prometheus/\d+\.prom$`)
+	debugCustomFileRe            = regexp.MustCompile(`^custom/[^/]+/\d+\.json$`)
+	debugMountHealthFileRe       = regexp.MustCompile(`^mounts/[^/]+/\d+/health\.json$`)
+	debugCustomStreamRe          = regexp.MustCompile(`^custom/[^/]+\.jsonl$`)
+	debugMountHealthStreamRe     = regexp.MustCompile(`^mounts/[^/]+/health\.jsonl$`)
+)
+
+// writeReadme writes a README.txt file at the root of the output directory
+// that describes the capture and the layout of the bundle, so that the bundle
+// can be understood without this command at hand.
+func (c *DebugCommand) writeReadme() error {
+	frameDirs := map[string]bool{}
+	for _, frame := range c.debugIndex.Frames {
+		frameDirs[frame.Directory] = true
+	}
+	for _, cluster := range c.debugIndex.Clusters {
+		for _, frame := range cluster.Frames {
+			frameDirs[frame.Directory] = true
+		}
+	}
+
+	// Files are listed by their generic path, such as <frame>/health.json,
+	// so that each one is only described once
+	files := map[string]bool{"README.txt": true, "index.json": true}
+	err := filepath.Walk(c.cfg.Output, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(c.cfg.Output, p)
+		if err != nil {
+			return err
+		}
+		files[debugGenericPath(filepath.ToSlash(relPath), frameDirs)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]string, 0, len(files))
+	for file := range files {
+		sorted = append(sorted, file)
+	}
+	sort.Strings(sorted)
+
+	index := c.debugIndex
+	var b strings.Builder
+	b.WriteString("Vault Debug Bundle\n")
+	b.WriteString("==================\n\n")
+	b.WriteString("This bundle was produced by the \"vault debug\" command. The index.json file\n")
+	b.WriteString("holds a machine-readable description of the capture, and the integrity of\n")
+	b.WriteString("the bundle can be checked with \"vault debug verify\".\n\n")
+
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Command:\t%s\n", strings.Join(append([]string{"vault", "debug"}, index.RawArgs...), " "))
+	fmt.Fprintf(tw, "Vault Address:\t%s\n", index.VaultAddress)
+	if index.ActiveAddress != "" {
+		fmt.Fprintf(tw, "Active Address:\t%s\n", index.ActiveAddress)
+	}
+	fmt.Fprintf(tw, "Captured:\t%s\n", index.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Duration:\t%s\n", time.Duration(index.DurationSeconds)*time.Second)
+	fmt.Fprintf(tw, "Interval:\t%s\n", time.Duration(index.IntervalSeconds)*time.Second)
+	fmt.Fprintf(tw, "Metrics Interval:\t%s\n", time.Duration(index.MetricsIntervalSeconds)*time.Second)
+	fmt.Fprintf(tw, "Targets:\t%s\n", strings.Join(index.Targets, ", "))
+	if len(index.Errors) > 0 {
+		fmt.Fprintf(tw, "Errors:\t%d, see index.json for details\n", len(index.Errors))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	b.WriteString("\nFrames\n------\n\n")
+	b.WriteString("Targets that are captured on every interval are written to a frame\n")
+	b.WriteString("directory, referred to as <frame> below.\n\n")
+	tw = tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	if len(index.Clusters) > 0 {
+		names := make([]string, 0, len(index.Clusters))
+		for name := range index.Clusters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			cluster := index.Clusters[name]
+			for _, frame := range cluster.Frames {
+				fmt.Fprintf(tw, "  %s/%s\t%s\n", cluster.Directory, frame.Directory, frame.Timestamp.Format(time.RFC3339))
+			}
+		}
+	}
+	for _, frame := range index.Frames {
+		fmt.Fprintf(tw, "  %s\t%s\n", frame.Directory, frame.Timestamp.Format(time.RFC3339))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	b.WriteString("\nFiles\n-----\n\n")
+	tw = tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	for _, file := range sorted {
+		fmt.Fprintf(tw, "  %s\t%s\n", file, debugFileDescription(file))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	return c.writeFile("README.txt", []byte(b.String()))
+}
+
+// debugGenericPath returns the generic form of a path relative to the bundle,
+// with frame directories, metrics indexes, custom paths, and policy names
+// replaced by a placeholder.
+func debugGenericPath(relPath string, frameDirs map[string]bool) string {
+	prefix := ""
+	if strings.HasPrefix(relPath, "clusters/") {
+		parts := strings.SplitN(relPath, "/", 3)
+		if len(parts) == 3 {
+			prefix = "clusters/<name>/"
+			relPath = parts[2]
+		}
+	}
+
+	dir, file := path.Split(relPath)
+	dir = strings.TrimSuffix(dir, "/")
+	switch {
+	case debugMetricsFileRe.MatchString(relPath):
+		relPath = "metrics/<index>.json"
+	case debugPrometheusMetricsFileRe.MatchString(relPath):
+		relPath = "metrics_prometheus/<index>.prom"
+	case debugCustomFileRe.MatchString(relPath):
+		relPath = "custom/<path>/<index>.json"
+	case debugMountHealthFileRe.MatchString(relPath):
+		relPath = "mounts/<mount>/<index>/health.json"
+	case debugCustomStreamRe.MatchString(relPath):
+		relPath = "custom/<path>.jsonl"
+	case debugMountHealthStreamRe.MatchString(relPath):
+		relPath = "mounts/<mount>/health.jsonl"
+	case dir == "policies" && path.Ext(file) == ".hcl":
+		relPath = "policies/<name>.hcl"
+	case frameDirs[dir]:
+		relPath = "<frame>/" + file
+	}
+
+	return prefix + relPath
+}
+
+// debugFileDescription returns the one-line description of the file at the
+// given generic path.
+func debugFileDescription(file string) string {
+	if desc, ok := debugFileOwnDescription(file); ok {
+		return desc
+	}
+	if strings.HasPrefix(file, "clusters/<name>/") {
+		if desc, ok := debugFileOwnDescription(strings.TrimPrefix(file, "clusters/<name>/")); ok {
+			return desc + ", for each cluster"
+		}
+	}
+	return "Captured data"
+}
+
+// debugFileOwnDescription returns the description of the file at the given
+// generic path outside of a cluster. The JSON lines file of a poll result
+// written with -format=jsonl is described after the poll result.
+func debugFileOwnDescription(file string) (string, bool) {
+	if desc, ok := debugFileDescriptions[file]; ok {
+		return desc, true
+	}
+	if path.Ext(file) != ".jsonl" {
+		return "", false
+	}
+
+	var source string
+	switch file {
+	case "metrics.jsonl":
+		source = "metrics/<index>.json"
+	case "custom/<path>.jsonl":
+		source = "custom/<path>/<index>.json"
+	case "mounts/<mount>/health.jsonl":
+		source = "mounts/<mount>/<index>/health.json"
+	default:
+		source = "<frame>/" + strings.TrimSuffix(file, ".jsonl") + ".json"
+	}
+	if desc, ok := debugFileDescriptions[source]; ok {
+		return desc + ", one JSON record per capture", true
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+package command
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugCommand_Readme(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	targets := []string{"config", "host", "metrics", "server-status"}
+	args := []string{
+		"-duration=1s",
+		fmt.Sprintf("-output=%s/readme", testDir),
+	}
+	for _, target := range targets {
+		args = append(args, "-target="+target)
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	f, err := os.Open(filepath.Join(testDir, "readme"+debugCompressionExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var readme string
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path.Base(header.Name) != "README.txt" {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readme = string(content)
+	}
+
+	if readme == "" {
+		t.Fatal("expected README.txt in the archive")
+	}
+	for _, target := range targets {
+		if !strings.Contains(readme, target) {
+			t.Fatalf("expected README.txt to mention target %q:\n%s", target, readme)
+		}
+	}
+
+	// Every file is described, including those captured on every frame
+	for _, expected := range []string{"config.json", "<frame>/host_info.json", "metrics/<index>.json", "<frame>/server_status.json", "index.json"} {
+		if !strings.Contains(readme, expected) {
+			t.Fatalf("expected README.txt to describe %s:\n%s", expected, readme)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// debugRedactIPPattern matches the IPv4 addresses, and the IPv6 addresses
+// either written in full or abbreviated with "::", found in a value.
+const debugRedactIPPattern = `\b(?:\d{1,3}\.){3}\d{1,3}\b|(?i:\b(?:[0-9a-f]{1,4}:){7}[0-9a-f]{1,4}\b|[0-9a-f]*::[0-9a-f:]*)`
+
+// debugRedactArgPattern matches the arguments of the command that hold an
+// address, such as -address or -cluster.
+const debugRedactArgPattern = debugRedactIPPattern + `|://|(?i:-address|-cluster|-proxy)=`
+
+// debugDefaultRedactRules are the rules applied with -redact, which redact
+// the values that identify a cluster or its hosts, or that grant access to
+// it. Any value containing an IP address is redacted from the server status,
+// host information, and configuration captures, along with the cluster names
+// and IDs, hostnames, and listener and cluster addresses they hold. The
+// cluster name and ID are also redacted from the health status, the leader
+// addresses from the HA lock holder, and the client addresses from the
+// in-flight requests. The index, and the README derived from it, have the
+// addresses of the servers, the arguments holding an address, and the errors
+// containing an IP address redacted. Token and mount accessors are redacted
+// from every capture, and the license, customer, and installation IDs from
+// the license status.
+var debugDefaultRedactRules = []struct {
+	path  string
+	match string
+}{
+	{"server_status.**.cluster_name", ""},
+	{"server_status.**.cluster_id", ""},
+	{"server_status.**.*", debugRedactIPPattern},
+	{"server_status.**.*[*]", debugRedactIPPattern},
+	{"host_info.**.hostname", ""},
+	{"host_info.**.hostid", ""},
+	{"host_info.**.*", debugRedactIPPattern},
+	{"host_info.**.*[*]", debugRedactIPPattern},
+	{"config.**.cluster_name", ""},
+	{"config.**.*_addr", ""},
+	{"config.**.address", ""},
+	{"config.**.*", debugRedactIPPattern},
+	{"config.**.*[*]", debugRedactIPPattern},
+	{"health.**.cluster_name", ""},
+	{"health.**.cluster_id", ""},
+	{"ha_lock.**.*_address", ""},
+	{"requests.**.client_remote_address", ""},
+	{"index.**.vault_address", ""},
+	{"index.**.active_address", ""},
+	{"index.raw_args[*]", debugRedactArgPattern},
+	{"index.**.error", debugRedactIPPattern},
+	{"*.**.accessor", ""},
+	{"*.**.*_accessor", ""},
+	{"license_status.**.license_id", ""},
+	{"license_status.**.customer_id", ""},
+	{"license_status.**.installation_id", ""},
+}
+
+// debugRedactFile is the format of the file passed with -redact-file, which
+// can be written in either JSON or YAML.
+type debugRedactFile struct {
+	Rules []struct {
+		Path  string `json:"path"`
+		Match string `json:"match"`
+	} `json:"rules"`
+}
+
+// debugRedactRule redacts the values found at a path in the captured JSON
+// files. The first segment of the path selects the files, and the remaining
+// segments address the values within each file.
+type debugRedactRule struct {
+	raw      string
+	file     string
+	segments []debugRedactSegment
+	match    *regexp.Regexp
+}
+
+// debugRedactSegment is a segment of a redaction path, which addresses either
+// the keys of an object or the elements of an array.
+type debugRedactSegment struct {
+	key     string
+	index   string
+	isIndex bool
+}
+
+// debugRedactor replaces the values matched by a set of redaction rules in
+// the captured JSON files.
+type debugRedactor struct {
+	rules []*debugRedactRule
+}
+
+// newDebugRedactor returns a redactor applying debugDefaultRedactRules if
+// defaults is set, along with the rules read from the given file, if any.
+func newDebugRedactor(defaults bool, file string) (*debugRedactor, error) {
+	r := &debugRedactor{}
+	if defaults {
+		for _, rule := range debugDefaultRedactRules {
+			parsed, err := parseDebugRedactRule(rule.path, rule.match)
+			if err != nil {
+				panic(fmt.Sprintf("debug: invalid default redaction rule: %s", err))
+			}
+			r.rules = append(r.rules, parsed)
+		}
+	}
+	if file != "" {
+		loaded, err := loadDebugRedactor(file)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, loaded.rules...)
+	}
+
+	return r, nil
+}
+
+// loadDebugRedactor reads the redaction rules from the given file.
+func loadDebugRedactor(file string) (*debugRedactor, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config debugRedactFile
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction rules: %s", err)
+	}
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("no redaction rules found in %s", file)
+	}
+
+	r := &debugRedactor{}
+	for _, rule := range config.Rules {
+		parsed, err := parseDebugRedactRule(rule.Path, rule.Match)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, parsed)
+	}
+
+	return r, nil
+}
+
+// parseDebugRedactRule parses a redaction path, such as
+// "metrics.Gauges[*].Name", along with an optional regular expression that
+// values must match to be redacted. Keys and the file selector are matched as
+// glob patterns, and indexes are either a number or "*". A "**" key matches
+// any number of nested keys and indexes, including none, so that
+// "config.**.address" addresses every address of the configuration.
+func parseDebugRedactRule(raw, match string) (*debugRedactRule, error) {
+	rule := &debugRedactRule{raw: raw}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid redaction path %q, must select a file and a value within it", raw)
+	}
+
+	for i, part := range parts {
+		key := part
+		var indexes []string
+		if open := strings.Index(part, "["); open != -1 {
+			key = part[:open]
+			rest := part[open:]
+			for rest != "" {
+				if rest[0] != '[' || !strings.Contains(rest, "]") {
+					return nil, fmt.Errorf("invalid redaction path %q, malformed index in %q", raw, part)
+				}
+				end := strings.Index(rest, "]")
+				index := rest[1:end]
+				if _, err := strconv.Atoi(index); err != nil && index != "*" {
+					return nil, fmt.Errorf("invalid redaction path %q, index %q must be a number or *", raw, index)
+				}
+				indexes = append(indexes, index)
+				rest = rest[end+1:]
+			}
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("invalid redaction path %q, empty key in %q", raw, part)
+		}
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("invalid redaction path %q, bad pattern %q", raw, key)
+		}
+
+		if i == 0 {
+			if len(indexes) > 0 {
+				return nil, fmt.Errorf("invalid redaction path %q, the file selector cannot be indexed", raw)
+			}
+			rule.file = key
+			continue
+		}
+		if key == "**" {
+			if len(indexes) > 0 {
+				return nil, fmt.Errorf("invalid redaction path %q, ** cannot be indexed", raw)
+			}
+			if i == len(parts)-1 {
+				return nil, fmt.Errorf("invalid redaction path %q, cannot end with **", raw)
+			}
+		}
+
+		rule.segments = append(rule.segments, debugRedactSegment{key: key})
+		for _, index := range indexes {
+			rule.segments = append(rule.segments, debugRedactSegment{index: index, isIndex: true})
+		}
+	}
+
+	if match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match for redaction path %q: %s", raw, err)
+		}
+		rule.match = re
+	}
+
+	return rule, nil
+}
+
+// matchesFile returns whether the rule applies to the file at the given path
+// relative to the bundle. The file selector is matched against the name of
+// the file without its extension, such as "health" for every frame's
+// health.json, and against the name of its directory, such as "metrics" for
+// every file under metrics.
+func (r *debugRedactRule) matchesFile(relPath string) bool {
+	dir, file := path.Split(filepath.ToSlash(relPath))
+	if ok, _ := path.Match(r.file, strings.TrimSuffix(file, path.Ext(file))); ok {
+		return true
+	}
+	if dir = strings.TrimSuffix(dir, "/"); dir != "" {
+		if ok, _ := path.Match(r.file, path.Base(dir)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redact replaces the values addressed by the segments in data, returning the
+// number of values that were replaced.
+func (r *debugRedactRule) redact(data interface{}, segments []debugRedactSegment) int {
+	seg := segments[0]
+	last := len(segments) == 1
+
+	var count int
+	if seg.key == "**" {
+		// Match the remaining segments here, then at every level below
+		count += r.redact(data, segments[1:])
+		switch v := data.(type) {
+		case map[string]interface{}:
+			for _, value := range v {
+				count += r.redact(value, segments)
+			}
+		case []interface{}:
+			for _, value := range v {
+				count += r.redact(value, segments)
+			}
+		}
+		return count
+	}
+
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if seg.isIndex {
+			return 0
+		}
+		for k, value := range v {
+			if ok, _ := path.Match(seg.key, k); !ok {
+				continue
+			}
+			if last {
+				if r.redactable(value) {
+					v[k] = debugRedactedValue
+					count++
+				}
+				continue
+			}
+			count += r.redact(value, segments[1:])
+		}
+	case []interface{}:
+		if !seg.isIndex {
+			return 0
+		}
+		for i, value := range v {
+			if seg.index != "*" && seg.index != strconv.Itoa(i) {
+				continue
+			}
+			if last {
+				if r.redactable(value) {
+					v[i] = debugRedactedValue
+					count++
+				}
+				continue
+			}
+			count += r.redact(value, segments[1:])
+		}
+	}
+
+	return count
+}
+
+// redactable returns whether the value should be redacted. Without a match,
+// every value is redacted, otherwise only the strings that match are.
+func (r *debugRedactRule) redactable(value interface{}) bool {
+	if r.match == nil {
+		return true
+	}
+	s, ok := value.(string)
+	return ok && r.match.MatchString(s)
+}
+
+// redactDir applies the redaction rules to every JSON file under dir except
+// the index, which is regenerated afterwards, and to the records of every JSON
+// lines file written with -format=jsonl, and returns the number of values that
+// were replaced.
+func (r *debugRedactor) redactDir(dir string) (int, error) {
+	var total int
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(p) == ".jsonl" {
+			count, err := r.redactStream(p)
+			total += count
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) != ".json" {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if relPath == "index.json" {
+			return nil
+		}
+
+		var rules []*debugRedactRule
+		for _, rule := range r.rules {
+			if rule.matchesFile(relPath) {
+				rules = append(rules, rule)
+			}
+		}
+		if len(rules) == 0 {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var data interface{}
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		if err := dec.Decode(&data); err != nil {
+			// Not every captured file is guaranteed to be valid JSON, such as
+			// a partially written response, so skip those that aren't
+			return nil
+		}
+
+		var count int
+		for _, rule := range rules {
+			count += rule.redact(data, rule.segments)
+		}
+		if count == 0 {
+			return nil
+		}
+		total += count
+
+		redacted, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(p, redacted, 0644)
+	})
+
+	return total, err
+}
+
+// redactIndex applies the redaction rules matching index.json to the index,
+// which is only written once the capture completes and is skipped by
+// redactDir, and returns the number of values that were replaced.
+func (r *debugRedactor) redactIndex(index *DebugIndex) (int, error) {
+	content, err := json.Marshal(index)
+	if err != nil {
+		return 0, err
+	}
+
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, rule := range r.rules {
+		if rule.matchesFile("index.json") {
+			count += rule.redact(data, rule.segments)
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	if content, err = json.Marshal(data); err != nil {
+		return count, err
+	}
+	redacted := &DebugIndex{}
+	if err := json.Unmarshal(content, redacted); err != nil {
+		return count, err
+	}
+	*index = *redacted
+	return count, nil
+}
+
+// redactStream applies the redaction rules matching the path of each record
+// of the JSON lines file to the data of the record, and returns the number of
+// values that were replaced. Lines that are not records, such as a line left
+// partially written, are kept as is.
+func (r *debugRedactor) redactStream(p string) (int, error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	lines := bytes.Split(content, []byte("\n"))
+	for i, line := range lines {
+		var record debugStreamRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.Path == "" {
+			continue
+		}
+
+		var data interface{}
+		dec := json.NewDecoder(bytes.NewReader(record.Data))
+		dec.UseNumber()
+		if err := dec.Decode(&data); err != nil {
+			continue
+		}
+
+		var count int
+		for _, rule := range r.rules {
+			if rule.matchesFile(record.Path) {
+				count += rule.redact(data, rule.segments)
+			}
+		}
+		if count == 0 {
+			continue
+		}
+		total += count
+
+		if record.Data, err = json.Marshal(data); err != nil {
+			return total, err
+		}
+		if lines[i], err = json.Marshal(&record); err != nil {
+			return total, err
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+
+	return total, ioutil.WriteFile(p, bytes.Join(lines, []byte("\n")), 0644)
+}
@@ -0,0 +1,343 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestDebugCommand_RedactFile(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/metrics":
+			w.Write([]byte(`{"Gauges":[{"Name":"vault.secret.kv.count","Value":1},{"Name":"vault.runtime.num_goroutines","Value":42}],"Counters":[]}`))
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{"api_addr":"https://vault-0.internal:8200","cluster_addr":"https://vault-0.internal:8201","log_level":"info"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	rulesPath := filepath.Join(testDir, "rules.yaml")
+	rules := `rules:
+  - path: metrics.Gauges[*].Name
+    match: ^vault\.secret\.
+  - path: config.config.*_addr
+`
+	if err := ioutil.WriteFile(rulesPath, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "redact")
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		"-target=metrics",
+		"-metrics-csv",
+		"-format=jsonl",
+		fmt.Sprintf("-redact-file=%s", rulesPath),
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "metrics", "000.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics struct {
+		Gauges []struct {
+			Name  string
+			Value json.Number
+		}
+	}
+	if err := json.Unmarshal(content, &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.Gauges) != 2 {
+		t.Fatalf("expected 2 gauges, got: %s", content)
+	}
+	if metrics.Gauges[0].Name != debugRedactedValue {
+		t.Fatalf("expected matching gauge name to be redacted, got: %s", content)
+	}
+	if metrics.Gauges[1].Name != "vault.runtime.num_goroutines" || metrics.Gauges[1].Value != "42" {
+		t.Fatalf("expected other gauge to be retained, got: %s", content)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(outputPath, "metrics.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "vault.secret.kv.count") {
+		t.Fatalf("expected redacted gauge name to be omitted from metrics.csv, got: %s", content)
+	}
+
+	// The records of the JSON lines files are redacted as well
+	content, err = ioutil.ReadFile(filepath.Join(outputPath, "metrics.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "vault.secret.kv.count") || !strings.Contains(string(content), debugRedactedValue) {
+		t.Fatalf("expected gauge name to be redacted from metrics.jsonl, got: %s", content)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(outputPath, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "vault-0.internal") {
+		t.Fatalf("expected addresses to be redacted, got: %s", content)
+	}
+	if !strings.Contains(string(content), `"log_level": "info"`) {
+		t.Fatalf("expected other configuration to be retained, got: %s", content)
+	}
+
+	// The index is generated after redaction, so the checksums still match
+	verifyUI, verifyCmd := testDebugVerifyCommand(t)
+	if code := verifyCmd.Run([]string{outputPath}); code != 0 {
+		t.Fatalf("expected bundle to verify, got %d: %s", code, verifyUI.ErrorWriter.String())
+	}
+}
+
+func TestDebugCommand_Redact(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.4.0","cluster_name":"vault-cluster-prod","cluster_id":"6e5fb8b5-1c3d-4f1e-9b6a-2d9e0c7a1f00"}`))
+		case "/v1/sys/seal-status":
+			w.Write([]byte(`{"type":"shamir","sealed":false,"cluster_name":"vault-cluster-prod","cluster_id":"6e5fb8b5-1c3d-4f1e-9b6a-2d9e0c7a1f00"}`))
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{"api_addr":"https://vault-0.internal:8200","cluster_name":"vault-cluster-prod","listeners":[{"config":{"address":"[::]:8200","tls_disable":false}}],"log_level":"info","storage":{"type":"raft","config":{"retry_join":"10.0.4.13"}}}}`))
+		case "/v1/sys/host-info":
+			w.Write([]byte(`{"data":{"host":{"hostname":"vault-0","hostid":"8f1a2b3c","os":"linux"},"net":[{"name":"eth0","addrs":["10.0.4.12/24"]}]}}`))
+		case "/v1/sys/auth":
+			w.Write([]byte(`{"data":{"token/":{"type":"token","accessor":"auth_token_1a2b3c"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.skipPreflight = true
+
+	outputPath := filepath.Join(testDir, "redact")
+	args := []string{
+		"-duration=1s",
+		"-target=auth",
+		"-target=config",
+		"-target=host",
+		"-target=server-status",
+		"-redact",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if out := ui.OutputWriter.String(); !strings.Contains(out, "matching the default rules") {
+		t.Fatalf("expected the redaction to be reported, got: %s", out)
+	}
+
+	for file, retained := range map[string]string{
+		"auth.json":              `"type": "token"`,
+		"config.json":            `"log_level": "info"`,
+		"000/host_info.json":     `"os": "linux"`,
+		"000/server_status.json": `"type": "shamir"`,
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range []string{"vault-cluster-prod", "6e5fb8b5", "vault-0", "8f1a2b3c", "10.0.4.", "[::]", "auth_token_1a2b3c"} {
+			if strings.Contains(string(content), value) {
+				t.Fatalf("expected %s to be redacted from %s, got: %s", value, file, content)
+			}
+		}
+		if !strings.Contains(string(content), retained) {
+			t.Fatalf("expected %s to be retained in %s, got: %s", retained, file, content)
+		}
+	}
+
+	// The index is generated after redaction, so the checksums still match
+	verifyUI, verifyCmd := testDebugVerifyCommand(t)
+	if code := verifyCmd.Run([]string{outputPath}); code != 0 {
+		t.Fatalf("expected bundle to verify, got %d: %s", code, verifyUI.ErrorWriter.String())
+	}
+}
+
+func TestDebugCommand_RedactBundle(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.4.0","cluster_name":"vault-cluster-prod","cluster_id":"6e5fb8b5-1c3d-4f1e-9b6a-2d9e0c7a1f00"}`))
+		case "/v1/sys/seal-status":
+			w.Write([]byte(`{"type":"shamir","sealed":false,"cluster_name":"vault-cluster-prod","cluster_id":"6e5fb8b5-1c3d-4f1e-9b6a-2d9e0c7a1f00"}`))
+		case "/v1/sys/leader":
+			w.Write([]byte(`{"ha_enabled":true,"is_self":true,"leader_address":"https://10.0.4.12:8200","leader_cluster_address":"https://10.0.4.12:8201"}`))
+		case "/v1/sys/in-flight-req":
+			w.Write([]byte(`{"data":{"a":{"start_time":"2019-10-15T21:44:49Z","request_method":"GET","request_path":"/v1/secret/data/app","client_remote_address":"10.0.4.99:51234"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverIP := strings.Split(strings.TrimPrefix(ts.URL, "http://"), ":")[0]
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.skipPreflight = true
+
+	// The replication status isn't served, so its error holds the address
+	// of the server
+	outputPath := filepath.Join(testDir, "redact")
+	args := []string{
+		"-duration=1s",
+		"-target=ha-lock",
+		"-target=health",
+		"-target=replication-status",
+		"-target=requests",
+		"-target=server-status",
+		"-redact",
+		fmt.Sprintf("-address=%s", ts.URL),
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	for file, retained := range map[string]string{
+		"README.txt":        "-duration=1s",
+		"index.json":        `"-duration=1s"`,
+		"000/health.json":   `"version": "1.4.0"`,
+		"000/ha_lock.json":  `"is_self": true`,
+		"000/requests.json": `"request_path": "/v1/secret/data/app"`,
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), retained) {
+			t.Fatalf("expected %s to be retained in %s, got: %s", retained, file, content)
+		}
+	}
+
+	// No address or cluster name of the server is left anywhere in the bundle
+	err = filepath.Walk(outputPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for _, value := range []string{serverIP, "10.0.4.", "vault-cluster-prod", "6e5fb8b5"} {
+			if strings.Contains(string(content), value) {
+				t.Errorf("expected %s to be redacted from %s, got: %s", value, p, content)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is redacted before it is written, so the checksums still
+	// match
+	verifyUI, verifyCmd := testDebugVerifyCommand(t)
+	if code := verifyCmd.Run([]string{outputPath}); code != 0 {
+		t.Fatalf("expected bundle to verify, got %d: %s", code, verifyUI.ErrorWriter.String())
+	}
+}
+
+func TestParseDebugRedactRule(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		path string
+		err  string
+	}{
+		{"metrics.Gauges[*].Name", ""},
+		{"*.data.items[0][*]", ""},
+		{"config.**.address", ""},
+		{"config.**", "cannot end with **"},
+		{"config.**[0].address", "** cannot be indexed"},
+		{"token_self", "must select a file and a value"},
+		{"metrics[0].Gauges", "file selector cannot be indexed"},
+		{"metrics.Gauges[x]", "must be a number or *"},
+		{"metrics.Gauges[*", "malformed index"},
+		{"metrics..Name", "empty key"},
+	}
+
+	for _, tc := range cases {
+		_, err := parseDebugRedactRule(tc.path, "")
+		switch {
+		case tc.err == "" && err != nil:
+			t.Fatalf("%s: unexpected error: %s", tc.path, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Fatalf("%s: expected error containing %q, got: %v", tc.path, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debugIndexSchema is the JSON schema of the index file of a bundle. It only
+// relies on the subset of JSON schema implemented by validateDebugSchema, so
+// that the index can be validated without an external library, but is
+// otherwise a standard draft-07 schema usable by other tooling.
+const debugIndexSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "Vault debug bundle index",
+  "type": "object",
+  "required": ["version", "vault_address", "timestamp", "duration_seconds", "interval_seconds", "metrics_interval_seconds", "compress", "raw_args", "targets", "output", "checksums", "errors", "frames"],
+  "additionalProperties": false,
+  "properties": {
+    "version": {"type": "integer", "minimum": 1},
+    "vault_address": {"type": "string"},
+    "active_address": {"type": "string"},
+    "namespace": {"type": "string"},
+    "server_version": {"type": "string"},
+    "client_version": {"type": "string"},
+    "timestamp": {"type": "string", "format": "date-time"},
+    "duration_seconds": {"type": "integer", "minimum": 0},
+    "interval_seconds": {"type": "integer", "minimum": 0},
+    "metrics_interval_seconds": {"type": "integer", "minimum": 0},
+    "compress": {"type": "boolean"},
+    "raw_args": {"type": ["array", "null"], "items": {"type": "string"}},
+    "targets": {"type": "array", "items": {"type": "string"}},
+    "output": {
+      "type": ["array", "null"],
+      "items": {
+        "anyOf": [
+          {"type": "string"},
+          {"$ref": "#/definitions/output_file"}
+        ]
+      }
+    },
+    "checksums": {"type": ["object", "null"], "additionalProperties": {"type": "string"}},
+    "metadata": {"type": "object", "additionalProperties": {"type": "string"}},
+    "errors": {"type": "array", "items": {"$ref": "#/definitions/error"}},
+    "frames": {"type": "array", "items": {"$ref": "#/definitions/frame"}},
+    "pprof_frames": {"type": "array", "items": {"type": "integer", "minimum": 0}},
+    "clusters": {"type": "object", "additionalProperties": {"$ref": "#/definitions/cluster"}},
+    "wait_until": {"type": "string"},
+    "wait_result": {"type": "string", "enum": ["met", "timeout"]},
+    "extend_on": {"type": "string"},
+    "extended_seconds": {"type": "integer", "minimum": 0},
+    "partial": {"type": "boolean"},
+    "captured_against_standby": {"type": "boolean"},
+    "tls_verification_skipped": {"type": "boolean"},
+    "signing_key_fingerprint": {"type": "string"},
+    "resumed": {"type": "array", "items": {"type": "string", "format": "date-time"}},
+    "custom_paths": {"type": "array", "items": {"$ref": "#/definitions/custom_path"}}
+  },
+  "definitions": {
+    "output_file": {
+      "type": "object",
+      "required": ["path", "timestamp"],
+      "additionalProperties": false,
+      "properties": {
+        "path": {"type": "string"},
+        "timestamp": {"type": "string", "format": "date-time"}
+      }
+    },
+    "error": {
+      "type": "object",
+      "required": ["target", "frame", "timestamp", "error"],
+      "additionalProperties": false,
+      "properties": {
+        "target": {"type": "string"},
+        "frame": {"type": "integer", "minimum": -1},
+        "timestamp": {"type": "string", "format": "date-time"},
+        "error": {"type": "string"}
+      }
+    },
+    "frame": {
+      "type": "object",
+      "required": ["frame", "directory", "timestamp"],
+      "additionalProperties": false,
+      "properties": {
+        "frame": {"type": "integer", "minimum": 0},
+        "directory": {"type": "string"},
+        "timestamp": {"type": "string", "format": "date-time"},
+        "end_timestamp": {"type": "string", "format": "date-time"}
+      }
+    },
+    "cluster": {
+      "type": "object",
+      "required": ["vault_address", "directory", "errors", "frames"],
+      "additionalProperties": false,
+      "properties": {
+        "vault_address": {"type": "string"},
+        "active_address": {"type": "string"},
+        "server_version": {"type": "string"},
+        "directory": {"type": "string"},
+        "errors": {"type": "array", "items": {"$ref": "#/definitions/error"}},
+        "frames": {"type": "array", "items": {"$ref": "#/definitions/frame"}},
+        "pprof_frames": {"type": "array", "items": {"type": "integer", "minimum": 0}},
+        "captured_against_standby": {"type": "boolean"}
+      }
+    },
+    "custom_path": {
+      "type": "object",
+      "required": ["path", "directory"],
+      "additionalProperties": false,
+      "properties": {
+        "path": {"type": "string"},
+        "directory": {"type": "string"}
+      }
+    }
+  }
+}`
+
+var (
+	debugIndexSchemaOnce   sync.Once
+	debugIndexSchemaParsed map[string]interface{}
+)
+
+// validateDebugIndex validates the serialized index file against
+// debugIndexSchema, returning an error describing every violation.
+func validateDebugIndex(data []byte) error {
+	debugIndexSchemaOnce.Do(func() {
+		if err := json.Unmarshal([]byte(debugIndexSchema), &debugIndexSchemaParsed); err != nil {
+			panic(fmt.Sprintf("invalid debug index schema: %s", err))
+		}
+	})
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("failed to parse index file: %s", err)
+	}
+
+	problems := validateDebugSchema(debugIndexSchemaParsed, debugIndexSchemaParsed, doc, "")
+	if len(problems) > 0 {
+		return fmt.Errorf("index file does not conform to the schema: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateDebugSchema validates the decoded JSON value against the schema,
+// returning a description of every violation prefixed with the path of the
+// offending value. Only the type, enum, minimum, format, properties,
+// required, additionalProperties, items, anyOf, and local $ref keywords are
+// supported.
+func validateDebugSchema(root, schema map[string]interface{}, value interface{}, path string) []string {
+	name := path
+	if name == "" {
+		name = "(root)"
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		def, ok := debugSchemaRef(root, ref)
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolvable reference %q", name, ref)}
+		}
+		return validateDebugSchema(root, def, value, path)
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		for _, sub := range anyOf {
+			subSchema, _ := sub.(map[string]interface{})
+			if len(validateDebugSchema(root, subSchema, value, path)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: does not match any of the allowed schemas", name)}
+	}
+
+	if types := debugSchemaTypes(schema["type"]); len(types) > 0 {
+		actual := debugSchemaType(value)
+		var matched bool
+		for _, t := range types {
+			if t == actual || (t == "number" && actual == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return []string{fmt.Sprintf("%s: expected %s, got %s", name, strings.Join(types, " or "), actual)}
+		}
+	}
+
+	var problems []string
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		var matched bool
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of the allowed values", name, value))
+		}
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		if min, ok := schema["minimum"].(float64); ok {
+			if n, err := v.Float64(); err == nil && n < min {
+				problems = append(problems, fmt.Sprintf("%s: %s is less than the minimum of %v", name, v, min))
+			}
+		}
+	case string:
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a valid date-time", name, v))
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				problems = append(problems, validateDebugSchema(root, items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := v[r.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: missing required field %q", name, r))
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+
+			if prop, ok := properties[k].(map[string]interface{}); ok {
+				problems = append(problems, validateDebugSchema(root, prop, v[k], fieldPath)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					problems = append(problems, fmt.Sprintf("%s: unexpected field", fieldPath))
+				}
+			case map[string]interface{}:
+				problems = append(problems, validateDebugSchema(root, additional, v[k], fieldPath)...)
+			}
+		}
+	}
+
+	return problems
+}
+
+// debugSchemaRef resolves a local reference such as "#/definitions/frame".
+func debugSchemaRef(root map[string]interface{}, ref string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+
+	var current interface{} = root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = m[part]
+	}
+
+	def, ok := current.(map[string]interface{})
+	return def, ok
+}
+
+// debugSchemaTypes returns the types allowed by the type keyword, which may
+// be either a single type or a list of types.
+func debugSchemaTypes(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			types = append(types, fmt.Sprint(item))
+		}
+		return types
+	}
+	return nil
+}
+
+// debugSchemaType returns the JSON schema type of a decoded JSON value.
+func debugSchemaType(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := n.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,135 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugCommand_ValidateIndex(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "validate")
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		"-validate-index",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateDebugIndex(content); err != nil {
+		t.Fatalf("expected written index to conform to the schema: %s", err)
+	}
+
+	// Corrupt the index in-memory and regenerate it, which should fail
+	// validation rather than write the corrupted index
+	cmd.debugIndex.Version = 0
+	cmd.debugIndex.WaitResult = "bogus"
+	err = cmd.generateIndex()
+	if err == nil {
+		t.Fatal("expected corrupted index to fail validation")
+	}
+	for _, exp := range []string{"version: 0 is less than the minimum", `wait_result: bogus is not one of the allowed values`} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Fatalf("expected error to contain %q, got: %s", exp, err)
+		}
+	}
+
+	after, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(content) {
+		t.Fatal("expected corrupted index not to be written")
+	}
+}
+
+func TestValidateDebugIndex(t *testing.T) {
+	t.Parallel()
+
+	valid := `{
+  "version": 2,
+  "vault_address": "https://127.0.0.1:8200",
+  "timestamp": "2020-01-01T00:00:00Z",
+  "duration_seconds": 10,
+  "interval_seconds": 5,
+  "metrics_interval_seconds": 5,
+  "compress": true,
+  "raw_args": null,
+  "targets": ["config"],
+  "output": [{"path": "config.json", "timestamp": "2020-01-01T00:00:01Z"}],
+  "checksums": {"config.json": "abc"},
+  "errors": [],
+  "frames": [{"frame": 0, "directory": "2020-01-01T00-00-00Z", "timestamp": "2020-01-01T00:00:00Z", "end_timestamp": "2020-01-01T00:00:01Z"}]
+}`
+
+	cases := []struct {
+		name    string
+		replace [2]string
+		err     string
+	}{
+		{"valid", [2]string{}, ""},
+		{"v1_output", [2]string{`[{"path": "config.json", "timestamp": "2020-01-01T00:00:01Z"}]`, `["config.json"]`}, ""},
+		{"unexpected_field", [2]string{`"compress": true`, `"compress": true, "extra": 1`}, "extra: unexpected field"},
+		{"missing_field", [2]string{`"targets": ["config"],`, ``}, `missing required field "targets"`},
+		{"wrong_type", [2]string{`"compress": true`, `"compress": "true"`}, "compress: expected boolean, got string"},
+		{"bad_timestamp", [2]string{`"timestamp": "2020-01-01T00:00:00Z",`, `"timestamp": "yesterday",`}, "timestamp: \"yesterday\" is not a valid date-time"},
+		{"bad_frame", [2]string{`"frame": 0`, `"frame": -1`}, "frames[0].frame: -1 is less than the minimum"},
+		{"bad_output", [2]string{`{"path": "config.json", "timestamp": "2020-01-01T00:00:01Z"}`, `{"path": "config.json"}`}, "output[0]: does not match any of the allowed schemas"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			content := valid
+			if tc.replace[0] != "" {
+				content = strings.Replace(content, tc.replace[0], tc.replace[1], 1)
+			}
+
+			err := validateDebugIndex([]byte(content))
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Fatalf("expected error containing %q, got: %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// debugSignatureExt is the extension appended to the path of an archived
+// bundle to name the file holding its signature.
+const debugSignatureExt = ".sig"
+
+// loadDebugSigningKey reads a PEM-encoded PKCS #8 ed25519 private key, such as
+// one generated with "openssl genpkey -algorithm ed25519".
+func loadDebugSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readDebugPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %s", err)
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is a %T, not an ed25519 key", key)
+	}
+
+	return signingKey, nil
+}
+
+// loadDebugPublicKey reads a PEM-encoded PKIX ed25519 public key, such as one
+// extracted with "openssl pkey -pubout".
+func loadDebugPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readDebugPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %s", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is a %T, not an ed25519 key", key)
+	}
+
+	return publicKey, nil
+}
+
+func readDebugPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	return block, nil
+}
+
+// debugKeyFingerprint returns the hex-encoded SHA256 of the public key, which
+// is recorded in the index so that the key a bundle was signed with is known.
+func debugKeyFingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// signBundle signs the archived bundle at the given path with -sign-key,
+// writing the base64-encoded signature next to it. It returns the path of the
+// signature file.
+func (c *DebugCommand) signBundle(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sig := ed25519.Sign(c.signingKey, data)
+	sigPath := path + debugSignatureExt
+	if err := ioutil.WriteFile(sigPath, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644); err != nil {
+		return "", err
+	}
+
+	return sigPath, nil
+}
+
+// verifyDebugSignature verifies the signature of the archived bundle at the
+// given path, read from the signature file next to it, against the public
+// key.
+func verifyDebugSignature(path string, key ed25519.PublicKey) error {
+	encoded, err := ioutil.ReadFile(path + debugSignatureExt)
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %s", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("signature does not match the bundle")
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// testDebugSigningKey generates an ed25519 key pair and writes both halves
+// to PEM files in dir, returning the public key and the path of each file.
+func testDebugSigningKey(tb testing.TB, dir, name string) (ed25519.PublicKey, string, string) {
+	tb.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	privPath := filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		tb.Fatal(err)
+	}
+	pubPath := filepath.Join(dir, name+".pub")
+	if err := ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		tb.Fatal(err)
+	}
+
+	return pub, privPath, pubPath
+}
+
+func TestDebugCommand_SignKey(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	pub, privPath, pubPath := testDebugSigningKey(t, testDir, "signing")
+	_, _, otherPubPath := testDebugSigningKey(t, testDir, "other")
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	bundle := filepath.Join(testDir, "signed"+debugCompressionExt)
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		fmt.Sprintf("-sign-key=%s", privPath),
+		fmt.Sprintf("-output=%s", bundle),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	data, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := ioutil.ReadFile(bundle + debugSignatureExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		t.Fatal("expected signature to verify with the public key")
+	}
+
+	index, _, err := readDebugBundleArchive(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.SigningKeyFingerprint != debugKeyFingerprint(pub) {
+		t.Fatalf("expected fingerprint %q in the index, got %q", debugKeyFingerprint(pub), index.SigningKeyFingerprint)
+	}
+
+	cases := []struct {
+		name      string
+		publicKey string
+		tamper    bool
+		out       string
+		exitCode  int
+	}{
+		{
+			"valid",
+			pubPath,
+			false,
+			"Success! Verified signature",
+			0,
+		},
+		{
+			"other_key",
+			otherPubPath,
+			false,
+			"bundle was not signed with the public key",
+			2,
+		},
+		{
+			"tampered_signature",
+			pubPath,
+			true,
+			"signature does not match the bundle",
+			2,
+		},
+	}
+
+	// Cases run sequentially since one of them modifies the signature
+	for _, tc := range cases {
+		if tc.tamper {
+			sig[0] ^= 0xff
+			if err := ioutil.WriteFile(bundle+debugSignatureExt, []byte(base64.StdEncoding.EncodeToString(sig)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ui, cmd := testDebugVerifyCommand(t)
+		code := cmd.Run([]string{fmt.Sprintf("-public-key=%s", tc.publicKey), bundle})
+		if code != tc.exitCode {
+			t.Log(ui.ErrorWriter.String())
+			t.Fatalf("%s: expected %d to be %d", tc.name, code, tc.exitCode)
+		}
+
+		combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+		if !strings.Contains(combined, tc.out) {
+			t.Fatalf("%s: expected %q to contain %q", tc.name, combined, tc.out)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// debugStreamRecord is a line of the JSON lines file that a poll result is
+// appended to with -format=jsonl.
+type debugStreamRecord struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Path      string          `json:"path"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// debugStreamPath returns the path of the JSON lines file that the JSON file
+// at the given path relative to the output is appended to with
+// -format=jsonl, and whether the file is a poll result at all. Poll results
+// are the files whose path includes the directory of a frame or the index of
+// a capture, which is dropped from the path of the stream, so that
+// "000/health.json" is appended to "health.jsonl", "metrics/000.json" to
+// "metrics.jsonl", and "mounts/kv/000/health.json" to
+// "mounts/kv/health.jsonl". The <frame> and <index> placeholders of the
+// planned files are treated as indexes as well.
+func debugStreamPath(file string) (string, bool) {
+	file = filepath.ToSlash(file)
+	if path.Ext(file) != ".json" {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(file, ".json"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if !debugStreamIndex(parts[i]) {
+			continue
+		}
+
+		rest := append(append([]string{}, parts[:i]...), parts[i+1:]...)
+		if len(rest) == 0 {
+			return "", false
+		}
+		return strings.Join(rest, "/") + ".jsonl", true
+	}
+	return "", false
+}
+
+// debugStreamIndex returns whether the path segment is the directory of a
+// frame, numbered or named after its time, or the index of a capture.
+func debugStreamIndex(segment string) bool {
+	if segment == "<frame>" || segment == "<index>" {
+		return true
+	}
+	if _, err := time.Parse(fileFriendlyTimeFormat, segment); err == nil {
+		return true
+	}
+	return segment != "" && strings.Trim(segment, "0123456789") == ""
+}
+
+// appendStream appends the poll result written to the given path relative to
+// the output to its JSON lines file when -format=jsonl is set. Each record is
+// written with a single write, so that the file can be followed as the
+// capture progresses and every complete line remains usable if the command is
+// killed.
+func (c *DebugCommand) appendStream(file string, data []byte) error {
+	if c.cfg.Format != "jsonl" {
+		return nil
+	}
+	stream, ok := debugStreamPath(file)
+	if !ok {
+		return nil
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return fmt.Errorf("failed to append %s to %s: %s", file, stream, err)
+	}
+	line, err := json.Marshal(&debugStreamRecord{
+		Timestamp: time.Now().UTC(),
+		Path:      filepath.ToSlash(file),
+		Data:      compact.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+
+	dst := filepath.Join(c.cfg.Output, filepath.FromSlash(stream))
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	c.recordFileTime(dst)
+	return nil
+}
+
+// appendStreamFile appends the poll result written to the given file relative
+// to the output to its JSON lines file the same way as appendStream, but
+// compacts and validates it as it is read from the file rather than holding
+// it in memory, so that the large responses that requestFile streams to disk
+// are never read back whole. The record is written in several writes while
+// the streams are locked, and the JSON lines file is truncated back to its
+// previous size if any of them fails or the result is not valid JSON, such as
+// a truncated response. A killed command may still leave its last line
+// incomplete.
+func (c *DebugCommand) appendStreamFile(file string) error {
+	if c.cfg.Format != "jsonl" {
+		return nil
+	}
+	stream, ok := debugStreamPath(file)
+	if !ok {
+		return nil
+	}
+
+	src, err := os.Open(filepath.Join(c.cfg.Output, file))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	timestamp, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	recordPath, err := json.Marshal(filepath.ToSlash(file))
+	if err != nil {
+		return err
+	}
+
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+
+	dst := filepath.Join(c.cfg.Output, filepath.FromSlash(stream))
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size := info.Size()
+
+	// The envelope matches the encoding of debugStreamRecord
+	w := bufio.NewWriterSize(f, 32*1024)
+	fmt.Fprintf(w, `{"timestamp":%s,"path":%s,"data":`, timestamp, recordPath)
+	cw := &debugCompactWriter{w: w}
+	if _, err = io.Copy(cw, src); err == nil {
+		err = cw.finish()
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to append %s to %s: %s", file, stream, err)
+	} else {
+		if !cw.written {
+			w.WriteString("null")
+		}
+		w.WriteString("}\n")
+		err = w.Flush()
+	}
+	if err != nil {
+		// Part of the record may have been flushed already
+		if truncErr := f.Truncate(size); truncErr != nil {
+			err = fmt.Errorf("%s; failed to truncate %s: %s", err, stream, truncErr)
+		}
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	c.recordFileTime(dst)
+	return nil
+}
+
+// debugJSONState is the state of the validation of the JSON written to a
+// debugCompactWriter.
+type debugJSONState int
+
+const (
+	// debugJSONValue expects a value, and debugJSONArrayValue a value or
+	// the end of the array that was just opened.
+	debugJSONValue debugJSONState = iota
+	debugJSONArrayValue
+
+	// debugJSONObjectKey expects a key, and debugJSONObjectFirstKey a key
+	// or the end of the object that was just opened.
+	debugJSONObjectKey
+	debugJSONObjectFirstKey
+	debugJSONColon
+
+	// debugJSONAfterValue expects a comma or the end of the enclosing
+	// array or object.
+	debugJSONAfterValue
+
+	debugJSONString
+	debugJSONStringEscape
+	debugJSONStringUnicode
+
+	debugJSONNumberMinus
+	debugJSONNumberZero
+	debugJSONNumberInt
+	debugJSONNumberDot
+	debugJSONNumberFrac
+	debugJSONNumberExp
+	debugJSONNumberExpSign
+	debugJSONNumberExpDigits
+
+	debugJSONLiteral
+
+	// debugJSONDone follows the end of the value, after which only
+	// whitespace is allowed.
+	debugJSONDone
+)
+
+// debugCompactWriter writes JSON to w with the whitespace outside of strings
+// elided, the same as json.Compact, and validates it as it goes, without
+// holding the whole value or allocating for each token.
+type debugCompactWriter struct {
+	w       io.Writer
+	buf     []byte
+	written bool
+
+	state   debugJSONState
+	stack   []byte
+	key     bool
+	literal string
+	pos     int
+	offset  int64
+}
+
+func (cw *debugCompactWriter) Write(p []byte) (int, error) {
+	cw.buf = cw.buf[:0]
+	for _, b := range p {
+		keep, err := cw.step(b)
+		if err != nil {
+			return 0, err
+		}
+		if keep {
+			cw.buf = append(cw.buf, b)
+		}
+		cw.offset++
+	}
+	if len(cw.buf) == 0 {
+		return len(p), nil
+	}
+	if _, err := cw.w.Write(cw.buf); err != nil {
+		return 0, err
+	}
+	cw.written = true
+	return len(p), nil
+}
+
+// finish returns an error unless a whole value, or nothing but whitespace,
+// was written.
+func (cw *debugCompactWriter) finish() error {
+	if cw.numberEnds() {
+		cw.endValue()
+	}
+	if cw.state == debugJSONDone || (!cw.written && cw.state == debugJSONValue) {
+		return nil
+	}
+	return fmt.Errorf("unexpected end of JSON input at offset %d", cw.offset)
+}
+
+// step advances the validation by a single byte, and returns whether the byte
+// is kept in the compacted output.
+func (cw *debugCompactWriter) step(b byte) (bool, error) {
+	switch cw.state {
+	case debugJSONString:
+		switch {
+		case b == '"':
+			if cw.key {
+				cw.state = debugJSONColon
+			} else {
+				cw.endValue()
+			}
+		case b == '\\':
+			cw.state = debugJSONStringEscape
+		case b < 0x20:
+			return false, cw.invalid(b)
+		}
+		return true, nil
+
+	case debugJSONStringEscape:
+		switch b {
+		case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+			cw.state = debugJSONString
+		case 'u':
+			cw.state = debugJSONStringUnicode
+			cw.pos = 0
+		default:
+			return false, cw.invalid(b)
+		}
+		return true, nil
+
+	case debugJSONStringUnicode:
+		if !(b >= '0' && b <= '9' || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F') {
+			return false, cw.invalid(b)
+		}
+		if cw.pos++; cw.pos == 4 {
+			cw.state = debugJSONString
+		}
+		return true, nil
+
+	case debugJSONLiteral:
+		if b != cw.literal[cw.pos] {
+			return false, cw.invalid(b)
+		}
+		if cw.pos++; cw.pos == len(cw.literal) {
+			cw.endValue()
+		}
+		return true, nil
+
+	case debugJSONNumberMinus, debugJSONNumberZero, debugJSONNumberInt, debugJSONNumberDot,
+		debugJSONNumberFrac, debugJSONNumberExp, debugJSONNumberExpSign, debugJSONNumberExpDigits:
+		if next, ok := cw.numberNext(b); ok {
+			cw.state = next
+			return true, nil
+		}
+		if !cw.numberEnds() {
+			return false, cw.invalid(b)
+		}
+		// The byte following a number belongs to what comes after it
+		cw.endValue()
+		return cw.step(b)
+	}
+
+	if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+		return false, nil
+	}
+
+	switch cw.state {
+	case debugJSONValue:
+		return true, cw.beginValue(b)
+
+	case debugJSONArrayValue:
+		if b == ']' {
+			cw.stack = cw.stack[:len(cw.stack)-1]
+			cw.endValue()
+			return true, nil
+		}
+		return true, cw.beginValue(b)
+
+	case debugJSONObjectFirstKey, debugJSONObjectKey:
+		switch {
+		case b == '"':
+			cw.state = debugJSONString
+			cw.key = true
+		case b == '}' && cw.state == debugJSONObjectFirstKey:
+			cw.stack = cw.stack[:len(cw.stack)-1]
+			cw.endValue()
+		default:
+			return false, cw.invalid(b)
+		}
+		return true, nil
+
+	case debugJSONColon:
+		if b != ':' {
+			return false, cw.invalid(b)
+		}
+		cw.state = debugJSONValue
+		return true, nil
+
+	case debugJSONAfterValue:
+		top := cw.stack[len(cw.stack)-1]
+		switch {
+		case b == ',' && top == '{':
+			cw.state = debugJSONObjectKey
+		case b == ',':
+			cw.state = debugJSONValue
+		case (b == '}' && top == '{') || (b == ']' && top == '['):
+			cw.stack = cw.stack[:len(cw.stack)-1]
+			cw.endValue()
+		default:
+			return false, cw.invalid(b)
+		}
+		return true, nil
+	}
+
+	return false, cw.invalid(b)
+}
+
+// beginValue starts the value that the given byte opens.
+func (cw *debugCompactWriter) beginValue(b byte) error {
+	switch {
+	case b == '{':
+		cw.stack = append(cw.stack, b)
+		cw.state = debugJSONObjectFirstKey
+	case b == '[':
+		cw.stack = append(cw.stack, b)
+		cw.state = debugJSONArrayValue
+	case b == '"':
+		cw.state = debugJSONString
+		cw.key = false
+	case b == '-':
+		cw.state = debugJSONNumberMinus
+	case b == '0':
+		cw.state = debugJSONNumberZero
+	case b >= '1' && b <= '9':
+		cw.state = debugJSONNumberInt
+	case b == 't':
+		cw.beginLiteral("true")
+	case b == 'f':
+		cw.beginLiteral("false")
+	case b == 'n':
+		cw.beginLiteral("null")
+	default:
+		return cw.invalid(b)
+	}
+	return nil
+}
+
+// beginLiteral starts the given literal, the first byte of which was written.
+func (cw *debugCompactWriter) beginLiteral(literal string) {
+	cw.literal = literal
+	cw.state = debugJSONLiteral
+	cw.pos = 1
+}
+
+// endValue moves past a value that just ended.
+func (cw *debugCompactWriter) endValue() {
+	if len(cw.stack) == 0 {
+		cw.state = debugJSONDone
+		return
+	}
+	cw.state = debugJSONAfterValue
+}
+
+// numberNext returns the state of the number being written once the given
+// byte is added to it, and whether the byte can be added at all.
+func (cw *debugCompactWriter) numberNext(b byte) (debugJSONState, bool) {
+	digit := b >= '0' && b <= '9'
+	exp := b == 'e' || b == 'E'
+
+	switch cw.state {
+	case debugJSONNumberMinus:
+		switch {
+		case b == '0':
+			return debugJSONNumberZero, true
+		case digit:
+			return debugJSONNumberInt, true
+		}
+	case debugJSONNumberZero, debugJSONNumberInt:
+		switch {
+		case digit && cw.state == debugJSONNumberInt:
+			return debugJSONNumberInt, true
+		case b == '.':
+			return debugJSONNumberDot, true
+		case exp:
+			return debugJSONNumberExp, true
+		}
+	case debugJSONNumberDot, debugJSONNumberFrac:
+		switch {
+		case digit:
+			return debugJSONNumberFrac, true
+		case exp && cw.state == debugJSONNumberFrac:
+			return debugJSONNumberExp, true
+		}
+	case debugJSONNumberExp:
+		switch {
+		case b == '+' || b == '-':
+			return debugJSONNumberExpSign, true
+		case digit:
+			return debugJSONNumberExpDigits, true
+		}
+	case debugJSONNumberExpSign, debugJSONNumberExpDigits:
+		if digit {
+			return debugJSONNumberExpDigits, true
+		}
+	}
+	return cw.state, false
+}
+
+// numberEnds returns whether the number being written, if any, is complete.
+func (cw *debugCompactWriter) numberEnds() bool {
+	switch cw.state {
+	case debugJSONNumberZero, debugJSONNumberInt, debugJSONNumberFrac, debugJSONNumberExpDigits:
+		return true
+	}
+	return false
+}
+
+func (cw *debugCompactWriter) invalid(b byte) error {
+	return fmt.Errorf("invalid character %q in JSON at offset %d", b, cw.offset)
+}
@@ -0,0 +1,258 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugStreamPath(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		file     string
+		expected string
+		ok       bool
+	}{
+		{"000/health.json", "health.jsonl", true},
+		{"2019-10-15T21-44-49Z/server_status.json", "server_status.jsonl", true},
+		{"metrics/003.json", "metrics.jsonl", true},
+		{"custom/sys_config/001.json", "custom/sys_config.jsonl", true},
+		{"mounts/kv/000/health.json", "mounts/kv/health.jsonl", true},
+		{"clusters/east/000/health.json", "clusters/east/health.jsonl", true},
+		{"<frame>/leases.json", "leases.jsonl", true},
+		{"config.json", "", false},
+		{"000/heap.prof", "", false},
+		{"000.json", "", false},
+	}
+
+	for _, tc := range cases {
+		stream, ok := debugStreamPath(tc.file)
+		if stream != tc.expected || ok != tc.ok {
+			t.Fatalf("expected %s to stream to %q (%t), got: %q (%t)", tc.file, tc.expected, tc.ok, stream, ok)
+		}
+	}
+}
+
+func TestDebugCommand_FormatJSONL(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "jsonl")
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		"-metrics-interval=1s",
+		"-target=config",
+		"-target=health",
+		"-target=metrics",
+		"-format=jsonl",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	// Every poll result is appended to the file of its target, while the
+	// static targets are written as is
+	for stream, pattern := range map[string]string{
+		"health.jsonl":  "[0-9][0-9][0-9]/health.json",
+		"metrics.jsonl": "metrics/[0-9][0-9][0-9].json",
+	} {
+		files, err := filepath.Glob(filepath.Join(outputPath, filepath.FromSlash(pattern)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			t.Fatalf("expected files matching %s", pattern)
+		}
+
+		f, err := os.Open(filepath.Join(outputPath, stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var paths []string
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 16*1024*1024)
+		for scanner.Scan() {
+			var record debugStreamRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("invalid record in %s: %s", stream, err)
+			}
+			if record.Timestamp.IsZero() || len(record.Data) == 0 {
+				t.Fatalf("expected a timestamp and data in %s, got: %s", stream, scanner.Text())
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, filepath.FromSlash(record.Path)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected, actual interface{}
+			if err := json.Unmarshal(content, &expected); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(record.Data, &actual); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(expected) != fmt.Sprint(actual) {
+				t.Fatalf("expected the record of %s to hold its data, got: %s", record.Path, record.Data)
+			}
+			paths = append(paths, record.Path)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != len(files) {
+			t.Fatalf("expected %d records in %s, got: %v", len(files), stream, paths)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, "config.jsonl")); !os.IsNotExist(err) {
+		t.Fatalf("expected no stream of the static targets, got: %v", err)
+	}
+
+	readme, err := ioutil.ReadFile(filepath.Join(outputPath, "README.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(readme), "health.jsonl") || !strings.Contains(string(readme), "Health status, one JSON record per capture") {
+		t.Fatalf("expected the streams to be described in the README:\n%s", readme)
+	}
+
+	// An unknown format is rejected
+	ui, cmd = testDebugCommand(t)
+	cmd.client = client
+	code = cmd.Run([]string{"-format=xml", fmt.Sprintf("-output=%s", filepath.Join(testDir, "xml"))})
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, "invalid format") {
+		t.Fatalf("expected invalid format error, got: %s", errOut)
+	}
+}
+
+func TestDebugCompactWriter(t *testing.T) {
+	t.Parallel()
+
+	cases := []string{
+		`{"a": [1, -2.5e+3, 0, true, false, null], "b": {"c": "d\"\\é"}}`,
+		"[\n  {},\n  []\n]\n",
+		`"string"`,
+		`12`,
+		`  `,
+		`{"a": 1`,
+		`{"a" 1}`,
+		`[1, 2,]`,
+		`{"a": 1,}`,
+		`[01]`,
+		`[1.]`,
+		`-`,
+		`tru`,
+		`nul1`,
+		`"unterminated`,
+		"\"line\nbreak\"",
+		`"\x"`,
+		`"\u12"`,
+		`{} {}`,
+		`[}`,
+	}
+
+	for _, input := range cases {
+		// Write a byte at a time, so that every state spans writes
+		var out strings.Builder
+		cw := &debugCompactWriter{w: &out}
+		var err error
+		for i := 0; i < len(input) && err == nil; i++ {
+			_, err = cw.Write([]byte{input[i]})
+		}
+		if err == nil {
+			err = cw.finish()
+		}
+
+		valid := json.Valid([]byte(input)) || strings.TrimSpace(input) == ""
+		if valid != (err == nil) {
+			t.Fatalf("expected %q to be valid %t, got: %v", input, valid, err)
+		}
+		if !valid || strings.TrimSpace(input) == "" {
+			continue
+		}
+
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != compact.String() {
+			t.Fatalf("expected %q to be compacted to %q, got: %q", input, compact.String(), out.String())
+		}
+	}
+}
+
+func TestDebugCommand_AppendStreamFileInvalid(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	_, cmd := testDebugCommand(t)
+	cmd.cfg.Output = testDir
+	cmd.cfg.Format = "jsonl"
+
+	if err := os.MkdirAll(filepath.Join(testDir, "000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(testDir, "000", "health.json"), []byte(`{"sealed": false}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.appendStreamFile("000/health.json"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(filepath.Join(testDir, "health.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated response leaves the JSON lines file as it was, even once
+	// part of its record has been written out
+	truncated := `{"sealed": false, "padding": "` + strings.Repeat("a", 128*1024)
+	if err := os.MkdirAll(filepath.Join(testDir, "001"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(testDir, "001", "health.json"), []byte(truncated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.appendStreamFile("001/health.json"); err == nil || !strings.Contains(err.Error(), "unexpected end of JSON input") {
+		t.Fatalf("expected the truncated response to be rejected, got: %v", err)
+	}
+	after, err := ioutil.ReadFile(filepath.Join(testDir, "health.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatalf("expected %q to be left as is, got: %q", before, after)
+	}
+}
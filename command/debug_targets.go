@@ -0,0 +1,296 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// CaptureFunc captures a target into the bundle of the command running the
+// capture, making its requests and writing its files through the frame.
+type CaptureFunc func(ctx context.Context, frame *CaptureFrame) error
+
+// CaptureFrame is the frame that a target is captured on. A target registered
+// with RegisterTarget is captured on a frame of its own at the root of the
+// bundle, with a Frame of -1 and an empty Dir.
+type CaptureFrame struct {
+	// Frame is the number of the frame, which continues from the frames of
+	// the bundle when a capture is resumed.
+	Frame int
+
+	// Dir is the directory of the frame, relative to the output.
+	Dir string
+
+	// Index is the position of the frame in the run, and Count the number of
+	// frames of the run.
+	Index int
+	Count int
+
+	c *DebugCommand
+}
+
+// RequestJSON performs a GET request against the given path of the server
+// being captured and decodes the response body onto out. The request is made
+// with the client of the run, counts towards -max-concurrent-requests, and is
+// recorded in the request timings of the bundle.
+func (f *CaptureFrame) RequestJSON(ctx context.Context, path string, params url.Values, out interface{}) error {
+	return f.c.requestJSON(ctx, path, params, out)
+}
+
+// RequestData performs a GET request the same way as RequestJSON and returns
+// the data portion of the response.
+func (f *CaptureFrame) RequestData(ctx context.Context, path string, params url.Values) (map[string]interface{}, error) {
+	return f.c.requestData(ctx, path, params)
+}
+
+// WriteJSON marshals the value and writes it to the given path relative to
+// the directory of the frame.
+func (f *CaptureFrame) WriteJSON(path string, v interface{}) error {
+	return f.c.writeJSON(filepath.Join(f.Dir, path), v)
+}
+
+// WriteFile writes the data to the given path relative to the directory of
+// the frame. The file is listed in the index of the bundle along with the
+// files of the built-in targets.
+func (f *CaptureFrame) WriteFile(path string, data []byte) error {
+	return f.c.writeFile(filepath.Join(f.Dir, path), data)
+}
+
+// debugTargetKind is when a registered target is captured.
+type debugTargetKind int
+
+const (
+	// debugTargetOnce targets are captured once, before the first frame.
+	debugTargetOnce debugTargetKind = iota
+
+	// debugTargetFrame targets are captured on every frame.
+	debugTargetFrame
+
+	// debugTargetRollup targets are derived from the captures of other
+	// targets once every frame is captured.
+	debugTargetRollup
+
+	// debugTargetCollected targets are collected by the command on a
+	// schedule of their own, such as the metrics on the metrics interval, and
+	// have no capture function.
+	debugTargetCollected
+)
+
+// debugTarget is a target registered in debugTargetRegistry.
+type debugTarget struct {
+	kind        debugTargetKind
+	description string
+	progress    string
+	capture     CaptureFunc
+
+	// optIn targets are only captured when selected with -target, or with
+	// a preset that captures every target.
+	optIn bool
+}
+
+// debugTargetRegistry holds every target that can be captured, keyed by its
+// name. The built-in targets are registered by init, and other targets with
+// RegisterTarget and RegisterFrameTarget.
+var debugTargetRegistry = map[string]*debugTarget{}
+
+// RegisterTarget registers a target captured once, before the first frame,
+// with the given capture function. Once registered, the target can be
+// selected with -target, is captured by default, and is listed by
+// -list-targets along with its description. It is meant to be called from an
+// init function, since registering a target while a capture runs is not
+// safe. It panics if the name is invalid or already registered.
+func RegisterTarget(name, description string, fn CaptureFunc) {
+	registerDebugTarget(name, &debugTarget{
+		kind:        debugTargetOnce,
+		description: description,
+		progress:    "Capturing " + name,
+		capture:     fn,
+	})
+}
+
+// RegisterFrameTarget registers a target captured on every frame with the
+// given capture function, the same way as RegisterTarget.
+func RegisterFrameTarget(name, description string, fn CaptureFunc) {
+	registerDebugTarget(name, &debugTarget{
+		kind:        debugTargetFrame,
+		description: description,
+		capture:     fn,
+	})
+}
+
+// registerDebugTarget adds the target to debugTargetRegistry under the given
+// name.
+func registerDebugTarget(name string, target *debugTarget) {
+	if name == "" || strings.ContainsAny(name, ", \t\n") {
+		panic(fmt.Sprintf("debug: invalid target name %q", name))
+	}
+	if target.capture == nil && target.kind != debugTargetCollected {
+		panic(fmt.Sprintf("debug: nil capture function for target %q", name))
+	}
+	if _, ok := debugTargetRegistry[name]; ok {
+		panic(fmt.Sprintf("debug: target %q registered twice", name))
+	}
+	debugTargetRegistry[name] = target
+}
+
+// debugTargetNames returns the sorted names of every registered target.
+func debugTargetNames() []string {
+	names := make([]string, 0, len(debugTargetRegistry))
+	for name := range debugTargetRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// debugDefaultTargets returns the sorted names of the targets captured when
+// no target is specified, which leaves out the opt-in targets.
+func debugDefaultTargets() []string {
+	var names []string
+	for _, name := range debugTargetNames() {
+		if !debugTargetRegistry[name].optIn {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// selectedTargets returns the targets of the given kind selected with
+// -target, sorted by name.
+func (c *DebugCommand) selectedTargets(kind debugTargetKind) []string {
+	var names []string
+	for _, name := range debugTargetNames() {
+		if debugTargetRegistry[name].kind == kind && strutil.StrListContains(c.cfg.Targets, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// runRegisteredCapture captures the registered target on the given frame. A
+// target captured once is given a frame at the root of the bundle if frame is
+// nil.
+func (c *DebugCommand) runRegisteredCapture(ctx context.Context, name string, frame *CaptureFrame) {
+	target := debugTargetRegistry[name]
+	if frame == nil {
+		frame = &CaptureFrame{Frame: debugStaticFrame, c: c}
+	}
+	c.runCapture(ctx, name, frame.Frame, func(ctx context.Context) error {
+		return target.capture(ctx, frame)
+	})
+}
+
+// captureOnce adapts a method capturing a target once to a CaptureFunc.
+func captureOnce(fn func(*DebugCommand, context.Context) error) CaptureFunc {
+	return func(ctx context.Context, frame *CaptureFrame) error {
+		return fn(frame.c, ctx)
+	}
+}
+
+// captureInFrame adapts a method capturing a target into the directory of a
+// frame to a CaptureFunc.
+func captureInFrame(fn func(*DebugCommand, context.Context, string) error) CaptureFunc {
+	return func(ctx context.Context, frame *CaptureFrame) error {
+		return fn(frame.c, ctx, frame.Dir)
+	}
+}
+
+func init() {
+	once := []struct {
+		name        string
+		description string
+		progress    string
+		capture     func(*DebugCommand, context.Context) error
+	}{
+		{"audit-probe", "Hash of a known input computed by every enabled audit device, captured once", "Probing audit devices", (*DebugCommand).captureAuditProbe},
+		{"auth", "Enabled auth methods and their configuration, captured once", "Capturing auth methods", (*DebugCommand).captureAuth},
+		{"clock-skew", "Estimated skew between the local clock and the server clock, captured once", "Capturing clock skew", (*DebugCommand).captureClockSkew},
+		{"config", "Sanitized configuration state, captured once", "Capturing configuration state", (*DebugCommand).captureConfig},
+		{"entropy", "Seal wrap rewrap status and the seal configuration, captured once", "Capturing seal wrap status", (*DebugCommand).captureEntropy},
+		{"license", "License status with the raw license redacted, captured once", "Capturing license status", (*DebugCommand).captureLicense},
+		{"mounts", "Secrets engine mount table, captured once", "Capturing secrets engine mounts", (*DebugCommand).captureMounts},
+		{"openapi", "OpenAPI document of every path exposed by the server, captured once", "Capturing OpenAPI document", (*DebugCommand).captureOpenAPI},
+		{"plugins", "Plugin catalog, captured once", "Capturing plugin catalog", (*DebugCommand).capturePlugins},
+		{"policies", "Names of the ACL policies, captured once", "Capturing ACL policies", (*DebugCommand).capturePolicies},
+		{"quotas", "Quota configuration and rate limit quotas, captured once", "Capturing quotas", (*DebugCommand).captureQuotas},
+		{"raft-snapshot-info", "Raft configuration and the last index and term of every server, captured once", "Capturing raft snapshot info", (*DebugCommand).captureRaftSnapshotInfo},
+		{"self", "Policies, TTL, and metadata of the token used for the run, captured once", "Capturing token information", (*DebugCommand).captureTokenSelf},
+		{"storage", "Storage backend type and HA status, captured once", "Capturing storage backend", (*DebugCommand).captureStorage},
+	}
+	for _, t := range once {
+		registerDebugTarget(t.name, &debugTarget{
+			kind:        debugTargetOnce,
+			description: t.description,
+			progress:    t.progress,
+			capture:     captureOnce(t.capture),
+		})
+	}
+
+	frame := []struct {
+		name        string
+		description string
+		capture     CaptureFunc
+	}{
+		{"audit-status", "Audit devices and the latency and failures of their logging, captured on every frame", captureInFrame((*DebugCommand).captureAuditStatus)},
+		{"counters", "Activity and token counters, captured on every frame", captureInFrame((*DebugCommand).captureCounters)},
+		{"goroutine-count", "Number of goroutines of the server, sampled on every frame", func(ctx context.Context, frame *CaptureFrame) error {
+			return frame.c.captureGoroutineCount(ctx, frame.Frame)
+		}},
+		{"ha-lock", "Holder of the HA lock, captured on every frame", captureInFrame((*DebugCommand).captureHALock)},
+		{"health", "Health status, captured on every frame", captureInFrame((*DebugCommand).captureHealth)},
+		{"host", "Information about the instance running the server, captured on every frame", captureInFrame((*DebugCommand).captureHostInfo)},
+		{"leases", "Token and lease counts, captured on every frame", captureInFrame((*DebugCommand).captureLeases)},
+		{"pprof", "Runtime profiles, CPU profile, and trace", func(ctx context.Context, frame *CaptureFrame) error {
+			c := frame.c
+			polling := frame.Index < frame.Count-1 && !c.cfg.SkipPollingProfiles
+			err := c.capturePprof(ctx, frame.Dir, c.pprofSnapshot(frame.Index, frame.Count), polling)
+			if c.cfg.PprofRing > 0 {
+				c.rotatePprofRing(frame.Frame, frame.Dir)
+			}
+			return err
+		}},
+		{"replication-perf", "Performance and DR replication status, captured on every frame", captureInFrame((*DebugCommand).captureReplicationPerf)},
+		{"replication-status", "Replication status, captured on every frame", captureInFrame((*DebugCommand).captureReplicationStatus)},
+		{"requests", "Requests being served by the server, captured on every frame", captureInFrame((*DebugCommand).captureRequests)},
+		{"server-status", "Health and seal status, captured on every frame", captureInFrame((*DebugCommand).captureServerStatus)},
+	}
+	for _, t := range frame {
+		registerDebugTarget(t.name, &debugTarget{
+			kind:        debugTargetFrame,
+			description: t.description,
+			capture:     t.capture,
+		})
+	}
+
+	// The seal timing and hot paths are only derived from the metrics
+	// captures, but are recorded as captures of their own targets
+	registerDebugTarget("hot-paths", &debugTarget{
+		kind:        debugTargetRollup,
+		description: "Busiest request paths by rate, rolled up from the metrics",
+		capture: func(_ context.Context, frame *CaptureFrame) error {
+			return frame.c.writeHotPaths()
+		},
+	})
+	registerDebugTarget("seal-timing", &debugTarget{
+		kind:        debugTargetRollup,
+		description: "Timing of the seal, unseal, and barrier operations, rolled up from the metrics",
+		capture: func(_ context.Context, frame *CaptureFrame) error {
+			return frame.c.writeSealTiming()
+		},
+	})
+
+	registerDebugTarget("metrics", &debugTarget{
+		kind:        debugTargetCollected,
+		description: "Telemetry, captured on every metrics interval",
+	})
+	registerDebugTarget("log", &debugTarget{
+		kind:        debugTargetCollected,
+		description: "Server log at -log-level, streamed for the duration of the capture from sys/monitor, which servers before Vault 1.5 don't serve",
+		optIn:       true,
+	})
+}
@@ -0,0 +1,46 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// SaveDebugTargets returns a function restoring the registered targets to
+// those registered when it was called, so that tests outside of the package
+// can register targets of their own.
+func SaveDebugTargets() func() {
+	registry := make(map[string]*debugTarget, len(debugTargetRegistry))
+	for name, target := range debugTargetRegistry {
+		registry[name] = target
+	}
+	return func() {
+		debugTargetRegistry = registry
+	}
+}
+
+func TestDebugTargetRegistry(t *testing.T) {
+	t.Parallel()
+
+	// Every built-in target is listed with a description, and can be captured
+	// unless it is collected on a schedule of its own
+	for _, name := range debugTargetNames() {
+		target := debugTargetRegistry[name]
+		if target.description == "" {
+			t.Fatalf("expected target %s to have a description", name)
+		}
+		if target.kind != debugTargetCollected && target.capture == nil {
+			t.Fatalf("expected target %s to have a capture function", name)
+		}
+	}
+
+	// The log target needs a server that serves sys/monitor, so it is only
+	// captured when selected
+	defaults := debugDefaultTargets()
+	if strutil.StrListContains(defaults, "log") {
+		t.Fatalf("expected the log target to not be captured by default, got: %v", defaults)
+	}
+	if len(defaults) != len(debugTargetNames())-1 {
+		t.Fatalf("expected every other target to be captured by default, got: %v", defaults)
+	}
+}
@@ -0,0 +1,5970 @@
+package command
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/server"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/vault"
+	"github.com/mitchellh/cli"
+)
+
+func testDebugCommand(tb testing.TB) (*cli.MockUi, *DebugCommand) {
+	tb.Helper()
+
+	ui := cli.NewMockUi()
+	return ui, &DebugCommand{
+		BaseCommand: &BaseCommand{
+			UI: ui,
+		},
+		cfg: CaptureConfig{
+			skipTimingChecks: true,
+		},
+	}
+}
+
+// testDebugStubServer creates an http server that serves the health and token
+// lookup endpoints required by preflight and delegates every other request to
+// the provided handler.
+func testDebugStubServer(tb testing.TB, handler http.HandlerFunc) (*api.Client, func()) {
+	tb.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.4.0"}`))
+			return
+		case "/v1/auth/token/lookup-self":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data":{"policies":["root"]}}`))
+			return
+		}
+		handler(w, r)
+	}))
+
+	client, err := api.NewClient(&api.Config{
+		Address: ts.URL,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return client, ts.Close
+}
+
+// testDebugRemoteClient returns a copy of the given client whose address is
+// not a loopback address, while still connecting to the server of the given
+// client, so that the server is treated as remote.
+func testDebugRemoteClient(tb testing.TB, local *api.Client) *api.Client {
+	tb.Helper()
+
+	u, err := url.Parse(local.Address())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, u.Host)
+		},
+	}
+
+	client, err := api.NewClient(&api.Config{
+		Address:    fmt.Sprintf("http://vault.example.com:%s", u.Port()),
+		HttpClient: &http.Client{Transport: transport},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	client.SetToken(local.Token())
+	return client
+}
+
+// testDebugArchiveFiles returns the list of file entries in the given
+// archive, in any of the formats of -archive-format.
+func testDebugArchiveFiles(tb testing.TB, path string) []string {
+	tb.Helper()
+
+	var files []string
+	err := walkDebugArchive(path, func(name string, _ io.Reader) error {
+		files = append(files, name)
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return files
+}
+
+func TestDebugCommand_Run(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	cases := []struct {
+		name string
+		args []string
+		out  string
+		code int
+	}{
+		{
+			"valid",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/valid", testDir),
+			},
+			"",
+			0,
+		},
+		{
+			"too_many_args",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/too_many_args", testDir),
+				"foo",
+			},
+			"Too many arguments",
+			1,
+		},
+		{
+			"invalid_target",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_target", testDir),
+				"-target=foo",
+			},
+			"invalid target",
+			1,
+		},
+		{
+			"invalid_metadata",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_metadata", testDir),
+				"-metadata=ticket",
+			},
+			"invalid metadata",
+			1,
+		},
+		{
+			"duplicate_metadata",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/duplicate_metadata", testDir),
+				"-metadata=ticket=INC-123",
+				"-metadata=ticket=INC-456",
+			},
+			"duplicate metadata key",
+			1,
+		},
+		{
+			"rotate_no_compress",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/rotate_no_compress", testDir),
+				"-rotate=1s",
+				"-compress=false",
+			},
+			"rotate requires compression",
+			1,
+		},
+		{
+			"rotate_dir_format",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/rotate_dir_format", testDir),
+				"-rotate=1s",
+				"-output-format=dir",
+			},
+			"rotate requires compression",
+			1,
+		},
+		{
+			"trace_exceeds_duration",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/trace_exceeds_duration", testDir),
+				"-pprof-trace-duration=2s",
+			},
+			"must not exceed the run length",
+			1,
+		},
+		{
+			"upload_dir_format",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/upload_dir_format", testDir),
+				"-upload-url=http://127.0.0.1:8080/bundles",
+				"-output-format=dir",
+			},
+			"upload-url requires compression",
+			1,
+		},
+		{
+			"invalid_upload_method",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_upload_method", testDir),
+				"-upload-url=http://127.0.0.1:8080/bundles",
+				"-upload-method=PATCH",
+			},
+			"invalid upload method",
+			1,
+		},
+		{
+			"metrics_csv_without_metrics",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/metrics_csv_without_metrics", testDir),
+				"-target=host",
+				"-metrics-csv",
+			},
+			"metrics-csv requires the metrics target",
+			1,
+		},
+		{
+			"metrics_delta_without_metrics",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/metrics_delta_without_metrics", testDir),
+				"-target=host",
+				"-metrics-delta",
+			},
+			"metrics-delta requires the metrics target",
+			1,
+		},
+		{
+			"invalid_cluster_name",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_cluster_name", testDir),
+				"-cluster=../primary=http://127.0.0.1:8200",
+			},
+			"invalid cluster name",
+			1,
+		},
+		{
+			"raft_peers_with_cluster",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/raft_peers_with_cluster", testDir),
+				"-cluster=primary=http://127.0.0.1:8200",
+				"-raft-peers",
+			},
+			"raft-peers cannot be used with cluster",
+			1,
+		},
+		{
+			"count_and_duration",
+			[]string{
+				"-duration=1s",
+				"-count=3",
+				fmt.Sprintf("-output=%s/count_and_duration", testDir),
+			},
+			"count and duration cannot be set together",
+			1,
+		},
+		{
+			"negative_count",
+			[]string{
+				"-count=-1",
+				fmt.Sprintf("-output=%s/negative_count", testDir),
+			},
+			"count must be a non-negative value",
+			1,
+		},
+		{
+			"negative_rate_limit",
+			[]string{
+				"-rate-limit=-1",
+				fmt.Sprintf("-output=%s/negative_rate_limit", testDir),
+			},
+			"rate limit must be a non-negative value",
+			1,
+		},
+		{
+			"invalid_min_free_space",
+			[]string{
+				"-min-free-space=10XB",
+				fmt.Sprintf("-output=%s/invalid_min_free_space", testDir),
+			},
+			"invalid min free space",
+			1,
+		},
+		{
+			"quiet_with_json",
+			[]string{
+				"-quiet",
+				"-json",
+				fmt.Sprintf("-output=%s/quiet_with_json", testDir),
+			},
+			"cannot be used together",
+			1,
+		},
+		{
+			"output_template_with_output",
+			[]string{
+				"-output-template=debug-{{.Time}}",
+				fmt.Sprintf("-output=%s/output_template_with_output", testDir),
+			},
+			"output-template cannot be used with output",
+			1,
+		},
+		{
+			"invalid_output_template",
+			[]string{
+				"-output-template=debug-{{.Region}}",
+			},
+			"invalid output template",
+			1,
+		},
+		{
+			"keep_dir_without_compress",
+			[]string{
+				"-keep-dir",
+				"-compress=false",
+				fmt.Sprintf("-output=%s/keep_dir_without_compress", testDir),
+			},
+			"keep-dir requires compression",
+			1,
+		},
+		{
+			"prometheus_without_metrics",
+			[]string{
+				"-prometheus-metrics",
+				"-target=config",
+				fmt.Sprintf("-output=%s/prometheus_without_metrics", testDir),
+			},
+			"prometheus-metrics requires the metrics target",
+			1,
+		},
+		{
+			"sign_key_without_compress",
+			[]string{
+				"-sign-key=debug.key",
+				"-compress=false",
+				fmt.Sprintf("-output=%s/sign_key_without_compress", testDir),
+			},
+			"sign-key requires compression",
+			1,
+		},
+		{
+			"invalid_pprof_profile",
+			[]string{
+				"-pprof-profiles=heap,threads",
+				fmt.Sprintf("-output=%s/invalid_pprof_profile", testDir),
+			},
+			"invalid pprof profile \"threads\"",
+			1,
+		},
+		{
+			"pprof_trace_skip_polling",
+			[]string{
+				"-pprof-trace-duration=1s",
+				"-skip-polling-profiles",
+				fmt.Sprintf("-output=%s/pprof_trace_skip_polling", testDir),
+			},
+			"cannot be set when skipping polling profiles",
+			1,
+		},
+		{
+			"invalid_proxy",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_proxy", testDir),
+				"-proxy=ftp://127.0.0.1:3128",
+			},
+			"invalid proxy URL",
+			1,
+		},
+		{
+			"invalid_output_format",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_output_format", testDir),
+				"-output-format=zip",
+			},
+			"invalid output format",
+			1,
+		},
+		{
+			"invalid_compression",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_compression", testDir),
+				"-compression=lz4",
+			},
+			"invalid compression",
+			1,
+		},
+		{
+			"unsupported_compression",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/unsupported_compression", testDir),
+				"-archive-format=zip",
+				"-compression=xz",
+			},
+			"compression xz is not supported with archive format zip",
+			1,
+		},
+		{
+			"invalid_archive_format",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_archive_format", testDir),
+				"-archive-format=rar",
+			},
+			"invalid archive format",
+			1,
+		},
+		{
+			"invalid_log_level",
+			[]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/invalid_log_level", testDir),
+				"-log-level=verbose",
+			},
+			"invalid log level",
+			1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			code := cmd.Run(tc.args)
+			if code != tc.code {
+				t.Errorf("expected %d to be %d", code, tc.code)
+			}
+
+			combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+			if !strings.Contains(combined, tc.out) {
+				t.Fatalf("expected %q to contain %q", combined, tc.out)
+			}
+		})
+	}
+
+	t.Run("no_tabs", func(t *testing.T) {
+		t.Parallel()
+
+		_, cmd := testDebugCommand(t)
+		assertNoTabs(t, cmd)
+	})
+}
+
+func TestDebugCommand_Archive(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		format      string
+		ext         string
+		expectError bool
+	}{
+		{
+			"no-ext",
+			"",
+			"",
+			false,
+		},
+		{
+			"with-ext-tar-gz",
+			"",
+			".tar.gz",
+			false,
+		},
+		{
+			"with-ext-tgz",
+			"",
+			".tgz",
+			false,
+		},
+		{
+			"zip-no-ext",
+			"zip",
+			"",
+			false,
+		},
+		{
+			"zip-with-ext",
+			"zip",
+			".zip",
+			false,
+		},
+		{
+			"tar-no-ext",
+			"tar",
+			"",
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			basePath := tc.name
+			args := []string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/%s%s", testDir, basePath, tc.ext),
+				"-target=server-status",
+			}
+			format := "tgz"
+			if tc.format != "" {
+				format = tc.format
+				args = append(args, "-archive-format="+tc.format)
+			}
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			bundlePath := filepath.Join(testDir, basePath+tc.ext)
+			if tc.ext == "" {
+				bundlePath += debugArchiveExts[format][0]
+			}
+
+			// The staging directory should have been removed
+			if _, err := os.Stat(filepath.Join(testDir, basePath)); !os.IsNotExist(err) {
+				t.Fatalf("expected staging directory to be removed, got: %v", err)
+			}
+
+			files := testDebugArchiveFiles(t, bundlePath)
+			for _, expected := range []string{"index.json", "000/server_status.json"} {
+				found := false
+				for _, file := range files {
+					if file == filepath.ToSlash(filepath.Join(basePath, expected)) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Fatalf("expected %q in archive, got: %v", expected, files)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugCommand_OutputTemplate(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The cluster name includes a separator, which must not be expanded into
+	// the path
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.4.0","cluster_name":"prod/east"}`))
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.skipPreflight = true
+
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		fmt.Sprintf("-output-template=%s/debug-{{.ClusterName}}-{{.Host}}-{{.Time}}", testDir),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(testDir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected a single bundle, got: %v", matches)
+	}
+
+	re := regexp.MustCompile(`^debug-prod_east-127\.0\.0\.1-\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}Z\.tar\.gz$`)
+	if name := filepath.Base(matches[0]); !re.MatchString(name) {
+		t.Fatalf("expected bundle name to match the expanded template, got: %s", name)
+	}
+}
+
+func TestDebugCommand_KeepDir(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "keep-dir")
+	args := []string{
+		"-duration=1s",
+		"-keep-dir",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s", outputPath),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputPath, "index.json")); err != nil {
+		t.Fatalf("expected output directory to be kept: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, "000", "server_status.json")); err != nil {
+		t.Fatalf("expected captured files to be kept: %s", err)
+	}
+
+	files := testDebugArchiveFiles(t, outputPath+debugCompressionExt)
+	if !strutil.StrListContains(files, "keep-dir/index.json") {
+		t.Fatalf("expected index.json in archive, got: %v", files)
+	}
+
+	if expected := "Directory kept at: " + outputPath; !strings.Contains(ui.OutputWriter.String(), expected) {
+		t.Fatalf("expected %q to contain %q", ui.OutputWriter.String(), expected)
+	}
+}
+
+func TestDebugCommand_Compression(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	cases := []struct {
+		compression string
+		output      string
+		bundle      string
+	}{
+		{"bzip2", "bzip2", "bzip2.tar.bz2"},
+		{"bzip2", "with-ext.tbz2", "with-ext.tbz2"},
+		{"xz", "xz", "xz.tar.xz"},
+		{"xz", "with-ext.tar.xz", "with-ext.tar.xz"},
+	}
+
+	for _, tc := range cases {
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{
+			"-duration=1s",
+			"-target=server-status",
+			fmt.Sprintf("-compression=%s", tc.compression),
+			fmt.Sprintf("-output=%s", filepath.Join(testDir, tc.output)),
+		})
+		if exp := 0; code != exp {
+			t.Log(ui.ErrorWriter.String())
+			t.Fatalf("%s: expected %d to be %d", tc.output, code, exp)
+		}
+
+		// The bundle gets the extension of its compression and is read back
+		// by the matching reader
+		bundle := filepath.Join(testDir, tc.bundle)
+		dir := strings.TrimSuffix(filepath.Base(tc.bundle), filepath.Ext(tc.bundle))
+		dir = strings.TrimSuffix(dir, ".tar")
+		files := testDebugArchiveFiles(t, bundle)
+		if !strutil.StrListContains(files, dir+"/index.json") {
+			t.Fatalf("%s: expected index.json in the archive, got: %v", tc.output, files)
+		}
+
+		verifyUI, verifyCmd := testDebugVerifyCommand(t)
+		if code := verifyCmd.Run([]string{bundle}); code != 0 {
+			t.Log(verifyUI.ErrorWriter.String())
+			t.Fatalf("%s: expected verify to succeed, got %d", tc.output, code)
+		}
+	}
+}
+
+func TestDebugCommand_DryRun(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	basePath := "dry-run"
+	args := []string{
+		"-duration=4s",
+		"-interval=2s",
+		"-dry-run",
+		"-target=config",
+		"-target=host",
+		fmt.Sprintf("-output=%s/%s", testDir, basePath),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	output := ui.OutputWriter.String()
+	for _, expected := range []string{"Targets: config, host", "Frames: 2", "config.json", "<frame>/host_info.json"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, output)
+		}
+	}
+
+	files, err := ioutil.ReadDir(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected no files to be created, got %d", len(files))
+	}
+
+	// Only the reachability of the server is checked, even if its version
+	// has to be looked up and the active node would be followed
+	var requests []string
+	var l sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		requests = append(requests, r.URL.Path)
+		l.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false}`))
+	}))
+	defer ts.Close()
+
+	stub, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd = testDebugCommand(t)
+	cmd.client = stub
+	code = cmd.Run([]string{
+		"-dry-run",
+		"-follow-active",
+		fmt.Sprintf("-output=%s/%s", testDir, "stub"),
+	})
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if len(requests) > 1 {
+		t.Fatalf("expected at most one request, got: %v", requests)
+	}
+}
+
+func TestDebugCommand_ListTargets(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	ui, cmd := testDebugCommand(t)
+
+	args := []string{
+		"-list-targets",
+		fmt.Sprintf("-output=%s", filepath.Join(testDir, "list")),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	// Every target but the opt-in log target is captured by default
+	lines := strings.Split(ui.OutputWriter.String(), "\n")
+	for _, target := range debugTargetNames() {
+		description := debugTargetRegistry[target].description
+		if description == "" {
+			t.Fatalf("expected a description for target %s", target)
+		}
+
+		included := "yes"
+		if target == "log" {
+			included = "no"
+		}
+
+		var found bool
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) > 2 && fields[0] == target && fields[1] == included && strings.HasSuffix(line, description) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("expected target %s to be listed with default %q:\n%s", target, included, ui.OutputWriter.String())
+		}
+	}
+
+	files, err := ioutil.ReadDir(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected no bundle to be created, got %d file(s)", len(files))
+	}
+}
+
+// TestDebugCommand_EnvTargets is not run in parallel since it modifies the
+// environment.
+func TestDebugCommand_EnvTargets(t *testing.T) {
+	cases := []struct {
+		name          string
+		env           string
+		args          []string
+		expectedFiles []string
+		missingFiles  []string
+		expectedCode  int
+	}{
+		{
+			"env",
+			"config, host",
+			[]string{},
+			[]string{"config.json", "000/host_info.json"},
+			[]string{"000/health.json", "000/server_status.json"},
+			0,
+		},
+		{
+			"flags-override",
+			"config,host",
+			[]string{"-target=server-status"},
+			[]string{"000/server_status.json"},
+			[]string{"config.json", "000/host_info.json"},
+			0,
+		},
+		{
+			"invalid",
+			"config,nope",
+			[]string{},
+			nil,
+			nil,
+			1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			os.Setenv(EnvVaultDebugTargets, tc.env)
+			defer os.Unsetenv(EnvVaultDebugTargets)
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			basePath := tc.name
+			args := append([]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/%s", testDir, basePath),
+				"-compress=false",
+			}, tc.args...)
+
+			code := cmd.Run(args)
+			if code != tc.expectedCode {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, tc.expectedCode)
+			}
+			if tc.expectedCode != 0 {
+				if !strings.Contains(ui.ErrorWriter.String(), "invalid target") {
+					t.Fatalf("expected invalid target error, got: %s", ui.ErrorWriter.String())
+				}
+				return
+			}
+
+			for _, file := range tc.expectedFiles {
+				if _, err := os.Stat(filepath.Join(testDir, basePath, file)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range tc.missingFiles {
+				if _, err := os.Stat(filepath.Join(testDir, basePath, file)); !os.IsNotExist(err) {
+					t.Fatalf("expected %s to not exist, got: %v", file, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugCommand_JSONSummary(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "json")
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		"-json",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s", outputPath),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	// The summary is the only output on stdout
+	output := ui.OutputWriter.String()
+	var summary debugSummary
+	if err := json.Unmarshal([]byte(output), &summary); err != nil {
+		t.Fatalf("expected stdout to be a single JSON object: %s\n%s", err, output)
+	}
+
+	if summary.Frames != 2 {
+		t.Fatalf("expected 2 frames, got %d", summary.Frames)
+	}
+	if exp := outputPath + debugCompressionExt; summary.OutputPath != exp {
+		t.Fatalf("expected output path %q, got %q", exp, summary.OutputPath)
+	}
+	if !reflect.DeepEqual(summary.Targets, []string{"server-status"}) {
+		t.Fatalf("unexpected targets: %v", summary.Targets)
+	}
+	if summary.Bytes <= 0 {
+		t.Fatalf("expected a positive bundle size, got %d", summary.Bytes)
+	}
+	if summary.Duration != "2s" {
+		t.Fatalf("expected duration 2s, got %q", summary.Duration)
+	}
+	if len(summary.Errors) != 0 {
+		t.Fatalf("expected no errors, got: %v", summary.Errors)
+	}
+}
+
+func TestDebugCommand_Quiet(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "quiet")
+	args := []string{
+		"-duration=1s",
+		"-quiet",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s", outputPath),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	if output := ui.OutputWriter.String(); output != "" {
+		t.Fatalf("expected no output, got: %s", output)
+	}
+	if output := ui.ErrorWriter.String(); output != "" {
+		t.Fatalf("expected no error output, got: %s", output)
+	}
+	if _, err := os.Stat(outputPath + debugCompressionExt); err != nil {
+		t.Fatalf("expected bundle to be written: %s", err)
+	}
+}
+
+func TestDebugCommand_Config(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		config        string
+		args          []string
+		expectedFiles []string
+		missingFiles  []string
+		out           string
+		code          int
+	}{
+		{
+			"targets",
+			`{"targets": ["host", "server-status"], "duration": "1s", "compress": false}`,
+			nil,
+			[]string{"000/host_info.json", "000/server_status.json"},
+			[]string{"000/health.json", "000/replication_status.json", "config.json"},
+			"",
+			0,
+		},
+		{
+			"flags-override",
+			`{"targets": ["host", "server-status"], "duration": 1, "compress": true}`,
+			[]string{"-target=health", "-compress=false"},
+			[]string{"000/health.json"},
+			[]string{"000/host_info.json", "000/server_status.json"},
+			"",
+			0,
+		},
+		{
+			"redact",
+			`{"targets": ["config"], "duration": "1s", "compress": false, "redact": true}`,
+			nil,
+			[]string{"config.json"},
+			nil,
+			"matching the default rules",
+			0,
+		},
+		{
+			"redact-file",
+			`{"targets": ["config"], "duration": "1s", "compress": false, "redact_file": "missing-rules.json"}`,
+			nil,
+			nil,
+			nil,
+			"missing-rules.json",
+			1,
+		},
+		{
+			"redact-flags-override",
+			`{"targets": ["config"], "duration": "1s", "compress": false, "redact": true, "redact_file": "missing-rules.json"}`,
+			[]string{"-redact=false", "-redact-file="},
+			[]string{"config.json"},
+			nil,
+			"",
+			0,
+		},
+		{
+			"unknown-key",
+			`{"targets": ["host"], "foo": "bar"}`,
+			nil,
+			nil,
+			nil,
+			`unknown field "foo"`,
+			1,
+		},
+		{
+			"invalid-duration",
+			`{"duration": "forever"}`,
+			nil,
+			nil,
+			nil,
+			"invalid duration value",
+			1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			configPath := filepath.Join(testDir, "debug.json")
+			if err := ioutil.WriteFile(configPath, []byte(tc.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			outputPath := filepath.Join(testDir, tc.name)
+			args := []string{
+				fmt.Sprintf("-config=%s", configPath),
+				fmt.Sprintf("-output=%s", outputPath),
+			}
+			args = append(args, tc.args...)
+
+			code := cmd.Run(args)
+			if code != tc.code {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, tc.code)
+			}
+
+			combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+			if !strings.Contains(combined, tc.out) {
+				t.Fatalf("expected %q to contain %q", combined, tc.out)
+			}
+
+			for _, file := range tc.expectedFiles {
+				if _, err := os.Stat(filepath.Join(outputPath, file)); err != nil {
+					t.Fatalf("expected %q to exist: %s", file, err)
+				}
+			}
+			for _, file := range tc.missingFiles {
+				if _, err := os.Stat(filepath.Join(outputPath, file)); !os.IsNotExist(err) {
+					t.Fatalf("expected %q to not exist: %v", file, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugCommand_OutputFormat(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	targets := []string{"-target=config", "-target=host", "-target=server-status"}
+
+	// Capture an archived bundle to compare against
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	archiveArgs := append([]string{
+		"-duration=1s",
+		"-output-format=archive",
+		fmt.Sprintf("-output=%s", filepath.Join(testDir, "archive")),
+	}, targets...)
+	if code := cmd.Run(archiveArgs); code != 0 {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, 0)
+	}
+
+	var archiveFiles []string
+	for _, file := range testDebugArchiveFiles(t, filepath.Join(testDir, "archive"+debugCompressionExt)) {
+		archiveFiles = append(archiveFiles, strings.TrimPrefix(file, "archive/"))
+	}
+	sort.Strings(archiveFiles)
+
+	// Capture the same targets in directory mode
+	ui, cmd = testDebugCommand(t)
+	cmd.client = client
+
+	dirPath := filepath.Join(testDir, "dir")
+	dirArgs := append([]string{
+		"-duration=1s",
+		"-output-format=dir",
+		fmt.Sprintf("-output=%s", dirPath),
+	}, targets...)
+	if code := cmd.Run(dirArgs); code != 0 {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, 0)
+	}
+
+	if _, err := os.Stat(dirPath + debugCompressionExt); !os.IsNotExist(err) {
+		t.Fatalf("expected no archive to be created, got: %v", err)
+	}
+
+	var dirFiles []string
+	err = filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		dirFiles = append(dirFiles, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(dirFiles)
+
+	if !reflect.DeepEqual(archiveFiles, dirFiles) {
+		t.Fatalf("expected directory layout %v to match archive layout %v", dirFiles, archiveFiles)
+	}
+}
+
+func TestDebugCommand_OutputCompressAfter(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		args     []string
+		archived bool
+	}{
+		{
+			"short",
+			[]string{"-duration=1s", "-output-compress-after=1h"},
+			false,
+		},
+		{
+			"long",
+			[]string{"-duration=2s", "-interval=1s", "-output-compress-after=1s"},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			outputPath := filepath.Join(testDir, tc.name)
+			args := append([]string{
+				"-target=server-status",
+				fmt.Sprintf("-output=%s", outputPath),
+			}, tc.args...)
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			_, archiveErr := os.Stat(outputPath + debugCompressionExt)
+			_, dirErr := os.Stat(filepath.Join(outputPath, "index.json"))
+			if tc.archived {
+				if archiveErr != nil || !os.IsNotExist(dirErr) {
+					t.Fatalf("expected only the archive to be written, got: %v, %v", archiveErr, dirErr)
+				}
+				return
+			}
+
+			if !os.IsNotExist(archiveErr) || dirErr != nil {
+				t.Fatalf("expected the output to be left as a directory, got: %v, %v", archiveErr, dirErr)
+			}
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var index DebugIndex
+			if err := json.Unmarshal(content, &index); err != nil {
+				t.Fatal(err)
+			}
+			if index.Compress {
+				t.Fatal("expected the index to record the output as uncompressed")
+			}
+		})
+	}
+}
+
+func TestDebugCommand_Rotate(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	basePath := "rotate"
+	args := []string{
+		"-duration=3s",
+		"-rotate=1s",
+		fmt.Sprintf("-output=%s", filepath.Join(testDir, basePath)),
+		"-target=server-status",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(testDir, "*"+debugCompressionExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 3 {
+		t.Fatalf("expected 3 archives, got: %v", matches)
+	}
+
+	for w := 0; w < 3; w++ {
+		windowPath := fmt.Sprintf("%s-%03d", basePath, w)
+		files := testDebugArchiveFiles(t, filepath.Join(testDir, windowPath+debugCompressionExt))
+
+		for _, expected := range []string{"index.json", "000/server_status.json"} {
+			found := false
+			for _, file := range files {
+				if file == windowPath+"/"+expected {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Fatalf("expected %q in archive %d, got: %v", expected, w, files)
+			}
+		}
+	}
+}
+
+func TestDebugCommand_CaptureTargets(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		targets       []string
+		expectedFiles []string
+	}{
+		{
+			"audit-probe",
+			[]string{"audit-probe"},
+			[]string{"audit_probe.json"},
+		},
+		{
+			"audit-status",
+			[]string{"audit-status"},
+			[]string{"000/audit_status.json"},
+		},
+		{
+			"auth",
+			[]string{"auth"},
+			[]string{"auth.json"},
+		},
+		{
+			"clock-skew",
+			[]string{"clock-skew"},
+			[]string{"clock_skew.json"},
+		},
+		{
+			"config",
+			[]string{"config"},
+			[]string{"config.json"},
+		},
+		{
+			"counters",
+			[]string{"counters"},
+			[]string{"000/counters_activity.txt", "000/counters_tokens.json"},
+		},
+		{
+			"entropy",
+			[]string{"entropy"},
+			[]string{"sealwrap_status.txt"},
+		},
+		{
+			"goroutine-count",
+			[]string{"goroutine-count"},
+			[]string{"goroutine_counts.json"},
+		},
+		{
+			"ha-lock",
+			[]string{"ha-lock"},
+			[]string{"000/ha_lock.json"},
+		},
+		{
+			"health",
+			[]string{"health"},
+			[]string{"000/health.json"},
+		},
+		{
+			"host-info",
+			[]string{"host"},
+			[]string{"000/host_info.json"},
+		},
+		{
+			"hot-paths",
+			[]string{"hot-paths"},
+			[]string{"hot_paths.txt"},
+		},
+		{
+			"leases",
+			[]string{"leases"},
+			[]string{"000/leases.json"},
+		},
+		{
+			"license",
+			[]string{"license"},
+			[]string{"license_status.txt"},
+		},
+		{
+			"log",
+			[]string{"log"},
+			[]string{"vault.log"},
+		},
+		{
+			"metrics",
+			[]string{"metrics"},
+			[]string{"metrics/000.json"},
+		},
+		{
+			"mounts",
+			[]string{"mounts"},
+			[]string{"mounts.json"},
+		},
+		{
+			"openapi",
+			[]string{"openapi"},
+			[]string{"openapi.json"},
+		},
+		{
+			"plugins",
+			[]string{"plugins"},
+			[]string{"plugins.json"},
+		},
+		{
+			"quotas",
+			[]string{"quotas"},
+			[]string{"quotas.txt"},
+		},
+		{
+			"raft-snapshot-info",
+			[]string{"raft-snapshot-info"},
+			[]string{"raft_snapshot_info.txt"},
+		},
+		{
+			"replication-perf",
+			[]string{"replication-perf"},
+			[]string{"000/replication_performance.txt", "000/replication_dr.txt"},
+		},
+		{
+			"replication-status",
+			[]string{"replication-status"},
+			[]string{"000/replication_status.json"},
+		},
+		{
+			"requests",
+			[]string{"requests"},
+			[]string{"000/requests.json"},
+		},
+		{
+			"seal-timing",
+			[]string{"seal-timing"},
+			[]string{"seal_timing.txt"},
+		},
+		{
+			"self",
+			[]string{"self"},
+			[]string{"token_self.json"},
+		},
+		{
+			"server-status",
+			[]string{"server-status"},
+			[]string{"000/server_status.json"},
+		},
+		{
+			"storage",
+			[]string{"storage"},
+			[]string{"storage.json"},
+		},
+		{
+			"all-minus-pprof",
+			[]string{"auth", "config", "health", "host", "license", "metrics", "mounts", "policies", "quotas", "replication-status", "self", "server-status"},
+			[]string{"auth.json", "config.json", "000/health.json", "000/host_info.json", "license_status.txt", "metrics/000.json", "mounts.json", "policies.json", "quotas.txt", "000/replication_status.json", "token_self.json", "000/server_status.json"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			basePath := tc.name
+			args := []string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/%s", testDir, basePath),
+				"-compress=false",
+			}
+			for _, target := range tc.targets {
+				args = append(args, fmt.Sprintf("-target=%s", target))
+			}
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			for _, file := range append(tc.expectedFiles, "index.json") {
+				if _, err := os.Stat(filepath.Join(testDir, basePath, file)); err != nil {
+					t.Fatalf("expected %q to exist: %s", file, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugCommand_LogFile(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	logPath := filepath.Join(testDir, "capture.log")
+	outputPath := filepath.Join(testDir, "log-file")
+	targets := []string{"config", "metrics", "server-status"}
+	args := []string{
+		"-duration=1s",
+		fmt.Sprintf("-log-file=%s", logPath),
+		"-log-in-bundle",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+	for _, target := range targets {
+		args = append(args, fmt.Sprintf("-target=%s", target))
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	ended := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected a JSON object per line, got %q: %s", line, err)
+		}
+		event, _ := entry["event"].(string)
+		events = append(events, event)
+		if event != "target_end" {
+			continue
+		}
+		if _, ok := entry["duration_ms"].(float64); !ok {
+			t.Fatalf("expected a duration for the end of the target, got: %s", line)
+		}
+		target, _ := entry["target"].(string)
+		ended[target] = true
+	}
+	if events[0] != "run_start" || events[len(events)-1] != "run_end" {
+		t.Fatalf("expected the log to start and end with the run, got: %v", events)
+	}
+	for _, target := range targets {
+		if !ended[target] {
+			t.Fatalf("expected the end of %s to be logged, got: %s", target, content)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outputPath, "capture_log.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log can only be included in the bundle when it is written
+	ui, cmd = testDebugCommand(t)
+	cmd.client = client
+	code = cmd.Run([]string{
+		"-duration=1s",
+		"-log-in-bundle",
+		fmt.Sprintf("-output=%s", filepath.Join(testDir, "no-log-file")),
+	})
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "log-in-bundle requires log-file") {
+		t.Fatalf("expected validation error, got: %s", ui.ErrorWriter.String())
+	}
+}
+
+func TestDebugCommand_AuditProbe(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	devices := []string{"file/", "file-secondary/"}
+	for i, path := range devices {
+		err := client.Sys().EnableAuditWithOptions(strings.TrimSuffix(path, "/"), &api.EnableAuditOptions{
+			Type: "file",
+			Options: map[string]string{
+				"file_path": filepath.Join(testDir, fmt.Sprintf("audit-%d.log", i)),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "audit-probe")
+	args := []string{
+		"-duration=1s",
+		"-target=audit-probe",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "audit_probe.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var probe struct {
+		Input   string              `json:"input"`
+		Devices []map[string]string `json:"devices"`
+	}
+	if err := json.Unmarshal(content, &probe); err != nil {
+		t.Fatal(err)
+	}
+	if probe.Input != debugAuditProbeInput {
+		t.Fatalf("expected input %q, got: %q", debugAuditProbeInput, probe.Input)
+	}
+	if len(probe.Devices) != len(devices) {
+		t.Fatalf("expected %d devices, got: %s", len(devices), content)
+	}
+
+	// The devices are sorted by path
+	for i, path := range []string{"file-secondary/", "file/"} {
+		device := probe.Devices[i]
+		expected, err := client.Sys().AuditHash(path, debugAuditProbeInput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if device["path"] != path || device["type"] != "file" || device["hash"] != expected {
+			t.Fatalf("expected %s to be hashed as %q, got: %v", path, expected, device)
+		}
+	}
+}
+
+func TestDebugCommand_AuditStatus(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// One device is blocked, one logs quickly, and one logs nothing, while a
+	// request could not be logged by any device
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false}`))
+		case "/v1/sys/audit":
+			w.Write([]byte(`{"data":{
+				"blocked/":{"type":"socket","description":"","local":false},
+				"file/":{"type":"file","description":"","local":false},
+				"idle/":{"type":"syslog","description":"","local":true}
+			}}`))
+		case "/v1/sys/metrics":
+			w.Write([]byte(`{
+				"Counters":[
+					{"Name":"vault.audit.log_request_failure","Count":3,"Sum":1},
+					{"Name":"vault.audit.log_response_failure","Count":2,"Sum":0}
+				],
+				"Samples":[
+					{"Name":"vault.audit.blocked/.log_request","Count":3,"Sum":6000,"Min":1000,"Max":3000},
+					{"Name":"vault.audit.file/.log_request","Count":3,"Sum":3,"Min":0.5,"Max":1.5},
+					{"Name":"vault.audit.file/.log_response","Count":2,"Sum":2,"Min":1,"Max":1}
+				]
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.skipPreflight = true
+
+	outputPath := filepath.Join(testDir, "audit-status")
+	args := []string{
+		"-duration=1s",
+		"-target=audit-status",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "audit_status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status struct {
+		Failing  bool               `json:"failing"`
+		Failures map[string]float64 `json:"failures"`
+		Devices  []struct {
+			Path       string `json:"path"`
+			Type       string `json:"type"`
+			Status     string `json:"status"`
+			LogRequest *struct {
+				Count  int     `json:"count"`
+				MeanMS float64 `json:"mean_ms"`
+				MaxMS  float64 `json:"max_ms"`
+			} `json:"log_request"`
+		} `json:"devices"`
+	}
+	if err := json.Unmarshal(content, &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Failing || status.Failures["log_request"] != 1 || status.Failures["log_response"] != 0 {
+		t.Fatalf("expected a failed request to be reported, got: %s", content)
+	}
+
+	// The devices are sorted by path
+	expected := []struct {
+		path   string
+		status string
+		count  int
+		mean   float64
+	}{
+		{"blocked/", "slow", 3, 2000},
+		{"file/", "ok", 3, 1},
+		{"idle/", "idle", 0, 0},
+	}
+	if len(status.Devices) != len(expected) {
+		t.Fatalf("expected %d devices, got: %s", len(expected), content)
+	}
+	for i, exp := range expected {
+		device := status.Devices[i]
+		if device.Path != exp.path || device.Status != exp.status {
+			t.Fatalf("expected %s to be %s, got: %s", exp.path, exp.status, content)
+		}
+		switch {
+		case exp.count == 0 && device.LogRequest != nil:
+			t.Fatalf("expected no requests logged by %s, got: %s", exp.path, content)
+		case exp.count > 0 && (device.LogRequest == nil || device.LogRequest.Count != exp.count || device.LogRequest.MeanMS != exp.mean):
+			t.Fatalf("expected %d requests logged by %s in %vms on average, got: %s", exp.count, exp.path, exp.mean, content)
+		}
+	}
+}
+
+func TestDebugCommand_Requests(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/in-flight-req":
+			w.Write([]byte(`{"data":{
+				"b":{"start_time":"2019-10-15T21:44:49.5Z","request_method":"GET","request_path":"/v1/secret/data/app","client_remote_address":"127.0.0.1:51234"},
+				"c":{"start_time":"2019-10-15T21:44:50Z","request_method":"GET","request_path":"/v1/sys/in-flight-req","client_remote_address":"127.0.0.1:51235"},
+				"a":{"start_time":"2019-10-15T21:44:40Z","request_method":"PUT","request_path":"/v1/transit/encrypt/app","client_remote_address":"127.0.0.1:51230"}
+			}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "requests")
+	args := []string{
+		"-duration=1s",
+		"-target=requests",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "requests.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests struct {
+		Count    int                    `json:"count"`
+		Requests []debugInFlightRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(content, &requests); err != nil {
+		t.Fatal(err)
+	}
+
+	// The requests are sorted oldest first, without the listing request
+	if requests.Count != 2 || len(requests.Requests) != 2 {
+		t.Fatalf("expected 2 requests, got: %s", content)
+	}
+	for i, exp := range []string{"a", "b"} {
+		if requests.Requests[i].ID != exp {
+			t.Fatalf("expected request %s at %d, got: %s", exp, i, content)
+		}
+	}
+	if req := requests.Requests[0]; req.Method != "PUT" || req.Path != "/v1/transit/encrypt/app" || req.ClientRemoteAddr != "127.0.0.1:51230" {
+		t.Fatalf("unexpected request: %#v", req)
+	}
+}
+
+func TestDebugCommand_Log(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The stream is held open until the command ends it
+	levels := make(chan string, 1)
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/monitor" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		levels <- r.URL.Query().Get("log_level")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("2019-10-15T21:44:49.000Z [DEBUG] core: first line\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("2019-10-15T21:44:50.000Z [DEBUG] core: second line\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "log")
+	args := []string{
+		"-duration=1s",
+		"-target=log",
+		"-log-level=DEBUG",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if level := <-levels; level != "debug" {
+		t.Fatalf("expected the log to be streamed at debug, got: %q", level)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "vault.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 2 || !strings.HasSuffix(lines[1], "second line") {
+		t.Fatalf("expected the streamed log, got: %s", content)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed DebugIndex
+	if err := json.Unmarshal(index, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Errors) != 0 {
+		t.Fatalf("expected no errors once the stream is ended, got: %#v", parsed.Errors)
+	}
+	if _, ok := parsed.Checksums["vault.log"]; !ok {
+		t.Fatalf("expected vault.log in the index checksums, got: %v", parsed.Checksums)
+	}
+}
+
+// Servers built from this version don't serve sys/monitor, so a note is
+// written in place of the log.
+func TestDebugCommand_LogUnavailable(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "log")
+	code := cmd.Run([]string{
+		"-duration=1s",
+		"-target=log",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	})
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "vault.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "doesn't serve sys/monitor"; !strings.Contains(string(content), expected) {
+		t.Fatalf("expected %q to contain %q", content, expected)
+	}
+}
+
+func TestDebugCommand_ClockSkew(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "clock-skew")
+	args := []string{
+		"-duration=1s",
+		"-target=clock-skew",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "clock_skew.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		t.Fatal(err)
+	}
+	skew, ok := entry["skew_seconds"].(float64)
+	if !ok {
+		t.Fatalf("expected a numeric skew_seconds field: %s", content)
+	}
+
+	// The server runs in the same process, so the only skew is from the
+	// resolution of the server time
+	if bound := entry["error_bound_seconds"].(float64); math.Abs(skew) > bound {
+		t.Fatalf("expected skew %f to be within the error bound %f", skew, bound)
+	}
+}
+
+func TestDebugCommand_OpenAPI(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "openapi")
+	args := []string{
+		"-duration=1s",
+		"-target=openapi",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "openapi.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		t.Fatalf("expected valid JSON: %s", err)
+	}
+	if version, ok := doc["openapi"].(string); !ok || version == "" {
+		t.Fatalf("expected an openapi version field, got: %v", doc["openapi"])
+	}
+}
+
+func TestDebugCommand_TokenSelf(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	// Use a non-root token, since root tokens are never renewable
+	secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies: []string{"default"},
+		TTL:      "1h",
+		Metadata: map[string]string{"purpose": "debug"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(secret.Auth.ClientToken)
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	basePath := "self"
+	args := []string{
+		"-duration=1s",
+		fmt.Sprintf("-output=%s/%s", testDir, basePath),
+		"-compress=false",
+		"-target=self",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, basePath, "token_self.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), client.Token()) {
+		t.Fatalf("expected token_self.json to not contain the token id: %s", content)
+	}
+
+	var entry struct {
+		Token map[string]interface{} `json:"token"`
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"policies", "ttl", "renewable", "meta"} {
+		if _, ok := entry.Token[k]; !ok {
+			t.Fatalf("expected %q to be present in token_self.json", k)
+		}
+	}
+	for _, k := range debugTokenRedactKeys {
+		if v := entry.Token[k]; v != debugRedactedValue {
+			t.Fatalf("expected %q to be redacted, got: %v", k, v)
+		}
+	}
+}
+
+func TestDebugCommand_Policies(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		args          []string
+		expectedFiles []string
+		missingFiles  []string
+	}{
+		{
+			"names",
+			[]string{},
+			[]string{"policies.json"},
+			[]string{"policies/default.hcl", "policies/debug.hcl"},
+		},
+		{
+			"bodies",
+			[]string{"-include-policy-bodies"},
+			[]string{"policies.json", "policies/default.hcl", "policies/debug.hcl"},
+			[]string{"policies/root.hcl"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			if err := client.Sys().PutPolicy("debug", `path "sys/*" { capabilities = ["read"] }`); err != nil {
+				t.Fatal(err)
+			}
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			basePath := tc.name
+			args := append([]string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/%s", testDir, basePath),
+				"-compress=false",
+				"-target=policies",
+			}, tc.args...)
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			for _, file := range tc.expectedFiles {
+				if _, err := os.Stat(filepath.Join(testDir, basePath, file)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range tc.missingFiles {
+				if _, err := os.Stat(filepath.Join(testDir, basePath, file)); !os.IsNotExist(err) {
+					t.Fatalf("expected %s to not exist, got: %v", file, err)
+				}
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(testDir, basePath, "policies.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), `"debug"`) {
+				t.Fatalf("expected policies.json to list the debug policy: %s", content)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_Quotas(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/quotas/config":
+			w.Write([]byte(`{"data":{"enable_rate_limit_audit_logging":false}}`))
+		case "/v1/sys/quotas/rate-limit":
+			w.Write([]byte(`{"data":{"keys":["global"]}}`))
+		case "/v1/sys/quotas/rate-limit/global":
+			w.Write([]byte(`{"data":{"name":"global","path":"","rate":100}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	basePath := "quotas"
+	args := []string{
+		"-duration=1s",
+		fmt.Sprintf("-output=%s/%s", testDir, basePath),
+		"-compress=false",
+		"-target=quotas",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	for _, file := range []string{"quota_config.json", "rate_limit_quotas.json"} {
+		if _, err := os.Stat(filepath.Join(testDir, basePath, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, basePath, "rate_limit_quotas.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"rate": 100`) {
+		t.Fatalf("expected rate_limit_quotas.json to contain the global quota: %s", content)
+	}
+}
+
+func TestDebugCommand_PermissionDenied(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		target       string
+		expectedFile string
+	}{
+		{
+			"auth",
+			"auth",
+			"auth.txt",
+		},
+		{
+			"mounts",
+			"mounts",
+			"mounts.txt",
+		},
+		{
+			"plugins",
+			"plugins",
+			"plugins.txt",
+		},
+		{
+			"policies",
+			"policies",
+			"policies.txt",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			// The default policy doesn't grant access to the sys endpoints
+			secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+				Policies: []string{"default"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err = client.Clone()
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.SetToken(secret.Auth.ClientToken)
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			basePath := tc.name
+			args := []string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s/%s", testDir, basePath),
+				"-compress=false",
+				fmt.Sprintf("-target=%s", tc.target),
+			}
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			if _, err := os.Stat(filepath.Join(testDir, basePath, tc.expectedFile)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_RedactLicense(t *testing.T) {
+	t.Parallel()
+
+	data := map[string]interface{}{
+		"expiration_time": "2020-01-01T00:00:00Z",
+		"features":        []interface{}{"HSM", "Performance Replication"},
+		"license_id":      "0123-4567",
+		"autoloaded": map[string]interface{}{
+			"license_id": "0123-4567",
+			"text":       "02MV4UU43BK5HGYYTOJZ",
+		},
+		"signed": "02MV4UU43BK5HGYYTOJZ",
+	}
+
+	expected := map[string]interface{}{
+		"expiration_time": "2020-01-01T00:00:00Z",
+		"features":        []interface{}{"HSM", "Performance Replication"},
+		"license_id":      "0123-4567",
+		"autoloaded": map[string]interface{}{
+			"license_id": "0123-4567",
+			"text":       debugRedactedValue,
+		},
+		"signed": debugRedactedValue,
+	}
+
+	if actual := redactLicense(data); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestDebugCommand_Pprof(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		args     []string
+		expected map[string]int
+	}{
+		{
+			"default",
+			nil,
+			// Snapshots are captured on the first and last frames, and
+			// polling profiles on every frame but the last
+			map[string]int{
+				"heap.prof":      2,
+				"goroutine.prof": 2,
+				"goroutines.txt": 2,
+				"profile.prof":   2,
+				"trace.out":      2,
+			},
+		},
+		{
+			"no-goroutine-dump",
+			[]string{"-goroutine-dump=false"},
+			map[string]int{
+				"heap.prof":      2,
+				"goroutine.prof": 2,
+				"goroutines.txt": 0,
+				"profile.prof":   2,
+				"trace.out":      2,
+			},
+		},
+		{
+			"every-frame",
+			[]string{"-pprof-every-frame"},
+			map[string]int{
+				"heap.prof":      3,
+				"goroutine.prof": 3,
+				"goroutines.txt": 3,
+				"profile.prof":   2,
+				"trace.out":      2,
+			},
+		},
+		{
+			"every-frame-max",
+			[]string{"-pprof-every-frame", "-pprof-max-frames=2"},
+			map[string]int{
+				"heap.prof":      2,
+				"goroutine.prof": 2,
+				"goroutines.txt": 2,
+				"profile.prof":   2,
+				"trace.out":      2,
+			},
+		},
+		{
+			"heap-only",
+			[]string{"-pprof-profiles=heap"},
+			map[string]int{
+				"heap.prof":      2,
+				"goroutine.prof": 0,
+				"goroutines.txt": 0,
+				"profile.prof":   0,
+				"trace.out":      0,
+				"block.txt":      0,
+				"mutex.txt":      0,
+			},
+		},
+		{
+			"unavailable-profiles",
+			[]string{"-pprof-profiles=block,mutex"},
+			map[string]int{
+				"heap.prof": 0,
+				"block.txt": 2,
+				"mutex.txt": 2,
+			},
+		},
+		{
+			"skip-polling-profiles",
+			[]string{"-skip-polling-profiles"},
+			map[string]int{
+				"heap.prof":      2,
+				"goroutine.prof": 2,
+				"goroutines.txt": 2,
+				"profile.prof":   0,
+				"trace.out":      0,
+			},
+		},
+		{
+			"compress-profiles",
+			[]string{"-compress-profiles"},
+			map[string]int{
+				"heap.prof":         0,
+				"heap.prof.gz":      2,
+				"goroutine.prof":    0,
+				"goroutine.prof.gz": 2,
+				"goroutines.txt":    2,
+				"profile.prof":      0,
+				"profile.prof.gz":   2,
+				"trace.out":         2,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			outputPath := filepath.Join(testDir, tc.name)
+			// pprof requires a minimum interval of 1s, which produces three
+			// frames over the duration.
+			args := []string{
+				"-compress=false",
+				"-duration=3s",
+				"-interval=1s",
+				fmt.Sprintf("-output=%s", outputPath),
+				"-target=pprof",
+			}
+			args = append(args, tc.args...)
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			for profile, count := range tc.expected {
+				matches, err := filepath.Glob(fmt.Sprintf("%s/*/%s", outputPath, profile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(matches) != count {
+					t.Fatalf("expected %d %s profiles, got: %v", count, profile, matches)
+				}
+			}
+
+			// Compressed profiles should be valid gzip streams
+			for profile, count := range tc.expected {
+				if !strings.HasSuffix(profile, ".gz") || count == 0 {
+					continue
+				}
+				f, err := os.Open(filepath.Join(outputPath, "000", profile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				gz, err := gzip.NewReader(f)
+				if err != nil {
+					f.Close()
+					t.Fatalf("expected %s to be a gzip stream: %s", profile, err)
+				}
+				_, err = io.Copy(ioutil.Discard, gz)
+				f.Close()
+				if err != nil {
+					t.Fatalf("expected %s to be a valid gzip stream: %s", profile, err)
+				}
+			}
+
+			// The goroutine dump should be the human-readable text form
+			if tc.expected["goroutines.txt"] > 0 {
+				data, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "goroutines.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(string(data), "goroutine ") {
+					t.Fatalf("expected full goroutine stack dump, got: %.100s", data)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugCommand_PprofTraceDuration(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "trace-duration")
+	args := []string{
+		"-duration=3s",
+		"-interval=1s",
+		"-pprof-trace-duration=2s",
+		"-target=pprof",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	// The trace started on the first frame is still in flight on the second,
+	// and the last frame doesn't capture polling profiles
+	matches, err := filepath.Glob(filepath.Join(outputPath, "*", "trace.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 trace, got: %v", matches)
+	}
+
+	// Execution traces start with a 16-byte header of the form "go 1.N trace"
+	// padded with NUL bytes, which is followed by the events
+	data, err := ioutil.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) <= 16 {
+		t.Fatalf("expected an execution trace with events, got: %q", data)
+	}
+	if header := data[:16]; !regexp.MustCompile(`^go 1\.[0-9]+ trace\x00+$`).Match(header) {
+		t.Fatalf("expected an execution trace, got header: %q", header)
+	}
+}
+
+func TestDebugCommand_PprofRing(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	basePath := "pprof-ring"
+	outputPath := filepath.Join(testDir, basePath)
+	args := []string{
+		"-duration=4s",
+		"-interval=1s",
+		"-pprof-ring=2",
+		"-target=pprof",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	retained := map[string]bool{
+		"000": false,
+		"001": false,
+		"002": true,
+		"003": true,
+	}
+	for frame, expected := range retained {
+		files, err := ioutil.ReadDir(filepath.Join(outputPath, frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := len(files) > 0; actual != expected {
+			t.Fatalf("expected pprof output of frame %s to be retained: %t, got %d files", frame, expected, len(files))
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var index DebugIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{2, 3}; !reflect.DeepEqual(index.PprofFrames, expected) {
+		t.Fatalf("expected pprof frames %v, got %v", expected, index.PprofFrames)
+	}
+}
+
+func TestDebugCommand_TimestampDirs(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "timestamp-dirs")
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		"-timestamp-dirs",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	// The per-frame glob pattern still finds every frame
+	matches, err := filepath.Glob(filepath.Join(outputPath, "*", "server_status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(matches))
+	}
+	for _, match := range matches {
+		dir := filepath.Base(filepath.Dir(match))
+		if _, err := time.Parse(fileFriendlyTimeFormat, dir); err != nil {
+			t.Fatalf("expected directory %q to parse as a timestamp: %s", dir, err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var index DebugIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Frames) != 2 {
+		t.Fatalf("expected 2 frames in the index, got: %v", index.Frames)
+	}
+	for i, frame := range index.Frames {
+		if frame.Frame != i {
+			t.Fatalf("expected frame %d, got %d", i, frame.Frame)
+		}
+		if frame.Directory != frame.Timestamp.Format(fileFriendlyTimeFormat) {
+			t.Fatalf("expected directory %q to match timestamp %s", frame.Directory, frame.Timestamp)
+		}
+	}
+}
+
+func TestDebugCommand_IndexFile(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	basePath := "index-test"
+	outputPath := filepath.Join(testDir, basePath)
+	args := []string{
+		"-duration=1s",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+		"-target=server-status",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Output) == 0 {
+		t.Fatalf("expected valid index file: got: %v", index)
+	}
+	if index.Output[0].Path != "000/server_status.json" {
+		t.Fatalf("unexpected output entry: %v", index.Output)
+	}
+	if index.ClientVersion == "" {
+		t.Fatal("expected client version to be recorded")
+	}
+	if index.ServerVersion == "" {
+		t.Fatal("expected server version to be recorded")
+	}
+
+	// Timestamps are parsed when unmarshaling, so only check that they fall
+	// within the frame that wrote the file
+	frame := index.Frames[0]
+	if frame.EndTimestamp.Before(frame.Timestamp) {
+		t.Fatalf("expected frame to end after it started, got: %v", frame)
+	}
+	written := index.Output[0].Timestamp
+	if written.Before(frame.Timestamp) || written.After(frame.EndTimestamp) {
+		t.Fatalf("expected output entry timestamp %s to be within frame %v", written, frame)
+	}
+}
+
+func TestDebugCommand_ServerVersionUnavailable(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Neither the health nor the seal status endpoint reports a version
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false}`))
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.skipPreflight = true
+
+	outputPath := filepath.Join(testDir, "no-version")
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		t.Fatal(err)
+	}
+	if index.ServerVersion != "" {
+		t.Fatalf("expected no server version, got: %q", index.ServerVersion)
+	}
+	if len(index.Errors) != 1 || index.Errors[0].Target != "version" {
+		t.Fatalf("expected version error to be recorded, got: %#v", index.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, "config.json")); err != nil {
+		t.Fatalf("expected capture to continue: %s", err)
+	}
+}
+
+func TestDebugOutputFile_UnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	// Version 1 of the index lists plain paths
+	var index DebugIndex
+	content := `{"version":1,"output":["000/health.json",{"path":"config.json","timestamp":"2019-10-15T21:44:49Z"}]}`
+	if err := json.Unmarshal([]byte(content), &index); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []DebugOutputFile{
+		{Path: "000/health.json"},
+		{Path: "config.json", Timestamp: time.Date(2019, 10, 15, 21, 44, 49, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(index.Output, expected) {
+		t.Fatalf("expected %v, got: %v", expected, index.Output)
+	}
+}
+
+func TestDebugCommand_PartialIndex(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "partial-index")
+
+	// Read the index as soon as the first frame completes, before the rest
+	// of the capture runs
+	var partial *DebugIndex
+	var partialErr error
+	cmd.frameHook = func(idx int) {
+		if idx != 0 {
+			return
+		}
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+		if err != nil {
+			partialErr = err
+			return
+		}
+		partial = &DebugIndex{}
+		partialErr = json.Unmarshal(content, partial)
+	}
+
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+		"-target=server-status",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	if partialErr != nil {
+		t.Fatalf("expected partial index after the first frame: %s", partialErr)
+	}
+	if !partial.Partial {
+		t.Fatal("expected index to be marked as partial")
+	}
+	if len(partial.Frames) != 1 || partial.Frames[0].Frame != 0 || partial.Frames[0].Directory != "000" {
+		t.Fatalf("expected partial index to list frame 0, got: %v", partial.Frames)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Partial {
+		t.Fatal("expected final index not to be marked as partial")
+	}
+	if len(index.Frames) != 2 {
+		t.Fatalf("expected 2 frames in the final index, got: %v", index.Frames)
+	}
+	for _, file := range index.Output {
+		if file.Path == "index.json" {
+			t.Fatalf("expected index not to list itself, got: %v", index.Output)
+		}
+	}
+}
+
+func TestDebugCommand_Metadata(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "metadata")
+	args := []string{
+		"-duration=1s",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+		"-target=server-status",
+		"-metadata=ticket=INC-123",
+		"-metadata=reason=high latency",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"ticket": "INC-123",
+		"reason": "high latency",
+	}
+	if !reflect.DeepEqual(index.Metadata, expected) {
+		t.Fatalf("expected metadata %v, got: %v", expected, index.Metadata)
+	}
+}
+
+func TestDebugCommand_TimingChecks(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	cases := []struct {
+		name            string
+		duration        string
+		interval        string
+		metricsInterval string
+	}{
+		{
+			"short-values-all",
+			"10ms",
+			"10ms",
+			"10ms",
+		},
+		{
+			"short-duration",
+			"10ms",
+			"",
+			"",
+		},
+		{
+			"short-interval",
+			debugMinInterval.String(),
+			"10ms",
+			"",
+		},
+		{
+			"short-metrics-interval",
+			debugMinInterval.String(),
+			"",
+			"10ms",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			// If we are past the minimum duration + some grace, trigger shutdown
+			// to prevent hanging
+			grace := 10 * time.Second
+			shutdownCh := make(chan struct{})
+			go func() {
+				time.AfterFunc(grace, func() {
+					close(shutdownCh)
+				})
+			}()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+			cmd.cfg.skipTimingChecks = false
+			cmd.ShutdownCh = shutdownCh
+
+			basePath := tc.name
+			outputPath := filepath.Join(testDir, basePath)
+			args := []string{
+				"-compress=false",
+				fmt.Sprintf("-output=%s", outputPath),
+				"-target=server-status",
+			}
+			if tc.duration != "" {
+				args = append(args, fmt.Sprintf("-duration=%s", tc.duration))
+			}
+			if tc.interval != "" {
+				args = append(args, fmt.Sprintf("-interval=%s", tc.interval))
+			}
+			if tc.metricsInterval != "" {
+				args = append(args, fmt.Sprintf("-metrics-interval=%s", tc.metricsInterval))
+			}
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			if !strings.Contains(ui.OutputWriter.String(), "Duration: 5s") {
+				t.Fatal("expected minimum duration value")
+			}
+
+			if tc.interval != "" {
+				if !strings.Contains(ui.OutputWriter.String(), "  Interval: 5s") {
+					t.Fatal("expected minimum interval value")
+				}
+			}
+
+			if tc.metricsInterval != "" {
+				if !strings.Contains(ui.OutputWriter.String(), "Metrics Interval: 5s") {
+					t.Fatal("expected minimum metrics interval value")
+				}
+			}
+		})
+	}
+}
+
+// testDebugConcurrencyTransport is an http.RoundTripper that tracks the peak
+// number of requests in flight.
+type testDebugConcurrencyTransport struct {
+	l        sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (t *testDebugConcurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The server log stream is held open outside of the limit
+	if req.URL.Path == "/v1/sys/monitor" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+
+	t.l.Lock()
+	t.inFlight++
+	if t.inFlight > t.peak {
+		t.peak = t.inFlight
+	}
+	t.l.Unlock()
+
+	defer func() {
+		t.l.Lock()
+		t.inFlight--
+		t.l.Unlock()
+	}()
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestDebugCommand_MaxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold each request open so that concurrent requests overlap
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"data":{}}`))
+	}))
+	defer ts.Close()
+
+	transport := &testDebugConcurrencyTransport{}
+	client, err := api.NewClient(&api.Config{
+		Address:    ts.URL,
+		HttpClient: &http.Client{Transport: transport},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	limit := 2
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		"-metrics-interval=1s",
+		fmt.Sprintf("-max-concurrent-requests=%d", limit),
+		fmt.Sprintf("-output=%s/max_concurrent", testDir),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	if transport.peak == 0 {
+		t.Fatal("expected requests to be made")
+	}
+	if transport.peak > limit {
+		t.Fatalf("expected peak concurrency %d to not exceed %d", transport.peak, limit)
+	}
+}
+
+func TestDebugCommand_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var lock sync.Mutex
+	var requests []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, time.Now())
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"data":{}}`))
+	}))
+	defer ts.Close()
+
+	client, err := api.NewClient(&api.Config{
+		Address: ts.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.skipPreflight = true
+
+	limit := 4.0
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		"-target=config",
+		"-target=server-status",
+		fmt.Sprintf("-rate-limit=%g", limit),
+		fmt.Sprintf("-output=%s/rate_limit", testDir),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The preflight health check isn't paced, so it is skipped
+	if len(requests) < 4 {
+		t.Fatalf("expected at least 4 requests, got %d", len(requests))
+	}
+	minSpacing := time.Duration(float64(time.Second)/limit) - 50*time.Millisecond
+	for i := 2; i < len(requests); i++ {
+		if spacing := requests[i].Sub(requests[i-1]); spacing < minSpacing {
+			t.Fatalf("expected requests to be at least %s apart, requests %d and %d were %s apart", minSpacing, i-1, i, spacing)
+		}
+	}
+}
+
+func TestDebugCommand_MinFreeSpace(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		minFreeSpace string
+		expectedErr  string
+		exitCode     int
+	}{
+		{
+			"insufficient",
+			"1000000TiB",
+			"bytes are free on the filesystem",
+			1,
+		},
+		{
+			"sufficient",
+			"1KB",
+			"",
+			0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			var requests int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"data":{}}`))
+			}))
+			defer ts.Close()
+
+			client, err := api.NewClient(&api.Config{
+				Address: ts.URL,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			outputPath := filepath.Join(testDir, "output")
+			args := []string{
+				"-duration=1s",
+				"-target=config",
+				fmt.Sprintf("-min-free-space=%s", tc.minFreeSpace),
+				fmt.Sprintf("-output=%s", outputPath),
+				"-compress=false",
+			}
+
+			code := cmd.Run(args)
+			if code != tc.exitCode {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, tc.exitCode)
+			}
+
+			if tc.exitCode == 0 {
+				if _, err := os.Stat(filepath.Join(outputPath, "config.json")); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, tc.expectedErr) {
+				t.Fatalf("expected %q to contain %q", errOut, tc.expectedErr)
+			}
+			if n := atomic.LoadInt32(&requests); n != 0 {
+				t.Fatalf("expected no requests before failing, got %d", n)
+			}
+			if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+				t.Fatalf("expected output directory to not be created, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_RequestTimings(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var l sync.Mutex
+	var requests int
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		requests++
+		l.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{}}`))
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	basePath := "timings"
+	targets := []string{"config", "host", "metrics"}
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		"-metrics-interval=1s",
+		fmt.Sprintf("-output=%s/%s", testDir, basePath),
+		"-compress=false",
+		"-timings-csv",
+	}
+	for _, target := range targets {
+		args = append(args, fmt.Sprintf("-target=%s", target))
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, basePath, "request_timings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var timings []requestTiming
+	if err := json.Unmarshal(content, &timings); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(timings) != requests {
+		t.Fatalf("expected %d timing entries, got %d", requests, len(timings))
+	}
+	for _, timing := range timings {
+		if !strutil.StrListContains(targets, timing.Target) {
+			t.Fatalf("unexpected target %q in timing entry: %#v", timing.Target, timing)
+		}
+		if timing.Status != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, timing.Status)
+		}
+		if timing.Target == "config" && timing.Frame != debugStaticFrame {
+			t.Fatalf("expected static frame for config, got %d", timing.Frame)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(testDir, basePath, "request_timings.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHeader := []string{"frame", "target", "path", "status", "latency_ms"}
+	if len(records) == 0 || !reflect.DeepEqual(records[0], expectedHeader) {
+		t.Fatalf("expected header %v, got %v", expectedHeader, records)
+	}
+	if rows := len(records) - 1; rows != len(timings) {
+		t.Fatalf("expected one row per request (%d), got %d", len(timings), rows)
+	}
+	for i, record := range records[1:] {
+		if record[1] != timings[i].Target || record[2] != timings[i].Path || record[3] != "200" {
+			t.Fatalf("expected row %d to match %#v, got: %v", i, timings[i], record)
+		}
+	}
+}
+
+func TestDebugCommand_RunDeadline(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The host-info endpoint never responds until the request is abandoned
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "deadline")
+	args := []string{
+		"-duration=1s",
+		"-grace=1s",
+		"-target-timeout=1h",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-target=health",
+		"-target=host",
+	}
+
+	start := time.Now()
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the run to end at the deadline, took %s", elapsed)
+	}
+
+	files := testDebugArchiveFiles(t, outputPath+debugCompressionExt)
+	for _, expected := range []string{"deadline/index.json", "deadline/000/health.json"} {
+		if !strutil.StrListContains(files, expected) {
+			t.Fatalf("expected %s in the bundle, got: %v", expected, files)
+		}
+	}
+}
+
+// TestDebugCommand_LargeResponse is not run in parallel so that allocations
+// made by other tests don't count towards the measurement.
+func TestDebugCommand_LargeResponse(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The body is a single JSON string, so that it can be appended to the
+	// JSON lines file of the metrics as well
+	const bodySize = 64 * 1024 * 1024
+	chunk := bytes.Repeat([]byte("a"), 32*1024)
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"`))
+		for remaining := bodySize - 2; remaining > 0; remaining -= len(chunk) {
+			n := len(chunk)
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return
+			}
+		}
+		w.Write([]byte(`"`))
+	})
+	defer closer()
+
+	for _, format := range []string{"json", "jsonl"} {
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+
+		outputPath := filepath.Join(testDir, format)
+		args := []string{
+			"-duration=1s",
+			"-metrics-interval=1s",
+			"-target=metrics",
+			fmt.Sprintf("-format=%s", format),
+			fmt.Sprintf("-output=%s", outputPath),
+			"-compress=false",
+		}
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		code := cmd.Run(args)
+
+		runtime.ReadMemStats(&after)
+
+		if exp := 0; code != exp {
+			t.Log(ui.ErrorWriter.String())
+			t.Fatalf("%s: expected %d to be %d", format, code, exp)
+		}
+
+		info, err := os.Stat(filepath.Join(outputPath, "metrics", "000.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != bodySize {
+			t.Fatalf("%s: expected %d bytes to be written, got %d", format, bodySize, info.Size())
+		}
+
+		// The record holds the whole body on a single line
+		if format == "jsonl" {
+			info, err := os.Stat(filepath.Join(outputPath, "metrics.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() < bodySize {
+				t.Fatalf("expected the record to hold the %d bytes of the body, got %d", bodySize, info.Size())
+			}
+			f, err := os.Open(filepath.Join(outputPath, "metrics.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var record debugStreamRecord
+			err = json.NewDecoder(f).Decode(&record)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Path != "metrics/000.json" || len(record.Data) != bodySize {
+				t.Fatalf("expected a record of metrics/000.json with %d bytes of data, got %s with %d", bodySize, record.Path, len(record.Data))
+			}
+		}
+
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > bodySize/4 {
+			t.Fatalf("%s: expected allocations to stay well below the body size of %d bytes, got %d", format, bodySize, allocated)
+		}
+	}
+}
+
+func TestDebugCommand_FollowActive(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Each node reports which node served the request
+	nodeHandler := func(node string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"data":{"node":%q}}`, node)
+		}
+	}
+
+	activeClient, activeCloser := testDebugStubServer(t, nodeHandler("active"))
+	defer activeCloser()
+
+	standbyHandler := nodeHandler("standby")
+	standbyClient, standbyCloser := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/sys/leader" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"ha_enabled":true,"is_self":false,"leader_address":%q}`, activeClient.Address())
+			return
+		}
+		standbyHandler(w, r)
+	})
+	defer standbyCloser()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = standbyClient
+
+	outputPath := filepath.Join(testDir, "follow-active")
+	args := []string{
+		"-duration=1s",
+		"-follow-active",
+		"-target=mounts",
+		"-target=host",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	expected := map[string]string{
+		"mounts.json":        "active",
+		"000/host_info.json": "standby",
+	}
+	for file, node := range expected {
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), fmt.Sprintf(`"node": %q`, node)) {
+			t.Fatalf("expected %s to be captured from the %s node: %s", file, node, content)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index DebugIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.ActiveAddress != activeClient.Address() {
+		t.Fatalf("expected active address %q, got %q", activeClient.Address(), index.ActiveAddress)
+	}
+}
+
+func TestDebugCommand_Standby(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The health status reports a performance standby
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":true,"performance_standby":true,"version":"1.4.0"}`))
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+		cmd.skipPreflight = true
+
+		outputPath := filepath.Join(testDir, "standby")
+		args := []string{
+			"-duration=1s",
+			"-target=config",
+			fmt.Sprintf("-output=%s", outputPath),
+			"-compress=false",
+		}
+
+		code := cmd.Run(args)
+		if exp := 0; code != exp {
+			t.Log(ui.ErrorWriter.String())
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "is a standby node") {
+			t.Fatalf("expected standby warning, got: %s", ui.ErrorWriter.String())
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index DebugIndex
+		if err := json.Unmarshal(content, &index); err != nil {
+			t.Fatal(err)
+		}
+		if !index.CapturedAgainstStandby {
+			t.Fatalf("expected index to record the capture against a standby: %s", content)
+		}
+	})
+
+	t.Run("require_active", func(t *testing.T) {
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+		cmd.skipPreflight = true
+
+		outputPath := filepath.Join(testDir, "require-active")
+		args := []string{
+			"-duration=1s",
+			"-target=config",
+			"-require-active",
+			fmt.Sprintf("-output=%s", outputPath),
+			"-compress=false",
+		}
+
+		code := cmd.Run(args)
+		if exp := 1; code != exp {
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "-require-active is set") {
+			t.Fatalf("expected require-active error, got: %s", ui.ErrorWriter.String())
+		}
+		if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+			t.Fatalf("expected no output to be written, got: %v", err)
+		}
+	})
+}
+
+func TestDebugCommand_TargetTimeout(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The host-info endpoint never responds within the target timeout
+	stopCh := make(chan struct{})
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stopCh:
+		case <-time.After(5 * time.Second):
+		}
+	})
+	defer closer()
+	defer close(stopCh)
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "timeout")
+	args := []string{
+		"-duration=1s",
+		"-target-timeout=100ms",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+		"-target=host",
+	}
+
+	// The only target fails, so the command signals that nothing was captured
+	start := time.Now()
+	code := cmd.Run(args)
+	if exp := 2; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected the frame to complete within the timeout, took %s", elapsed)
+	}
+
+	combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+	if !strings.Contains(combined, "Error capturing host on frame 0") || !strings.Contains(combined, "deadline exceeded") {
+		t.Fatalf("expected a recorded timeout error, got: %s", combined)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Errors) != 1 || index.Errors[0].Target != "host" || !strings.Contains(index.Errors[0].TargetError, "deadline exceeded") {
+		t.Fatalf("expected a recorded timeout error in the index, got: %v", index.Errors)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputPath, "000", "host_info.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected no host info to be written, got: %v", err)
+	}
+}
+
+func TestDebugCommand_CaptureErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		targets []string
+		code    int
+	}{
+		{
+			"partial-failure",
+			[]string{"host", "replication-status"},
+			0,
+		},
+		{
+			"total-failure",
+			[]string{"replication-status"},
+			2,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			// Replication status always fails, while host info succeeds
+			client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v1/sys/host-info" {
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(`{"data":{"host":{}}}`))
+					return
+				}
+				http.Error(w, `{"errors":["replication unavailable"]}`, http.StatusInternalServerError)
+			})
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			outputPath := filepath.Join(testDir, tc.name)
+			args := []string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s", outputPath),
+				"-compress=false",
+			}
+			for _, target := range tc.targets {
+				args = append(args, fmt.Sprintf("-target=%s", target))
+			}
+
+			code := cmd.Run(args)
+			if code != tc.code {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, tc.code)
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			index := &DebugIndex{}
+			if err := json.Unmarshal(content, index); err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Errors) != 1 {
+				t.Fatalf("expected 1 error in the index, got: %v", index.Errors)
+			}
+
+			captureErr := index.Errors[0]
+			if captureErr.Target != "replication-status" || captureErr.Frame != 0 {
+				t.Fatalf("unexpected error entry: %#v", captureErr)
+			}
+			if !strings.Contains(captureErr.TargetError, "replication unavailable") || captureErr.Timestamp.IsZero() {
+				t.Fatalf("unexpected error entry: %#v", captureErr)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_NoConnection(t *testing.T) {
+	t.Parallel()
+
+	client, err := api.NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.SetAddress(""); err != nil {
+		t.Fatal(err)
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	args := []string{
+		"-duration=1s",
+		"-target=server-status",
+	}
+
+	code := cmd.Run(args)
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	// The run fails in preflight, before any frame is captured
+	if !strings.Contains(ui.ErrorWriter.String(), "pre-flight check failed: unable to reach") {
+		t.Fatalf("expected pre-flight connection error, got: %s", ui.ErrorWriter.String())
+	}
+	if cmd.debugIndex != nil {
+		t.Fatal("expected the run to fail before the index is created")
+	}
+}
+
+func TestDebugCommand_PreflightToken(t *testing.T) {
+	t.Parallel()
+
+	client, closer := testVaultServer(t)
+	defer closer()
+	client.SetToken("invalid-token")
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	args := []string{
+		"-duration=1s",
+		"-target=server-status",
+	}
+
+	code := cmd.Run(args)
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "permission denied looking up the token") {
+		t.Fatalf("expected pre-flight permission error, got: %s", ui.ErrorWriter.String())
+	}
+}
+
+func TestDebugCommand_OutputExists(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		compress      bool
+		outputFile    string
+		expectedError string
+	}{
+		{
+			"no-compress",
+			false,
+			"output-exists",
+			"output directory already exists",
+		},
+		{
+			"compress",
+			true,
+			"output-exist.tar.gz",
+			"output file already exists",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			// Create a pre-existing file or directory
+			outputPath := filepath.Join(testDir, tc.outputFile)
+			if tc.compress {
+				_, err = os.Create(outputPath)
+			} else {
+				err = os.Mkdir(outputPath, 0755)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args := []string{
+				fmt.Sprintf("-compress=%t", tc.compress),
+				"-duration=1s",
+				fmt.Sprintf("-output=%s", outputPath),
+				"-target=server-status",
+			}
+
+			code := cmd.Run(args)
+			if exp := 1; code != exp {
+				t.Log(ui.OutputWriter.String())
+				t.Log(ui.ErrorWriter.String())
+				t.Errorf("expected %d to be %d", code, exp)
+			}
+
+			output := ui.ErrorWriter.String() + ui.OutputWriter.String()
+			if !strings.Contains(output, tc.expectedError) {
+				t.Fatalf("expected %s, got: %s", tc.expectedError, output)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_Upload(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		method     string
+		statusCode int
+		expectedRC int
+	}{
+		{
+			"put",
+			"PUT",
+			http.StatusOK,
+			0,
+		},
+		{
+			"post",
+			"POST",
+			http.StatusCreated,
+			0,
+		},
+		{
+			"failure",
+			"PUT",
+			http.StatusInternalServerError,
+			1,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			var lock sync.Mutex
+			var body []byte
+			var method, contentType, authHeader string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				method = r.Method
+				contentType = r.Header.Get("Content-Type")
+				authHeader = r.Header.Get("Authorization")
+				body, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(tc.statusCode)
+			}))
+			defer ts.Close()
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			basePath := tc.name
+			args := []string{
+				"-duration=1s",
+				"-target=config",
+				fmt.Sprintf("-output=%s/%s", testDir, basePath),
+				fmt.Sprintf("-upload-url=%s/bundles", ts.URL),
+				fmt.Sprintf("-upload-method=%s", tc.method),
+				"-upload-header=Authorization=Bearer test",
+			}
+
+			code := cmd.Run(args)
+			if code != tc.expectedRC {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, tc.expectedRC)
+			}
+
+			// The local bundle is preserved regardless of the outcome
+			bundlePath := filepath.Join(testDir, basePath+debugCompressionExt)
+			if _, err := os.Stat(bundlePath); err != nil {
+				t.Fatalf("expected local bundle to be preserved: %s", err)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if method != tc.method {
+				t.Fatalf("expected method %s, got %s", tc.method, method)
+			}
+			if contentType != "application/gzip" {
+				t.Fatalf("expected content type application/gzip, got %s", contentType)
+			}
+			if authHeader != "Bearer test" {
+				t.Fatalf("expected authorization header to be set, got %q", authHeader)
+			}
+
+			// Ensure the body received round-trips as a valid archive
+			uploadPath := filepath.Join(testDir, "uploaded"+debugCompressionExt)
+			if err := ioutil.WriteFile(uploadPath, body, 0644); err != nil {
+				t.Fatal(err)
+			}
+			files := testDebugArchiveFiles(t, uploadPath)
+			if !strutil.StrListContains(files, filepath.Join(basePath, "index.json")) {
+				t.Fatalf("expected index.json in uploaded archive, got: %v", files)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_MetricsCSV(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var lock sync.Mutex
+	var goroutines int
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		lock.Lock()
+		goroutines += 10
+		value := goroutines
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Timestamp":"%s","Gauges":[{"Name":"vault.runtime.num_goroutines","Value":%d,"Labels":{}}],"Counters":[{"Name":"vault.core.handle_request","Count":1,"Sum":1,"Labels":{}}]}`, time.Now().UTC(), value)
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "metrics-csv")
+	args := []string{
+		"-duration=3s",
+		"-metrics-interval=1s",
+		"-target=metrics",
+		"-metrics-csv",
+		"-metrics-csv-keys=vault.runtime.num_goroutines",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	metricsFiles, err := filepath.Glob(filepath.Join(outputPath, "metrics", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(outputPath, "metrics.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedHeader := []string{"timestamp", "vault.runtime.num_goroutines"}
+	if len(records) == 0 || !reflect.DeepEqual(records[0], expectedHeader) {
+		t.Fatalf("expected header %v, got %v", expectedHeader, records)
+	}
+	if rows := len(records) - 1; rows != len(metricsFiles) || rows != 3 {
+		t.Fatalf("expected one row per metrics capture (%d), got %d", len(metricsFiles), rows)
+	}
+	for _, record := range records[1:] {
+		if record[0] == "" || record[1] == "" {
+			t.Fatalf("expected row to be populated: %v", record)
+		}
+	}
+}
+
+func TestDebugCommand_MetricsDelta(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	if err := os.MkdirAll(filepath.Join(testDir, "metrics"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	frames := []string{
+		`{"Timestamp":"2020-01-01 00:00:00 +0000 UTC","Gauges":[{"Name":"vault.runtime.num_goroutines","Value":40,"Labels":{}}],"Counters":[{"Name":"vault.core.handle_request","Count":4,"Sum":4,"Labels":{}},{"Name":"vault.route.read","Count":1,"Sum":1,"Labels":{"mount":"secret"}}]}`,
+		`{"Timestamp":"2020-01-01 00:00:10 +0000 UTC","Gauges":[{"Name":"vault.runtime.num_goroutines","Value":55,"Labels":{}}],"Counters":[{"Name":"vault.core.handle_request","Count":29,"Sum":29,"Labels":{}},{"Name":"vault.core.check_token","Count":3,"Sum":3,"Labels":{}}]}`,
+	}
+	for i, frame := range frames {
+		if err := ioutil.WriteFile(filepath.Join(testDir, "metrics", fmt.Sprintf("%03d.json", i)), []byte(frame), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, cmd := testDebugCommand(t)
+	cmd.cfg.Output = testDir
+	cmd.cfg.MetricsInterval = 10 * time.Second
+	cmd.debugIndex = &DebugIndex{}
+	if err := cmd.writeMetricsDelta(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, "metrics_delta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		IntervalSeconds float64             `json:"interval_seconds"`
+		Deltas          []debugMetricsDelta `json:"deltas"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.IntervalSeconds != 10 || len(result.Deltas) != 1 {
+		t.Fatalf("expected a single delta over a 10s interval, got: %s", content)
+	}
+	delta := result.Deltas[0]
+	if delta.From != 0 || delta.To != 1 {
+		t.Fatalf("expected delta between captures 0 and 1, got: %d and %d", delta.From, delta.To)
+	}
+
+	// Counters missing from either capture have no delta
+	expected := map[string]debugCounterDelta{
+		"vault.core.handle_request": {Delta: 25, RatePerSecond: 2.5},
+	}
+	if !reflect.DeepEqual(delta.Counters, expected) {
+		t.Fatalf("expected counters %v, got: %v", expected, delta.Counters)
+	}
+	if !reflect.DeepEqual(delta.Gauges, map[string]float64{"vault.runtime.num_goroutines": 55}) {
+		t.Fatalf("expected gauges to be passed through, got: %v", delta.Gauges)
+	}
+}
+
+func TestDebugCommand_SealTiming(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	if err := os.MkdirAll(filepath.Join(testDir, "metrics"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	frames := []string{
+		`{"Timestamp":"2020-01-01 00:00:00 +0000 UTC","Gauges":[],"Counters":[],"Samples":[{"Name":"vault.barrier.get","Count":2,"Sum":3,"Min":1,"Max":2,"Mean":1.5,"Labels":{}},{"Name":"vault.core.handle_request","Count":5,"Sum":10,"Min":1,"Max":4,"Mean":2,"Labels":{}}]}`,
+		`{"Timestamp":"2020-01-01 00:00:10 +0000 UTC","Gauges":[],"Counters":[],"Samples":[{"Name":"vault.barrier.get","Count":4,"Sum":21,"Min":0.5,"Max":9,"Mean":5.25,"Labels":{}},{"Name":"vault.core.unseal","Count":0,"Sum":0,"Min":0,"Max":0,"Mean":0,"Labels":{}}]}`,
+	}
+	for i, frame := range frames {
+		if err := ioutil.WriteFile(filepath.Join(testDir, "metrics", fmt.Sprintf("%03d.json", i)), []byte(frame), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, cmd := testDebugCommand(t)
+	cmd.cfg.Output = testDir
+	cmd.cfg.Targets = []string{"metrics", "seal-timing"}
+	cmd.debugIndex = &DebugIndex{}
+	if err := cmd.writeSealTiming(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, "seal_timing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Metrics map[string]debugSealTiming `json:"metrics"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unrelated timers and timers without samples are left out
+	expected := map[string]debugSealTiming{
+		"vault.barrier.get": {Captures: 2, Count: 6, Min: 0.5, Max: 9, Mean: 4},
+	}
+	if !reflect.DeepEqual(result.Metrics, expected) {
+		t.Fatalf("expected seal timing %v, got: %s", expected, content)
+	}
+}
+
+func TestDebugCommand_HotPaths(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	if err := os.MkdirAll(filepath.Join(testDir, "metrics"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	frames := []string{
+		`{"Timestamp":"2020-01-01 00:00:00 +0000 UTC","Gauges":[],"Counters":[{"Name":"vault.requests","Count":9,"Sum":9,"Labels":{"path":"sys/health"}}],"Samples":[{"Name":"vault.route.read.secret-","Count":30,"Sum":15,"Labels":{}},{"Name":"vault.route.create.pki-","Count":2,"Sum":4,"Labels":{}},{"Name":"vault.core.handle_request","Count":50,"Sum":20,"Labels":{}}]}`,
+		`{"Timestamp":"2020-01-01 00:00:10 +0000 UTC","Gauges":[],"Counters":[{"Name":"vault.requests","Count":11,"Sum":11,"Labels":{"path":"sys/health"}}],"Samples":[{"Name":"vault.route.read.secret-","Count":20,"Sum":10,"Labels":{}}]}`,
+	}
+	for i, frame := range frames {
+		if err := ioutil.WriteFile(filepath.Join(testDir, "metrics", fmt.Sprintf("%03d.json", i)), []byte(frame), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, cmd := testDebugCommand(t)
+	cmd.cfg.Output = testDir
+	cmd.cfg.Targets = []string{"metrics", "hot-paths"}
+	cmd.cfg.MetricsInterval = 10 * time.Second
+	cmd.cfg.HotPathsN = 2
+	cmd.debugIndex = &DebugIndex{}
+	if err := cmd.writeHotPaths(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, "hot_paths.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Captures int            `json:"captures"`
+		Paths    []debugHotPath `json:"paths"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests that aren't attributed to a path aren't counted, and only the
+	// busiest paths are listed
+	expected := []debugHotPath{
+		{Path: "vault.route.read.secret-", Count: 50, RatePerSecond: 2.5},
+		{Path: "vault.requests;path=sys/health", Count: 20, RatePerSecond: 1},
+	}
+	if result.Captures != 2 || !reflect.DeepEqual(result.Paths, expected) {
+		t.Fatalf("expected hot paths %v, got: %s", expected, content)
+	}
+}
+
+func TestDebugCommand_PrometheusMetrics(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	const prometheusMetrics = `# TYPE vault_core_handle_request summary
+vault_core_handle_request{quantile="0.5"} 0.25
+vault_core_handle_request_sum 1
+vault_core_handle_request_count 4
+`
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "prometheus" {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			w.Write([]byte(prometheusMetrics))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Gauges":[{"Name":"vault.runtime.num_goroutines","Value":42,"Labels":{}}],"Counters":[]}`))
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "prometheus")
+	args := []string{
+		"-duration=2s",
+		"-metrics-interval=1s",
+		"-target=metrics",
+		"-prometheus-metrics",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	for idx := 0; idx < 2; idx++ {
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, "metrics", fmt.Sprintf("%03d.json", idx)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "vault.runtime.num_goroutines") {
+			t.Fatalf("expected JSON metrics in capture %d, got: %s", idx, content)
+		}
+
+		content, err = ioutil.ReadFile(filepath.Join(outputPath, "metrics_prometheus", fmt.Sprintf("%03d.prom", idx)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != prometheusMetrics {
+			t.Fatalf("expected Prometheus metrics in capture %d, got: %s", idx, content)
+		}
+	}
+}
+
+func TestDebugCommand_Count(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "count")
+	args := []string{
+		"-count=3",
+		"-interval=1s",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	entries, err := ioutil.ReadDir(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frameDirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			frameDirs = append(frameDirs, entry.Name())
+		}
+	}
+
+	expected := []string{"000", "001", "002"}
+	if !reflect.DeepEqual(frameDirs, expected) {
+		t.Fatalf("expected frame directories %v, got %v", expected, frameDirs)
+	}
+}
+
+func TestDebugCommand_Report(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	args := []string{
+		"-count=2",
+		"-interval=1s",
+		"-target=config",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s/report", testDir),
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	// The report is written to stderr
+	report := ui.ErrorWriter.String()
+	if !strings.Contains(report, "2 frame(s)") {
+		t.Fatalf("expected report to contain the frame count: %s", report)
+	}
+	if !strings.Contains(report, "2 of 2 target(s) captured") {
+		t.Fatalf("expected report to contain the number of targets captured: %s", report)
+	}
+
+	matches := regexp.MustCompile(`(\d+) bytes on disk`).FindStringSubmatch(report)
+	if matches == nil || matches[1] == "0" {
+		t.Fatalf("expected report to contain the bundle size: %s", report)
+	}
+
+	info, err := os.Stat(filepath.Join(testDir, "report"+debugCompressionExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := fmt.Sprintf("%d", info.Size()); matches[1] != exp {
+		t.Fatalf("expected bundle size %s, got %s", exp, matches[1])
+	}
+}
+
+func TestDebugCommand_Proxy(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	// The proxy records every CONNECT and tunnels it to the target
+	var lock sync.Mutex
+	var connects []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		lock.Lock()
+		connects = append(connects, r.Host)
+		lock.Unlock()
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		done := make(chan struct{}, 2)
+		go func() {
+			io.Copy(upstream, conn)
+			done <- struct{}{}
+		}()
+		go func() {
+			io.Copy(conn, upstream)
+			done <- struct{}{}
+		}()
+		<-done
+	}))
+	defer proxy.Close()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "proxy")
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		fmt.Sprintf("-proxy=%s", proxy.URL),
+		"-tls-skip-verify",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputPath, "config.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	serverHost := strings.TrimPrefix(client.Address(), "https://")
+	if len(connects) == 0 {
+		t.Fatal("expected requests to flow through the proxy")
+	}
+	for _, host := range connects {
+		if host != serverHost {
+			t.Fatalf("expected CONNECT to %s, got %s", serverHost, host)
+		}
+	}
+}
+
+func TestDebugCommand_UnixSocket(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	socket := filepath.Join(testDir, "vault.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.4.0"}`))
+		case "/v1/auth/token/lookup-self", "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	ts.Listener.Close()
+	ts.Listener = listener
+	ts.Start()
+	defer ts.Close()
+
+	ui, cmd := testDebugCommand(t)
+
+	// The TLS flags are ignored, so the missing CA certificate is not an error
+	outputPath := filepath.Join(testDir, "unix")
+	args := []string{
+		"-address=unix://" + socket,
+		"-ca-cert=" + filepath.Join(testDir, "missing.pem"),
+		"-insecure",
+		"-duration=1s",
+		"-target=config",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "TLS flags are ignored") {
+		t.Fatalf("expected warning, got: %s", ui.ErrorWriter.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(outputPath, "config.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index DebugIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.VaultAddress != "unix://"+socket {
+		t.Fatalf("expected address unix://%s, got: %s", socket, index.VaultAddress)
+	}
+	if index.TLSVerificationSkipped {
+		t.Fatal("expected TLS verification not to be recorded as skipped")
+	}
+	if len(index.Errors) != 0 {
+		t.Fatalf("expected no errors, got: %#v", index.Errors)
+	}
+
+	// A server on a unix socket runs on the same machine
+	if !cmd.localServer() {
+		t.Fatal("expected a server on a unix socket to be local")
+	}
+}
+
+func TestDebugCommand_Namespace(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var l sync.Mutex
+	hostNamespaces := map[string]bool{}
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ns := r.Header.Get(consts.NamespaceHeaderName)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/mounts":
+			if ns == "ns1/" {
+				w.Write([]byte(`{"data":{"team-kv/":{"type":"kv"}}}`))
+				return
+			}
+			w.Write([]byte(`{"data":{"secret/":{"type":"kv"},"sys/":{"type":"system"}}}`))
+		case "/v1/sys/host-info":
+			l.Lock()
+			hostNamespaces[ns] = true
+			l.Unlock()
+			w.Write([]byte(`{"data":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+	client.SetNamespace("ns1/")
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "namespace")
+	args := []string{
+		"-duration=1s",
+		"-target=mounts",
+		"-target=host",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "mounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mounts struct {
+		Mounts map[string]interface{} `json:"mounts"`
+	}
+	if err := json.Unmarshal(content, &mounts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mounts.Mounts["team-kv/"]; !ok || len(mounts.Mounts) != 1 {
+		t.Fatalf("expected only the mounts of the namespace, got: %v", mounts.Mounts)
+	}
+
+	// The host target is global, so it is never scoped to the namespace
+	if !reflect.DeepEqual(hostNamespaces, map[string]bool{"": true}) {
+		t.Fatalf("expected host info to be requested without the namespace, got: %v", hostNamespaces)
+	}
+	if client.Headers().Get(consts.NamespaceHeaderName) != "ns1/" {
+		t.Fatal("expected the namespace of the client to be left untouched")
+	}
+
+	if cmd.debugIndex.Namespace != "ns1/" {
+		t.Fatalf("expected namespace to be recorded in the index, got: %q", cmd.debugIndex.Namespace)
+	}
+}
+
+func TestDebugCommand_Insecure(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The test server presents a self-signed certificate
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false,"version":"1.4.0"}`))
+		case "/v1/auth/token/lookup-self", "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	for _, insecure := range []bool{false, true} {
+		client, err := api.NewClient(&api.Config{
+			Address: ts.URL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+
+		outputPath := filepath.Join(testDir, fmt.Sprintf("insecure-%t", insecure))
+		args := []string{
+			"-duration=1s",
+			"-target=config",
+			fmt.Sprintf("-insecure=%t", insecure),
+			fmt.Sprintf("-output=%s", outputPath),
+			"-compress=false",
+		}
+
+		code := cmd.Run(args)
+		if !insecure {
+			if exp := 1; code != exp {
+				t.Fatalf("expected %d to be %d without -insecure", code, exp)
+			}
+			if !strings.Contains(ui.ErrorWriter.String(), "certificate") {
+				t.Fatalf("expected certificate error, got: %s", ui.ErrorWriter.String())
+			}
+			continue
+		}
+
+		if exp := 0; code != exp {
+			t.Log(ui.ErrorWriter.String())
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+		if !strings.Contains(ui.ErrorWriter.String(), "TLS verification of the server's certificate is disabled") {
+			t.Fatalf("expected warning, got: %s", ui.ErrorWriter.String())
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index DebugIndex
+		if err := json.Unmarshal(content, &index); err != nil {
+			t.Fatal(err)
+		}
+		if !index.TLSVerificationSkipped {
+			t.Fatal("expected TLS verification to be recorded as skipped")
+		}
+		if len(index.Errors) != 0 {
+			t.Fatalf("expected no errors, got: %#v", index.Errors)
+		}
+	}
+}
+
+func TestDebugCommand_Storage(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, _, closer := testVaultServerCoreConfig(t, &vault.CoreConfig{
+		DisableMlock:       true,
+		DisableCache:       true,
+		Logger:             defaultVaultLogger,
+		CredentialBackends: defaultVaultCredentialBackends,
+		AuditBackends:      defaultVaultAuditBackends,
+		LogicalBackends:    defaultVaultLogicalBackends,
+		RawConfig: &server.Config{
+			Storage: &server.Storage{
+				Type:         "inmem",
+				RedirectAddr: "https://vault.example.com:8200",
+				Config: map[string]string{
+					"secret_key": "hunter2",
+				},
+			},
+		},
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "storage")
+	args := []string{
+		"-duration=1s",
+		"-target=storage",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "storage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "hunter2") {
+		t.Fatalf("expected backend configuration to be omitted: %s", content)
+	}
+
+	var entry struct {
+		Storage map[string]interface{} `json:"storage"`
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Storage["type"] != "inmem" {
+		t.Fatalf("expected storage type inmem, got: %s", content)
+	}
+}
+
+func TestDebugCommand_ReplicationPerf(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Performance replication is enabled while DR replication is disabled
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/replication/performance/status":
+			w.Write([]byte(`{"data":{"mode":"primary","state":"running","last_wal":42,"merkle_root":"abc"}}`))
+		case "/v1/sys/replication/dr/status":
+			w.Write([]byte(`{"data":{"mode":"disabled"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "replication-perf")
+	args := []string{
+		"-duration=1s",
+		"-target=replication-perf",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "replication_performance.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status map[string]interface{}
+	if err := json.Unmarshal(content, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status["last_wal"] != float64(42) {
+		t.Fatalf("expected last_wal to be captured, got: %s", content)
+	}
+
+	note, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "replication_dr.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(note), "disabled") {
+		t.Fatalf("expected note to explain that DR replication is disabled, got: %s", note)
+	}
+}
+
+func TestDebugCommand_GoroutineCount(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		metrics bool
+		source  string
+	}{
+		{"metrics", true, "metrics"},
+		{"pprof_fallback", false, "pprof"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/v1/sys/metrics" && tc.metrics:
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(`{"Gauges":[{"Name":"vault.runtime.num_goroutines","Value":42,"Labels":{}}],"Counters":[]}`))
+				case r.URL.Path == "/v1/sys/pprof/goroutine" && r.URL.Query().Get("debug") == "1":
+					w.Write([]byte("goroutine profile: total 42\n1 @ 0x1\n"))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			defer closer()
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+
+			outputPath := filepath.Join(testDir, "goroutines")
+			args := []string{
+				"-duration=2s",
+				"-interval=1s",
+				"-target=goroutine-count",
+				fmt.Sprintf("-output=%s", outputPath),
+				"-compress=false",
+			}
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, "goroutine_counts.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var counts []goroutineCount
+			if err := json.Unmarshal(content, &counts); err != nil {
+				t.Fatal(err)
+			}
+
+			frames := cmd.debugIndex.Frames
+			if len(frames) < 2 || len(counts) != len(frames) {
+				t.Fatalf("expected one entry per frame (%d), got: %s", len(frames), content)
+			}
+			for i, count := range counts {
+				if count.Frame != i || count.Count != 42 || count.Source != tc.source {
+					t.Fatalf("unexpected entry %d: %#v", i, count)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugCommand_ReplicationProgress(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	_, cmd := testDebugCommand(t)
+	cmd.cfg.Output = testDir
+	cmd.debugIndex = &DebugIndex{}
+
+	// The DR secondary catches up while the performance secondary falls
+	// behind, and frame 002 failed to capture the status
+	statuses := []string{
+		`{"dr":{"mode":"secondary","last_wal":100,"last_remote_wal":40},"performance":{"mode":"secondary","last_wal":100,"last_remote_wal":95}}`,
+		`{"dr":{"mode":"secondary","last_wal":120,"last_remote_wal":90},"performance":{"mode":"secondary","last_wal":120,"last_remote_wal":100}}`,
+		"",
+		`{"dr":{"mode":"secondary","last_wal":130,"last_remote_wal":125},"performance":{"mode":"secondary","last_wal":200,"last_remote_wal":150}}`,
+	}
+	start := time.Now().UTC()
+	for i, status := range statuses {
+		dir := fmt.Sprintf("%03d", i)
+		if err := os.MkdirAll(filepath.Join(testDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		cmd.debugIndex.Frames = append(cmd.debugIndex.Frames, DebugFrame{
+			Frame:     i,
+			Directory: dir,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		})
+		if status == "" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(testDir, dir, "replication_status.json"), []byte(status), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cmd.writeReplicationProgress(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, "replication_progress.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress map[string]*debugReplicationProgress
+	if err := json.Unmarshal(content, &progress); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]struct {
+		lag       uint64
+		lagChange int64
+		trend     string
+	}{
+		"dr":          {5, -55, "shrinking"},
+		"performance": {50, 45, "growing"},
+	}
+	for name, exp := range expected {
+		p, ok := progress[name]
+		if !ok {
+			t.Fatalf("expected %s progress, got: %s", name, content)
+		}
+		if len(p.Samples) != 3 {
+			t.Fatalf("expected 3 %s samples, got: %#v", name, p.Samples)
+		}
+		if p.Lag != exp.lag || p.LagChange != exp.lagChange || p.Trend != exp.trend {
+			t.Fatalf("expected %s lag %d, change %d, and trend %q, got: %#v", name, exp.lag, exp.lagChange, exp.trend, p)
+		}
+	}
+	if p := progress["performance"]; p.SyncPercent != 75 {
+		t.Fatalf("expected performance to be 75%% synced, got: %v", p.SyncPercent)
+	}
+
+	// Without WAL positions, such as when replication is disabled, nothing
+	// is written
+	emptyDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(emptyDir)
+
+	cmd.cfg.Output = emptyDir
+	cmd.debugIndex.Frames = []DebugFrame{{Frame: 0, Directory: "000"}}
+	if err := os.MkdirAll(filepath.Join(emptyDir, "000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(emptyDir, "000", "replication_status.json"), []byte(`{"dr":{"mode":"disabled"},"performance":{"mode":"disabled"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.writeReplicationProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(emptyDir, "replication_progress.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected no replication progress to be written, got: %v", err)
+	}
+}
+
+func TestDebugCommand_Entropy(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/sealwrap/rewrap":
+			w.Write([]byte(`{"data":{"is_running":false}}`))
+		case "/v1/sys/config/state/sanitized":
+			w.Write([]byte(`{"data":{"disable_sealwrap":false,"seals":[{"type":"pkcs11","disabled":false}],"listeners":[{"type":"tcp"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+
+	outputPath := filepath.Join(testDir, "entropy")
+	args := []string{
+		"-duration=1s",
+		"-target=entropy",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "sealwrap_status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status struct {
+		Rewrap map[string]interface{} `json:"rewrap"`
+		Config map[string]interface{} `json:"config"`
+	}
+	if err := json.Unmarshal(content, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Rewrap["is_running"] != false {
+		t.Fatalf("expected rewrap status to be captured, got: %s", content)
+	}
+	if _, ok := status.Config["seals"]; !ok {
+		t.Fatalf("expected seal configuration to be captured, got: %s", content)
+	}
+	if _, ok := status.Config["listeners"]; ok {
+		t.Fatalf("expected unrelated configuration to be omitted, got: %s", content)
+	}
+}
+
+func TestDebugLoopbackAddress(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]bool{
+		"http://127.0.0.1:8200":         true,
+		"https://localhost:8200":        true,
+		"http://[::1]:8200":             true,
+		"http://127.1.2.3:8200":         true,
+		"https://10.0.0.5:8200":         false,
+		"https://vault.example.com":     false,
+		"https://localhost.example.com": false,
+		"://invalid":                    false,
+	}
+	for address, expected := range cases {
+		if actual := debugLoopbackAddress(address); actual != expected {
+			t.Fatalf("expected %s to be loopback %t, got: %t", address, expected, actual)
+		}
+	}
+}
+
+func TestDebugCommand_ContainerLimits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		files  map[string]string
+		remote bool
+		limits *containerLimits
+	}{
+		{
+			"cgroup_v2",
+			map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"memory.max":         "536870912\n",
+				"cpu.max":            "150000 100000\n",
+			},
+			false,
+			&containerLimits{
+				CgroupVersion:    2,
+				MemoryLimitBytes: 536870912,
+				CPUQuotaUS:       150000,
+				CPUPeriodUS:      100000,
+				CPUs:             1.5,
+			},
+		},
+		{
+			"cgroup_v1",
+			map[string]string{
+				"memory/memory.limit_in_bytes": "1073741824\n",
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+			},
+			false,
+			&containerLimits{
+				CgroupVersion:    1,
+				MemoryLimitBytes: 1073741824,
+			},
+		},
+		{
+			"not_containerized",
+			map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"memory.max":         "max\n",
+				"cpu.max":            "max 100000\n",
+			},
+			false,
+			nil,
+		},
+		{
+			// The limits of the machine running the command are not those
+			// of a remote server
+			"remote",
+			map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"memory.max":         "536870912\n",
+				"cpu.max":            "150000 100000\n",
+			},
+			true,
+			nil,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			cgroupRoot := filepath.Join(testDir, "cgroup")
+			for name, content := range tc.files {
+				path := filepath.Join(cgroupRoot, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/v1/sys/host-info":
+					w.Write([]byte(`{"data":{"host":{"hostname":"vault-0"},"memory":{"total":8589934592}}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			defer closer()
+
+			if tc.remote {
+				client = testDebugRemoteClient(t, client)
+			}
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+			cmd.cgroupRoot = cgroupRoot
+
+			outputPath := filepath.Join(testDir, "output")
+			args := []string{
+				"-duration=1s",
+				"-target=host",
+				fmt.Sprintf("-output=%s", outputPath),
+				"-compress=false",
+			}
+
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "host_info.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var hostInfo struct {
+				Host            map[string]interface{} `json:"host"`
+				ContainerLimits *containerLimits       `json:"container_limits"`
+			}
+			if err := json.Unmarshal(content, &hostInfo); err != nil {
+				t.Fatal(err)
+			}
+			if hostInfo.Host["hostname"] != "vault-0" {
+				t.Fatalf("expected host information to be captured, got: %s", content)
+			}
+			if !reflect.DeepEqual(hostInfo.ContainerLimits, tc.limits) {
+				t.Fatalf("expected container limits %#v, got: %s", tc.limits, content)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_ProcessEnv(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// Only the vault server process should be read
+	procRoot := filepath.Join(testDir, "proc")
+	files := map[string]string{
+		"1/cmdline":   "/sbin/init\x00",
+		"42/cmdline":  "vault\x00debug\x00",
+		"123/cmdline": "/usr/bin/vault\x00server\x00-config=/etc/vault.hcl\x00-dev-root-token-id=root\x00",
+		"123/environ": "VAULT_TOKEN=s.0123456789\x00AWS_SECRET_ACCESS_KEY=abc\x00VAULT_LOG_LEVEL=debug\x00",
+	}
+	for name, content := range files {
+		path := filepath.Join(procRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/host-info":
+			w.Write([]byte(`{"data":{"host":{"hostname":"vault-0"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.procRoot = procRoot
+
+	outputPath := filepath.Join(testDir, "output")
+	args := []string{
+		"-duration=1s",
+		"-target=host",
+		"-capture-process-env",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "host_info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hostInfo struct {
+		Process *debugProcess `json:"process"`
+	}
+	if err := json.Unmarshal(content, &hostInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &debugProcess{
+		PID:  123,
+		Args: []string{"/usr/bin/vault", "server", "-config=/etc/vault.hcl", "-dev-root-token-id=" + debugRedactedValue},
+		Env: map[string]string{
+			"AWS_SECRET_ACCESS_KEY": debugRedactedValue,
+			"VAULT_LOG_LEVEL":       "debug",
+			"VAULT_TOKEN":           debugRedactedValue,
+		},
+	}
+	if !reflect.DeepEqual(hostInfo.Process, expected) {
+		t.Fatalf("expected process %#v, got: %s", expected, content)
+	}
+
+	// Without a server process, a note is written in its place
+	if process := readVaultProcess(filepath.Join(testDir, "missing")); process.Note == "" || process.PID != 0 {
+		t.Fatalf("expected a note in place of the process, got: %#v", process)
+	}
+
+	// A local process is not attributed to a remote server
+	ui, cmd = testDebugCommand(t)
+	cmd.client = testDebugRemoteClient(t, client)
+	cmd.procRoot = procRoot
+
+	outputPath = filepath.Join(testDir, "remote")
+	code = cmd.Run([]string{
+		"-duration=1s",
+		"-target=host",
+		"-capture-process-env",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	})
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(outputPath, "000", "host_info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostInfo.Process = nil
+	if err := json.Unmarshal(content, &hostInfo); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&debugProcess{Note: debugRemoteNote}); !reflect.DeepEqual(hostInfo.Process, expected) {
+		t.Fatalf("expected process %#v, got: %s", expected, content)
+	}
+
+	// Nor is either of several server processes
+	path := filepath.Join(procRoot, "456", "cmdline")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("vault\x00server\x00-dev\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if process := readVaultProcess(procRoot); process.PID != 0 || !strings.Contains(process.Note, "PIDs 123, 456") {
+		t.Fatalf("expected a note naming both processes, got: %#v", process)
+	}
+}
+
+func TestDebugCommand_ProcessLimits(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("process limits are only read from /proc on Linux")
+	}
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// The server process is faked with the limits, status, and file
+	// descriptors of the test process
+	procDir := filepath.Join(testDir, "proc", "123")
+	if err := os.MkdirAll(procDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(procDir, "cmdline"), []byte("vault\x00server\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"fd", "limits", "status"} {
+		if err := os.Symlink(filepath.Join("/proc/self", name), filepath.Join(procDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, closer := testDebugStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/host-info":
+			w.Write([]byte(`{"data":{"host":{"hostname":"vault-0"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	cmd.procRoot = filepath.Join(testDir, "proc")
+
+	outputPath := filepath.Join(testDir, "output")
+	args := []string{
+		"-duration=1s",
+		"-target=host",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "000", "host_info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hostInfo struct {
+		ProcessLimits map[string]interface{} `json:"process_limits"`
+	}
+	if err := json.Unmarshal(content, &hostInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{"pid", "open_fds", "max_open_files_soft", "max_open_files_hard", "threads"} {
+		v, ok := hostInfo.ProcessLimits[field].(float64)
+		if !ok {
+			t.Fatalf("expected %s to be a number, got: %s", field, content)
+		}
+		if v == 0 {
+			t.Fatalf("expected %s to be set, got: %s", field, content)
+		}
+	}
+	if note, ok := hostInfo.ProcessLimits["note"]; ok {
+		t.Fatalf("expected no note, got: %v", note)
+	}
+
+	// The limits of a local process are not attributed to a remote server
+	ui, cmd = testDebugCommand(t)
+	cmd.client = testDebugRemoteClient(t, client)
+	cmd.procRoot = filepath.Join(testDir, "proc")
+
+	outputPath = filepath.Join(testDir, "remote")
+	code = cmd.Run([]string{
+		"-duration=1s",
+		"-target=host",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	})
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(outputPath, "000", "host_info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostInfo.ProcessLimits = nil
+	if err := json.Unmarshal(content, &hostInfo); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]interface{}{"note": debugRemoteNote}; !reflect.DeepEqual(hostInfo.ProcessLimits, expected) {
+		t.Fatalf("expected only a note in place of the limits, got: %s", content)
+	}
+
+	// Without a server process, a note is written in its place
+	if limits := readProcessLimits(filepath.Join(testDir, "missing")); limits.Note == "" || limits.OpenFDs != nil {
+		t.Fatalf("expected a note in place of the limits, got: %#v", limits)
+	}
+}
+
+func TestDebugCommand_Resume(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	outputPath := filepath.Join(testDir, "resume")
+	args := []string{
+		"-duration=2s",
+		"-interval=1s",
+		"-target=server-status",
+		fmt.Sprintf("-output=%s", outputPath),
+		"-compress=false",
+	}
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	if code := cmd.Run(args); code != 0 {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, 0)
+	}
+
+	// The bundle is resumed in place, without -output or -compress=false
+	ui, cmd = testDebugCommand(t)
+	cmd.client = client
+	if code := cmd.Run(append(args[:3:3], fmt.Sprintf("-resume=%s", outputPath))); code != 0 {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, 0)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Frames) != 4 {
+		t.Fatalf("expected 4 frames after resuming, got: %v", index.Frames)
+	}
+	for i, frame := range index.Frames {
+		if frame.Frame != i || frame.Directory != fmt.Sprintf("%03d", i) {
+			t.Fatalf("expected frame %d in directory %03d, got: %v", i, i, frame)
+		}
+		if _, ok := index.Checksums[frame.Directory+"/server_status.json"]; !ok {
+			t.Fatalf("expected frame %d to be captured, got: %v", i, index.Checksums)
+		}
+	}
+	if len(index.Resumed) != 1 {
+		t.Fatalf("expected the resumed capture to be recorded, got: %v", index.Resumed)
+	}
+
+	var timings []requestTiming
+	content, err = ioutil.ReadFile(filepath.Join(outputPath, "request_timings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &timings); err != nil {
+		t.Fatal(err)
+	}
+	frames := map[int]bool{}
+	for _, timing := range timings {
+		frames[timing.Frame] = true
+	}
+	if !frames[0] || !frames[3] {
+		t.Fatalf("expected request timings of both captures, got: %v", timings)
+	}
+
+	t.Run("mismatched_targets", func(t *testing.T) {
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{
+			"-duration=1s",
+			"-target=host",
+			fmt.Sprintf("-resume=%s", outputPath),
+		})
+		if exp := 1; code != exp {
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+		if expected := "use -force to resume anyway"; !strings.Contains(ui.ErrorWriter.String(), expected) {
+			t.Fatalf("expected %q to contain %q", ui.ErrorWriter.String(), expected)
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		archive := filepath.Join(testDir, "resume"+debugCompressionExt)
+		if err := writeArchive(outputPath, archive, "tgz", false); err != nil {
+			t.Fatal(err)
+		}
+
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{
+			"-duration=1s",
+			fmt.Sprintf("-resume=%s", archive),
+		})
+		if exp := 1; code != exp {
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+		if expected := "only directory bundles can be resumed"; !strings.Contains(ui.ErrorWriter.String(), expected) {
+			t.Fatalf("expected %q to contain %q", ui.ErrorWriter.String(), expected)
+		}
+	})
+
+	t.Run("other_output", func(t *testing.T) {
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+
+		code := cmd.Run([]string{
+			"-duration=1s",
+			fmt.Sprintf("-output=%s", filepath.Join(testDir, "other")),
+			fmt.Sprintf("-resume=%s", outputPath),
+		})
+		if exp := 1; code != exp {
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+		if expected := "must be the bundle being resumed"; !strings.Contains(ui.ErrorWriter.String(), expected) {
+			t.Fatalf("expected %q to contain %q", ui.ErrorWriter.String(), expected)
+		}
+	})
+
+	// A killed run leaves its partial index behind, without the frame it was
+	// capturing, along with the index it was rewriting
+	t.Run("interrupted", func(t *testing.T) {
+		partial := *index
+		partial.Partial = true
+		partial.Output = nil
+		partial.Checksums = nil
+		partial.Frames = index.Frames[:3]
+		content, err := json.Marshal(&partial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(outputPath, "index.json"), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(outputPath, "index.json.tmp"), content[:10], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		ui, cmd := testDebugCommand(t)
+		cmd.client = client
+		if code := cmd.Run([]string{"-duration=1s", "-target=server-status", fmt.Sprintf("-resume=%s", outputPath)}); code != 0 {
+			t.Log(ui.ErrorWriter.String())
+			t.Fatalf("expected %d to be %d", code, 0)
+		}
+
+		content, err = ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed := &DebugIndex{}
+		if err := json.Unmarshal(content, resumed); err != nil {
+			t.Fatal(err)
+		}
+		if resumed.Partial {
+			t.Fatal("expected the index to be regenerated")
+		}
+		if len(resumed.Resumed) != 2 {
+			t.Fatalf("expected both resumed captures to be recorded, got: %v", resumed.Resumed)
+		}
+
+		// The new frame is numbered after the frame left behind, whose files
+		// are still listed
+		last := resumed.Frames[len(resumed.Frames)-1]
+		if len(resumed.Frames) != 4 || last.Frame != 4 || last.Directory != "004" {
+			t.Fatalf("expected frame 4 to be appended, got: %v", resumed.Frames)
+		}
+		for _, file := range []string{"003/server_status.json", "004/server_status.json"} {
+			if _, ok := resumed.Checksums[file]; !ok {
+				t.Fatalf("expected %s in the index checksums, got: %v", file, resumed.Checksums)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(outputPath, "index.json.tmp")); !os.IsNotExist(err) {
+			t.Fatalf("expected the stale index to be removed, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,618 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/helper/awsutil"
+	"github.com/hashicorp/vault/sdk/helper/useragent"
+	"google.golang.org/api/option"
+)
+
+const (
+	// debugMinUploadPartSize is the smallest part size S3 accepts for every
+	// part of a multipart upload but the last.
+	debugMinUploadPartSize = 5 * 1024 * 1024
+
+	// debugMaxUploadParts is the largest number of parts S3 accepts for a
+	// multipart upload.
+	debugMaxUploadParts = 10000
+
+	// debugMaxUploadBlocks is the largest number of blocks Azure accepts for a
+	// block blob, and debugMaxUploadBlockSize the largest size of a block.
+	debugMaxUploadBlocks    = 50000
+	debugMaxUploadBlockSize = 100 * 1024 * 1024
+
+	// debugUploadPartAttempts is the number of times a part is sent before the
+	// upload is aborted.
+	debugUploadPartAttempts = 3
+
+	// debugUploadRetryWait is the time waited before resending a failed part,
+	// multiplied by the number of attempts so far.
+	debugUploadRetryWait = 500 * time.Millisecond
+)
+
+// debugMultipartUploader is the subset of the S3 API used to upload a bundle
+// to an s3:// URL. It is satisfied by *s3.S3.
+type debugMultipartUploader interface {
+	CreateMultipartUploadWithContext(aws.Context, *s3.CreateMultipartUploadInput, ...request.Option) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartWithContext(aws.Context, *s3.UploadPartInput, ...request.Option) (*s3.UploadPartOutput, error)
+	CompleteMultipartUploadWithContext(aws.Context, *s3.CompleteMultipartUploadInput, ...request.Option) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUploadWithContext(aws.Context, *s3.AbortMultipartUploadInput, ...request.Option) (*s3.AbortMultipartUploadOutput, error)
+	HeadObjectWithContext(aws.Context, *s3.HeadObjectInput, ...request.Option) (*s3.HeadObjectOutput, error)
+}
+
+// newDebugS3Uploader returns an S3 client configured the same way as the S3
+// storage backend: the credentials come from the default AWS chain, and the
+// region and endpoint from the AWS_REGION, AWS_DEFAULT_REGION, and
+// AWS_S3_ENDPOINT environment variables.
+func newDebugS3Uploader() (debugMultipartUploader, error) {
+	credsConfig := &awsutil.CredentialsConfig{}
+	creds, err := credsConfig.GenerateCredentialChain()
+	if err != nil {
+		return nil, err
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+		if region == "" {
+			region = awsutil.DefaultRegion
+		}
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: creds,
+		HTTPClient:  cleanhttp.DefaultClient(),
+		Endpoint:    aws.String(os.Getenv("AWS_S3_ENDPOINT")),
+		Region:      aws.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// uploadBundle uploads the archived bundle to -upload-url. An s3://, gcs://,
+// or azure:// URL is uploaded in parts with uploadBundleS3, uploadBundleGCS,
+// or uploadBundleAzure, and any other URL with a single request using the
+// configured method and headers, in which case any response other than a 2xx
+// is treated as a failure.
+func (c *DebugCommand) uploadBundle(path string) error {
+	u, err := url.Parse(c.cfg.UploadURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "s3":
+		return c.uploadBundleS3(context.Background(), path, u)
+	case "gcs":
+		return c.uploadBundleGCS(context.Background(), path, u)
+	case "azure":
+		return c.uploadBundleAzure(context.Background(), path, u)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(c.cfg.UploadMethod, c.cfg.UploadURL, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", debugArchiveContentTypes[c.cfg.ArchiveFormat])
+	for k, v := range c.cfg.UploadHeaders {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("unexpected response status %d: %s", resp.StatusCode, msg)
+		}
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// uploadBundleS3 uploads the archived bundle to the bucket and key of the
+// s3:// URL as a multipart upload of -upload-part-size parts. A key ending in
+// a slash is a prefix the name of the archive is appended to. Each part is
+// sent with its MD5 so that S3 rejects a corrupted part, and a failed part is
+// resent on its own up to debugUploadPartAttempts times. Once complete, the
+// ETag of the object is compared with the one expected from the parts of the
+// local file, and the SHA256 of the file is stored in the object metadata.
+// The upload is aborted if a part fails for good, and an error is returned if
+// the object does not match the local file.
+func (c *DebugCommand) uploadBundleS3(ctx context.Context, bundlePath string, u *url.URL) error {
+	uploader := c.s3Uploader
+	if uploader == nil {
+		var err error
+		uploader, err = newDebugS3Uploader()
+		if err != nil {
+			return err
+		}
+	}
+
+	bucket, key := debugUploadObject(u, bundlePath)
+
+	checksum, err := fileChecksum(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	partSize := c.partSize()
+	if parts := (info.Size() + partSize - 1) / partSize; parts > debugMaxUploadParts {
+		return fmt.Errorf("bundle of %d bytes needs %d parts of %d bytes, more than the %d allowed, use a larger upload-part-size", info.Size(), parts, partSize, debugMaxUploadParts)
+	}
+
+	created, err := uploader.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(debugArchiveContentTypes[c.cfg.ArchiveFormat]),
+		Metadata: map[string]*string{
+			"sha256": aws.String(checksum),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %s", err)
+	}
+
+	abort := func(err error) error {
+		if _, abortErr := uploader.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		}); abortErr != nil {
+			return fmt.Errorf("%s; failed to abort multipart upload: %s", err, abortErr)
+		}
+		return err
+	}
+
+	// Each part is checked against its MD5 by S3 through ContentMD5. The
+	// ETag of a part is only its MD5 when the object isn't encrypted with
+	// SSE-KMS or SSE-C, in which case the ETag of the object can be checked
+	// as well.
+	var completed []*s3.CompletedPart
+	var partSums []byte
+	md5ETags := true
+	buf := make([]byte, partSize)
+	for number := int64(1); ; number++ {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+
+		sum := md5.Sum(buf[:n])
+		etag, err := c.uploadPart(ctx, uploader, &s3.UploadPartInput{
+			Bucket:     aws.String(bucket),
+			Key:        aws.String(key),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int64(number),
+			ContentMD5: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		}, buf[:n])
+		if err != nil {
+			return abort(fmt.Errorf("failed to upload part %d: %s", number, err))
+		}
+		if strings.Trim(etag, `"`) != hex.EncodeToString(sum[:]) {
+			md5ETags = false
+		}
+
+		completed = append(completed, &s3.CompletedPart{
+			ETag:       aws.String(etag),
+			PartNumber: aws.Int64(number),
+		})
+		partSums = append(partSums, sum[:]...)
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	result, err := uploader.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("failed to complete multipart upload: %s", err))
+	}
+
+	// The ETag of a multipart object is the MD5 of the concatenated MD5s of
+	// its parts, followed by the number of parts
+	if md5ETags {
+		expected := fmt.Sprintf("%x-%d", md5.Sum(partSums), len(completed))
+		if etag := strings.Trim(aws.StringValue(result.ETag), `"`); etag != expected {
+			return fmt.Errorf("uploaded object failed verification against the local bundle with SHA256 %s, expected ETag %s, got %s", checksum, expected, etag)
+		}
+		return nil
+	}
+
+	// Otherwise the object is checked to have the size of the bundle and its
+	// checksum in the metadata
+	head, err := uploader.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify uploaded object: %s", err)
+	}
+	if size := aws.Int64Value(head.ContentLength); size != info.Size() {
+		return fmt.Errorf("uploaded object failed verification against the local bundle with SHA256 %s, expected %d bytes, got %d", checksum, info.Size(), size)
+	}
+	var sum string
+	for name, value := range head.Metadata {
+		// The SDK returns the metadata keys in canonical header case
+		if strings.EqualFold(name, "sha256") {
+			sum = aws.StringValue(value)
+		}
+	}
+	if sum != checksum {
+		return fmt.Errorf("uploaded object failed verification against the local bundle with SHA256 %s, got %q in its metadata", checksum, sum)
+	}
+
+	return nil
+}
+
+// uploadPart sends a single part of a multipart upload, resending it if it
+// fails, and returns the ETag of the part.
+func (c *DebugCommand) uploadPart(ctx context.Context, uploader debugMultipartUploader, input *s3.UploadPartInput, data []byte) (string, error) {
+	var out *s3.UploadPartOutput
+	err := retryUploadPart(ctx, func() error {
+		input.Body = bytes.NewReader(data)
+		input.ContentLength = aws.Int64(int64(len(data)))
+
+		var err error
+		out, err = uploader.UploadPartWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+// retryUploadPart sends a single part of an upload with send, resending it
+// if it fails up to debugUploadPartAttempts times in all, and returns the
+// error of the last attempt.
+func retryUploadPart(ctx context.Context, send func() error) error {
+	var err error
+	for attempt := 1; attempt <= debugUploadPartAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt-1) * debugUploadRetryWait):
+			}
+		}
+
+		if err = send(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// partSize returns the size of the parts the bundle is uploaded in, parsed
+// from -upload-part-size.
+func (c *DebugCommand) partSize() int64 {
+	if c.uploadPartSize == 0 {
+		return debugMinUploadPartSize
+	}
+	return c.uploadPartSize
+}
+
+// debugUploadObject returns the bucket, or container, and the name of the
+// object the bundle is uploaded to for the s3://, gcs://, or azure:// URL. A
+// name that is empty or ends in a slash is a prefix the name of the archive is
+// appended to.
+func debugUploadObject(u *url.URL, bundlePath string) (string, string) {
+	name := strings.TrimPrefix(u.Path, "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += filepath.Base(bundlePath)
+	}
+	return u.Host, name
+}
+
+// debugGCSWriter is the subset of *storage.Writer used to upload a bundle to a
+// gcs:// URL.
+type debugGCSWriter interface {
+	io.WriteCloser
+	Attrs() *gcs.ObjectAttrs
+}
+
+// debugGCSWriterFunc returns a writer of the object with the given name and
+// content type in the bucket, which is uploaded in chunks of the given size
+// along with the given metadata once the writer is closed.
+type debugGCSWriterFunc func(ctx context.Context, bucket, name, contentType string, chunkSize int, metadata map[string]string) debugGCSWriter
+
+// newDebugGCSWriterFunc returns a debugGCSWriterFunc using a client
+// configured the same way as the GCS storage backend, with the application
+// default credentials such as those of GOOGLE_APPLICATION_CREDENTIALS.
+func newDebugGCSWriterFunc(ctx context.Context) (debugGCSWriterFunc, error) {
+	client, err := gcs.NewClient(ctx, option.WithUserAgent(useragent.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %s", err)
+	}
+
+	return func(ctx context.Context, bucket, name, contentType string, chunkSize int, metadata map[string]string) debugGCSWriter {
+		w := client.Bucket(bucket).Object(name).NewWriter(ctx)
+		w.ChunkSize = chunkSize
+		w.ContentType = contentType
+		w.Metadata = metadata
+		return w
+	}, nil
+}
+
+// uploadBundleGCS uploads the archived bundle to the bucket and object of the
+// gcs:// URL, named the same way as with uploadBundleS3. The bundle is
+// streamed as a resumable upload of -upload-part-size chunks, each of which
+// the client resends on its own if it fails. Once complete, the MD5 of the
+// object is compared with the one of the local file, and the SHA256 of the
+// file is stored in the object metadata. The upload is canceled if the bundle
+// cannot be sent, so that no partial object is left behind, and an error is
+// returned if the object does not match the local file.
+func (c *DebugCommand) uploadBundleGCS(ctx context.Context, bundlePath string, u *url.URL) error {
+	newWriter := c.gcsWriter
+	if newWriter == nil {
+		var err error
+		newWriter, err = newDebugGCSWriterFunc(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	bucket, name := debugUploadObject(u, bundlePath)
+
+	checksum, err := fileChecksum(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := newWriter(ctx, bucket, name, debugArchiveContentTypes[c.cfg.ArchiveFormat], int(c.partSize()), map[string]string{
+		"sha256": checksum,
+	})
+	hash := md5.New()
+	if _, err := io.Copy(w, io.TeeReader(f, hash)); err != nil {
+		cancel()
+		w.Close()
+		return fmt.Errorf("failed to upload object: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to upload object: %s", err)
+	}
+
+	expected := hash.Sum(nil)
+	var actual []byte
+	if attrs := w.Attrs(); attrs != nil {
+		actual = attrs.MD5
+	}
+	if !bytes.Equal(actual, expected) {
+		return fmt.Errorf("uploaded object failed verification against the local bundle with SHA256 %s, expected MD5 %x, got %x", checksum, expected, actual)
+	}
+
+	return nil
+}
+
+// debugBlockBlobUploader is the subset of the Azure blob API used to upload a
+// bundle to an azure:// URL. It is satisfied by *debugAzureUploader.
+type debugBlockBlobUploader interface {
+	PutBlock(container, name, blockID string, chunk []byte, contentMD5 string) error
+	PutBlockList(container, name string, blocks []azstorage.Block, contentType, contentMD5 string, metadata map[string]string) error
+	GetBlockList(container, name string) (azstorage.BlockListResponse, error)
+}
+
+// debugAzureUploader uploads block blobs with a client of the blob service.
+type debugAzureUploader struct {
+	client *azstorage.BlobStorageClient
+}
+
+// newDebugAzureUploader returns an uploader configured the same way as the
+// Azure storage backend, with the account read from the AZURE_ACCOUNT_NAME and
+// AZURE_ACCOUNT_KEY environment variables and the cloud from
+// AZURE_ENVIRONMENT or AZURE_ARM_ENDPOINT.
+func newDebugAzureUploader() (debugBlockBlobUploader, error) {
+	accountName := os.Getenv("AZURE_ACCOUNT_NAME")
+	accountKey := os.Getenv("AZURE_ACCOUNT_KEY")
+	if accountName == "" || accountKey == "" {
+		return nil, fmt.Errorf("AZURE_ACCOUNT_NAME and AZURE_ACCOUNT_KEY must be set to upload to Azure")
+	}
+
+	var environment azure.Environment
+	var err error
+	if environmentURL := os.Getenv("AZURE_ARM_ENDPOINT"); environmentURL != "" {
+		environment, err = azure.EnvironmentFromURL(environmentURL)
+	} else {
+		environmentName := os.Getenv("AZURE_ENVIRONMENT")
+		if environmentName == "" {
+			environmentName = "AzurePublicCloud"
+		}
+		environment, err = azure.EnvironmentFromName(environmentName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up Azure environment: %s", err)
+	}
+
+	client, err := azstorage.NewBasicClientOnSovereignCloud(accountName, accountKey, environment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %s", err)
+	}
+	client.HTTPClient = cleanhttp.DefaultClient()
+
+	blobClient := client.GetBlobService()
+	return &debugAzureUploader{client: &blobClient}, nil
+}
+
+func (u *debugAzureUploader) blob(container, name string) *azstorage.Blob {
+	return u.client.GetContainerReference(container).GetBlobReference(name)
+}
+
+func (u *debugAzureUploader) PutBlock(container, name, blockID string, chunk []byte, contentMD5 string) error {
+	return u.blob(container, name).PutBlock(blockID, chunk, &azstorage.PutBlockOptions{
+		ContentMD5: contentMD5,
+	})
+}
+
+func (u *debugAzureUploader) PutBlockList(container, name string, blocks []azstorage.Block, contentType, contentMD5 string, metadata map[string]string) error {
+	blob := u.blob(container, name)
+	blob.Properties.ContentType = contentType
+	blob.Properties.ContentMD5 = contentMD5
+	blob.Metadata = azstorage.BlobMetadata(metadata)
+	return blob.PutBlockList(blocks, nil)
+}
+
+func (u *debugAzureUploader) GetBlockList(container, name string) (azstorage.BlockListResponse, error) {
+	return u.blob(container, name).GetBlockList(azstorage.BlockListTypeCommitted, nil)
+}
+
+// uploadBundleAzure uploads the archived bundle to the container and blob of
+// the azure:// URL, named the same way as with uploadBundleS3. The bundle is
+// sent as blocks of -upload-part-size, each sent with its MD5 so that Azure
+// rejects a corrupted block, and resent on its own up to
+// debugUploadPartAttempts times if it fails. Blocks are only made part of the
+// blob once every block is sent, so a failed upload leaves the blob as it
+// was. Once committed, the blocks of the blob are compared with those sent,
+// the MD5 of the bundle is set as the content MD5 of the blob, and the SHA256
+// of the bundle is stored in its metadata.
+func (c *DebugCommand) uploadBundleAzure(ctx context.Context, bundlePath string, u *url.URL) error {
+	uploader := c.azureUploader
+	if uploader == nil {
+		var err error
+		uploader, err = newDebugAzureUploader()
+		if err != nil {
+			return err
+		}
+	}
+
+	container, name := debugUploadObject(u, bundlePath)
+
+	checksum, err := fileChecksum(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	partSize := c.partSize()
+	if blocks := (info.Size() + partSize - 1) / partSize; blocks > debugMaxUploadBlocks {
+		return fmt.Errorf("bundle of %d bytes needs %d blocks of %d bytes, more than the %d allowed, use a larger upload-part-size", info.Size(), blocks, partSize, debugMaxUploadBlocks)
+	}
+
+	var blocks []azstorage.Block
+	sizes := map[string]int64{}
+	hash := md5.New()
+	buf := make([]byte, partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		hash.Write(buf[:n])
+
+		// Block IDs must all have the same length
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%05d", number)))
+		sum := md5.Sum(buf[:n])
+		if err := retryUploadPart(ctx, func() error {
+			return uploader.PutBlock(container, name, blockID, buf[:n], base64.StdEncoding.EncodeToString(sum[:]))
+		}); err != nil {
+			return fmt.Errorf("failed to upload block %d: %s", number, err)
+		}
+		blocks = append(blocks, azstorage.Block{ID: blockID, Status: azstorage.BlockStatusLatest})
+		sizes[blockID] = int64(n)
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	contentMD5 := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	contentType := debugArchiveContentTypes[c.cfg.ArchiveFormat]
+	if err := uploader.PutBlockList(container, name, blocks, contentType, contentMD5, map[string]string{"sha256": checksum}); err != nil {
+		return fmt.Errorf("failed to commit blocks: %s", err)
+	}
+
+	committed, err := uploader.GetBlockList(container, name)
+	if err != nil {
+		return fmt.Errorf("failed to verify uploaded blob: %s", err)
+	}
+	matches := len(committed.CommittedBlocks) == len(blocks)
+	for i, block := range committed.CommittedBlocks {
+		matches = matches && block.Name == blocks[i].ID && block.Size == sizes[block.Name]
+	}
+	if !matches {
+		return fmt.Errorf("uploaded blob failed verification against the local bundle with SHA256 %s, expected %d blocks totaling %d bytes, got %d blocks", checksum, len(blocks), info.Size(), len(committed.CommittedBlocks))
+	}
+
+	return nil
+}
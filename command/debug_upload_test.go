@@ -0,0 +1,677 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	gcs "cloud.google.com/go/storage"
+	azstorage "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// testMultipartUploader is an in-memory debugMultipartUploader. Each part
+// fails the number of times set in failures before it is accepted, and the
+// ETag of the completed object is replaced with etag if set. With encrypted,
+// the ETags aren't MD5s, as with SSE-KMS or SSE-C, and the sha256 metadata of
+// the object is replaced with headSHA256 if set.
+type testMultipartUploader struct {
+	l          sync.Mutex
+	failures   map[int64]int
+	etag       string
+	encrypted  bool
+	headSHA256 string
+	key       string
+	metadata  map[string]*string
+	attempts  map[int64]int
+	parts     map[int64][]byte
+	completed bool
+	aborted   bool
+}
+
+func (u *testMultipartUploader) CreateMultipartUploadWithContext(_ aws.Context, input *s3.CreateMultipartUploadInput, _ ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	u.key = aws.StringValue(input.Key)
+	u.metadata = input.Metadata
+	u.attempts = map[int64]int{}
+	u.parts = map[int64][]byte{}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil
+}
+
+func (u *testMultipartUploader) UploadPartWithContext(_ aws.Context, input *s3.UploadPartInput, _ ...request.Option) (*s3.UploadPartOutput, error) {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	number := aws.Int64Value(input.PartNumber)
+	u.attempts[number]++
+	if u.attempts[number] <= u.failures[number] {
+		return nil, errors.New("connection reset by peer")
+	}
+
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	u.parts[number] = data
+	sum := md5.Sum(data)
+	if u.encrypted {
+		sum = md5.Sum(append([]byte("kms"), data...))
+	}
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("%q", fmt.Sprintf("%x", sum)))}, nil
+}
+
+func (u *testMultipartUploader) CompleteMultipartUploadWithContext(_ aws.Context, input *s3.CompleteMultipartUploadInput, _ ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	u.completed = true
+	var sums []byte
+	for _, part := range input.MultipartUpload.Parts {
+		sum := md5.Sum(u.parts[aws.Int64Value(part.PartNumber)])
+		sums = append(sums, sum[:]...)
+	}
+	etag := fmt.Sprintf("%x-%d", md5.Sum(sums), len(input.MultipartUpload.Parts))
+	if u.encrypted {
+		etag = fmt.Sprintf("%x-%d", md5.Sum(append([]byte("kms"), sums...)), len(input.MultipartUpload.Parts))
+	}
+	if u.etag != "" {
+		etag = u.etag
+	}
+	return &s3.CompleteMultipartUploadOutput{ETag: aws.String(fmt.Sprintf("%q", etag))}, nil
+}
+
+func (u *testMultipartUploader) AbortMultipartUploadWithContext(_ aws.Context, _ *s3.AbortMultipartUploadInput, _ ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	u.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (u *testMultipartUploader) HeadObjectWithContext(_ aws.Context, _ *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	object := u.object()
+
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	sum := aws.StringValue(u.metadata["sha256"])
+	if u.headSHA256 != "" {
+		sum = u.headSHA256
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(object))),
+		Metadata:      map[string]*string{"Sha256": aws.String(sum)},
+	}, nil
+}
+
+// object returns the parts of the upload joined in order.
+func (u *testMultipartUploader) object() []byte {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	var buf bytes.Buffer
+	for i := int64(1); i <= int64(len(u.parts)); i++ {
+		buf.Write(u.parts[i])
+	}
+	return buf.Bytes()
+}
+
+func TestDebugCommand_UploadS3(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		failures   map[int64]int
+		etag       string
+		encrypted  bool
+		headSHA256 string
+		attempts   map[int64]int
+		expectErr  string
+	}{
+		{
+			"verified",
+			nil,
+			"",
+			false,
+			"",
+			map[int64]int{1: 1, 2: 1, 3: 1},
+			"",
+		},
+		{
+			"retried_part",
+			map[int64]int{2: 1},
+			"",
+			false,
+			"",
+			map[int64]int{1: 1, 2: 2, 3: 1},
+			"",
+		},
+		{
+			"failed_part",
+			map[int64]int{2: debugUploadPartAttempts},
+			"",
+			false,
+			"",
+			map[int64]int{1: 1, 2: debugUploadPartAttempts},
+			"failed to upload part 2",
+		},
+		{
+			"mismatch",
+			nil,
+			"d41d8cd98f00b204e9800998ecf8427e-3",
+			false,
+			"",
+			map[int64]int{1: 1, 2: 1, 3: 1},
+			"failed verification",
+		},
+		{
+			"encrypted",
+			nil,
+			"",
+			true,
+			"",
+			map[int64]int{1: 1, 2: 1, 3: 1},
+			"",
+		},
+		{
+			"encrypted_mismatch",
+			nil,
+			"",
+			true,
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			map[int64]int{1: 1, 2: 1, 3: 1},
+			"failed verification",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			// Two full parts and a short last one
+			data := make([]byte, 2*debugMinUploadPartSize+1024)
+			rand.New(rand.NewSource(1)).Read(data)
+			bundlePath := filepath.Join(testDir, "vault-debug"+debugCompressionExt)
+			if err := ioutil.WriteFile(bundlePath, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			uploader := &testMultipartUploader{
+				failures:   tc.failures,
+				etag:       tc.etag,
+				encrypted:  tc.encrypted,
+				headSHA256: tc.headSHA256,
+			}
+			_, cmd := testDebugCommand(t)
+			cmd.cfg.UploadURL = "s3://bucket/bundles/"
+			cmd.uploadPartSize = debugMinUploadPartSize
+			cmd.s3Uploader = uploader
+
+			err = cmd.uploadBundle(bundlePath)
+			switch {
+			case tc.expectErr == "" && err != nil:
+				t.Fatal(err)
+			case tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)):
+				t.Fatalf("expected error containing %q, got: %v", tc.expectErr, err)
+			}
+
+			for number, exp := range tc.attempts {
+				if uploader.attempts[number] != exp {
+					t.Fatalf("expected part %d to be sent %d times, got: %d", number, exp, uploader.attempts[number])
+				}
+			}
+			if len(uploader.attempts) != len(tc.attempts) {
+				t.Fatalf("expected parts %v to be sent, got: %v", tc.attempts, uploader.attempts)
+			}
+
+			if tc.name == "failed_part" {
+				if !uploader.aborted || uploader.completed {
+					t.Fatalf("expected the upload to be aborted, got aborted %t and completed %t", uploader.aborted, uploader.completed)
+				}
+				return
+			}
+
+			if uploader.key != "bundles/vault-debug"+debugCompressionExt {
+				t.Fatalf("unexpected key: %s", uploader.key)
+			}
+			if !bytes.Equal(uploader.object(), data) {
+				t.Fatal("expected the uploaded object to match the local bundle")
+			}
+			checksum, err := fileChecksum(bundlePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum := aws.StringValue(uploader.metadata["sha256"]); sum != checksum {
+				t.Fatalf("expected sha256 metadata %s, got: %s", checksum, sum)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_UploadS3Mismatch(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	uploader := &testMultipartUploader{
+		etag: "d41d8cd98f00b204e9800998ecf8427e-1",
+	}
+	cmd.s3Uploader = uploader
+
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		fmt.Sprintf("-output=%s/mismatch", testDir),
+		"-upload-url=s3://bucket/bundles/",
+	}
+
+	code := cmd.Run(args)
+	if exp := 1; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, "failed verification") {
+		t.Fatalf("expected verification error, got: %s", errOut)
+	}
+
+	// The local bundle is preserved
+	bundlePath := filepath.Join(testDir, "mismatch"+debugCompressionExt)
+	if _, err := os.Stat(bundlePath); err != nil {
+		t.Fatalf("expected local bundle to be preserved: %s", err)
+	}
+	if uploader.key != "bundles/mismatch"+debugCompressionExt {
+		t.Fatalf("unexpected key: %s", uploader.key)
+	}
+}
+
+// testGCSWriter is an in-memory debugGCSWriter. The MD5 of the uploaded
+// object is replaced with md5 if set.
+type testGCSWriter struct {
+	bucket    string
+	name      string
+	chunkSize int
+	metadata  map[string]string
+	md5       []byte
+	buf       bytes.Buffer
+	closed    bool
+}
+
+func (w *testGCSWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *testGCSWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *testGCSWriter) Attrs() *gcs.ObjectAttrs {
+	if !w.closed {
+		return nil
+	}
+	sum := md5.Sum(w.buf.Bytes())
+	attrs := &gcs.ObjectAttrs{MD5: sum[:]}
+	if w.md5 != nil {
+		attrs.MD5 = w.md5
+	}
+	return attrs
+}
+
+func TestDebugCommand_UploadGCS(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		md5       []byte
+		expectErr string
+	}{
+		{
+			"verified",
+			nil,
+			"",
+		},
+		{
+			"mismatch",
+			[]byte("0123456789abcdef"),
+			"failed verification",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			data := make([]byte, debugMinUploadPartSize+1024)
+			rand.New(rand.NewSource(1)).Read(data)
+			bundlePath := filepath.Join(testDir, "vault-debug"+debugCompressionExt)
+			if err := ioutil.WriteFile(bundlePath, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			writer := &testGCSWriter{md5: tc.md5}
+			_, cmd := testDebugCommand(t)
+			cmd.cfg.UploadURL = "gcs://bucket/bundles/"
+			cmd.uploadPartSize = debugMinUploadPartSize
+			cmd.gcsWriter = func(_ context.Context, bucket, name, _ string, chunkSize int, metadata map[string]string) debugGCSWriter {
+				writer.bucket = bucket
+				writer.name = name
+				writer.chunkSize = chunkSize
+				writer.metadata = metadata
+				return writer
+			}
+
+			err = cmd.uploadBundle(bundlePath)
+			switch {
+			case tc.expectErr == "" && err != nil:
+				t.Fatal(err)
+			case tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)):
+				t.Fatalf("expected error containing %q, got: %v", tc.expectErr, err)
+			}
+
+			if writer.bucket != "bucket" || writer.name != "bundles/vault-debug"+debugCompressionExt {
+				t.Fatalf("unexpected object: %s/%s", writer.bucket, writer.name)
+			}
+			if writer.chunkSize != debugMinUploadPartSize {
+				t.Fatalf("expected chunks of %d bytes, got: %d", debugMinUploadPartSize, writer.chunkSize)
+			}
+			if !bytes.Equal(writer.buf.Bytes(), data) {
+				t.Fatal("expected the uploaded object to match the local bundle")
+			}
+			checksum, err := fileChecksum(bundlePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum := writer.metadata["sha256"]; sum != checksum {
+				t.Fatalf("expected sha256 metadata %s, got: %s", checksum, sum)
+			}
+		})
+	}
+}
+
+// testBlockBlobUploader is an in-memory debugBlockBlobUploader. Each block
+// fails the number of times set in failures, by its position, before it is
+// accepted, and the last committed block is dropped from the block list if
+// dropLast is set.
+type testBlockBlobUploader struct {
+	l          sync.Mutex
+	failures   map[int]int
+	dropLast   bool
+	container  string
+	name       string
+	contentMD5 string
+	metadata   map[string]string
+	order      []string
+	attempts   map[string]int
+	blocks     map[string][]byte
+	committed  []azstorage.Block
+}
+
+func (u *testBlockBlobUploader) PutBlock(container, name, blockID string, chunk []byte, contentMD5 string) error {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	if u.attempts == nil {
+		u.attempts = map[string]int{}
+		u.blocks = map[string][]byte{}
+	}
+	if u.attempts[blockID] == 0 {
+		u.order = append(u.order, blockID)
+	}
+	u.attempts[blockID]++
+	if u.attempts[blockID] <= u.failures[len(u.order)] {
+		return errors.New("connection reset by peer")
+	}
+
+	sum := md5.Sum(chunk)
+	if base64.StdEncoding.EncodeToString(sum[:]) != contentMD5 {
+		return errors.New("Md5Mismatch")
+	}
+	u.container = container
+	u.name = name
+	u.blocks[blockID] = append([]byte{}, chunk...)
+	return nil
+}
+
+func (u *testBlockBlobUploader) PutBlockList(container, name string, blocks []azstorage.Block, _, contentMD5 string, metadata map[string]string) error {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	u.committed = blocks
+	u.contentMD5 = contentMD5
+	u.metadata = metadata
+	return nil
+}
+
+func (u *testBlockBlobUploader) GetBlockList(container, name string) (azstorage.BlockListResponse, error) {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	var resp azstorage.BlockListResponse
+	for _, block := range u.committed {
+		resp.CommittedBlocks = append(resp.CommittedBlocks, azstorage.BlockResponse{
+			Name: block.ID,
+			Size: int64(len(u.blocks[block.ID])),
+		})
+	}
+	if u.dropLast && len(resp.CommittedBlocks) > 0 {
+		resp.CommittedBlocks = resp.CommittedBlocks[:len(resp.CommittedBlocks)-1]
+	}
+	return resp, nil
+}
+
+// object returns the committed blocks joined in order.
+func (u *testBlockBlobUploader) object() []byte {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	var buf bytes.Buffer
+	for _, block := range u.committed {
+		buf.Write(u.blocks[block.ID])
+	}
+	return buf.Bytes()
+}
+
+func TestDebugCommand_UploadAzure(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		failures  map[int]int
+		dropLast  bool
+		attempts  []int
+		expectErr string
+	}{
+		{
+			"verified",
+			nil,
+			false,
+			[]int{1, 1, 1},
+			"",
+		},
+		{
+			"retried_block",
+			map[int]int{2: 1},
+			false,
+			[]int{1, 2, 1},
+			"",
+		},
+		{
+			"failed_block",
+			map[int]int{2: debugUploadPartAttempts},
+			false,
+			[]int{1, debugUploadPartAttempts},
+			"failed to upload block 2",
+		},
+		{
+			"mismatch",
+			nil,
+			true,
+			[]int{1, 1, 1},
+			"failed verification",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			// Two full blocks and a short last one
+			data := make([]byte, 2*debugMinUploadPartSize+1024)
+			rand.New(rand.NewSource(1)).Read(data)
+			bundlePath := filepath.Join(testDir, "vault-debug"+debugCompressionExt)
+			if err := ioutil.WriteFile(bundlePath, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			uploader := &testBlockBlobUploader{
+				failures: tc.failures,
+				dropLast: tc.dropLast,
+			}
+			_, cmd := testDebugCommand(t)
+			cmd.cfg.UploadURL = "azure://container/bundles/"
+			cmd.uploadPartSize = debugMinUploadPartSize
+			cmd.azureUploader = uploader
+
+			err = cmd.uploadBundle(bundlePath)
+			switch {
+			case tc.expectErr == "" && err != nil:
+				t.Fatal(err)
+			case tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)):
+				t.Fatalf("expected error containing %q, got: %v", tc.expectErr, err)
+			}
+
+			if len(uploader.order) != len(tc.attempts) {
+				t.Fatalf("expected %d blocks to be sent, got: %v", len(tc.attempts), uploader.order)
+			}
+			for i, exp := range tc.attempts {
+				if n := uploader.attempts[uploader.order[i]]; n != exp {
+					t.Fatalf("expected block %d to be sent %d times, got: %d", i+1, exp, n)
+				}
+			}
+
+			if tc.name == "failed_block" {
+				if uploader.committed != nil {
+					t.Fatalf("expected no blocks to be committed, got: %v", uploader.committed)
+				}
+				return
+			}
+
+			if uploader.container != "container" || uploader.name != "bundles/vault-debug"+debugCompressionExt {
+				t.Fatalf("unexpected blob: %s/%s", uploader.container, uploader.name)
+			}
+			if !bytes.Equal(uploader.object(), data) {
+				t.Fatal("expected the uploaded blob to match the local bundle")
+			}
+			sum := md5.Sum(data)
+			if uploader.contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+				t.Fatalf("unexpected content MD5: %s", uploader.contentMD5)
+			}
+			checksum, err := fileChecksum(bundlePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uploader.metadata["sha256"] != checksum {
+				t.Fatalf("expected sha256 metadata %s, got: %s", checksum, uploader.metadata["sha256"])
+			}
+		})
+	}
+}
+
+func TestDebugCommand_UploadRemove(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := ioutil.TempDir("", "vault-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	client, closer := testVaultServer(t)
+	defer closer()
+
+	ui, cmd := testDebugCommand(t)
+	cmd.client = client
+	uploader := &testMultipartUploader{}
+	cmd.s3Uploader = uploader
+
+	args := []string{
+		"-duration=1s",
+		"-target=config",
+		fmt.Sprintf("-output=%s/remove", testDir),
+		"-upload-url=s3://bucket/bundles/",
+		"-upload-remove",
+	}
+
+	code := cmd.Run(args)
+	if exp := 0; code != exp {
+		t.Log(ui.ErrorWriter.String())
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if !uploader.completed || uploader.key != "bundles/remove"+debugCompressionExt {
+		t.Fatalf("expected the bundle to be uploaded, got key %q", uploader.key)
+	}
+
+	// The uploaded bundle is removed
+	bundlePath := filepath.Join(testDir, "remove"+debugCompressionExt)
+	if _, err := os.Stat(bundlePath); !os.IsNotExist(err) {
+		t.Fatalf("expected the local bundle to be removed, got: %v", err)
+	}
+
+	// Removing the bundle requires an upload
+	ui, cmd = testDebugCommand(t)
+	cmd.client = client
+	code = cmd.Run([]string{"-upload-remove", fmt.Sprintf("-output=%s/no-upload", testDir)})
+	if exp := 1; code != exp {
+		t.Fatalf("expected %d to be %d", code, exp)
+	}
+	if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, "upload-remove requires upload-url") {
+		t.Fatalf("expected upload-remove error, got: %s", errOut)
+	}
+}
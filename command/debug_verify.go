@@ -0,0 +1,277 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	"github.com/posener/complete"
+)
+
+var _ cli.Command = (*DebugVerifyCommand)(nil)
+var _ cli.CommandAutocomplete = (*DebugVerifyCommand)(nil)
+
+type DebugVerifyCommand struct {
+	*BaseCommand
+
+	flagPublicKey string
+}
+
+func (c *DebugVerifyCommand) Synopsis() string {
+	return "Verifies the integrity of a debug bundle"
+}
+
+func (c *DebugVerifyCommand) Help() string {
+	helpText := `
+Usage: vault debug verify <bundle>
+
+  Verifies the integrity of a bundle produced by "vault debug". The checksum
+  of every file is recomputed and compared against the checksums recorded in
+  the index file. Both directory bundles and bundles archived in any of the
+  formats of -archive-format are supported.
+
+  Verify an archived bundle:
+
+      $ vault debug verify vault-debug-2019-10-15T21-44-49Z.tar.gz
+
+  Verify a directory bundle:
+
+      $ vault debug verify vault-debug-2019-10-15T21-44-49Z
+
+  Verify an archived bundle and its signature:
+
+      $ vault debug verify -public-key=debug.pub vault-debug-2019-10-15T21-44-49Z.tar.gz
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *DebugVerifyCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetNone)
+
+	f := set.NewFlagSet("Command Options")
+
+	f.StringVar(&StringVar{
+		Name:       "public-key",
+		Target:     &c.flagPublicKey,
+		Completion: complete.PredictFiles("*"),
+		Usage: "Path to a PEM-encoded ed25519 public key to verify the " +
+			"signature of an archived bundle signed with -sign-key against. " +
+			"The signature is read from the file next to the archive with a " +
+			".sig extension.",
+	})
+
+	return set
+}
+
+func (c *DebugVerifyCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictFiles("*")
+}
+
+func (c *DebugVerifyCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *DebugVerifyCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	if len(args) != 1 {
+		c.UI.Error(fmt.Sprintf("Incorrect arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+	bundle := strings.TrimSpace(args[0])
+
+	info, err := os.Stat(bundle)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening bundle: %s", err))
+		return 1
+	}
+
+	var index *DebugIndex
+	var checksums map[string]string
+	if info.IsDir() {
+		index, checksums, err = readDebugBundleDir(bundle)
+	} else {
+		index, checksums, err = readDebugBundleArchive(bundle)
+	}
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading bundle: %s", err))
+		return 1
+	}
+	if index.Checksums == nil {
+		c.UI.Error("Error reading bundle: index file does not contain checksums")
+		return 1
+	}
+
+	if c.flagPublicKey != "" {
+		if info.IsDir() {
+			c.UI.Error("Error: only archived bundles can be signed")
+			return 1
+		}
+
+		key, err := loadDebugPublicKey(c.flagPublicKey)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error loading public key: %s", err))
+			return 1
+		}
+		if fingerprint := debugKeyFingerprint(key); index.SigningKeyFingerprint != fingerprint {
+			c.UI.Error(fmt.Sprintf("Error: bundle was not signed with the public key, expected fingerprint %q but got %q", index.SigningKeyFingerprint, fingerprint))
+			return 2
+		}
+		if err := verifyDebugSignature(bundle, key); err != nil {
+			c.UI.Error(fmt.Sprintf("Error verifying signature: %s", err))
+			return 2
+		}
+	}
+
+	problems := verifyDebugChecksums(index.Checksums, checksums)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			c.UI.Error(problem)
+		}
+		c.UI.Error(fmt.Sprintf("Error: bundle is corrupt, %d problem(s) found", len(problems)))
+		return 2
+	}
+
+	if c.flagPublicKey != "" {
+		c.UI.Output(fmt.Sprintf("Success! Verified signature and %d file(s) in bundle: %s", len(index.Checksums), bundle))
+		return 0
+	}
+	c.UI.Output(fmt.Sprintf("Success! Verified %d file(s) in bundle: %s", len(index.Checksums), bundle))
+	return 0
+}
+
+// verifyDebugChecksums compares the checksums recorded in the index against
+// the actual checksums of the files in the bundle, returning a description of
+// every missing, modified, or unexpected file.
+func verifyDebugChecksums(expected, actual map[string]string) []string {
+	var problems []string
+	for file, sum := range expected {
+		actualSum, ok := actual[file]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("Missing file: %s", file))
+		case actualSum != sum:
+			problems = append(problems, fmt.Sprintf("Checksum mismatch: %s", file))
+		}
+	}
+	for file := range actual {
+		if _, ok := expected[file]; !ok {
+			problems = append(problems, fmt.Sprintf("Unexpected file: %s", file))
+		}
+	}
+	sort.Strings(problems)
+
+	return problems
+}
+
+// readDebugBundleDir reads the index file of a directory bundle, validating it
+// against the index schema, and computes the checksum of every other file in
+// the bundle.
+func readDebugBundleDir(dir string) (*DebugIndex, map[string]string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateDebugIndex(content); err != nil {
+		return nil, nil, err
+	}
+
+	index := &DebugIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse index file: %s", err)
+	}
+
+	checksums := map[string]string{}
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "index.json" {
+			return nil
+		}
+
+		sum, err := fileChecksum(p)
+		if err != nil {
+			return err
+		}
+		checksums[relPath] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return index, checksums, nil
+}
+
+// readDebugBundleArchive reads the index file of an archived bundle,
+// validating it against the index schema, and computes the checksum of every
+// other file in the archive. Entries are
+// expected to be rooted at a single top-level directory.
+func readDebugBundleArchive(file string) (*DebugIndex, map[string]string, error) {
+	var index *DebugIndex
+	checksums := map[string]string{}
+	err := walkDebugArchive(file, func(name string, r io.Reader) error {
+		// Strip the top-level directory from the entry name
+		name = path.Clean(name)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+
+		if name == "index.json" {
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if err := validateDebugIndex(content); err != nil {
+				return err
+			}
+
+			index = &DebugIndex{}
+			if err := json.Unmarshal(content, index); err != nil {
+				return fmt.Errorf("failed to parse index file: %s", err)
+			}
+			return nil
+		}
+
+		sum, err := readerChecksum(r)
+		if err != nil {
+			return err
+		}
+		checksums[name] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if index == nil {
+		return nil, nil, fmt.Errorf("index file not found in archive")
+	}
+
+	return index, checksums, nil
+}
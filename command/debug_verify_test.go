@@ -0,0 +1,168 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func testDebugVerifyCommand(tb testing.TB) (*cli.MockUi, *DebugVerifyCommand) {
+	tb.Helper()
+
+	ui := cli.NewMockUi()
+	return ui, &DebugVerifyCommand{
+		BaseCommand: &BaseCommand{
+			UI: ui,
+		},
+	}
+}
+
+func TestDebugVerifyCommand_Run(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		archive  bool
+		tamper   func(tb testing.TB, dir string)
+		out      string
+		exitCode int
+	}{
+		{
+			"dir",
+			false,
+			nil,
+			"Success! Verified",
+			0,
+		},
+		{
+			"archive",
+			true,
+			nil,
+			"Success! Verified",
+			0,
+		},
+		{
+			"dir_modified",
+			false,
+			func(tb testing.TB, dir string) {
+				if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0644); err != nil {
+					tb.Fatal(err)
+				}
+			},
+			"Checksum mismatch: config.json",
+			2,
+		},
+		{
+			"dir_missing",
+			false,
+			func(tb testing.TB, dir string) {
+				if err := os.Remove(filepath.Join(dir, "000", "server_status.json")); err != nil {
+					tb.Fatal(err)
+				}
+			},
+			"Missing file: 000/server_status.json",
+			2,
+		},
+		{
+			"dir_unexpected",
+			false,
+			func(tb testing.TB, dir string) {
+				if err := ioutil.WriteFile(filepath.Join(dir, "extra.txt"), []byte("extra"), 0644); err != nil {
+					tb.Fatal(err)
+				}
+			},
+			"Unexpected file: extra.txt",
+			2,
+		},
+		{
+			"archive_modified",
+			true,
+			func(tb testing.TB, dir string) {
+				if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0644); err != nil {
+					tb.Fatal(err)
+				}
+			},
+			"Checksum mismatch: config.json",
+			2,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			client, closer := testVaultServer(t)
+			defer closer()
+
+			// Capture a directory bundle, which is archived after tampering
+			// if needed so that the archive holds the modified contents.
+			debugUI, debugCmd := testDebugCommand(t)
+			debugCmd.client = client
+
+			bundleDir := filepath.Join(testDir, tc.name)
+			args := []string{
+				"-duration=1s",
+				fmt.Sprintf("-output=%s", bundleDir),
+				"-compress=false",
+				"-target=config",
+				"-target=server-status",
+			}
+			if code := debugCmd.Run(args); code != 0 {
+				t.Log(debugUI.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, 0)
+			}
+
+			if tc.tamper != nil {
+				tc.tamper(t, bundleDir)
+			}
+
+			bundle := bundleDir
+			if tc.archive {
+				bundle = bundleDir + debugCompressionExt
+				if err := writeArchive(bundleDir, bundle, "tgz", false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ui, cmd := testDebugVerifyCommand(t)
+			code := cmd.Run([]string{bundle})
+			if code != tc.exitCode {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, tc.exitCode)
+			}
+
+			combined := ui.OutputWriter.String() + ui.ErrorWriter.String()
+			if !strings.Contains(combined, tc.out) {
+				t.Fatalf("expected %q to contain %q", combined, tc.out)
+			}
+		})
+	}
+
+	t.Run("no_args", func(t *testing.T) {
+		t.Parallel()
+
+		ui, cmd := testDebugVerifyCommand(t)
+		code := cmd.Run([]string{})
+		if exp := 1; code != exp {
+			t.Fatalf("expected %d to be %d", code, exp)
+		}
+
+		expected := "Incorrect arguments"
+		if combined := ui.OutputWriter.String() + ui.ErrorWriter.String(); !strings.Contains(combined, expected) {
+			t.Fatalf("expected %q to contain %q", combined, expected)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// debugWaitInterval is the interval at which the -wait-until condition is
+// evaluated.
+var debugWaitInterval = time.Second
+
+// debugConditionOps are the operators supported in -wait-until conditions.
+// Two-character operators are listed first so that they take precedence.
+var debugConditionOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// debugMetricPrefix is the prefix of -wait-until fields that refer to a gauge
+// reported by the metrics endpoint rather than a field of the health
+// response.
+const debugMetricPrefix = "metric."
+
+// debugCondition is a parsed -wait-until condition, such as sealed==true or
+// metric.vault.runtime.num_goroutines>1000.
+type debugCondition struct {
+	Field string
+	Op    string
+	Value string
+}
+
+func (d *debugCondition) String() string {
+	return d.Field + d.Op + d.Value
+}
+
+// parseDebugCondition parses a condition in the format of <field><op><value>.
+func parseDebugCondition(s string) (*debugCondition, error) {
+	s = strings.TrimSpace(s)
+	for _, op := range debugConditionOps {
+		idx := strings.Index(s, op)
+		if idx == -1 {
+			continue
+		}
+
+		cond := &debugCondition{
+			Field: strings.TrimSpace(s[:idx]),
+			Op:    op,
+			Value: strings.TrimSpace(s[idx+len(op):]),
+		}
+		if cond.Field == "" || cond.Value == "" {
+			return nil, fmt.Errorf("condition %q must be in the format of <field><operator><value>", s)
+		}
+
+		// Ordering is only meaningful for numbers
+		if op != "==" && op != "!=" {
+			if _, err := strconv.ParseFloat(cond.Value, 64); err != nil {
+				return nil, fmt.Errorf("condition %q compares against a non-numeric value using %s", s, op)
+			}
+		}
+		return cond, nil
+	}
+
+	return nil, fmt.Errorf("condition %q must use one of the operators: %s", s, strings.Join(debugConditionOps, ", "))
+}
+
+// satisfied returns whether the given value satisfies the condition. Numbers
+// are compared numerically and every other value is compared by its string
+// representation.
+func (d *debugCondition) satisfied(actual interface{}) (bool, error) {
+	if n, ok := actual.(float64); ok {
+		expected, err := strconv.ParseFloat(d.Value, 64)
+		if err != nil {
+			return false, fmt.Errorf("field %q is numeric but %q is not", d.Field, d.Value)
+		}
+
+		switch d.Op {
+		case "==":
+			return n == expected, nil
+		case "!=":
+			return n != expected, nil
+		case ">=":
+			return n >= expected, nil
+		case "<=":
+			return n <= expected, nil
+		case ">":
+			return n > expected, nil
+		default:
+			return n < expected, nil
+		}
+	}
+
+	switch d.Op {
+	case "==":
+		return fmt.Sprint(actual) == d.Value, nil
+	case "!=":
+		return fmt.Sprint(actual) != d.Value, nil
+	default:
+		return false, fmt.Errorf("field %q is not numeric and cannot be compared using %s", d.Field, d.Op)
+	}
+}
+
+// conditionValue fetches the current value of the field referenced by the
+// condition.
+func (c *DebugCommand) conditionValue(ctx context.Context, cond *debugCondition) (interface{}, error) {
+	ctx, cancel := c.targetContext(ctx, 0)
+	defer cancel()
+
+	if strings.HasPrefix(cond.Field, debugMetricPrefix) {
+		name := strings.TrimPrefix(cond.Field, debugMetricPrefix)
+
+		var metrics struct {
+			Gauges []struct {
+				Name  string  `json:"Name"`
+				Value float64 `json:"Value"`
+			} `json:"Gauges"`
+		}
+		if err := c.requestJSON(ctx, "/v1/sys/metrics", nil, &metrics); err != nil {
+			return nil, err
+		}
+		for _, gauge := range metrics.Gauges {
+			if gauge.Name == name {
+				return gauge.Value, nil
+			}
+		}
+		return nil, fmt.Errorf("gauge %q is not reported by the server", name)
+	}
+
+	data, err := c.requestRaw(ctx, "/v1/sys/health", debugHealthParams())
+	if err != nil {
+		return nil, err
+	}
+
+	var health map[string]interface{}
+	if err := json.Unmarshal(data, &health); err != nil {
+		return nil, err
+	}
+	value, ok := health[cond.Field]
+	if !ok {
+		return nil, fmt.Errorf("field %q is not reported by the health endpoint", cond.Field)
+	}
+	return value, nil
+}
+
+// waitForCondition polls the -wait-until condition until it is met, the
+// -wait-timeout elapses, or an interrupt is received. It returns whether the
+// condition was met and whether the capture should proceed.
+func (c *DebugCommand) waitForCondition() (bool, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var timeoutCh <-chan time.Time
+	if c.cfg.WaitTimeout > 0 {
+		timer := time.NewTimer(c.cfg.WaitTimeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	ticker := time.NewTicker(debugWaitInterval)
+	defer ticker.Stop()
+
+	// Requests made while waiting are not part of the capture, so they are
+	// dropped from the request timings
+	defer func() {
+		c.timingLock.Lock()
+		c.requestTimings = nil
+		c.timingLock.Unlock()
+	}()
+
+	var lastErr string
+	for {
+		value, err := c.conditionValue(ctx, c.waitCondition)
+		if err == nil {
+			var met bool
+			met, err = c.waitCondition.satisfied(value)
+			if met {
+				return true, true
+			}
+		}
+		if err != nil && err.Error() != lastErr {
+			c.UI.Warn(fmt.Sprintf("Error evaluating wait condition: %s", err))
+			lastErr = err.Error()
+		}
+
+		select {
+		case <-c.ShutdownCh:
+			return false, false
+		case <-timeoutCh:
+			return false, true
+		case <-ticker.C:
+		}
+	}
+}
+
+// observeExtendCondition evaluates the -extend-on condition, recording whether
+// it was observed during the current window.
+func (c *DebugCommand) observeExtendCondition(ctx context.Context) {
+	value, err := c.conditionValue(ctx, c.extendCondition)
+	if err == nil {
+		var met bool
+		met, err = c.extendCondition.satisfied(value)
+		if met {
+			atomic.StoreInt32(&c.extendObserved, 1)
+		}
+	}
+	if err != nil {
+		c.UI.Warn(fmt.Sprintf("Error evaluating extend condition: %s", err))
+	}
+}
+
+// extendCapture extends the capture by -extend-by for as long as the
+// -extend-on condition was observed during the previous window, until the
+// capture has run for -max-duration in total. The frames and metrics captures
+// of each extension are numbered after those already captured.
+func (c *DebugCommand) extendCapture(ctx context.Context, elapsed time.Duration) error {
+	for atomic.SwapInt32(&c.extendObserved, 0) == 1 && ctx.Err() == nil {
+		extend := c.cfg.ExtendBy
+		if remaining := c.cfg.MaxDuration - elapsed; remaining < extend {
+			extend = remaining
+		}
+		if extend <= 0 {
+			c.UI.Info(fmt.Sprintf("==> Observed %s, but the maximum duration of %s was reached", c.extendCondition, c.cfg.MaxDuration))
+			return nil
+		}
+
+		c.UI.Info(fmt.Sprintf("==> Observed %s, extending the capture by %s...", c.extendCondition, extend))
+
+		// The first frame of the extension follows the last frame by an
+		// interval, as any other frame does
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.cfg.Interval):
+		}
+
+		c.errLock.Lock()
+		for _, frame := range c.debugIndex.Frames {
+			if frame.Frame >= c.frameOffset {
+				c.frameOffset = frame.Frame + 1
+			}
+		}
+		c.debugIndex.DurationSeconds += int(extend.Seconds())
+		c.debugIndex.ExtendedSeconds += int(extend.Seconds())
+		c.errLock.Unlock()
+		c.metricsOffset = nextCaptureIndex(filepath.Join(c.cfg.Output, "metrics"))
+
+		if err := c.capturePollingTargets(ctx, extend); err != nil {
+			return err
+		}
+		elapsed += extend
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestDebugCommand_WaitUntil(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name           string
+		flipAfter      time.Duration
+		args           []string
+		expectedResult string
+	}{
+		{
+			"met",
+			2 * time.Second,
+			[]string{"-wait-until=sealed==true"},
+			"met",
+		},
+		{
+			"timeout",
+			time.Hour,
+			[]string{"-wait-until=sealed==true", "-wait-timeout=1s"},
+			"timeout",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			// The health endpoint reports the node as sealed once flipAt has
+			// passed, and the time of the first capture request is recorded
+			flipAt := time.Now().Add(tc.flipAfter)
+			var lock sync.Mutex
+			var firstCapture time.Time
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/v1/sys/health":
+					fmt.Fprintf(w, `{"initialized":true,"sealed":%t,"standby":false}`, time.Now().After(flipAt))
+				case "/v1/sys/host-info":
+					lock.Lock()
+					if firstCapture.IsZero() {
+						firstCapture = time.Now()
+					}
+					lock.Unlock()
+					w.Write([]byte(`{"data":{"host":{"hostname":"vault-0"}}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer ts.Close()
+
+			client, err := api.NewClient(&api.Config{
+				Address: ts.URL,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+			cmd.skipPreflight = true
+
+			outputPath := filepath.Join(testDir, tc.name)
+			args := append([]string{
+				"-duration=1s",
+				"-target=host",
+				fmt.Sprintf("-output=%s", outputPath),
+				"-compress=false",
+			}, tc.args...)
+
+			start := time.Now()
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			switch tc.expectedResult {
+			case "met":
+				if firstCapture.Before(flipAt) {
+					t.Fatalf("expected capture to start after the condition was met at %s, started at %s", flipAt, firstCapture)
+				}
+			case "timeout":
+				if elapsed := firstCapture.Sub(start); elapsed < time.Second {
+					t.Fatalf("expected capture to start after the wait timeout, started after %s", elapsed)
+				}
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var index DebugIndex
+			if err := json.Unmarshal(content, &index); err != nil {
+				t.Fatal(err)
+			}
+			if index.WaitUntil != "sealed==true" {
+				t.Fatalf("expected wait condition to be recorded, got %q", index.WaitUntil)
+			}
+			if index.WaitResult != tc.expectedResult {
+				t.Fatalf("expected wait result %q, got %q", tc.expectedResult, index.WaitResult)
+			}
+		})
+	}
+}
+
+func TestDebugCommand_ExtendOn(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		spikeFor      time.Duration
+		maxDuration   string
+		frames        int
+		extendedTotal int
+	}{
+		{
+			"never-met",
+			0,
+			"10s",
+			2,
+			0,
+		},
+		{
+			"spike",
+			1500 * time.Millisecond,
+			"10s",
+			4,
+			2,
+		},
+		{
+			"capped",
+			time.Hour,
+			"5s",
+			5,
+			3,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testDir, err := ioutil.TempDir("", "vault-debug")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(testDir)
+
+			// The goroutine gauge spikes until spikeUntil has passed
+			spikeUntil := time.Now().Add(tc.spikeFor)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/v1/sys/health":
+					w.Write([]byte(`{"initialized":true,"sealed":false,"standby":false}`))
+				case "/v1/sys/metrics":
+					goroutines := 50
+					if time.Now().Before(spikeUntil) {
+						goroutines = 20000
+					}
+					fmt.Fprintf(w, `{"Gauges":[{"Name":"vault.runtime.num_goroutines","Value":%d,"Labels":{}}],"Counters":[]}`, goroutines)
+				case "/v1/sys/host-info":
+					w.Write([]byte(`{"data":{"host":{"hostname":"vault-0"}}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer ts.Close()
+
+			client, err := api.NewClient(&api.Config{
+				Address: ts.URL,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ui, cmd := testDebugCommand(t)
+			cmd.client = client
+			cmd.skipPreflight = true
+
+			outputPath := filepath.Join(testDir, tc.name)
+			args := []string{
+				"-duration=2s",
+				"-interval=1s",
+				"-target=host",
+				"-extend-on=metric.vault.runtime.num_goroutines>10000",
+				"-extend-by=2s",
+				"-max-duration=" + tc.maxDuration,
+				fmt.Sprintf("-output=%s", outputPath),
+				"-compress=false",
+			}
+
+			start := time.Now()
+			code := cmd.Run(args)
+			if exp := 0; code != exp {
+				t.Log(ui.ErrorWriter.String())
+				t.Fatalf("expected %d to be %d", code, exp)
+			}
+			elapsed := time.Since(start)
+
+			content, err := ioutil.ReadFile(filepath.Join(outputPath, "index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var index DebugIndex
+			if err := json.Unmarshal(content, &index); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(index.Frames) != tc.frames {
+				t.Fatalf("expected %d frames, got %d:\n%s", tc.frames, len(index.Frames), ui.OutputWriter.String())
+			}
+			for i, frame := range index.Frames {
+				if frame.Frame != i {
+					t.Fatalf("expected frames to be numbered in order, got %d at %d", frame.Frame, i)
+				}
+			}
+			if index.ExtendedSeconds != tc.extendedTotal || index.DurationSeconds != 2+tc.extendedTotal {
+				t.Fatalf("expected the capture to be extended by %ds, got %ds over %ds", tc.extendedTotal, index.ExtendedSeconds, index.DurationSeconds)
+			}
+			if index.ExtendOn != "metric.vault.runtime.num_goroutines>10000" {
+				t.Fatalf("expected extend condition to be recorded, got %q", index.ExtendOn)
+			}
+
+			// An extended capture runs past the base duration, at least up to
+			// its last frame
+			if tc.extendedTotal > 0 && elapsed < time.Duration(tc.frames-1)*time.Second {
+				t.Fatalf("expected the capture to run longer than the base duration, ran for %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestDebugCondition(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		condition string
+		value     interface{}
+		expected  bool
+		parseErr  string
+	}{
+		{"sealed==true", true, true, ""},
+		{"sealed==true", false, false, ""},
+		{"standby!=true", false, true, ""},
+		{"metric.vault.runtime.num_goroutines>1000", float64(1500), true, ""},
+		{"metric.vault.runtime.num_goroutines>=1000", float64(1000), true, ""},
+		{"metric.vault.runtime.num_goroutines<1000", float64(1000), false, ""},
+		{"sealed", nil, false, "must use one of the operators"},
+		{"==true", nil, false, "must be in the format"},
+		{"version>abc", nil, false, "non-numeric value"},
+	}
+
+	for _, tc := range cases {
+		cond, err := parseDebugCondition(tc.condition)
+		if tc.parseErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.parseErr) {
+				t.Fatalf("%s: expected error containing %q, got %v", tc.condition, tc.parseErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", tc.condition, err)
+		}
+
+		met, err := cond.satisfied(tc.value)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.condition, err)
+		}
+		if met != tc.expected {
+			t.Fatalf("%s: expected %t for %v", tc.condition, tc.expected, tc.value)
+		}
+	}
+}
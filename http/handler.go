@@ -21,6 +21,7 @@ import (
 	"github.com/hashicorp/errwrap"
 	cleanhttp "github.com/hashicorp/go-cleanhttp"
 	sockaddr "github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/jsonutil"
@@ -205,6 +206,22 @@ func wrapGenericHandler(core *vault.Core, h http.Handler, maxRequestSize int64,
 			}
 			r = newR
 
+			// Track the request while it is served, so that it is listed by
+			// sys/in-flight-req
+			inFlightReqID, err := uuid.GenerateUUID()
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Errorf("failed to generate an identifier for the in-flight request"))
+				cancelFunc()
+				return
+			}
+			core.StoreInFlightReqData(inFlightReqID, vault.InFlightReqData{
+				StartTime:        time.Now(),
+				ClientRemoteAddr: r.RemoteAddr,
+				ReqPath:          r.URL.Path,
+				Method:           r.Method,
+			})
+			defer core.FinalizeInFlightReqData(inFlightReqID)
+
 		case strings.HasPrefix(r.URL.Path, "/ui"), r.URL.Path == "/robots.txt", r.URL.Path == "/":
 		default:
 			respondError(w, http.StatusNotFound, nil)
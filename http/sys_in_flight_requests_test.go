@@ -0,0 +1,100 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestSysInFlightRequests(t *testing.T) {
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+	TestServerAuth(t, addr, token)
+
+	// The address of the client is the address of the connection, rather
+	// than one the client claims with X-Forwarded-For
+	req, err := http.NewRequest("GET", addr+"/v1/sys/in-flight-req", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResponseStatus(t, resp, 200)
+
+	var body struct {
+		Data map[string]vault.InFlightReqData `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	// The request listing the in-flight requests is in flight itself
+	if len(body.Data) != 1 {
+		t.Fatalf("expected a single request in flight, got: %#v", body.Data)
+	}
+	for id, req := range body.Data {
+		if id == "" || req.StartTime.IsZero() || req.ClientRemoteAddr == "" {
+			t.Fatalf("expected the request data to be set, got: %#v", req)
+		}
+		if req.ClientRemoteAddr == "203.0.113.1" {
+			t.Fatalf("expected the address of the connection, got: %#v", req)
+		}
+		if req.ReqPath != "/v1/sys/in-flight-req" || req.Method != "GET" {
+			t.Fatalf("expected the listing request, got: %#v", req)
+		}
+	}
+
+	// Once served, requests are no longer listed
+	resp = testHttpGet(t, token, addr+"/v1/sys/in-flight-req")
+	testResponseStatus(t, resp, 200)
+	body.Data = nil
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Data) != 1 {
+		t.Fatalf("expected served requests to be removed, got: %#v", body.Data)
+	}
+
+	resp = testHttpGet(t, "", addr+"/v1/sys/in-flight-req")
+	testResponseStatus(t, resp, 400)
+
+	// The path is root-protected, so reading it requires sudo
+	policies := []struct {
+		name  string
+		rules string
+		code  int
+	}{
+		{"in-flight-read", `path "sys/in-flight-req" { capabilities = ["read"] }`, 403},
+		{"in-flight-sudo", `path "sys/in-flight-req" { capabilities = ["read", "sudo"] }`, 200},
+	}
+	for _, policy := range policies {
+		resp = testHttpPost(t, token, addr+"/v1/sys/policy/"+policy.name, map[string]interface{}{
+			"policy": policy.rules,
+		})
+		testResponseStatus(t, resp, 204)
+
+		resp = testHttpPost(t, token, addr+"/v1/auth/token/create", map[string]interface{}{
+			"policies": []string{policy.name},
+		})
+		testResponseStatus(t, resp, 200)
+		var created struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		resp = testHttpGet(t, created.Auth.ClientToken, addr+"/v1/sys/in-flight-req")
+		testResponseStatus(t, resp, policy.code)
+	}
+}
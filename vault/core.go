@@ -454,6 +454,10 @@ type Core struct {
 	// Stores request counters
 	counters counters
 
+	// inFlightReqData holds the requests being served, keyed by an ID
+	// generated for each request
+	inFlightReqData sync.Map
+
 	// Stores the raft applied index for standby nodes
 	raftFollowerStates *raftFollowerStates
 	// Stop channel for raft TLS rotations
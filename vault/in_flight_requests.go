@@ -0,0 +1,36 @@
+package vault
+
+import (
+	"time"
+)
+
+// InFlightReqData is the data of a request that is being served, as listed by
+// sys/in-flight-req.
+type InFlightReqData struct {
+	StartTime        time.Time `json:"start_time"`
+	ClientRemoteAddr string    `json:"client_remote_address"`
+	ReqPath          string    `json:"request_path"`
+	Method           string    `json:"request_method"`
+}
+
+// StoreInFlightReqData records the request with the given ID as being served
+// until FinalizeInFlightReqData is called with the same ID.
+func (c *Core) StoreInFlightReqData(reqID string, data InFlightReqData) {
+	c.inFlightReqData.Store(reqID, data)
+}
+
+// FinalizeInFlightReqData removes the request with the given ID once it has
+// been served.
+func (c *Core) FinalizeInFlightReqData(reqID string) {
+	c.inFlightReqData.Delete(reqID)
+}
+
+// LoadInFlightReqData returns the requests being served, keyed by their ID.
+func (c *Core) LoadInFlightReqData() map[string]InFlightReqData {
+	requests := map[string]InFlightReqData{}
+	c.inFlightReqData.Range(func(key, value interface{}) bool {
+		requests[key.(string)] = value.(InFlightReqData)
+		return true
+	})
+	return requests
+}
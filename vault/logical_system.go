@@ -110,6 +110,7 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 				"leases/revoke-prefix/*",
 				"leases/revoke-force/*",
 				"leases/lookup/*",
+				"in-flight-req",
 			},
 
 			Unauthenticated: []string{
@@ -170,6 +171,7 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 	b.Backend.Paths = append(b.Backend.Paths, b.remountPath())
 	b.Backend.Paths = append(b.Backend.Paths, b.metricsPath())
 	b.Backend.Paths = append(b.Backend.Paths, b.hostInfoPath())
+	b.Backend.Paths = append(b.Backend.Paths, b.inFlightRequestPath())
 
 	if core.rawEnabled {
 		b.Backend.Paths = append(b.Backend.Paths, &framework.Path{
@@ -2677,6 +2679,19 @@ func (b *SystemBackend) handleHostInfo(ctx context.Context, req *logical.Request
 	return resp, nil
 }
 
+// handleInFlightRequestData returns the requests being served by the node,
+// keyed by the ID generated for each request.
+func (b *SystemBackend) handleInFlightRequestData(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	respData := map[string]interface{}{}
+	for id, inFlight := range b.Core.LoadInFlightReqData() {
+		respData[id] = inFlight
+	}
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
 func (b *SystemBackend) handleWrappingLookup(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	// This ordering of lookups has been validated already in the wrapping
 	// validation func, we're just doing this for a safety check
@@ -4163,4 +4178,10 @@ This path responds to the following HTTP methods.
 		The information that gets collected includes host hardware information, and CPU,
 		disk, and memory utilization`,
 	},
+	"in-flight-req": {
+		"Requests being served by this Vault server.",
+		`Requests being served by this Vault server, keyed by an identifier generated
+		for each request. Each request lists the time it started, its method and path,
+		and the address of the client that made it.`,
+	},
 }
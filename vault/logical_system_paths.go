@@ -1214,6 +1214,21 @@ func (b *SystemBackend) hostInfoPath() *framework.Path {
 	}
 }
 
+func (b *SystemBackend) inFlightRequestPath() *framework.Path {
+	return &framework.Path{
+		Pattern: "in-flight-req/?",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:    b.handleInFlightRequestData,
+				Summary:     strings.TrimSpace(sysHelp["in-flight-req"][0]),
+				Description: strings.TrimSpace(sysHelp["in-flight-req"][1]),
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(sysHelp["in-flight-req"][0]),
+		HelpDescription: strings.TrimSpace(sysHelp["in-flight-req"][1]),
+	}
+}
+
 func (b *SystemBackend) authPaths() []*framework.Path {
 	return []*framework.Path{
 		{
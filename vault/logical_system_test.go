@@ -54,6 +54,7 @@ func TestSystemBackend_RootPaths(t *testing.T) {
 		"leases/revoke-prefix/*",
 		"leases/revoke-force/*",
 		"leases/lookup/*",
+		"in-flight-req",
 	}
 
 	b := testSystemBackend(t)